@@ -1,18 +1,47 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
 	"io"
 	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Sample usage:
@@ -21,8 +50,8 @@ import (
 // Where the input folder is the root of the expanded Mastodon archive. It MUST
 // include an outbox.json file
 //
-// The contents in the --output folder will be purged before rendering the
-// new toots
+// With --clean, the files the previous run generated in the --output folder
+// are deleted before rendering the new toots
 //
 // /////////////////////////////////////////////////////////////////////////////
 // _                  _      _
@@ -33,33 +62,425 @@ import (
 // /////////////////////////////////////////////////////////////////////////////
 
 var TEMPLATE_TOOT_FRONTMATTER = `---
-title: "Mastodon - {{ .Toot.Published }}"
+title: "{{ .Title }}"
 subtitle: ""
 canonical: {{ .Toot.Object.ID }}
-description:
-image: "/images/mastodon.png"
-
+description: {{ printf "%q" .Description }}
+image: {{ printf "%q" .Image }}
+{{ with .Images }}images: [{{ range $index, $eachImage := . }}{{ if $index }},{{ end }}{{ printf "%q" $eachImage }}{{ end }}]
+{{ end }}{{ with .Audio }}audio: [{{ range $index, $eachAudio := . }}{{ if $index }},{{ end }}{{ printf "%q" $eachAudio }}{{ end }}]
+{{ end }}{{ with .Videos }}videos: [{{ range $index, $eachVideo := . }}{{ if $index }},{{ end }}{{ printf "%q" $eachVideo }}{{ end }}]
+{{ end }}
 date: {{ .Toot.Published }}
-lastmod: {{ .Toot.Published }}
-image: ""
+lastmod: {{ .LastMod }}
+{{ if .Draft }}draft: true
+{{ end -}}
+{{ with .Series }}series: ["{{ . }}"]
+{{ end -}}
 tags: [{{ range $index, $eachTag := .Toot.Object.Tags}}{{if $index}},{{end}}"{{$eachTag.Name}}"{{end}}]
-
+{{ with .Keywords }}keywords: [{{ range $index, $eachKeyword := . }}{{ if $index }},{{ end }}{{ printf "%q" $eachKeyword }}{{ end }}]
+{{ end }}{{ with .Aliases }}aliases: [{{ range $index, $eachAlias := . }}{{ if $index }},{{ end }}"{{ $eachAlias }}"{{ end }}]
+{{ end }}{{ with .Toot.Object.Language }}language: "{{ . }}"
+{{ end }}{{ if .Featured }}featured: true
+{{ with .Weight }}weight: {{ . }}
+{{ end }}{{ end }}{{ with .Placeholders }}placeholders:
+{{ range . }}  - image: "{{ .BaseFilename }}"
+    blurhash: "{{ .Blurhash }}"
+    src: "{{ .PlaceholderFilename }}"
+{{ end }}{{ end }}{{ with .Resources }}resources:
+{{ range . }}  - src: "{{ .BaseFilename }}"
+    name: "{{ .BaseFilename }}"
+{{ with .Name }}    title: {{ printf "%q" . }}
+{{ end }}{{ $focalPoint := and .IsImage .HasFocalPoint }}{{ if or .Width .Height $focalPoint }}    params:
+{{ with .Width }}      width: {{ . }}
+{{ end }}{{ with .Height }}      height: {{ . }}
+{{ end }}{{ if $focalPoint }}      focalPoint: [{{ .FocusX }}, {{ .FocusY }}]
+      anchor: "{{ .FocalAnchor }}"
+{{ end }}{{ end }}{{ end }}{{ end }}{{ range .Params }}{{ .Key }}: {{ .Value }}
+{{ end }}
 categories: ["mastodon"]
-# generated: {{ .ExecutionTime }}
----
+{{ with .ExecutionTime }}# generated: {{ . }}
+{{ end }}---
 ![Mastodon](/images/mastodon.png)
 `
 
 var TEMPLATE_TOOT = `
-{{ .Toot.Object.Content }}
-{{ range $index, $eachAttachment := .Toot.Object.Attachments}}
-{{ if eq $eachAttachment.MediaType "video/mp4"}}<video controls autoplay muted loop width="512"><source src="{{$eachAttachment.BaseFilename}}" type="{{ $eachAttachment.MediaType}}" /></video>{{else}}![{{$eachAttachment.Name}}]({{$eachAttachment.BaseFilename}}){{end}}{{end}}
+{{ with .InReplyTo }}*{{ $.Messages.InReplyTo }} [{{ . }}]({{ . }})*
+
+{{ end }}{{ with .Toot.Object.Summary }}{{ if eq $.CWMode "fold" }}{{ if $.LayoutShortcodes }}{{ "{{<" }} cw warning={{ printf "%q" . }} >}}{{ else }}<details><summary>{{ html . }}</summary>{{ end }}
 
-###### [Mastodon Source 🐘]({{ .Toot.Object.URL }})
+{{ else }}**{{ $.Messages.ContentWarning }}: {{ html . }}**
+
+{{ end }}{{ end }}{{ .Content }}
+{{ with .Toot.Object.LinkPreview }}
+<div class="mastodon-link-preview" style="border:1px solid #ccc;border-radius:8px;padding:0.5em;overflow:hidden"><a href="{{ html .URL }}" style="display:flex;gap:0.75em;text-decoration:none;color:inherit">{{ with .ImageFilename }}<img src="{{ html . }}" alt="" loading="lazy" style="width:120px;height:80px;object-fit:cover;border-radius:4px;margin:0" />{{ end }}<span><strong>{{ html .Title }}</strong>{{ with .Description }}<br /><small>{{ html . }}</small>{{ end }}<br /><small>{{ .Host }}</small></span></a></div>
+{{ end }}{{ $sensitive := and .Toot.Object.Sensitive .Toot.Object.Attachments (ne $.SensitiveMedia "show") }}{{ if $sensitive }}
+{{ if and (eq $.SensitiveMedia "fold") $.LayoutShortcodes }}{{ "{{<" }} cw warning={{ printf "%q" $.Messages.SensitiveMedia }} >}}
+{{ else if eq $.SensitiveMedia "fold" }}<details><summary>{{ $.Messages.SensitiveMedia }}</summary>
+{{ else }}<div class="{{ $.SensitiveClass }}">
+{{ end }}{{ end }}{{ $gallery := and $.Gallery (gt (len .Toot.Object.ImageAttachments) 1) }}{{ if $gallery }}
+{{ with $.GalleryShortcode }}{{ "{{<" }} {{ . }} >}}{{ else }}<div class="mastodon-gallery" style="display:grid;grid-template-columns:repeat(2,1fr);gap:0.25em">{{ end }}
+{{ range .Toot.Object.ImageAttachments }}<figure style="margin:0">{{ if .FallbackFilename }}<picture><source srcset="{{ .BaseFilename }}" type="{{ .MediaType }}" /><img src="{{ .FallbackFilename }}" alt="{{ html .Name }}" style="width:100%;height:100%;object-fit:cover" /></picture>{{ else }}<img src="{{ .BaseFilename }}"{{ with .SrcsetValue }} srcset="{{ . }}" sizes="{{ $.SrcsetSizes }}"{{ end }} alt="{{ html .Name }}" style="width:100%;height:100%;object-fit:cover" />{{ end }}</figure>
+{{ end }}{{ with $.GalleryShortcode }}{{ "{{</" }} {{ . }} >}}{{ else }}</div>{{ end }}{{ end }}{{ range $index, $eachAttachment := .Toot.Object.Attachments}}{{ if not (and $gallery $eachAttachment.IsImage) }}
+{{ if eq $eachAttachment.MediaType "video/mp4"}}{{ if $.LayoutShortcodes }}{{ "{{<" }} mastodon-video src="{{$eachAttachment.BaseFilename}}" type="{{$eachAttachment.MediaType}}" autoplay="true" caption={{ printf "%q" $eachAttachment.Name }} >}}{{ else }}<video controls autoplay muted loop width="512"><source src="{{$eachAttachment.BaseFilename}}" type="{{ $eachAttachment.MediaType}}" /></video>{{ end }}{{else if $eachAttachment.FallbackFilename}}<picture><source srcset="{{$eachAttachment.BaseFilename}}" type="{{$eachAttachment.MediaType}}" /><img src="{{$eachAttachment.FallbackFilename}}" alt="{{ html $eachAttachment.Name }}" /></picture>{{else if $eachAttachment.IsAudio}}{{ with $.AudioShortcode }}{{ "{{<" }} {{ . }} src="{{$eachAttachment.BaseFilename}}" type="{{$eachAttachment.MediaType}}" caption={{ printf "%q" $eachAttachment.Name }} >}}{{ else }}<figure><audio controls src="{{$eachAttachment.BaseFilename}}"></audio>{{ with $eachAttachment.Name }}<figcaption>{{ html . }}</figcaption>{{ end }}</figure>{{ end }}{{else if $eachAttachment.Srcset}}<img src="{{$eachAttachment.BaseFilename}}" srcset="{{$eachAttachment.SrcsetValue}}" sizes="{{$.SrcsetSizes}}" alt="{{ html $eachAttachment.Name }}" />{{else}}![{{$eachAttachment.Name}}]({{$eachAttachment.BaseFilename}}){{end}}{{end}}{{end}}{{ if $sensitive }}
+
+{{ if and (eq $.SensitiveMedia "fold") $.LayoutShortcodes }}{{ "{{</" }} cw >}}{{ else if eq $.SensitiveMedia "fold" }}</details>{{ else }}</div>{{ end }}{{ end }}
+{{ if and .Toot.Object.Summary (eq .CWMode "fold") }}
+{{ if .LayoutShortcodes }}{{ "{{</" }} cw >}}{{ else }}</details>{{ end }}
+{{ end }}
+###### [{{ .Messages.Source }}]({{ .Toot.Object.URL }})
 
 ___
 `
 
+// micro.blog profile. Short posts are untitled microposts.
+var TEMPLATE_MICROBLOG_FRONTMATTER = `---
+{{ if gt (len .PlainText) 280 }}title: {{ printf "%q" .Excerpt }}
+{{ end -}}
+date: {{ .Toot.Published }}
+{{ if ne .LastMod .Toot.Published }}lastmod: {{ .LastMod }}
+{{ end -}}
+{{ if .Draft }}draft: true
+{{ end -}}
+{{ with .Series }}series: ["{{ . }}"]
+{{ end -}}
+{{ with .Photos }}photos: [{{ range $index, $eachPhoto := . }}{{ if $index }}, {{ end }}"{{ $eachPhoto }}"{{ end }}]
+{{ end -}}
+tags: [{{ range $index, $eachTag := .Toot.Object.Tags}}{{if $index}},{{end}}"{{$eachTag.Name}}"{{end}}]
+{{ with .Keywords }}keywords: [{{ range $index, $eachKeyword := . }}{{ if $index }},{{ end }}{{ printf "%q" $eachKeyword }}{{ end }}]
+{{ end -}}
+canonical: {{ .Toot.Object.URL }}
+{{ with .Description }}description: {{ printf "%q" . }}
+{{ end -}}
+{{ with .Aliases }}aliases: [{{ range $index, $eachAlias := . }}{{ if $index }},{{ end }}"{{ $eachAlias }}"{{ end }}]
+{{ end -}}
+{{ with .Placeholders }}placeholders:
+{{ range . }}  - image: "{{ .BaseFilename }}"
+    blurhash: "{{ .Blurhash }}"
+    src: "{{ .PlaceholderFilename }}"
+{{ end }}{{ end -}}
+{{ range .Params }}{{ .Key }}: {{ .Value }}
+{{ end -}}
+---
+`
+
+var TEMPLATE_MICROBLOG_TOOT = `{{ .Content }}
+{{ with .Toot.Object.LinkPreview }}
+<div class="mastodon-link-preview" style="border:1px solid #ccc;border-radius:8px;padding:0.5em;overflow:hidden"><a href="{{ html .URL }}" style="display:flex;gap:0.75em;text-decoration:none;color:inherit">{{ with .ImageFilename }}<img src="{{ html . }}" alt="" loading="lazy" style="width:120px;height:80px;object-fit:cover;border-radius:4px;margin:0" />{{ end }}<span><strong>{{ html .Title }}</strong>{{ with .Description }}<br /><small>{{ html . }}</small>{{ end }}<br /><small>{{ .Host }}</small></span></a></div>
+{{ end }}{{ $sensitive := and .Toot.Object.Sensitive .Toot.Object.Attachments (ne $.SensitiveMedia "show") }}{{ if $sensitive }}
+{{ if and (eq $.SensitiveMedia "fold") $.LayoutShortcodes }}{{ "{{<" }} cw warning={{ printf "%q" $.Messages.SensitiveMedia }} >}}
+{{ else if eq $.SensitiveMedia "fold" }}<details><summary>{{ $.Messages.SensitiveMedia }}</summary>
+{{ else }}<div class="{{ $.SensitiveClass }}">
+{{ end }}{{ end }}{{ range $index, $eachAttachment := .Toot.Object.Attachments}}
+{{ if eq $eachAttachment.MediaType "video/mp4"}}{{ if $.LayoutShortcodes }}{{ "{{<" }} mastodon-video src="{{$eachAttachment.BaseFilename}}" type="{{$eachAttachment.MediaType}}" caption={{ printf "%q" $eachAttachment.Name }} >}}{{ else }}<video controls muted loop width="512"><source src="{{$eachAttachment.BaseFilename}}" type="{{ $eachAttachment.MediaType}}" /></video>{{ end }}{{else if $eachAttachment.IsAudio}}{{ with $.AudioShortcode }}{{ "{{<" }} {{ . }} src="{{$eachAttachment.BaseFilename}}" type="{{$eachAttachment.MediaType}}" caption={{ printf "%q" $eachAttachment.Name }} >}}{{ else }}<figure><audio controls src="{{$eachAttachment.BaseFilename}}"></audio>{{ with $eachAttachment.Name }}<figcaption>{{ html . }}</figcaption>{{ end }}</figure>{{ end }}{{else}}<img src="{{$eachAttachment.BaseFilename}}"{{ with $eachAttachment.SrcsetValue }} srcset="{{ . }}" sizes="{{ $.SrcsetSizes }}"{{ end }} alt="{{ html $eachAttachment.Name }}"{{ if $eachAttachment.HasFocalPoint }} data-focus-x="{{ $eachAttachment.FocusX }}" data-focus-y="{{ $eachAttachment.FocusY }}"{{ end }} />{{end}}{{end}}{{ if $sensitive }}
+{{ if and (eq $.SensitiveMedia "fold") $.LayoutShortcodes }}{{ "{{</" }} cw >}}{{ else if eq $.SensitiveMedia "fold" }}</details>{{ else }}</div>{{ end }}{{ end }}
+`
+
+// SQLite export schema
+var SQLITE_SCHEMA = `DROP TABLE IF EXISTS toots;
+DROP TABLE IF EXISTS attachments;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS threads;
+CREATE TABLE toots (
+  id TEXT PRIMARY KEY,
+  url TEXT NOT NULL,
+  published TEXT NOT NULL,
+  in_reply_to TEXT,
+  content TEXT NOT NULL,
+  plain_text TEXT NOT NULL
+);
+CREATE TABLE attachments (
+  toot_id TEXT NOT NULL REFERENCES toots(id),
+  position INTEGER NOT NULL,
+  media_type TEXT NOT NULL,
+  url TEXT NOT NULL,
+  name TEXT NOT NULL,
+  width INTEGER,
+  height INTEGER,
+  PRIMARY KEY (toot_id, position)
+);
+CREATE TABLE tags (
+  toot_id TEXT NOT NULL REFERENCES toots(id),
+  type TEXT NOT NULL,
+  name TEXT NOT NULL,
+  href TEXT NOT NULL
+);
+CREATE TABLE threads (
+  root_id TEXT NOT NULL,
+  toot_id TEXT NOT NULL REFERENCES toots(id),
+  position INTEGER NOT NULL,
+  PRIMARY KEY (root_id, toot_id)
+);
+CREATE INDEX tags_name ON tags(name);
+CREATE INDEX toots_published ON toots(published);
+`
+
+// Companion shortcode for --search-index. Usage:
+//
+//	{{< mastodon-search index="/mastodon-search.json" >}}
+var TEMPLATE_SEARCH_SHORTCODE = `<div class="mastodon-search">
+  <input type="search" placeholder="Search toots" aria-label="Search toots" />
+  <ul class="mastodon-search-results"></ul>
+</div>
+<script>
+(function () {
+  var root = document.currentScript.previousElementSibling;
+  var input = root.querySelector("input");
+  var results = root.querySelector("ul");
+  var documents = null;
+  function render(query) {
+    results.innerHTML = "";
+    if (!documents || query.length < 2) { return; }
+    var terms = query.toLowerCase().split(/\s+/);
+    documents.filter(function (doc) {
+      var haystack = (doc.content + " " + doc.tags.join(" ")).toLowerCase();
+      return terms.every(function (term) { return haystack.indexOf(term) >= 0; });
+    }).slice(0, 50).forEach(function (doc) {
+      var item = document.createElement("li");
+      var link = document.createElement("a");
+      link.href = doc.url;
+      link.textContent = doc.date.substring(0, 10) + " — " + doc.title;
+      item.appendChild(link);
+      results.appendChild(item);
+    });
+  }
+  input.addEventListener("input", function () {
+    if (documents === null) {
+      documents = [];
+      fetch("{{ .Get "index" | default "/mastodon-search.json" }}")
+        .then(function (response) { return response.json(); })
+        .then(function (json) { documents = json; render(input.value); });
+    }
+    render(input.value);
+  });
+})();
+</script>
+`
+
+// Page listing the pinned toots, written to pinned/index.md
+var TEMPLATE_PINNED_PAGE = `---
+title: {{ printf "%q" .Title }}
+description: {{ printf "%q" .Description }}
+{{ with .ExecutionTime }}# generated: {{ . }}
+{{ end }}---
+{{ range .Links }}
+- [{{ .Title }}]({{ "{{<" }} relref "{{ .Path }}" {{ ">}}" }})
+{{- end }}
+`
+
+// Per-hashtag index page written to tags/<slug>/_index.md
+var TEMPLATE_TAG_INDEX = `---
+title: "#{{ .Tag }}"
+description: {{ printf "%q" .Description }}
+count: {{ .Count }}
+---
+{{ range .Links }}
+- [{{ .Title }}]({{ "{{<" }} relref "{{ .Path }}" {{ ">}}" }})
+{{- end }}
+`
+
+// Thread index page written to series/<name>/_index.md, listing the pages
+// of the thread in order
+var TEMPLATE_SERIES_INDEX = `---
+title: {{ printf "%q" .Title }}
+description: {{ printf "%q" .Description }}
+count: {{ len .Links }}
+---
+{{ range .Links }}
+1. [{{ .Title }}]({{ "{{<" }} relref "{{ .Path }}" {{ ">}}" }})
+{{- end }}
+`
+
+// Section list page written to the output root and to every year directory
+var TEMPLATE_SECTION_INDEX = `---
+title: "{{ .Title }}"
+description: {{ printf "%q" .Description }}
+count: {{ .Count }}
+cascade:
+  type: "mastodon"
+{{ with .ExecutionTime }}# generated: {{ . }}
+{{ end }}---
+`
+
+// Share card HTML rendered for the --og-image-hook command
+var TEMPLATE_OG_IMAGE_CARD = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<style>
+html,body{margin:0;width:{{ .Width }}px;height:{{ .Height }}px;overflow:hidden}
+body{display:flex;flex-direction:column;background:{{ .Background }};color:#fff;font-family:system-ui,sans-serif}
+main{flex:1;display:flex;align-items:center;padding:0 80px;font-size:52px;line-height:1.3}
+footer{padding:32px 80px;background:rgba(0,0,0,.3);font-size:30px}
+</style>
+</head>
+<body>
+<main>{{ .Text }}</main>
+<footer>{{ .Footer }}</footer>
+</body>
+</html>
+`
+
+// Standalone HTML format. The stylesheet is embedded in every page so that
+// each file is self-contained.
+var TEMPLATE_HTML_STYLE = `body{max-width:42rem;margin:2rem auto;padding:0 1rem;font-family:system-ui,sans-serif;line-height:1.5;color:#222;background:#fdfdfd}
+article{border-bottom:1px solid #ddd;padding:1rem 0}
+img,video{max-width:100%;height:auto;border-radius:4px}
+time,.source{font-size:.85rem;color:#666}
+a{color:#563acc}
+ul.toots{list-style:none;padding:0}
+ul.toots li{margin:.5rem 0}`
+
+var TEMPLATE_HTML_PAGE = `<!DOCTYPE html>
+<html lang="{{ .Lang }}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ .Title }}</title>
+<style>{{ .Style }}</style>
+</head>
+<body>
+<nav><a href="{{ .IndexPath }}">&larr; {{ .Messages.AllToots }}</a></nav>
+<h1>{{ .Title }}</h1>
+{{- range .Toots }}
+<article id="{{ .AnchorID }}">
+<time datetime="{{ .Entry.Published }}">{{ .Entry.Published }}</time>
+{{ .Content }}
+{{- range .Entry.Object.Attachments }}
+{{- if eq .MediaType "video/mp4" }}
+<video controls muted loop src="{{ .BaseFilename }}"></video>
+{{- else if .IsAudio }}
+<figure><audio controls src="{{ .BaseFilename }}"></audio>{{ with .Name }}<figcaption>{{ . }}</figcaption>{{ end }}</figure>
+{{- else }}
+<img src="{{ .BaseFilename }}" alt="{{ .Name }}"{{ if .HasFocalPoint }} data-focus-x="{{ .FocusX }}" data-focus-y="{{ .FocusY }}"{{ end }} loading="lazy">
+{{- end }}
+{{- end }}
+<p class="source"><a href="{{ .Entry.Object.URL }}">{{ $.Messages.Source }}</a></p>
+</article>
+{{- end }}
+</body>
+</html>
+`
+
+var TEMPLATE_HTML_INDEX = `<!DOCTYPE html>
+<html lang="{{ .Lang }}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ .Title }}</title>
+<style>{{ .Style }}</style>
+</head>
+<body>
+<h1>{{ .Title }}</h1>
+<ul class="toots">
+{{- range .Links }}
+<li><a href="{{ .Path }}">{{ .Title }}</a></li>
+{{- end }}
+</ul>
+</body>
+</html>
+`
+
+// Companion shortcode for the hugo-data format. Usage:
+//
+//	{{< mastodon-toots month="2024-02" >}}
+//	{{< mastodon-toots year="2024" >}}
+var TEMPLATE_HUGO_DATA_SHORTCODE = `{{- $month := .Get "month" -}}
+{{- $year := .Get "year" -}}
+{{- range $key, $data := site.Data.mastodon -}}
+{{- if or (eq $key $month) (and $year (hasPrefix $key $year)) (and (not $month) (not $year)) -}}
+{{- range $data.toots }}
+<article class="mastodon-toot" id="toot-{{ .id | urlize }}">
+  <time datetime="{{ .published }}">{{ dateFormat "2006-01-02 15:04" .published }}</time>
+  {{ .content | safeHTML }}
+  {{- range .attachments }}
+  {{- if hasPrefix .mediaType "video/" }}
+  <video controls muted loop src="{{ .url | relURL }}"></video>
+  {{- else if hasPrefix .mediaType "audio/" }}
+  <audio controls src="{{ .url | relURL }}"></audio>
+  {{- else }}
+  <img src="{{ .url | relURL }}" alt="{{ .name }}" loading="lazy" />
+  {{- end }}
+  {{- end }}
+  <a href="{{ .url }}">Mastodon Source 🐘</a>
+</article>
+{{- end }}
+{{- end -}}
+{{- end -}}
+`
+
+// Companion shortcode for --audio-shortcode. Usage:
+//
+//	{{< mastodon-audio src="episode.mp3" type="audio/mpeg" caption="Episode one" >}}
+var TEMPLATE_AUDIO_SHORTCODE = `{{- $src := .Get "src" -}}
+{{- with .Page.Resources.GetMatch $src }}{{ $src = .RelPermalink }}{{ end -}}
+<figure class="mastodon-audio">
+  <audio controls preload="metadata">
+    <source src="{{ $src }}"{{ with .Get "type" }} type="{{ . }}"{{ end }} />
+  </audio>
+  {{- with .Get "caption" }}
+  <figcaption>{{ . }}</figcaption>
+  {{- end }}
+</figure>
+`
+
+// Companion shortcode for --gallery-shortcode. Usage:
+//
+//	{{< mastodon-gallery columns="3" >}}<img src="a.jpg" alt="" />{{< /mastodon-gallery >}}
+var TEMPLATE_GALLERY_SHORTCODE = `<div class="mastodon-gallery" style="display:grid;grid-template-columns:repeat({{ .Get "columns" | default 2 }},1fr);gap:0.25em">
+{{ .Inner }}
+</div>
+`
+
+// Companion video player shortcode. Usage:
+//
+//	{{< mastodon-video src="clip.mp4" type="video/mp4" autoplay="true" caption="A clip" >}}
+var TEMPLATE_VIDEO_SHORTCODE = `{{- $src := .Get "src" -}}
+{{- with .Page.Resources.GetMatch $src }}{{ $src = .RelPermalink }}{{ end -}}
+<figure class="mastodon-video">
+  <video controls muted loop playsinline preload="metadata" width="{{ .Get "width" | default 512 }}"{{ if eq (.Get "autoplay") "true" }} autoplay{{ end }}{{ with .Get "poster" }} poster="{{ . }}"{{ end }}>
+    <source src="{{ $src }}"{{ with .Get "type" }} type="{{ . }}"{{ end }} />
+  </video>
+  {{- with .Get "caption" }}
+  <figcaption>{{ . }}</figcaption>
+  {{- end }}
+</figure>
+`
+
+// Companion content warning fold shortcode. The folded Markdown is rendered
+// by the shortcode, so the <details> markup survives Goldmark's raw HTML
+// filtering. Usage:
+//
+//	{{< cw warning="Spoilers" >}}The butler did it{{< /cw >}}
+var TEMPLATE_CW_SHORTCODE = `<details class="mastodon-cw">
+<summary>{{ .Get "warning" | default "Content warning" }}</summary>
+{{ .Inner | .Page.RenderString (dict "display" "block") }}
+</details>
+`
+
+// Companion toot card shortcode, framing a toot with its date and source
+// link. Usage:
+//
+//	{{< mastodon-toot url="https://hachyderm.io/@mweagle/111" date="2024-02-01T12:00:00Z" >}}Hello{{< /mastodon-toot >}}
+var TEMPLATE_TOOT_CARD_SHORTCODE = `<article class="mastodon-toot" style="border:1px solid #ccc;border-radius:8px;padding:0.75em">
+{{ .Inner | .Page.RenderString (dict "display" "block") }}
+<footer>
+  {{- with .Get "date" }}<time datetime="{{ . }}">{{ dateFormat "2006-01-02 15:04" . }}</time>{{ end }}
+  {{- with .Get "url" }} <a href="{{ . }}">Mastodon Source 🐘</a>{{ end -}}
+</footer>
+</article>
+`
+
+// Shortcodes written by the install-layouts command, by file name
+var LAYOUT_SHORTCODES = map[string]string{
+	"cw.html":               TEMPLATE_CW_SHORTCODE,
+	"mastodon-audio.html":   TEMPLATE_AUDIO_SHORTCODE,
+	"mastodon-gallery.html": TEMPLATE_GALLERY_SHORTCODE,
+	"mastodon-search.html":  TEMPLATE_SEARCH_SHORTCODE,
+	"mastodon-toot.html":    TEMPLATE_TOOT_CARD_SHORTCODE,
+	"mastodon-video.html":   TEMPLATE_VIDEO_SHORTCODE,
+}
+
 // /////////////////////////////////////////////////////////////////////////////
 // _            _
 // __ ___ _ _  __| |_ __ _ _ _| |_ ___
@@ -72,415 +493,9901 @@ var HOST = "hachyderm.io"
 var USER = "mweagle"
 var MY_FOLLOWERS_URL = fmt.Sprintf("https://%s/users/%s/followers", HOST, USER)
 
-// /////////////////////////////////////////////////////////////////////////////
-// _
-// | |_ _  _ _ __  ___ ___
-// |  _| || | '_ \/ -_|_-<
-//  \__|\_, | .__/\___/__/
-// 	 |__/|_|
-//
-// /////////////////////////////////////////////////////////////////////////////
-
-type FilterTootFunc func(*ActivityEntry) bool
+// The ActivityStreams public collection, in all of the forms it may appear in
+// an addressing field
+var ACTIVITY_STREAMS_PUBLIC = []string{
+	"https://www.w3.org/ns/activitystreams#Public",
+	"as:Public",
+	"Public",
+}
 
-// //////////////////////////////////////////////////////////////////////////////
-// commandLineArgs
-type commandLineArgs struct {
-	inputRootPathExpandedArchive string
-	outputRootPathHugoAssets     string
-	logLevelValue                int
+var OUTPUT_FORMATS = map[string]OutputWriterFunc{
+	"hugo":      renderTootsToDisk,
+	"org":       renderOrgToDisk,
+	"obsidian":  renderObsidianToDisk,
+	"logseq":    renderLogseqToDisk,
+	"hugo-data": renderHugoDataToDisk,
+	"html":      renderHTMLToDisk,
+	"gemtext":   renderGemtextToDisk,
+	"epub":      renderEPUBToDisk,
+	"ghost":     renderGhostToDisk,
+	"microblog": renderMicroblogToDisk,
+	"dayone":    renderDayOneToDisk,
 }
 
-func (cla *commandLineArgs) parseCommandLine(log *slog.Logger) error {
-	flag.StringVar(&cla.inputRootPathExpandedArchive, "input", "", "Path to unzipped archive")
-	flag.StringVar(&cla.outputRootPathHugoAssets, "output", "", "Path to root directory for output. Existing contents will be deleted.")
-	logLevelString := ""
-	flag.StringVar(&logLevelString, "level", "INFO", "Logging verbosity level. Must be one of: {DEBUG, INFO, WARN, ERROR}")
-	flag.Parse()
+// --frontmatter formats. Frontmatter is rendered as YAML and converted to
+// the others by convertFrontmatter.
+var FRONTMATTER_FORMATS = []string{"json", "toml", "yaml"}
 
-	if (len(cla.inputRootPathExpandedArchive) <= 0) || len(cla.outputRootPathHugoAssets) <= 0 {
-		return fmt.Errorf("Invalid command line arguments")
-	}
-	expanded, expandedErr := filepath.Abs(cla.inputRootPathExpandedArchive)
-	if expandedErr != nil {
-		return fmt.Errorf("Failed to expand input path")
-	}
-	cla.inputRootPathExpandedArchive = expanded
-	expanded, expandedErr = filepath.Abs(cla.outputRootPathHugoAssets)
-	if expandedErr != nil {
-		return fmt.Errorf("Failed to expand output path")
-	}
-	cla.outputRootPathHugoAssets = expanded
-	// Parse the verbosity level
-	switch strings.ToLower(logLevelString) {
-	case "debug":
-		cla.logLevelValue = int(slog.LevelDebug)
-	case "info":
-		cla.logLevelValue = int(slog.LevelInfo)
-	case "warn":
-		cla.logLevelValue = int(slog.LevelWarn)
-	case "error":
-		cla.logLevelValue = int(slog.LevelError)
-	default:
-		return fmt.Errorf("Invalid log level specified: %s", logLevelString)
-	}
-	return nil
+// Frontmatter keys Hugo reads as dates, which TOML frontmatter writes as
+// datetimes rather than strings
+var HUGO_DATE_KEYS = []string{"date", "lastmod", "publishDate", "expiryDate"}
+
+// TOML keys that don't need quotes
+var TOML_BARE_KEY_PATTERN = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Toot audiences, from the widest to the narrowest
+var VISIBILITIES = []string{"public", "unlisted", "followers", "direct"}
+
+// Boost rendering styles for --boost-style. Boosts are skipped unless a
+// style is selected. The quote and full styles fetch the boosted toot and
+// fall back to the link style if it's unavailable.
+var BOOST_STYLES = map[string]bool{
+	"link":  true,
+	"quote": true,
+	"full":  true,
 }
 
-// /////////////////////////////////////////////////////////////////////////////
-// publishingStats
-type PublishingStats struct {
-	totalTootCount    uint
-	renderedTootCount uint
-	filteredTootCount uint
-	mediaFilesCount   uint
-	replyThreadsCount uint
+// Content warning handling for --cw-mode
+var CW_MODES = map[string]bool{
+	// Don't publish toots with a content warning
+	"skip": true,
+	// Print the warning above the content
+	"inline": true,
+	// Collapse the content into a <details> block titled with the warning
+	"fold": true,
 }
 
-// /////////////////////////////////////////////////////////////////////////////
-// ActivityObjectAttachment
-type ActivityObjectAttachment struct {
-	Type         string `json:"type"`
-	MediaType    string `json:"mediaType"`
-	URL          string `json:"url"`
-	Name         string `json:"name"`
-	BaseFilename string
-	AtomURI      string `json:"atomUri"`
-	Width        uint   `json:"width"`
-	Height       uint   `json:"height"`
+// How --content-mode renders the toot HTML in the hugo and microblog pages
+var CONTENT_MODES = map[string]func(content string) string{
+	// The HTML as published, for sites that render raw HTML in Markdown
+	"html": func(content string) string {
+		return content
+	},
+	"markdown": htmlToMarkdown,
+	// Plain text, escaped so the page's Markdown renders it as written
+	"text": func(content string) string {
+		return resolveMarkdownEscapes(escapeMarkdownText(htmlToText(content)))
+	},
 }
 
-// /////////////////////////////////////////////////////////////////////////////
-// ActivityObjectTag
-type ActivityObjectTag struct {
-	Type string `json:"type"`
-	Name string `json:"name"`
-	HREF string `json:"href"`
+// Hashtag link handling for --hashtag-mode
+var HASHTAG_MODES = map[string]bool{
+	// Link to the tag on the Mastodon server, as published
+	"mastodon": true,
+	// Link to the site's own tag page
+	"site": true,
+	// Plain #tag text
+	"text": true,
+	// Remove the hashtags from the content
+	"strip": true,
 }
 
-// /////////////////////////////////////////////////////////////////////////////
-// ActivityObject
-type ActivityObject struct {
-	Announcement string
-	ID           string                      `json:"id"`
-	Type         string                      `json:"type"`
-	InReplyTo    string                      `json:"inReplyTo"`
-	Published    string                      `json:"published"`
-	URL          string                      `json:"url"`
-	CC           []string                    `json:"cc"`
-	AtomURI      string                      `json:"atomUri"`
-	Content      string                      `json:"content"`
-	Attachments  []*ActivityObjectAttachment `json:"attachment"`
-	Tags         []*ActivityObjectTag        `json:"tag"`
+// Hashtag name normalization for --tag-style
+var TAG_STYLES = map[string]func(tagName string) string{
+	// As published, e.g. GoLang
+	"as-is": func(tagName string) string {
+		return tagName
+	},
+	// Lowercase, e.g. golang
+	"lower": strings.ToLower,
+	// Lowercase words split at CamelCase humps and punctuation, e.g.
+	// SocialMedia and social_media both become social-media
+	"slug": func(tagName string) string {
+		return tagSlug(splitCamelCase(tagName))
+	},
 }
 
-func (ao *ActivityObject) UnmarshalJSON(data []byte) error {
-	var s string
-	stringUnmarshalErr := json.Unmarshal(data, &s)
-	// If this succeeded, we need to ignore the rest of the data
-	if stringUnmarshalErr == nil {
-		ao.Announcement = s
-	} else {
-		dictMap := map[string]interface{}{}
-		objUnmarshalErr := json.Unmarshal(data, &dictMap)
-		if objUnmarshalErr != nil {
-			return objUnmarshalErr
+// Page bundle directory names for --slug-style
+var SLUG_STYLES = map[string]func(entry *ActivityEntry) string{
+	// The status ID, e.g. 2024/02/111785860775952275
+	"id": tootFileID,
+	// The publish date and status ID, e.g. 2024/02/2024-02-02-111785860775952275
+	"date-id": func(entry *ActivityEntry) string {
+		parsedDate, _ := parsePublished(entry)
+		return parsedDate.Format("2006-01-02") + "-" + tootFileID(entry)
+	},
+	// The first words of the toot, e.g. 2024/02/photo-time-with-the-cat.
+	// Toots without words use the status ID.
+	"content-slug": func(entry *ActivityEntry) string {
+		slugWords := strings.Split(tagSlug(headerExcerpt(entry.Object.Content, 1000)), "-")
+		slug := strings.Join(slugWords[:min(len(slugWords), CONTENT_SLUG_WORDS)], "-")
+		if len(slug) <= 0 {
+			return tootFileID(entry)
 		}
-		ao.ID = jsonScalar[string]("id", dictMap)
-		ao.Type = jsonScalar[string]("type", dictMap)
-		ao.InReplyTo = jsonScalar[string]("inReplyTo", dictMap)
-		ao.Published = jsonScalar[string]("published", dictMap)
-		ao.URL = jsonScalar[string]("url", dictMap)
-		ao.AtomURI = jsonScalar[string]("atomUri", dictMap)
-		ao.Content = jsonScalar[string]("content", dictMap)
+		return slug
+	},
+}
 
-		fieldValue, fieldValueExists := dictMap["cc"]
-		if fieldValueExists {
-			jsonBytes, _ := json.Marshal(fieldValue)
-			fieldUnmarshalErr := json.Unmarshal(jsonBytes, &ao.CC)
-			if fieldUnmarshalErr != nil {
-				return fieldUnmarshalErr
-			}
-		}
+// Number of words in a content-slug style page bundle name
+var CONTENT_SLUG_WORDS = 6
 
-		fieldValue, fieldValueExists = dictMap["attachment"]
-		if fieldValueExists {
-			jsonBytes, _ := json.Marshal(fieldValue)
-			fieldUnmarshalErr := json.Unmarshal(jsonBytes, &ao.Attachments)
-			if fieldUnmarshalErr != nil {
-				return fieldUnmarshalErr
-			}
-			// For each one, update the BaseFilename to make the template
-			// easier
-			for _, eachAttachment := range ao.Attachments {
-				urlPathParts := strings.Split(eachAttachment.URL, "/")
-				eachAttachment.BaseFilename = urlPathParts[len(urlPathParts)-1]
-			}
+// Frontmatter keys added for the --theme-preset Hugo themes, given the
+// frontmatter template parameters. The summary replaces Hugo's automatic
+// one, which would start with the header image and raw toot markup.
+var THEME_PRESETS = map[string]func(templateParams map[string]interface{}) map[string]interface{}{
+	// PaperMod lists the cover image, which is hidden on the page since the
+	// toot already shows it
+	"papermod": func(templateParams map[string]interface{}) map[string]interface{} {
+		presetValues := map[string]interface{}{
+			"summary":         templateParams["Description"],
+			"ShowToc":         false,
+			"ShowReadingTime": false,
+			"ShowWordCount":   false,
 		}
-		fieldValue, fieldValueExists = dictMap["tag"]
-		if fieldValueExists {
-			jsonBytes, _ := json.Marshal(fieldValue)
-			fieldUnmarshalErr := json.Unmarshal(jsonBytes, &ao.Tags)
-			if fieldUnmarshalErr != nil {
-				return fieldUnmarshalErr
-			}
-			// Remove any hashtags from the tags...
-			for _, eachTag := range ao.Tags {
-				eachTag.Name = strings.Replace(eachTag.Name, "#", "", -1)
+		if images := templateParams["Images"].([]string); len(images) != 0 {
+			presetValues["cover"] = map[string]interface{}{
+				"image":          images[0],
+				"alt":            templateParams["ImageAlt"],
+				"hiddenInSingle": true,
 			}
 		}
-		// Always add a "Social Media" tag
-		if len(ao.Tags) <= 0 {
-			ao.Tags = make([]*ActivityObjectTag, 0)
+		return presetValues
+	},
+	// Ananke uses the featured image as the page header
+	"ananke": func(templateParams map[string]interface{}) map[string]interface{} {
+		presetValues := map[string]interface{}{
+			"summary": templateParams["Description"],
 		}
-		ao.Tags = append(ao.Tags, &ActivityObjectTag{
-			Type: "Hashtag",
-			HREF: fmt.Sprintf("https://%s/tags/social%20media", HOST),
-			Name: "Social Media",
-		})
-	}
-	return nil
+		if images := templateParams["Images"].([]string); len(images) != 0 {
+			presetValues["featured_image"] = images[0]
+		}
+		return presetValues
+	},
+	// Stack already reads the image and description keys. Toots are too
+	// short for its table of contents.
+	"stack": func(templateParams map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"toc": false,
+		}
+	},
 }
 
-// /////////////////////////////////////////////////////////////////////////////
-// ActivityEntry
-type ActivityEntry struct {
-	ID        string          `json:"id"`
-	Type      string          `json:"type"`
-	Published string          `json:"published"`
-	CC        []string        `json:"cc"`
-	Object    *ActivityObject `json:"object"`
+// Generated page strings for --lang, by message key. The <key>One messages
+// are used for a count of one. Messages missing from a catalog use the
+// English ones.
+var MESSAGE_CATALOGS = map[string]messageCatalog{
+	"en": {
+		"AllToots":              "All toots",
+		"Boosted":               "Boosted",
+		"ContentWarning":        "Content Warning",
+		"InReplyTo":             "In reply to",
+		"Pinned":                "Pinned",
+		"PinnedDescription":     "%d pinned toots",
+		"PinnedDescriptionOne":  "%d pinned toot",
+		"SectionDescription":    "%d toots",
+		"SectionDescriptionOne": "%d toot",
+		"SectionTitle":          "Toots from %s",
+		"SensitiveMedia":        "Sensitive media",
+		"Source":                "Mastodon Source 🐘",
+		"TagDescription":        "%d toots tagged #%s",
+		"TagDescriptionOne":     "%d toot tagged #%s",
+		"Thread":                "Thread: %s",
+		"ThreadDescription":     "A thread in %d parts",
+	},
+	"de": {
+		"AllToots":              "Alle Toots",
+		"Boosted":               "Geteilt",
+		"ContentWarning":        "Inhaltswarnung",
+		"InReplyTo":             "Antwort auf",
+		"Pinned":                "Angeheftet",
+		"PinnedDescription":     "%d angeheftete Toots",
+		"PinnedDescriptionOne":  "%d angehefteter Toot",
+		"SectionDescription":    "%d Toots",
+		"SectionDescriptionOne": "%d Toot",
+		"SectionTitle":          "Toots aus %s",
+		"SensitiveMedia":        "Sensible Medien",
+		"Source":                "Mastodon-Quelle 🐘",
+		"TagDescription":        "%d Toots mit #%s",
+		"TagDescriptionOne":     "%d Toot mit #%s",
+		"Thread":                "Thread: %s",
+		"ThreadDescription":     "Ein Thread in %d Teilen",
+	},
+	"es": {
+		"AllToots":              "Todas las publicaciones",
+		"Boosted":               "Impulsado",
+		"ContentWarning":        "Advertencia de contenido",
+		"InReplyTo":             "En respuesta a",
+		"Pinned":                "Fijadas",
+		"PinnedDescription":     "%d publicaciones fijadas",
+		"PinnedDescriptionOne":  "%d publicación fijada",
+		"SectionDescription":    "%d publicaciones",
+		"SectionDescriptionOne": "%d publicación",
+		"SectionTitle":          "Publicaciones de %s",
+		"SensitiveMedia":        "Contenido sensible",
+		"Source":                "Fuente en Mastodon 🐘",
+		"TagDescription":        "%d publicaciones con #%s",
+		"TagDescriptionOne":     "%d publicación con #%s",
+		"Thread":                "Hilo: %s",
+		"ThreadDescription":     "Un hilo en %d partes",
+	},
+	"fr": {
+		"AllToots":              "Tous les messages",
+		"Boosted":               "Partagé",
+		"ContentWarning":        "Avertissement de contenu",
+		"InReplyTo":             "En réponse à",
+		"Pinned":                "Épinglés",
+		"PinnedDescription":     "%d messages épinglés",
+		"PinnedDescriptionOne":  "%d message épinglé",
+		"SectionDescription":    "%d messages",
+		"SectionDescriptionOne": "%d message",
+		"SectionTitle":          "Messages de %s",
+		"SensitiveMedia":        "Média sensible",
+		"Source":                "Source Mastodon 🐘",
+		"TagDescription":        "%d messages avec #%s",
+		"TagDescriptionOne":     "%d message avec #%s",
+		"Thread":                "Fil : %s",
+		"ThreadDescription":     "Un fil en %d parties",
+	},
 }
 
-// /////////////////////////////////////////////////////////////////////////////
-// Outbox
-type Outbox struct {
-	TotalItems           uint             `json:"totalItems"`
-	OrderedItems         []*ActivityEntry `json:"orderedItems"`
-	ArchiveDirectoryRoot string
-	ThreadIDChain        map[string]*ActivityEntry
+// Mention link handling for --mention-mode
+var MENTION_MODES = map[string]bool{
+	// Link to the profile, as published
+	"link": true,
+	// Plain @user@domain text
+	"handle": true,
+	// Plain @user text, without the domain
+	"short": true,
+	// Remove the mentions from the content
+	"remove": true,
 }
 
-func (ob *Outbox) filterToots(filterFunc FilterTootFunc) {
-	filteredToots := []*ActivityEntry{}
-	for _, eachEntry := range ob.OrderedItems {
-		if filterFunc(eachEntry) {
-			filteredToots = append(filteredToots, eachEntry)
-		}
-	}
-	ob.OrderedItems = filteredToots
+// The h-card wrapper of a removed mention link
+var EMPTY_HCARD_PATTERN = regexp.MustCompile(`<span class="h-card"[^>]*>\s*</span>`)
+
+// Whitespace left at the start of a paragraph
+var PARAGRAPH_LEADING_SPACE_PATTERN = regexp.MustCompile(`<p>\s+`)
+
+// Default --url-shorteners, whose links --expand-urls resolves
+var URL_SHORTENERS = "t.co,bit.ly,buff.ly,ow.ly,tinyurl.com,goo.gl,is.gd,dlvr.it,fb.me,lnkd.in,trib.al,amzn.to,tr.im,j.mp,shorturl.at,rebrand.ly,cutt.ly"
+
+// Default --tracking-params. A trailing * matches any parameter with the prefix
+var TRACKING_PARAMETERS = "utm_*,fbclid,gclid,dclid,gbraid,wbraid,msclkid,yclid,mc_cid,mc_eid,igshid,_hsenc,_hsmi,mkt_tok,ref_src,ref_url"
+
+// Anchor elements of toot HTML, with their href and contents
+var HTML_ANCHOR_PATTERN = regexp.MustCompile(`(?s)<a\s([^>]*?)href="([^"]*)"([^>]*)>(.*?)</a>`)
+
+// Length of the visible part of a link's URL text, as rendered by Mastodon
+var MASTODON_LINK_TEXT_LENGTH = 30
+
+// Toots with at most this many characters besides their links are mostly a
+// URL, and get a --link-previews card
+var LINK_PREVIEW_MAX_TEXT_LENGTH = 100
+
+// Most of a linked page read for its --link-previews metadata, which is in
+// the <head>
+var LINK_PREVIEW_MAX_PAGE_BYTES int64 = 1 << 20
+
+// HTML <meta> and <title> elements, and the attributes of an element
+var HTML_META_PATTERN = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+var HTML_TITLE_PATTERN = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+var HTML_ATTRIBUTE_PATTERN = regexp.MustCompile(`([A-Za-z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+// Custom emoji shortcodes --custom-emoji renders as images, e.g. :blobcat:
+var CUSTOM_EMOJI_NAME_PATTERN = regexp.MustCompile(`^:[A-Za-z0-9_]+:$`)
+
+// A paragraph left empty, for example by --hashtag-mode strip
+var EMPTY_PARAGRAPH_PATTERN = regexp.MustCompile(`<p>\s*</p>`)
+
+// Whitespace left at the end of a paragraph
+var PARAGRAPH_TRAILING_SPACE_PATTERN = regexp.MustCompile(`\s+</p>`)
+
+// Handling of media in toots marked sensitive, for --sensitive-media
+var SENSITIVE_MEDIA_MODES = map[string]bool{
+	// Render the media like any other toot
+	"show": true,
+	// Collapse the media into a <details> block
+	"fold": true,
+	// Wrap the media in a <div> with the --sensitive-class classes
+	"blur": true,
+	// Don't publish toots with sensitive media
+	"skip": true,
 }
 
-func jsonScalar[V any](key string, dict map[string]interface{}) V {
-	curVal, curValOk := dict[key]
-	if !curValOk {
-		curVal = new(V)
-	}
-	typedVal, typedValOk := curVal.(V)
-	if !typedValOk {
-		return *new(V)
-	}
-	return typedVal
+// Mastodon renders mentions as <a href="..." class="u-url mention">, and
+// hashtags as <a href="..." class="mention hashtag">
+var MENTION_LINK_PATTERN = regexp.MustCompile(`<a\s[^>]*class="[^"]*\bmention\b[^"]*"[^>]*>.*?</a>`)
+
+// Image transcoding for --image-format: the command that encodes each format
+// and the arguments for the quality, input and output paths
+var IMAGE_FORMATS = map[string]*imageFormat{
+	"webp": {
+		mediaType: "image/webp",
+		command:   "cwebp",
+		arguments: func(quality int, inputPath string, outputPath string) []string {
+			return []string{"-quiet", "-q", strconv.Itoa(quality), inputPath, "-o", outputPath}
+		},
+	},
+	"avif": {
+		mediaType: "image/avif",
+		command:   "avifenc",
+		arguments: func(quality int, inputPath string, outputPath string) []string {
+			return []string{"-q", strconv.Itoa(quality), inputPath, outputPath}
+		},
+	},
 }
 
-func selfPublishFilter(entry *ActivityEntry) bool {
-	selfReplyToURL := fmt.Sprintf("https://%s/users/%s", HOST, USER)
-	// Include only Create toots
-	if entry.Type != "Create" {
-		return false
-	}
-	// Include self-replies only
-	if len(entry.Object.InReplyTo) != 0 &&
-		!strings.HasPrefix(entry.Object.InReplyTo, selfReplyToURL) {
-		return false
-	}
-	// ok, what about CCs
-	if len(entry.Object.CC) > 1 || !slices.Contains(entry.Object.CC, MY_FOLLOWERS_URL) {
-		return false
-	}
-	return true
+// How --redact treats toots containing a listed term
+var REDACT_MODES = map[string]bool{
+	// Don't publish the toot
+	"skip": true,
+	// Replace every occurrence of the term with REDACTED_TEXT
+	"replace": true,
 }
 
-func newOutbox(inputFile string) (*Outbox, error) {
-	inputData, inputDataErr := os.ReadFile(inputFile)
-	if inputDataErr != nil {
-		return nil, inputDataErr
-	}
-	outbox := Outbox{}
-	err := json.Unmarshal(inputData, &outbox)
-	if err != nil {
-		return nil, err
-	}
-	// Get the input file source. That's the root directory
-	// for all media references
-	outbox.ArchiveDirectoryRoot = path.Dir(inputFile)
+// Fixed length, so the replacement doesn't reveal the redacted term
+var REDACTED_TEXT = "█████"
 
-	// For each activity, find the root thread element, which may be empty...
-	outbox.ThreadIDChain = map[string]*ActivityEntry{}
-	for _, eachActivity := range outbox.OrderedItems {
-		outbox.ThreadIDChain[eachActivity.Object.ID] = eachActivity
+// Hugo shortcode names --audio-shortcode and --gallery-shortcode accept
+var SHORTCODE_NAME_PATTERN = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Runs of HTML whitespace, which render as a single space
+var HTML_WHITESPACE_PATTERN = regexp.MustCompile(`[ \t\r\n]+`)
+
+// Runs of blank lines in converted Markdown, collapsed to one
+var MARKDOWN_BLANK_LINES_PATTERN = regexp.MustCompile(`\n{3,}`)
+
+// Backslash escapes for the characters Goldmark may read as inline Markdown
+// in toot text
+var MARKDOWN_INLINE_ESCAPER = strings.NewReplacer(`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`, "~", `\~`)
+
+// Characters that would end a Markdown link destination early
+var MARKDOWN_URL_ESCAPER = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29")
+
+// Text that starts a heading, block quote, list or thematic break when it
+// begins a line
+var MARKDOWN_LINE_START_PATTERN = regexp.MustCompile(`^( *)(\d{1,9}[.)]|[#>+=-])`)
+
+// Marks a line start escape in converted text, which is only known to be
+// needed once the text is placed in its block. See resolveMarkdownEscapes.
+var MARKDOWN_LINE_START_ESCAPE = "\uE000"
+
+// Line start escape marks that begin a line, after any indent or list number
+var MARKDOWN_LINE_START_ESCAPE_PATTERN = regexp.MustCompile(`(?m)^( *\d*)` + MARKDOWN_LINE_START_ESCAPE)
+
+// Link text that is a URL or bare domain, e.g. Mastodon's shortened
+// "example.com/post…" link text, and URLs in the text, which
+// headerExcerpt drops
+var URL_TEXT_PATTERN = regexp.MustCompile(`(?i)^(https?://)?[\w-]+(\.[\w-]+)+(/\S*)?…?$`)
+var TEXT_URL_PATTERN = regexp.MustCompile(`(?i)\bhttps?://\S+`)
+
+// Markdown syntax typed into toots, which headerExcerpt reduces to its text
+var MARKDOWN_LINK_SYNTAX_PATTERN = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+var MARKDOWN_EMPHASIS_SYNTAX_PATTERN = regexp.MustCompile("\\*+|_{2,}|~~|`+")
+var MARKDOWN_BLOCK_SYNTAX_PATTERN = regexp.MustCompile(`(?m)^\s*(#{1,6}|>+)\s+`)
+
+// Words in the toot text that --keywords counts, including contractions
+var KEYWORD_WORD_PATTERN = regexp.MustCompile(`\pL[\pL\pN]*(['’]\pL+)?`)
+
+// Custom emoji :shortcode: text, which isn't a keyword
+var CUSTOM_EMOJI_TEXT_PATTERN = regexp.MustCompile(`:[A-Za-z0-9_]+:`)
+
+// Shortest word, in characters, --keywords uses
+var KEYWORD_MIN_LENGTH = 4
+
+// Common English words --keywords skips
+var KEYWORD_STOPWORDS = map[string]bool{
+	"about": true, "above": true, "after": true, "again": true, "also": true, "always": true, "another": true,
+	"anyone": true, "anything": true, "around": true, "because": true, "been": true, "before": true,
+	"being": true, "below": true, "best": true, "better": true, "between": true, "both": true,
+	"can't": true, "come": true, "could": true, "couldn't": true, "didn't": true, "does": true,
+	"doesn't": true, "doing": true, "done": true, "don't": true, "down": true, "during": true,
+	"each": true, "even": true, "ever": true, "every": true, "first": true, "from": true, "further": true,
+	"gets": true, "getting": true, "going": true, "gonna": true, "good": true, "great": true,
+	"have": true, "haven't": true, "having": true, "here": true, "here's": true, "into": true,
+	"it's": true, "just": true, "know": true, "last": true, "like": true, "little": true, "look": true,
+	"made": true, "make": true, "many": true, "maybe": true, "more": true, "most": true, "much": true,
+	"must": true, "need": true, "never": true, "next": true, "nothing": true, "once": true, "only": true,
+	"other": true, "over": true, "really": true, "right": true, "said": true, "same": true, "should": true,
+	"since": true, "some": true, "something": true, "still": true, "such": true, "sure": true,
+	"take": true, "than": true, "that": true, "that's": true, "their": true, "them": true, "then": true,
+	"there": true, "there's": true, "these": true, "they": true, "they're": true, "thing": true,
+	"things": true, "think": true, "this": true, "those": true, "though": true, "through": true,
+	"time": true, "today": true, "under": true, "until": true, "very": true, "want": true,
+	"wasn't": true, "well": true, "were": true, "what": true, "what's": true, "when": true, "where": true,
+	"which": true, "while": true, "will": true, "with": true, "without": true, "won't": true,
+	"would": true, "yeah": true, "year": true, "your": true, "you're": true, "yours": true,
+}
+
+// Space separated CSS class names --sensitive-class accepts
+var CSS_CLASSES_PATTERN = regexp.MustCompile(`^[A-Za-z_-][A-Za-z0-9_-]*( [A-Za-z_-][A-Za-z0-9_-]*)*$`)
+
+// Frontmatter keys --frontmatter-params accepts
+var FRONTMATTER_KEY_PATTERN = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// Keys the built-in hugo and microblog frontmatter writes, which
+// --frontmatter-params can't repeat unless --frontmatter-template replaces it
+var BUILTIN_FRONTMATTER_KEYS = []string{"aliases", "audio", "canonical", "categories", "date", "description", "draft", "featured", "image", "images", "keywords", "language", "lastmod", "photos", "placeholders", "resources", "series", "subtitle", "tags", "title", "videos", "weight"}
+
+// How --media-mode puts each media file in the output. Media processors
+// replace files rather than writing to them, so linked archive files are
+// never modified.
+var MEDIA_MODES = map[string]func(sourceFilePath string, destFilePath string) (int64, error){
+	"copy": copyMediaFile,
+	// Falls back to a copy when the output is on another file system
+	"hardlink": func(sourceFilePath string, destFilePath string) (int64, error) {
+		if os.Link(sourceFilePath, destFilePath) != nil {
+			return copyMediaFile(sourceFilePath, destFilePath)
+		}
+		return mediaFileSize(destFilePath)
+	},
+	"symlink": func(sourceFilePath string, destFilePath string) (int64, error) {
+		absoluteSourcePath, absoluteSourcePathErr := filepath.Abs(sourceFilePath)
+		if absoluteSourcePathErr != nil {
+			return 0, absoluteSourcePathErr
+		}
+		symlinkErr := os.Symlink(absoluteSourcePath, destFilePath)
+		if symlinkErr != nil {
+			return 0, symlinkErr
+		}
+		return mediaFileSize(destFilePath)
+	},
+}
+
+// Suffix of the temporary files output files are written to before they're
+// renamed into place. The next run deletes those an interrupted run left.
+var ATOMIC_WRITE_SUFFIX = ".mastodon-to-hugo.tmp"
+
+// Name of the --skip-unchanged-media manifest in the output root
+var MEDIA_MANIFEST_FILENAME = ".media-manifest.json"
+
+// Name of the --incremental state file in the output root
+var RENDER_STATE_FILENAME = ".render-state.json"
+
+// Name of the list of generated files in the output root, which --clean
+// limits deletion to
+var OUTPUT_MANIFEST_FILENAME = "mastodon-to-hugo.manifest.json"
+
+// Name of the lockfile in the output root that keeps concurrent runs out
+var OUTPUT_LOCK_FILENAME = ".mastodon-to-hugo.lock"
+
+// Number of hashtags the stats command lists
+var STATS_HASHTAG_COUNT = 10
+
+// Prefix of the --backup tarball names, which are followed by the time
+var BACKUP_FILENAME_PREFIX = "mastodon-to-hugo-"
+
+// The generation time comment of the pages and Hugo config, which --dry-run
+// ignores when comparing files
+var GENERATED_COMMENT_PATTERN = regexp.MustCompile(`(?m)^# generated: .*$`)
+
+// Lines of context around the changes in the --dry-run-diff diffs
+var DRY_RUN_DIFF_CONTEXT = 3
+
+// Largest line count product of two files --dry-run-diff compares, as the
+// diff table uses memory in proportion to it
+var DRY_RUN_DIFF_MAX_CELLS = 4000000
+
+// Width in pixels of the --blurhash placeholder images. The height follows
+// the attachment's aspect ratio.
+var BLURHASH_PLACEHOLDER_WIDTH = 32
+
+// Digits of the blurhash base 83 encoding
+var BLURHASH_BASE83_CHARACTERS = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// File name of the --og-images share card written to the page bundle
+var OG_IMAGE_FILENAME = "og-image.png"
+
+// Size in pixels of the --og-images share cards, the Open Graph 1.91:1 ratio
+var OG_IMAGE_WIDTH = 1200
+var OG_IMAGE_HEIGHT = 630
+
+// Colors --og-image-background accepts
+var HEX_COLOR_PATTERN = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// Toot languages --multilingual uses as content directory names, e.g. en or
+// pt-br
+var LANGUAGE_CODE_PATTERN = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]+)*$`)
+
+// 5x8 pixel glyphs for printable ASCII, starting with the space, that the
+// built-in --og-images cards are drawn with. Each byte is a column, with the
+// top row in the lowest bit.
+var OG_IMAGE_FONT = [][5]byte{
+	{0x00, 0x00, 0x00, 0x00, 0x00}, {0x00, 0x00, 0x5F, 0x00, 0x00}, {0x00, 0x07, 0x00, 0x07, 0x00}, {0x14, 0x7F, 0x14, 0x7F, 0x14},
+	{0x24, 0x2A, 0x7F, 0x2A, 0x12}, {0x23, 0x13, 0x08, 0x64, 0x62}, {0x36, 0x49, 0x56, 0x20, 0x50}, {0x00, 0x08, 0x07, 0x03, 0x00},
+	{0x00, 0x1C, 0x22, 0x41, 0x00}, {0x00, 0x41, 0x22, 0x1C, 0x00}, {0x2A, 0x1C, 0x7F, 0x1C, 0x2A}, {0x08, 0x08, 0x3E, 0x08, 0x08},
+	{0x00, 0x80, 0x70, 0x30, 0x00}, {0x08, 0x08, 0x08, 0x08, 0x08}, {0x00, 0x00, 0x60, 0x60, 0x00}, {0x20, 0x10, 0x08, 0x04, 0x02},
+	{0x3E, 0x51, 0x49, 0x45, 0x3E}, {0x00, 0x42, 0x7F, 0x40, 0x00}, {0x72, 0x49, 0x49, 0x49, 0x46}, {0x21, 0x41, 0x49, 0x4D, 0x33},
+	{0x18, 0x14, 0x12, 0x7F, 0x10}, {0x27, 0x45, 0x45, 0x45, 0x39}, {0x3C, 0x4A, 0x49, 0x49, 0x31}, {0x41, 0x21, 0x11, 0x09, 0x07},
+	{0x36, 0x49, 0x49, 0x49, 0x36}, {0x46, 0x49, 0x49, 0x29, 0x1E}, {0x00, 0x00, 0x14, 0x00, 0x00}, {0x00, 0x40, 0x34, 0x00, 0x00},
+	{0x00, 0x08, 0x14, 0x22, 0x41}, {0x14, 0x14, 0x14, 0x14, 0x14}, {0x00, 0x41, 0x22, 0x14, 0x08}, {0x02, 0x01, 0x59, 0x09, 0x06},
+	{0x3E, 0x41, 0x5D, 0x59, 0x4E}, {0x7C, 0x12, 0x11, 0x12, 0x7C}, {0x7F, 0x49, 0x49, 0x49, 0x36}, {0x3E, 0x41, 0x41, 0x41, 0x22},
+	{0x7F, 0x41, 0x41, 0x41, 0x3E}, {0x7F, 0x49, 0x49, 0x49, 0x41}, {0x7F, 0x09, 0x09, 0x09, 0x01}, {0x3E, 0x41, 0x41, 0x51, 0x73},
+	{0x7F, 0x08, 0x08, 0x08, 0x7F}, {0x00, 0x41, 0x7F, 0x41, 0x00}, {0x20, 0x40, 0x41, 0x3F, 0x01}, {0x7F, 0x08, 0x14, 0x22, 0x41},
+	{0x7F, 0x40, 0x40, 0x40, 0x40}, {0x7F, 0x02, 0x1C, 0x02, 0x7F}, {0x7F, 0x04, 0x08, 0x10, 0x7F}, {0x3E, 0x41, 0x41, 0x41, 0x3E},
+	{0x7F, 0x09, 0x09, 0x09, 0x06}, {0x3E, 0x41, 0x51, 0x21, 0x5E}, {0x7F, 0x09, 0x19, 0x29, 0x46}, {0x26, 0x49, 0x49, 0x49, 0x32},
+	{0x03, 0x01, 0x7F, 0x01, 0x03}, {0x3F, 0x40, 0x40, 0x40, 0x3F}, {0x1F, 0x20, 0x40, 0x20, 0x1F}, {0x3F, 0x40, 0x38, 0x40, 0x3F},
+	{0x63, 0x14, 0x08, 0x14, 0x63}, {0x03, 0x04, 0x78, 0x04, 0x03}, {0x61, 0x59, 0x49, 0x4D, 0x43}, {0x00, 0x7F, 0x41, 0x41, 0x41},
+	{0x02, 0x04, 0x08, 0x10, 0x20}, {0x00, 0x41, 0x41, 0x41, 0x7F}, {0x04, 0x02, 0x01, 0x02, 0x04}, {0x40, 0x40, 0x40, 0x40, 0x40},
+	{0x00, 0x03, 0x07, 0x08, 0x00}, {0x20, 0x54, 0x54, 0x78, 0x40}, {0x7F, 0x28, 0x44, 0x44, 0x38}, {0x38, 0x44, 0x44, 0x44, 0x28},
+	{0x38, 0x44, 0x44, 0x28, 0x7F}, {0x38, 0x54, 0x54, 0x54, 0x18}, {0x00, 0x08, 0x7E, 0x09, 0x02}, {0x18, 0xA4, 0xA4, 0x9C, 0x78},
+	{0x7F, 0x08, 0x04, 0x04, 0x78}, {0x00, 0x44, 0x7D, 0x40, 0x00}, {0x20, 0x40, 0x40, 0x3D, 0x00}, {0x7F, 0x10, 0x28, 0x44, 0x00},
+	{0x00, 0x41, 0x7F, 0x40, 0x00}, {0x7C, 0x04, 0x78, 0x04, 0x78}, {0x7C, 0x08, 0x04, 0x04, 0x78}, {0x38, 0x44, 0x44, 0x44, 0x38},
+	{0xFC, 0x18, 0x24, 0x24, 0x18}, {0x18, 0x24, 0x24, 0x18, 0xFC}, {0x7C, 0x08, 0x04, 0x04, 0x08}, {0x48, 0x54, 0x54, 0x54, 0x24},
+	{0x04, 0x04, 0x3F, 0x44, 0x24}, {0x3C, 0x40, 0x40, 0x20, 0x7C}, {0x1C, 0x20, 0x40, 0x20, 0x1C}, {0x3C, 0x40, 0x30, 0x40, 0x3C},
+	{0x44, 0x28, 0x10, 0x28, 0x44}, {0x4C, 0x90, 0x90, 0x90, 0x7C}, {0x44, 0x64, 0x54, 0x4C, 0x44}, {0x00, 0x08, 0x36, 0x41, 0x00},
+	{0x00, 0x00, 0x77, 0x00, 0x00}, {0x00, 0x41, 0x36, 0x08, 0x00}, {0x02, 0x01, 0x02, 0x04, 0x02},
+}
+
+// ASCII stand-ins for the punctuation and Latin letters the OG_IMAGE_FONT
+// doesn't have. Other characters, e.g. emoji, are left out of the card.
+var OG_IMAGE_FOLDED_RUNES = strings.NewReplacer(
+	"‘", "'", "’", "'", "“", "\"", "”", "\"", "–", "-", "—", "-", "…", "...", "·", "-", "\u00a0", " ",
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "ç", "c", "è", "e", "é", "e", "ê", "e", "ë", "e",
+	"ì", "i", "í", "i", "î", "i", "ï", "i", "ñ", "n", "ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u", "ý", "y", "ÿ", "y", "ß", "ss",
+	"À", "A", "Á", "A", "Â", "A", "Ã", "A", "Ä", "A", "Å", "A", "Ç", "C", "È", "E", "É", "E", "Ê", "E", "Ë", "E",
+	"Ì", "I", "Í", "I", "Î", "I", "Ï", "I", "Ñ", "N", "Ò", "O", "Ó", "O", "Ô", "O", "Õ", "O", "Ö", "O", "Ø", "O",
+	"Ù", "U", "Ú", "U", "Û", "U", "Ü", "U", "Ý", "Y",
+)
+
+// Redirect file syntaxes for --redirects. Each func formats a single rule
+var REDIRECT_FORMATS = map[string]redirectFormat{
+	// Netlify/Cloudflare Pages _redirects file
+	"netlify": {
+		rule: func(fromPath string, toURL string) string {
+			return fmt.Sprintf("%s %s 301", fromPath, toURL)
+		},
+	},
+	// Caddyfile directives, for inclusion in a site block
+	"caddy": {
+		rule: func(fromPath string, toURL string) string {
+			return fmt.Sprintf("redir %s %s permanent", fromPath, toURL)
+		},
+	},
+	// nginx map, used with: if ($mastodon_redirect) { return 301 $mastodon_redirect; }
+	"nginx": {
+		header: "map $uri $mastodon_redirect {",
+		rule: func(fromPath string, toURL string) string {
+			return fmt.Sprintf("    %s %s;", fromPath, toURL)
+		},
+		footer: "}",
+	},
+}
+
+// Formats whose --output is the Hugo site root rather than a content
+// directory. These writers manage their own subdirectories, which are all
+// that --clean deletes from.
+var SITE_ROOT_OUTPUT_FORMATS = map[string]bool{
+	"hugo-data": true,
+}
+
+var HUGO_GROUP_BY_MODES = map[string]*hugoGroupByMode{
+	// One page bundle per thread, named for the root toot
+	"bundle": {
+		bundlePath: func(entry *ActivityEntry, threadRoot *ActivityEntry) (string, error) {
+			return tootBundlePath(threadRoot)
+		},
+		pageTitle: func(firstToot *ActivityEntry) string {
+			return fmt.Sprintf("Mastodon - %s", firstToot.Published)
+		},
+		sectionHeading: func(entry *ActivityEntry, threadRoot *ActivityEntry) string {
+			return ""
+		},
+		permalink: ":year/:month/:filename/",
+	},
+	// One page bundle per thread dated at the root, with an anchored section
+	// for every reply, even when the replies span several days
+	"thread": {
+		bundlePath: func(entry *ActivityEntry, threadRoot *ActivityEntry) (string, error) {
+			return tootBundlePath(threadRoot)
+		},
+		pageTitle: func(firstToot *ActivityEntry) string {
+			return fmt.Sprintf("Mastodon - %s", firstToot.Published)
+		},
+		sectionHeading: func(entry *ActivityEntry, threadRoot *ActivityEntry) string {
+			if entry == threadRoot {
+				return ""
+			}
+			parsedDate, _ := parsePublished(entry)
+			return fmt.Sprintf("%s {#%s}", parsedDate.Format("2006-01-02 15:04"), tootAnchorID(entry))
+		},
+		permalink: ":year/:month/:filename/",
+	},
+	// One page bundle per toot, including self-replies, each with its own
+	// frontmatter date and permalink
+	"toot": {
+		bundlePath: func(entry *ActivityEntry, threadRoot *ActivityEntry) (string, error) {
+			return tootBundlePath(entry)
+		},
+		pageTitle: func(firstToot *ActivityEntry) string {
+			return fmt.Sprintf("Mastodon - %s", firstToot.Published)
+		},
+		sectionHeading: func(entry *ActivityEntry, threadRoot *ActivityEntry) string {
+			return ""
+		},
+		permalink: ":year/:month/:filename/",
+	},
+	// One YYYY-MM page bundle per month with a section per day. Threads
+	// are placed in the month and day of their root toot.
+	"month": {
+		bundlePath: func(entry *ActivityEntry, threadRoot *ActivityEntry) (string, error) {
+			dayKey, dayKeyErr := publishedDayKey(threadRoot)
+			if dayKeyErr != nil {
+				return "", dayKeyErr
+			}
+			return dayKey[:len("2006-01")], nil
+		},
+		pageTitle: func(firstToot *ActivityEntry) string {
+			return fmt.Sprintf("Mastodon - %s", firstToot.Published[:len("2006-01")])
+		},
+		sectionHeading: func(entry *ActivityEntry, threadRoot *ActivityEntry) string {
+			dayKey, _ := publishedDayKey(threadRoot)
+			return dayKey
+		},
+		permalink: ":year-:month/",
+	},
+	// One page bundle per year with a section per month, a table of contents
+	// and an anchor for every toot
+	"year": {
+		bundlePath: func(entry *ActivityEntry, threadRoot *ActivityEntry) (string, error) {
+			parsedDate, parsedDateErr := parsePublished(threadRoot)
+			if parsedDateErr != nil {
+				return "", parsedDateErr
+			}
+			return fmt.Sprintf("%d", parsedDate.Year()), nil
+		},
+		pageTitle: func(firstToot *ActivityEntry) string {
+			return fmt.Sprintf("Mastodon - %s", firstToot.Published[:len("2006")])
+		},
+		sectionHeading: func(entry *ActivityEntry, threadRoot *ActivityEntry) string {
+			parsedDate, _ := parsePublished(threadRoot)
+			return parsedDate.Format("January 2006")
+		},
+		tableOfContents: true,
+		permalink:       ":year/",
+	},
+}
+
+func groupByModeNames() []string {
+	modeNames := []string{}
+	for eachName := range HUGO_GROUP_BY_MODES {
+		modeNames = append(modeNames, eachName)
 	}
-	return &outbox, nil
+	slices.Sort(modeNames)
+	return modeNames
 }
 
-type cleanupFunc func(log *slog.Logger)
+func boostStyleNames() []string {
+	return slices.Sorted(maps.Keys(BOOST_STYLES))
+}
+
+func cwModeNames() []string {
+	return slices.Sorted(maps.Keys(CW_MODES))
+}
+
+func hashtagModeNames() []string {
+	return slices.Sorted(maps.Keys(HASHTAG_MODES))
+}
+
+func tagStyleNames() []string {
+	return slices.Sorted(maps.Keys(TAG_STYLES))
+}
+
+func slugStyleNames() []string {
+	return slices.Sorted(maps.Keys(SLUG_STYLES))
+}
+
+func themePresetNames() []string {
+	return slices.Sorted(maps.Keys(THEME_PRESETS))
+}
+
+func mentionModeNames() []string {
+	return slices.Sorted(maps.Keys(MENTION_MODES))
+}
+
+func contentModeNames() []string {
+	return slices.Sorted(maps.Keys(CONTENT_MODES))
+}
+
+func sensitiveMediaModeNames() []string {
+	return slices.Sorted(maps.Keys(SENSITIVE_MEDIA_MODES))
+}
+
+func redirectFormatNames() []string {
+	return slices.Sorted(maps.Keys(REDIRECT_FORMATS))
+}
+
+func outputFormatNames() []string {
+	formatNames := []string{}
+	for eachName := range OUTPUT_FORMATS {
+		formatNames = append(formatNames, eachName)
+	}
+	slices.Sort(formatNames)
+	return formatNames
+}
 
 // /////////////////////////////////////////////////////////////////////////////
-//  __              _   _
-// / _|_  _ _ _  __| |_(_)___ _ _  ___
-// |  _| || | ' \/ _|  _| / _ \ ' \(_-<
-// |_|  \_,_|_||_\__|\__|_\___/_||_/__/
+// _
+// | |_ _  _ _ __  ___ ___
+// |  _| || | '_ \/ -_|_-<
+//  \__|\_, | .__/\___/__/
+// 	 |__/|_|
 //
 // /////////////////////////////////////////////////////////////////////////////
 
-func ensureDirectory(root string, deleteExisting bool, log *slog.Logger) error {
-	_, emptyDirectoryStatErr := os.Stat(root)
-	log.Debug("Ensuring directory", "path", root, "deleteExisting", deleteExisting)
-	if emptyDirectoryStatErr == nil && deleteExisting {
-		removeAllErr := os.RemoveAll(root)
-		log.Info("Deleting existing directory contents", "path", root)
-		if removeAllErr != nil {
-			return removeAllErr
+type FilterTootFunc func(*ActivityEntry) bool
+
+// OutputWriterFunc renders the filtered toots to the output root directory
+type OutputWriterFunc func(cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error
+
+// hugoGroupByMode describes how the hugo format buckets toots into pages
+type hugoGroupByMode struct {
+	// bundlePath returns the page bundle directory, relative to the output
+	// root, for the toot and its thread root
+	bundlePath func(entry *ActivityEntry, threadRoot *ActivityEntry) (string, error)
+	// pageTitle returns the page title given the first toot on the page
+	pageTitle func(firstToot *ActivityEntry) string
+	// sectionHeading returns the H2 heading the toot is rendered beneath.
+	// An empty heading means the page has no sections.
+	sectionHeading func(entry *ActivityEntry, threadRoot *ActivityEntry) string
+	// tableOfContents renders a linked list of toots after the frontmatter
+	// and an anchored heading above each toot
+	tableOfContents bool
+	// permalink is the Hugo permalink pattern, relative to the section URL,
+	// that reproduces the bundlePath layout
+	permalink string
+}
+
+// filterRule is a single --filters rule. Every condition that is set must
+// match for the rule to apply. Tags match if the toot has any of them.
+type filterRule struct {
+	Action     string   `json:"action"`
+	Visibility []string `json:"visibility"`
+	Tags       []string `json:"tags"`
+	Matching   string   `json:"matching"`
+	Since      string   `json:"since"`
+	Until      string   `json:"until"`
+	// One of: none (not a reply), self, others
+	Replies string `json:"replies"`
+
+	matchingRegexp *regexp.Regexp
+	sinceTime      time.Time
+	untilTime      time.Time
+}
+
+// frontmatterParams are the --frontmatter-params keys added to every hugo
+// and microblog page. Strings, including those nested in lists and maps, are
+// Go templates rendered with the page's frontmatter parameters.
+type frontmatterParams struct {
+	keys      []string
+	values    map[string]interface{}
+	templates map[string]*template.Template
+}
+
+// messageCatalog holds the generated page strings, by message key
+type messageCatalog map[string]string
+
+// renderedFrontmatterParam is a --frontmatter-params key and its value for a
+// page, encoded for the frontmatter
+type renderedFrontmatterParam struct {
+	Key   string
+	Value string
+}
+
+// filterRules is the --filters file. The first matching rule decides whether
+// a toot is published, and toots no rule matches get the default action.
+type filterRules struct {
+	Default string        `json:"default"`
+	Rules   []*filterRule `json:"rules"`
+}
+
+// regexpListFlag is a repeatable command line flag of regular expressions
+type regexpListFlag []*regexp.Regexp
+
+func (rlf *regexpListFlag) String() string {
+	patterns := []string{}
+	for _, eachRegexp := range *rlf {
+		patterns = append(patterns, eachRegexp.String())
+	}
+	return strings.Join(patterns, ", ")
+}
+
+func (rlf *regexpListFlag) Set(value string) error {
+	compiled, compiledErr := regexp.Compile(value)
+	if compiledErr != nil {
+		return compiledErr
+	}
+	*rlf = append(*rlf, compiled)
+	return nil
+}
+
+// imageFormat describes an --image-format encoder
+type imageFormat struct {
+	mediaType string
+	command   string
+	arguments func(quality int, inputPath string, outputPath string) []string
+}
+
+// mediaProcessorFunc transforms a media file copied to the output, e.g. to
+// resize it. It updates the attachment to describe the result and returns the
+// path of the processed file, which may differ from mediaFilePath.
+type mediaProcessorFunc func(attachment *ActivityObjectAttachment, mediaFilePath string, log *slog.Logger) (string, error)
+
+// redirectFormat describes a redirect file syntax
+type redirectFormat struct {
+	header string
+	rule   func(fromPath string, toURL string) string
+	footer string
+}
+
+// tootGroup is an ordered set of toots rendered to the same output file
+type tootGroup struct {
+	Key   string
+	Toots []*ActivityEntry
+	// --multilingual language tree of the page
+	Language string
+}
+
+// //////////////////////////////////////////////////////////////////////////////
+// commandLineArgs
+type commandLineArgs struct {
+	command                      string
+	configPath                   string
+	account                      string
+	inputRootPathExpandedArchive string
+	outputRootPathHugoAssets     string
+	jsonFeedPath                 string
+	atomFeedPath                 string
+	sqlitePath                   string
+	csvPath                      string
+	searchIndexPath              string
+	shortcodesDirectory          string
+	audioShortcode               string
+	gallery                      bool
+	galleryShortcode             string
+	layoutShortcodes             bool
+	sensitiveMedia               string
+	contentMode                  string
+	hashtagMode                  string
+	tagStyle                     string
+	tagNormalizer                *tagNormalizer
+	slugStyle                    string
+	themePreset                  string
+	mentionMode                  string
+	customEmoji                  bool
+	linkPreviews                 bool
+	stripTracking                bool
+	expandURLs                   bool
+	urlShorteners                []string
+	expandURLsTimeout            time.Duration
+	expandedURLsCachePath        string
+	trackingParams               []string
+	linkPreviewCachePath         string
+	sensitiveClass               string
+	sectionURL                   string
+	baseURL                      string
+	activityPub                  bool
+	redirectsPath                string
+	hugoConfigPath               string
+	redirectsFormat              string
+	aliases                      bool
+	includeReplies               bool
+	boostStyle                   string
+	boostCachePath               string
+	visibilities                 []string
+	draftVisibilities            []string
+	since                        time.Time
+	until                        time.Time
+	onlyTags                     []string
+	excludeTags                  []string
+	includeMatching              regexpListFlag
+	excludeMatching              regexpListFlag
+	languages                    []string
+	cwMode                       string
+	filterRules                  *filterRules
+	excludeMentions              []string
+	onlyMedia                    bool
+	textOnly                     bool
+	onlyIDs                      map[string]bool
+	excludeIDs                   map[string]bool
+	fetchPinned                  bool
+	pinnedWeight                 int
+	pinnedPage                   bool
+	minChars                     int
+	redactPattern                *regexp.Regexp
+	redactMode                   string
+	anonymizeMentions            bool
+	mentionPlaceholder           string
+	dedupeWindow                 time.Duration
+	reportPath                   string
+	altTextReportPath            string
+	dedupeMedia                  bool
+	maxImageWidth                int
+	keepOriginals                bool
+	imageFormat                  string
+	imageQuality                 int
+	keepFallback                 bool
+	stripMetadata                bool
+	srcsetWidths                 []int
+	srcsetSizes                  string
+	mediaMode                    string
+	mediaHook                    []string
+	verifyMedia                  bool
+	skipUnchangedMedia           bool
+	incremental                  bool
+	noOverwrite                  bool
+	clean                        bool
+	force                        bool
+	removeOrphans                bool
+	backupDirectory              string
+	backupCount                  int
+	reproducible                 bool
+	dryRun                       bool
+	dryRunDiff                   bool
+	blurhashPlaceholders         bool
+	ogImages                     bool
+	ogImageBackground            string
+	ogImageHook                  []string
+	offline                      bool
+	mediaBaseURL                 string
+	mediaCacheDirectory          string
+	downloadRetries              int
+	outputFormat                 string
+	groupBy                      string
+	tagPages                     bool
+	series                       bool
+	sectionPages                 bool
+	sectionTitle                 string
+	lang                         string
+	messages                     messageCatalog
+	multilingual                 bool
+	headerLength                 int
+	frontmatterTemplatePath      string
+	frontmatterTemplate          string
+	frontmatterParams            *frontmatterParams
+	frontmatterFormat            string
+	descriptionLength            int
+	keywordCount                 int
+	descriptionTemplate          *template.Template
+	logLevelValue                int
+}
+
+// pageBundlePath returns the page bundle directory, relative to the output
+// root, that the hugo format renders the toot into
+func (cla *commandLineArgs) pageBundlePath(filteredOutbox *Outbox, entry *ActivityEntry) (string, error) {
+	threadRootActivityItem, _, threadRootErr := filteredOutbox.threadRoot(entry)
+	if threadRootErr != nil {
+		return "", threadRootErr
+	}
+	return HUGO_GROUP_BY_MODES[cla.groupBy].bundlePath(entry, threadRootActivityItem)
+}
+
+// tootLanguage returns the --multilingual language tree of the toot, which
+// is the language of its thread root so a thread stays on one page. Toots
+// without a language, and every toot without --multilingual, are in the
+// --lang tree.
+func (cla *commandLineArgs) tootLanguage(filteredOutbox *Outbox, entry *ActivityEntry) string {
+	if !cla.multilingual {
+		return cla.lang
+	}
+	threadRootActivityItem, _, threadRootErr := filteredOutbox.threadRoot(entry)
+	if threadRootErr != nil {
+		threadRootActivityItem = entry
+	}
+	language := strings.ToLower(threadRootActivityItem.Object.Language)
+	if !LANGUAGE_CODE_PATTERN.MatchString(language) {
+		return cla.lang
+	}
+	return language
+}
+
+// languageOutputRoot returns the output directory of the language tree. The
+// --output is the content/<lang>/<section> directory of the --lang tree and
+// the other languages are in the content/<language>/<section> directories
+// beside it.
+func (cla *commandLineArgs) languageOutputRoot(language string) string {
+	if language == cla.lang {
+		return cla.outputRootPathHugoAssets
+	}
+	contentRoot := filepath.Dir(filepath.Dir(cla.outputRootPathHugoAssets))
+	return filepath.Join(contentRoot, language, filepath.Base(cla.outputRootPathHugoAssets))
+}
+
+// languageURLPrefix returns the site relative URL prefix of the language.
+// Hugo serves the default content language, --lang, from the site root and
+// the other languages from /<language>.
+func (cla *commandLineArgs) languageURLPrefix(language string) string {
+	if language == cla.lang {
+		return ""
+	}
+	return "/" + language
+}
+
+// languageSectionURL returns the --section-url of the language tree
+func (cla *commandLineArgs) languageSectionURL(language string) string {
+	return cla.languageURLPrefix(language) + cla.sectionURL
+}
+
+// languageMessages returns the message catalog of the language tree. That's
+// the --lang catalog, with the --messages overrides, for toots in the --lang
+// language or one without a catalog.
+func (cla *commandLineArgs) languageMessages(language string) messageCatalog {
+	baseLanguage, _, _ := strings.Cut(language, "-")
+	if baseLanguage == cla.lang {
+		return cla.messages
+	}
+	messages, messagesErr := newMessageCatalog(baseLanguage, "")
+	if messagesErr != nil {
+		return cla.messages
+	}
+	return messages
+}
+
+// groupLanguagePages buckets the toots into pages by the bundlePathFunc page
+// bundle directory, with separate pages for each --multilingual language
+func (cla *commandLineArgs) groupLanguagePages(filteredOutbox *Outbox,
+	entries []*ActivityEntry,
+	bundlePathFunc func(*ActivityEntry) (string, error)) ([]*tootGroup, error) {
+	pages, pagesErr := groupToots(entries, func(entry *ActivityEntry) (string, error) {
+		bundlePath, bundlePathErr := bundlePathFunc(entry)
+		if bundlePathErr != nil {
+			return "", bundlePathErr
+		}
+		return path.Join(cla.tootLanguage(filteredOutbox, entry), bundlePath), nil
+	})
+	if pagesErr != nil {
+		return nil, pagesErr
+	}
+	for _, eachPage := range pages {
+		eachPage.Language, eachPage.Key, _ = strings.Cut(eachPage.Key, "/")
+	}
+	return pages, nil
+}
+
+// outputDirectories returns the directories the run generates files in: the
+// output root and the other --multilingual trees beside it, or the Mastodon
+// directories of a site root output
+func (cla *commandLineArgs) outputDirectories() []string {
+	outputRoot := cla.outputRootPathHugoAssets
+	if SITE_ROOT_OUTPUT_FORMATS[cla.outputFormat] {
+		return []string{path.Join(outputRoot, "data", "mastodon"),
+			path.Join(outputRoot, "static", "mastodon"),
+			path.Join(outputRoot, "layouts", "shortcodes")}
+	}
+	if !cla.multilingual {
+		return []string{outputRoot}
+	}
+	languageRoots, _ := filepath.Glob(filepath.Join(filepath.Dir(filepath.Dir(outputRoot)), "*", filepath.Base(outputRoot)))
+	if !slices.Contains(languageRoots, outputRoot) {
+		languageRoots = append(languageRoots, outputRoot)
+	}
+	return languageRoots
+}
+
+// generatedTime returns the time the exports of the toots are stamped with:
+// now, or the latest edit of the toots with --reproducible
+func (cla *commandLineArgs) generatedTime(toots []*ActivityEntry) time.Time {
+	if !cla.reproducible {
+		return time.Now()
+	}
+	lastModified, _ := time.Parse(time.RFC3339, pageLastModified(toots))
+	return lastModified
+}
+
+// tootPermalink returns the site relative URL of the page, and anchor when
+// the page has per-toot anchors, that the hugo format renders the toot to
+func (cla *commandLineArgs) tootPermalink(filteredOutbox *Outbox, entry *ActivityEntry) (string, error) {
+	bundlePath, bundlePathErr := cla.pageBundlePath(filteredOutbox, entry)
+	if bundlePathErr != nil {
+		return "", bundlePathErr
+	}
+	permalink := cla.languageSectionURL(cla.tootLanguage(filteredOutbox, entry)) + bundlePath + "/"
+	groupByMode := HUGO_GROUP_BY_MODES[cla.groupBy]
+	threadRootActivityItem, _, _ := filteredOutbox.threadRoot(entry)
+	if groupByMode.tableOfContents ||
+		strings.Contains(groupByMode.sectionHeading(entry, threadRootActivityItem), tootAnchorID(entry)) {
+		permalink += "#" + tootAnchorID(entry)
+	}
+	return permalink, nil
+}
+
+func (cla *commandLineArgs) parseCommandLine(args []string, log *slog.Logger) error {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [%s] [flags]\n\nThe command defaults to convert. The flags are shared by every command except install-layouts:\n\n",
+			filepath.Base(os.Args[0]),
+			strings.Join(append(commandNames(), "install-layouts"), "|"))
+		flag.PrintDefaults()
+	}
+	flag.StringVar(&cla.configPath, "config", "", "Optional JSON, YAML (.yaml, .yml) or TOML (.toml) file of flag values, e.g. output: content/mastodon, optionally grouped in sections. Flags given on the command line override the file")
+	flag.StringVar(&cla.account, "account", USER+"@"+HOST, "The user@host Mastodon account the archive belongs to")
+	flag.StringVar(&cla.inputRootPathExpandedArchive, "input", "", "Path to unzipped archive")
+	flag.StringVar(&cla.outputRootPathHugoAssets, "output", "", "Path to root directory for output. Files generated by the previous run are deleted with --clean")
+	flag.BoolVar(&cla.includeReplies, "include-replies", false, "Include public replies to other users, rendered with an \"In reply to\" link")
+	visibilityString := ""
+	draftsString := ""
+	flag.StringVar(&draftsString, "drafts", "", fmt.Sprintf("Comma separated audiences whose toots the hugo and microblog formats render as draft pages rather than skip. Each must be one of: {%s}", strings.Join(VISIBILITIES, ", ")))
+	flag.StringVar(&visibilityString, "visibility", "public", fmt.Sprintf("Comma separated audiences to publish. Each must be one of: {%s}", strings.Join(VISIBILITIES, ", ")))
+	sinceString := ""
+	untilString := ""
+	flag.StringVar(&sinceString, "since", "", "Only publish toots published on or after this date (YYYY-MM-DD) or RFC3339 timestamp")
+	flag.StringVar(&untilString, "until", "", "Only publish toots published up to and including this date (YYYY-MM-DD), or before this RFC3339 timestamp")
+	onlyTagsString := ""
+	excludeTagsString := ""
+	flag.StringVar(&onlyTagsString, "only-tags", "", "Comma separated hashtags. Only publish toots with at least one of them")
+	flag.StringVar(&excludeTagsString, "exclude-tags", "", "Comma separated hashtags. Don't publish toots with any of them")
+	flag.Var(&cla.includeMatching, "include-matching", "Only publish toots whose plain text matches one of these regular expressions. May be repeated")
+	flag.Var(&cla.excludeMatching, "exclude-matching", "Don't publish toots whose plain text matches this regular expression. May be repeated")
+	languagesString := ""
+	flag.StringVar(&languagesString, "language", "", "Comma separated language codes from the toot contentMap, e.g. en,de. Only publish toots in these languages")
+	flag.StringVar(&cla.cwMode, "cw-mode", "inline", fmt.Sprintf("Content warning handling. Must be one of: {%s}", strings.Join(cwModeNames(), ", ")))
+	flag.StringVar(&cla.contentMode, "content-mode", "html", fmt.Sprintf("How the hugo and microblog pages render the toot content. Must be one of: {%s}", strings.Join(contentModeNames(), ", ")))
+	flag.StringVar(&cla.slugStyle, "slug-style", "id", fmt.Sprintf("How the page bundle directories are named. Must be one of: {%s}", strings.Join(slugStyleNames(), ", ")))
+	flag.StringVar(&cla.tagStyle, "tag-style", "as-is", fmt.Sprintf("How hashtag names are normalized for the frontmatter tags and tag pages. Must be one of: {%s}", strings.Join(tagStyleNames(), ", ")))
+	tagMapPath := ""
+	flag.StringVar(&tagMapPath, "tag-map", "", "Optional YAML or JSON file mapping hashtags to the tag they're renamed to, e.g. golang: go. Tags mapped to an empty name are removed")
+	flag.StringVar(&cla.hashtagMode, "hashtag-mode", "mastodon", fmt.Sprintf("How hashtags in the toot content are rendered. Must be one of: {%s}", strings.Join(hashtagModeNames(), ", ")))
+	flag.StringVar(&cla.mentionMode, "mention-mode", "link", fmt.Sprintf("How @-mentions in the toot content are rendered. Must be one of: {%s}", strings.Join(mentionModeNames(), ", ")))
+	flag.BoolVar(&cla.customEmoji, "custom-emoji", false, "Copy the custom emoji images into the hugo and microblog page bundles and render :shortcode: emoji as inline images. Emoji whose image isn't available stay as text")
+	flag.BoolVar(&cla.expandURLs, "expand-urls", false, "Replace shortened links in the toot content, see --url-shorteners, with the destination they redirect to")
+	urlShortenersString := ""
+	flag.StringVar(&urlShortenersString, "url-shorteners", URL_SHORTENERS, "Comma separated URL shortener hosts whose links --expand-urls resolves")
+	flag.DurationVar(&cla.expandURLsTimeout, "expand-urls-timeout", 10*time.Second, "Timeout for resolving each shortened link")
+	flag.StringVar(&cla.expandedURLsCachePath, "expanded-urls-cache", "", "JSON file of resolved short links, reused by later runs. Defaults to mastodon-to-hugo/expanded-urls.json in the user cache directory")
+	flag.BoolVar(&cla.stripTracking, "strip-tracking", false, "Remove tracking query parameters, see --tracking-params, from the links in the toot content")
+	trackingParamsString := ""
+	flag.StringVar(&trackingParamsString, "tracking-params", TRACKING_PARAMETERS, "Comma separated query parameters --strip-tracking removes. A trailing * matches any parameter with the prefix")
+	flag.BoolVar(&cla.linkPreviews, "link-previews", false, "Fetch the OpenGraph title, description and image of the page linked by toots that are mostly a URL, and render a preview card in the hugo and microblog pages")
+	flag.StringVar(&cla.linkPreviewCachePath, "link-preview-cache", "", "JSON file of fetched link previews, reused by later runs. Defaults to mastodon-to-hugo/link-previews.json in the user cache directory")
+	flag.StringVar(&cla.sensitiveMedia, "sensitive-media", "show", fmt.Sprintf("Handling of media in toots marked sensitive. Must be one of: {%s}", strings.Join(sensitiveMediaModeNames(), ", ")))
+	flag.StringVar(&cla.sensitiveClass, "sensitive-class", "mastodon-sensitive", "CSS class names of the <div> wrapping sensitive media for --sensitive-media blur, e.g. to apply a filter: blur() rule from the theme")
+	excludeMentionsString := ""
+	flag.StringVar(&excludeMentionsString, "exclude-mentions", "", "Comma separated accounts, e.g. @someone@example.com. Don't publish toots that mention any of them")
+	flag.BoolVar(&cla.onlyMedia, "only-media", false, "Only publish toots with media attachments, e.g. for a photo-blog section")
+	flag.BoolVar(&cla.textOnly, "text-only", false, "Only publish toots without media attachments, e.g. for a notes section")
+	onlyIDsPath := ""
+	excludeIDsPath := ""
+	flag.StringVar(&onlyIDsPath, "only-ids", "", "Optional file of status IDs or URLs, one per line. Only publish these toots")
+	flag.StringVar(&excludeIDsPath, "exclude-ids", "", "Optional file of status IDs or URLs, one per line. Don't publish these toots")
+	flag.BoolVar(&cla.fetchPinned, "fetch-pinned", false, "Fetch the featured (pinned) collection from the server if the archive only references it by URL")
+	flag.IntVar(&cla.pinnedWeight, "pinned-weight", 0, "Optional Hugo weight for the pages of pinned toots")
+	flag.BoolVar(&cla.pinnedPage, "pinned-page", false, "Write a pinned/index.md page listing the pinned toots")
+	flag.IntVar(&cla.minChars, "min-chars", 0, "Don't publish toots whose plain text is shorter than this many characters. Toots with media are always published")
+	redactPath := ""
+	flag.StringVar(&redactPath, "redact", "", "Optional file of words or phrases, one per line, matched case-insensitively in the toot text")
+	flag.StringVar(&cla.redactMode, "redact-mode", "replace", fmt.Sprintf("What to do with toots containing a --redact term. Must be one of: {%s}", strings.Join(slices.Sorted(maps.Keys(REDACT_MODES)), ", ")))
+	flag.BoolVar(&cla.anonymizeMentions, "anonymize-mentions", false, "Replace other users' handles and profile links with --mention-placeholder")
+	flag.StringVar(&cla.mentionPlaceholder, "mention-placeholder", "@someone", "Replacement text for --anonymize-mentions")
+	flag.DurationVar(&cla.dedupeWindow, "dedupe-window", 0, "Drop toots whose content is identical to a toot published within this duration before it, e.g. 15m. 0 disables content deduplication")
+	flag.BoolVar(&cla.dedupeMedia, "dedupe-media", false, "Store media files with identical contents once, hard linking the other page bundle copies")
+	flag.IntVar(&cla.maxImageWidth, "max-image-width", 0, "Downsize JPEG and PNG images wider than this many pixels. 0 keeps the original size")
+	flag.BoolVar(&cla.keepOriginals, "keep-originals", false, "Keep a copy of each processed image in an originals/ directory next to it")
+	flag.StringVar(&cla.imageFormat, "image-format", "", fmt.Sprintf("Transcode JPEG and PNG images to this format. Must be one of: {%s}. Requires the cwebp or avifenc command", strings.Join(slices.Sorted(maps.Keys(IMAGE_FORMATS)), ", ")))
+	flag.IntVar(&cla.imageQuality, "image-quality", 80, "Quality (1-100) for --image-format")
+	flag.BoolVar(&cla.keepFallback, "keep-fallback", false, "Keep the original image of --image-format as a <picture> fallback")
+	srcsetWidthsString := ""
+	flag.StringVar(&srcsetWidthsString, "srcset-widths", "", "Comma separated widths, e.g. 480,960, of smaller copies made of each JPEG and PNG image for a responsive srcset. Applied after --max-image-width")
+	flag.StringVar(&cla.srcsetSizes, "srcset-sizes", "100vw", "The sizes attribute of the --srcset-widths images")
+	flag.BoolVar(&cla.stripMetadata, "strip-exif", false, "Remove EXIF (including GPS), XMP and text metadata from JPEG and PNG images")
+	flag.BoolVar(&cla.offline, "offline", false, "Never download media. Attachments missing from the archive are only used if already in the --media-cache")
+	flag.StringVar(&cla.mediaBaseURL, "media-base-url", "https://"+HOST, "URL that archive relative media paths missing from the archive are downloaded from")
+	flag.StringVar(&cla.mediaCacheDirectory, "media-cache", "", "Directory of downloaded media, reused by later runs. Defaults to mastodon-to-hugo/media in the user cache directory")
+	flag.IntVar(&cla.downloadRetries, "download-retries", 3, "Number of times a failed media download is retried")
+	flag.BoolVar(&cla.blurhashPlaceholders, "blurhash", false, "Decode each attachment's blurhash into a small <name>-blurhash.png placeholder, listed in the frontmatter placeholders for blur-up loading")
+	flag.BoolVar(&cla.ogImages, "og-images", false, "Write an "+OG_IMAGE_FILENAME+" share card with the description, the content warning or text, to each hugo page without an image, and use it as the frontmatter image")
+	flag.StringVar(&cla.ogImageBackground, "og-image-background", "#563acc", "Background color of the --og-images cards, e.g. #563acc")
+	ogImageHookString := ""
+	flag.StringVar(&ogImageHookString, "og-image-hook", "", "Optional command that renders the --og-images card HTML file {src} to the PNG {dest} instead of the built-in drawing, e.g. 'chromium --headless --screenshot={dest} --window-size=1200,630 {src}'. A failing hook falls back to the built-in drawing")
+	flag.BoolVar(&cla.skipUnchangedMedia, "skip-unchanged-media", false, "Keep the media copied by the previous run, recorded in "+MEDIA_MANIFEST_FILENAME+", and only copy and process new or changed media")
+	flag.BoolVar(&cla.incremental, "incremental", false, "Keep the hugo and microblog pages written by the previous run, recorded in "+RENDER_STATE_FILENAME+", and only write pages whose content changed. Implies --skip-unchanged-media")
+	flag.BoolVar(&cla.clean, "clean", false, "Delete the files the previous run generated, listed in "+OUTPUT_MANIFEST_FILENAME+", before rendering. Other files are never deleted")
+	flag.BoolVar(&cla.force, "force", false, "Clean the output even though it has files the previous run didn't generate, which are kept")
+	flag.BoolVar(&cla.removeOrphans, "remove-orphans", false, "Delete the hugo and microblog pages, and their media, the previous run generated for toots no longer in the archive. Without it they're only reported")
+	flag.StringVar(&cla.backupDirectory, "backup", "", "Optional directory to save a timestamped "+BACKUP_FILENAME_PREFIX+"<time>.tar.gz of the output in before --clean or --remove-orphans delete files")
+	flag.IntVar(&cla.backupCount, "backup-count", 5, "Number of --backup tarballs to keep. Older ones are deleted")
+	flag.BoolVar(&cla.reproducible, "reproducible", false, "Leave out the # generated times and stamp the EPUB and Ghost exports with the latest toot edit instead of the current time, so identical input renders byte-identical output")
+	flag.BoolVar(&cla.dryRun, "dry-run", false, "Render into a temporary directory and report the files the run would create, update or delete, without changing the output directory or output files")
+	flag.BoolVar(&cla.dryRunDiff, "dry-run-diff", false, "Print a unified diff of every file the --dry-run would create or update")
+	flag.BoolVar(&cla.noOverwrite, "no-overwrite", false, "Keep the pages edited since the previous run wrote them, instead of writing or deleting them. Implies --incremental")
+	flag.StringVar(&cla.mediaMode, "media-mode", "copy", fmt.Sprintf("How media is put in the output. Must be one of: {%s}. Links avoid copying when the archive and site share a file system", strings.Join(slices.Sorted(maps.Keys(MEDIA_MODES)), ", ")))
+	mediaHookString := ""
+	flag.StringVar(&mediaHookString, "media-hook", "", "Optional command run for each media file instead of copying it, e.g. 'ffmpeg -i {src} {dest}'. {src} and {dest} are replaced with the file paths. A failing hook falls back to --media-mode")
+	flag.BoolVar(&cla.verifyMedia, "verify-media", false, "Check that each media file decodes as its media type, skipping corrupt or truncated files, and that each copy is complete")
+	filterRulesPath := ""
+	flag.StringVar(&filterRulesPath, "filters", "", "Optional YAML or JSON file of ordered include/exclude rules, applied after the filter flags")
+	flag.StringVar(&cla.boostStyle, "boost-style", "", fmt.Sprintf("Include boosts, rendered in this style. Must be one of: {%s}", strings.Join(boostStyleNames(), ", ")))
+	flag.StringVar(&cla.boostCachePath, "boost-cache", "", "JSON file of the boosted toots fetched for --boost-style quote and full, reused by later runs. Defaults to mastodon-to-hugo/boosts.json in the user cache directory")
+	flag.StringVar(&cla.jsonFeedPath, "json-feed", "", "Optional path to a JSON Feed (1.1) file of the rendered toots")
+	flag.StringVar(&cla.atomFeedPath, "rss", "", "Optional path to an Atom feed of the rendered toots")
+	flag.StringVar(&cla.searchIndexPath, "search-index", "", "Optional path to a client-side search index (Lunr documents JSON), e.g. ./blog/static/mastodon-search.json")
+	flag.StringVar(&cla.shortcodesDirectory, "shortcodes", "", "Optional Hugo layouts/shortcodes directory for the companion shortcodes")
+	flag.StringVar(&cla.audioShortcode, "audio-shortcode", "", "Optional shortcode name, e.g. mastodon-audio, to render audio attachments with instead of an <audio> element. Written to --shortcodes if set")
+	flag.BoolVar(&cla.gallery, "gallery", false, "Render the images of toots with more than one as a grid of figures instead of a vertical stack")
+	flag.StringVar(&cla.galleryShortcode, "gallery-shortcode", "", "Optional shortcode name, e.g. mastodon-gallery, wrapping the --gallery images instead of the grid markup. Implies --gallery. Written to --shortcodes if set")
+	flag.BoolVar(&cla.layoutShortcodes, "layout-shortcodes", false, "Render videos, and content warning and sensitive media folds, as calls to the mastodon-video and cw shortcodes written by the install-layouts command instead of inline HTML. Written to --shortcodes if set")
+	flag.StringVar(&cla.sectionURL, "section-url", "", "URL path of the output section. Defaults to /<output directory name>/")
+	flag.StringVar(&cla.baseURL, "base-url", "", "Absolute URL of the Hugo site, e.g. https://example.com. Required by --activitypub")
+	flag.BoolVar(&cla.activityPub, "activitypub", false, "Write a static ActivityStreams <id>.json Note next to each page and an activitypub.json ID mapping index")
+	flag.StringVar(&cla.hugoConfigPath, "hugo-config", "", "Optional path to a Hugo config snippet, e.g. ./blog/hugo-mastodon.toml, of the taxonomy, permalink and cascade settings for the output section")
+	flag.StringVar(&cla.redirectsPath, "redirects", "", "Optional path to a redirects file mapping the Mastodon status URLs to the Hugo permalinks")
+	flag.StringVar(&cla.redirectsFormat, "redirects-format", "netlify", fmt.Sprintf("Syntax of the --redirects file. Must be one of: {%s}", strings.Join(redirectFormatNames(), ", ")))
+	flag.BoolVar(&cla.aliases, "aliases", false, "Add Hugo aliases for the original /@user/<id> status paths to each page's frontmatter")
+	flag.StringVar(&cla.reportPath, "report", "", "Optional path to a JSON Lines report of every skipped toot and the filter that skipped it")
+	flag.StringVar(&cla.altTextReportPath, "alt-text-report", "", "Optional path to a JSON Lines report, one record per page, of the rendered images without alt text")
+	flag.StringVar(&cla.csvPath, "csv", "", "Optional path to a CSV file of toot metadata. A path ending in .tsv is tab separated")
+	flag.StringVar(&cla.sqlitePath, "sqlite", "", "Optional path to a SQLite database of the rendered toots. Requires the sqlite3 command, unless the path ends in .sql")
+	flag.StringVar(&cla.outputFormat, "format", "hugo", fmt.Sprintf("Output format. Must be one of: {%s}", strings.Join(outputFormatNames(), ", ")))
+	flag.StringVar(&cla.groupBy, "group-by", "bundle", fmt.Sprintf("How the hugo format groups toots into pages. Must be one of: {%s}", strings.Join(groupByModeNames(), ", ")))
+	flag.BoolVar(&cla.tagPages, "tag-pages", false, "Write a tags/<hashtag>/_index.md page listing the toots for each hashtag")
+	flag.BoolVar(&cla.series, "series", false, "Add a series: [\"thread-<root id>\"] frontmatter taxonomy to the pages of threads spread over several pages, e.g. with --group-by toot, and write a series/thread-<root id>/_index.md page listing the parts")
+	flag.BoolVar(&cla.sectionPages, "section-pages", false, "Write _index.md section pages for the output root and each year")
+	flag.StringVar(&cla.frontmatterTemplatePath, "frontmatter-template", "", "Go text/template file that renders the frontmatter, including the --- delimiters, of the hugo and microblog pages in place of the built-in one")
+	flag.StringVar(&cla.frontmatterFormat, "frontmatter", "yaml", fmt.Sprintf("Frontmatter format of the hugo and microblog pages. Must be one of: {%s}", strings.Join(FRONTMATTER_FORMATS, ", ")))
+	flag.StringVar(&cla.themePreset, "theme-preset", "", fmt.Sprintf("Add the frontmatter keys, e.g. cover images and summaries, that this Hugo theme expects to the hugo pages. Must be one of: {%s}", strings.Join(themePresetNames(), ", ")))
+	flag.IntVar(&cla.keywordCount, "keywords", 0, "Add a keywords frontmatter list, for Hugo's related content, of the page's hashtags and up to this many of the most frequent other words in its text. 0 disables keywords")
+	flag.IntVar(&cla.descriptionLength, "description-length", 160, "Maximum length, in characters, of the frontmatter description excerpt of the toot")
+	descriptionTemplateString := ""
+	flag.StringVar(&descriptionTemplateString, "description-template", "", "Optional Go template for the frontmatter description, e.g. '{{ .Description }} (@me)'. It can use the frontmatter template parameters, where .Description is the content warning or excerpt")
+	frontmatterParamsPath := ""
+	flag.StringVar(&frontmatterParamsPath, "frontmatter-params", "", "Optional YAML or JSON file of extra keys added to the frontmatter of the hugo and microblog pages. String values may be Go templates using the frontmatter template parameters")
+	flag.IntVar(&cla.headerLength, "header-length", 60, "Maximum length, in characters, of the toot excerpt in the headings of pages with several toots. URLs and Markdown syntax are left out and the excerpt ends at a word boundary")
+	flag.StringVar(&cla.sectionTitle, "section-title", "", "Title format for the year section pages. The year replaces the %s verb. Defaults to the SectionTitle message of the page language, e.g. Toots from %s")
+	flag.StringVar(&cla.lang, "lang", "en", fmt.Sprintf("Language of the generated page strings, e.g. the content warning label and source link. Must be one of: {%s}", strings.Join(slices.Sorted(maps.Keys(MESSAGE_CATALOGS)), ", ")))
+	flag.BoolVar(&cla.multilingual, "multilingual", false, "Write the hugo pages of each toot language to a content/<language>/<section> tree, for a Hugo multilingual site with a content directory per language. The --output must be the tree of the --lang language, e.g. site/content/en/mastodon")
+	messagesPath := ""
+	flag.StringVar(&messagesPath, "messages", "", "Optional YAML or JSON file of message keys, e.g. Source or ContentWarning, and the text that replaces the --lang message")
+	logLevelString := ""
+	flag.StringVar(&logLevelString, "level", "INFO", "Logging verbosity level. Must be one of: {DEBUG, INFO, WARN, ERROR}")
+	defaultMediaBaseURL := cla.mediaBaseURL
+	flag.CommandLine.Parse(args)
+	if flag.NArg() != 0 {
+		return fmt.Errorf("Invalid %s argument specified: %s", cla.command, flag.Arg(0))
+	}
+
+	if len(cla.configPath) != 0 {
+		configErr := applyConfigFile(cla.configPath, flag.CommandLine)
+		if configErr != nil {
+			return configErr
+		}
+	}
+	accountUser, accountHost, isAccount := strings.Cut(strings.TrimPrefix(strings.TrimSpace(cla.account), "@"), "@")
+	if !isAccount || len(accountUser) <= 0 || len(accountHost) <= 0 || strings.ContainsAny(accountHost, "@/") {
+		return fmt.Errorf("Invalid account specified: %s. Must be user@host", cla.account)
+	}
+	USER = accountUser
+	HOST = accountHost
+	MY_FOLLOWERS_URL = fmt.Sprintf("https://%s/users/%s/followers", HOST, USER)
+	if cla.mediaBaseURL == defaultMediaBaseURL {
+		cla.mediaBaseURL = "https://" + HOST
+	}
+	// Only clean doesn't read the archive, and only convert and clean
+	// change the output
+	if len(cla.inputRootPathExpandedArchive) <= 0 && cla.command != "clean" {
+		return fmt.Errorf("Invalid command line arguments: %s requires --input", cla.command)
+	}
+	if len(cla.outputRootPathHugoAssets) <= 0 && (cla.command == "convert" || cla.command == "clean") {
+		return fmt.Errorf("Invalid command line arguments: %s requires --output", cla.command)
+	}
+	if cla.dryRun && cla.command != "convert" {
+		return fmt.Errorf("Invalid command line arguments: --dry-run requires the convert command")
+	}
+	if cla.offline && cla.command == "fetch" {
+		return fmt.Errorf("Invalid command line arguments: fetch can't be combined with --offline")
+	}
+	if cla.command == "clean" {
+		cla.clean = true
+	}
+	expanded, expandedErr := filepath.Abs(cla.inputRootPathExpandedArchive)
+	if expandedErr != nil {
+		return fmt.Errorf("Failed to expand input path")
+	}
+	cla.inputRootPathExpandedArchive = expanded
+	expanded, expandedErr = filepath.Abs(cla.outputRootPathHugoAssets)
+	if expandedErr != nil {
+		return fmt.Errorf("Failed to expand output path")
+	}
+	cla.outputRootPathHugoAssets = expanded
+	// Optional output files
+	for _, eachOptionalPath := range []*string{&cla.jsonFeedPath, &cla.atomFeedPath, &cla.sqlitePath, &cla.csvPath, &cla.searchIndexPath, &cla.shortcodesDirectory, &cla.redirectsPath, &cla.hugoConfigPath, &cla.reportPath, &cla.altTextReportPath, &cla.mediaCacheDirectory, &cla.linkPreviewCachePath, &cla.expandedURLsCachePath, &cla.boostCachePath, &cla.frontmatterTemplatePath, &cla.backupDirectory} {
+		if len(*eachOptionalPath) == 0 {
+			continue
+		}
+		expanded, expandedErr = filepath.Abs(*eachOptionalPath)
+		if expandedErr != nil {
+			return fmt.Errorf("Failed to expand path: %s", *eachOptionalPath)
+		}
+		*eachOptionalPath = expanded
+	}
+	if len(cla.frontmatterTemplatePath) != 0 {
+		templateData, templateDataErr := os.ReadFile(cla.frontmatterTemplatePath)
+		if templateDataErr != nil {
+			return fmt.Errorf("Failed to read frontmatter template: %s", templateDataErr)
+		}
+		_, parseErr := template.New("tootRoot").Parse(string(templateData))
+		if parseErr != nil {
+			return fmt.Errorf("Failed to parse frontmatter template: %s", parseErr)
+		}
+		cla.frontmatterTemplate = string(templateData)
+	}
+	if len(cla.sectionURL) <= 0 {
+		cla.sectionURL = fmt.Sprintf("/%s/", filepath.Base(cla.outputRootPathHugoAssets))
+	}
+	if !strings.HasSuffix(cla.sectionURL, "/") {
+		cla.sectionURL += "/"
+	}
+	cla.baseURL = strings.TrimSuffix(cla.baseURL, "/")
+	if cla.activityPub && len(cla.baseURL) <= 0 {
+		return fmt.Errorf("Invalid command line arguments: --activitypub requires --base-url")
+	}
+	if len(cla.audioShortcode) != 0 && !SHORTCODE_NAME_PATTERN.MatchString(cla.audioShortcode) {
+		return fmt.Errorf("Invalid audio shortcode specified: %s", cla.audioShortcode)
+	}
+	if len(cla.galleryShortcode) != 0 {
+		if !SHORTCODE_NAME_PATTERN.MatchString(cla.galleryShortcode) {
+			return fmt.Errorf("Invalid gallery shortcode specified: %s", cla.galleryShortcode)
+		}
+		cla.gallery = true
+	}
+	if _, formatExists := OUTPUT_FORMATS[cla.outputFormat]; !formatExists {
+		return fmt.Errorf("Invalid output format specified: %s", cla.outputFormat)
+	}
+	if !slices.Contains(FRONTMATTER_FORMATS, cla.frontmatterFormat) {
+		return fmt.Errorf("Invalid frontmatter format specified: %s", cla.frontmatterFormat)
+	}
+	if cla.keywordCount < 0 {
+		return fmt.Errorf("Invalid keyword count specified: %d", cla.keywordCount)
+	}
+	if cla.descriptionLength <= 0 {
+		return fmt.Errorf("Invalid description length specified: %d", cla.descriptionLength)
+	}
+	if len(descriptionTemplateString) != 0 {
+		descriptionTemplate, descriptionTemplateErr := template.New("description").Parse(descriptionTemplateString)
+		if descriptionTemplateErr != nil {
+			return fmt.Errorf("Invalid description template specified: %s", descriptionTemplateErr)
+		}
+		cla.descriptionTemplate = descriptionTemplate
+	}
+	if cla.headerLength <= 0 {
+		return fmt.Errorf("Invalid header length specified: %d", cla.headerLength)
+	}
+	for _, eachVisibility := range splitListFlag(visibilityString) {
+		eachVisibility = strings.ToLower(eachVisibility)
+		if !slices.Contains(VISIBILITIES, eachVisibility) {
+			return fmt.Errorf("Invalid visibility specified: %s", eachVisibility)
+		}
+		cla.visibilities = append(cla.visibilities, eachVisibility)
+	}
+	for _, eachVisibility := range splitListFlag(draftsString) {
+		eachVisibility = strings.ToLower(eachVisibility)
+		if !slices.Contains(VISIBILITIES, eachVisibility) {
+			return fmt.Errorf("Invalid draft visibility specified: %s", eachVisibility)
+		}
+		cla.draftVisibilities = append(cla.draftVisibilities, eachVisibility)
+		if !slices.Contains(cla.visibilities, eachVisibility) {
+			cla.visibilities = append(cla.visibilities, eachVisibility)
+		}
+	}
+	if len(cla.draftVisibilities) != 0 && cla.outputFormat != "hugo" && cla.outputFormat != "microblog" {
+		return fmt.Errorf("Invalid command line arguments: --drafts requires the hugo or microblog format")
+	}
+	if len(cla.hugoConfigPath) != 0 && cla.outputFormat != "hugo" && cla.outputFormat != "microblog" {
+		return fmt.Errorf("Invalid command line arguments: --hugo-config requires the hugo or microblog format")
+	}
+	for _, eachTag := range splitListFlag(onlyTagsString) {
+		cla.onlyTags = append(cla.onlyTags, normalizeTagFlag(eachTag))
+	}
+	for _, eachTag := range splitListFlag(excludeTagsString) {
+		cla.excludeTags = append(cla.excludeTags, normalizeTagFlag(eachTag))
+	}
+	for _, eachHost := range splitListFlag(urlShortenersString) {
+		cla.urlShorteners = append(cla.urlShorteners, strings.TrimPrefix(strings.ToLower(eachHost), "www."))
+	}
+	for _, eachParam := range splitListFlag(trackingParamsString) {
+		cla.trackingParams = append(cla.trackingParams, strings.ToLower(eachParam))
+	}
+	for _, eachLanguage := range splitListFlag(languagesString) {
+		cla.languages = append(cla.languages, strings.ToLower(eachLanguage))
+	}
+	for _, eachAccount := range splitListFlag(excludeMentionsString) {
+		cla.excludeMentions = append(cla.excludeMentions, normalizeAccount(eachAccount, HOST))
+	}
+	for _, eachIDList := range []struct {
+		listPath string
+		ids      *map[string]bool
+	}{{onlyIDsPath, &cla.onlyIDs}, {excludeIDsPath, &cla.excludeIDs}} {
+		if len(eachIDList.listPath) == 0 {
+			continue
+		}
+		ids, idsErr := readIDList(eachIDList.listPath)
+		if idsErr != nil {
+			return fmt.Errorf("Failed to read ID list %s: %s", eachIDList.listPath, idsErr)
+		}
+		*eachIDList.ids = ids
+	}
+	if len(redactPath) != 0 {
+		redactPattern, redactErr := readRedactTerms(redactPath)
+		if redactErr != nil {
+			return fmt.Errorf("Failed to read redact terms %s: %s", redactPath, redactErr)
+		}
+		cla.redactPattern = redactPattern
+	}
+	if _, mediaModeExists := MEDIA_MODES[cla.mediaMode]; !mediaModeExists {
+		return fmt.Errorf("Invalid media mode specified: %s", cla.mediaMode)
+	}
+	if !HEX_COLOR_PATTERN.MatchString(cla.ogImageBackground) {
+		return fmt.Errorf("Invalid share card background specified: %s", cla.ogImageBackground)
+	}
+	if len(ogImageHookString) != 0 {
+		if !strings.Contains(ogImageHookString, "{src}") || !strings.Contains(ogImageHookString, "{dest}") {
+			return fmt.Errorf("Invalid share card hook specified: %s. Must include {src} and {dest}", ogImageHookString)
+		}
+		ogImageHook, ogImageHookErr := splitHookCommand(ogImageHookString)
+		if ogImageHookErr != nil {
+			return fmt.Errorf("Invalid share card hook specified: %s", ogImageHookErr)
+		}
+		cla.ogImageHook = ogImageHook
+	}
+	if cla.noOverwrite {
+		cla.incremental = true
+	}
+	if cla.force && !cla.clean {
+		return fmt.Errorf("Invalid command line arguments: --force requires --clean")
+	}
+	if len(cla.backupDirectory) != 0 && !cla.clean && !cla.removeOrphans {
+		return fmt.Errorf("Invalid command line arguments: --backup requires --clean or --remove-orphans")
+	}
+	for _, eachDirectory := range cla.outputDirectories() {
+		if relativePath, relativePathErr := filepath.Rel(eachDirectory, cla.backupDirectory); len(cla.backupDirectory) != 0 && relativePathErr == nil && !strings.HasPrefix(relativePath, "..") {
+			return fmt.Errorf("Invalid command line arguments: --backup must be outside the output directory %s", eachDirectory)
+		}
+	}
+	if cla.backupCount <= 0 {
+		return fmt.Errorf("Invalid backup count specified: %d", cla.backupCount)
+	}
+	if cla.dryRunDiff && !cla.dryRun {
+		return fmt.Errorf("Invalid command line arguments: --dry-run-diff requires --dry-run")
+	}
+	if cla.incremental {
+		if cla.outputFormat != "hugo" && cla.outputFormat != "microblog" {
+			return fmt.Errorf("Invalid command line arguments: --incremental requires the hugo or microblog format")
+		}
+		cla.skipUnchangedMedia = true
+	}
+	if cla.ogImages && cla.outputFormat != "hugo" {
+		return fmt.Errorf("Invalid command line arguments: --og-images requires the hugo format")
+	}
+	if len(mediaHookString) != 0 {
+		if !strings.Contains(mediaHookString, "{src}") || !strings.Contains(mediaHookString, "{dest}") {
+			return fmt.Errorf("Invalid media hook specified: %s. Must include {src} and {dest}", mediaHookString)
+		}
+		mediaHook, mediaHookErr := splitHookCommand(mediaHookString)
+		if mediaHookErr != nil {
+			return fmt.Errorf("Invalid media hook specified: %s", mediaHookErr)
+		}
+		cla.mediaHook = mediaHook
+	}
+	if !REDACT_MODES[cla.redactMode] {
+		return fmt.Errorf("Invalid redact mode specified: %s", cla.redactMode)
+	}
+	if len(cla.imageFormat) != 0 {
+		transcodeFormat, transcodeFormatExists := IMAGE_FORMATS[cla.imageFormat]
+		if !transcodeFormatExists {
+			return fmt.Errorf("Invalid image format specified: %s", cla.imageFormat)
+		}
+		if _, lookPathErr := exec.LookPath(transcodeFormat.command); lookPathErr != nil {
+			return fmt.Errorf("--image-format %s requires the %s command: %s", cla.imageFormat, transcodeFormat.command, lookPathErr)
+		}
+	}
+	if cla.imageQuality < 1 || cla.imageQuality > 100 {
+		return fmt.Errorf("Invalid image quality specified: %d", cla.imageQuality)
+	}
+	if len(cla.mediaCacheDirectory) <= 0 {
+		cla.mediaCacheDirectory = userCachePath("media")
+	}
+	if len(cla.linkPreviewCachePath) <= 0 {
+		cla.linkPreviewCachePath = userCachePath("link-previews.json")
+	}
+	if len(cla.expandedURLsCachePath) <= 0 {
+		cla.expandedURLsCachePath = userCachePath("expanded-urls.json")
+	}
+	if len(cla.boostCachePath) <= 0 {
+		cla.boostCachePath = userCachePath("boosts.json")
+	}
+	if cla.expandURLsTimeout <= 0 {
+		return fmt.Errorf("Invalid expand URLs timeout specified: %s", cla.expandURLsTimeout)
+	}
+	cla.mediaBaseURL = strings.TrimSuffix(cla.mediaBaseURL, "/")
+	if !isRemoteURL(cla.mediaBaseURL) {
+		return fmt.Errorf("Invalid media base URL specified: %s", cla.mediaBaseURL)
+	}
+	if cla.downloadRetries < 0 {
+		return fmt.Errorf("Invalid download retries specified: %d", cla.downloadRetries)
+	}
+	if cla.offline && cla.fetchPinned {
+		return fmt.Errorf("Invalid command line arguments: --offline can't be combined with --fetch-pinned")
+	}
+	for _, eachWidth := range splitListFlag(srcsetWidthsString) {
+		width, widthErr := strconv.Atoi(eachWidth)
+		if widthErr != nil || width <= 0 {
+			return fmt.Errorf("Invalid srcset width specified: %s", eachWidth)
+		}
+		cla.srcsetWidths = append(cla.srcsetWidths, width)
+	}
+	slices.Sort(cla.srcsetWidths)
+	cla.srcsetWidths = slices.Compact(cla.srcsetWidths)
+	if len(cla.srcsetWidths) != 0 && len(cla.imageFormat) != 0 {
+		return fmt.Errorf("Invalid command line arguments: --srcset-widths can't be combined with --image-format")
+	}
+	if cla.onlyMedia && cla.textOnly {
+		return fmt.Errorf("Invalid command line arguments: --only-media and --text-only are mutually exclusive")
+	}
+	var parseDateErr error
+	cla.since, parseDateErr = parseDateFlag(sinceString, false)
+	if parseDateErr != nil {
+		return fmt.Errorf("Invalid since date specified: %s", sinceString)
+	}
+	cla.until, parseDateErr = parseDateFlag(untilString, true)
+	if parseDateErr != nil {
+		return fmt.Errorf("Invalid until date specified: %s", untilString)
+	}
+	if len(frontmatterParamsPath) != 0 {
+		reservedKeys := BUILTIN_FRONTMATTER_KEYS
+		if len(cla.frontmatterTemplate) != 0 {
+			reservedKeys = []string{}
+		}
+		params, paramsErr := newFrontmatterParams(frontmatterParamsPath, reservedKeys)
+		if paramsErr != nil {
+			return paramsErr
+		}
+		cla.frontmatterParams = params
+	}
+	if len(filterRulesPath) != 0 {
+		rules, rulesErr := newFilterRules(filterRulesPath)
+		if rulesErr != nil {
+			return rulesErr
+		}
+		cla.filterRules = rules
+	}
+	if _, cwModeExists := CW_MODES[cla.cwMode]; !cwModeExists {
+		return fmt.Errorf("Invalid content warning mode specified: %s", cla.cwMode)
+	}
+	if _, contentModeExists := CONTENT_MODES[cla.contentMode]; !contentModeExists {
+		return fmt.Errorf("Invalid content mode specified: %s", cla.contentMode)
+	}
+	if _, slugStyleExists := SLUG_STYLES[cla.slugStyle]; !slugStyleExists {
+		return fmt.Errorf("Invalid slug style specified: %s", cla.slugStyle)
+	}
+	messages, messagesErr := newMessageCatalog(cla.lang, messagesPath)
+	if messagesErr != nil {
+		return messagesErr
+	}
+	cla.messages = messages
+	if cla.multilingual {
+		if cla.outputFormat != "hugo" {
+			return fmt.Errorf("Invalid command line arguments: --multilingual requires the hugo format")
+		}
+		if filepath.Base(filepath.Dir(cla.outputRootPathHugoAssets)) != cla.lang {
+			return fmt.Errorf("Invalid command line arguments: --multilingual requires an --output in the content/%s directory of the --lang language", cla.lang)
+		}
+	}
+	if _, themePresetExists := THEME_PRESETS[cla.themePreset]; len(cla.themePreset) != 0 && !themePresetExists {
+		return fmt.Errorf("Invalid theme preset specified: %s", cla.themePreset)
+	}
+	if len(cla.themePreset) != 0 && cla.outputFormat != "hugo" {
+		return fmt.Errorf("Invalid command line arguments: --theme-preset requires the hugo format")
+	}
+	if _, tagStyleExists := TAG_STYLES[cla.tagStyle]; !tagStyleExists {
+		return fmt.Errorf("Invalid tag style specified: %s", cla.tagStyle)
+	}
+	normalizer, normalizerErr := newTagNormalizer(cla.tagStyle, tagMapPath)
+	if normalizerErr != nil {
+		return normalizerErr
+	}
+	cla.tagNormalizer = normalizer
+	if _, hashtagModeExists := HASHTAG_MODES[cla.hashtagMode]; !hashtagModeExists {
+		return fmt.Errorf("Invalid hashtag mode specified: %s", cla.hashtagMode)
+	}
+	if _, mentionModeExists := MENTION_MODES[cla.mentionMode]; !mentionModeExists {
+		return fmt.Errorf("Invalid mention mode specified: %s", cla.mentionMode)
+	}
+	if _, sensitiveModeExists := SENSITIVE_MEDIA_MODES[cla.sensitiveMedia]; !sensitiveModeExists {
+		return fmt.Errorf("Invalid sensitive media mode specified: %s", cla.sensitiveMedia)
+	}
+	if !CSS_CLASSES_PATTERN.MatchString(cla.sensitiveClass) {
+		return fmt.Errorf("Invalid sensitive media class specified: %s", cla.sensitiveClass)
+	}
+	if _, boostStyleExists := BOOST_STYLES[cla.boostStyle]; len(cla.boostStyle) != 0 && !boostStyleExists {
+		return fmt.Errorf("Invalid boost style specified: %s", cla.boostStyle)
+	}
+	if _, redirectFormatExists := REDIRECT_FORMATS[cla.redirectsFormat]; !redirectFormatExists {
+		return fmt.Errorf("Invalid redirects format specified: %s", cla.redirectsFormat)
+	}
+	if _, groupByExists := HUGO_GROUP_BY_MODES[cla.groupBy]; !groupByExists {
+		return fmt.Errorf("Invalid group-by mode specified: %s", cla.groupBy)
+	}
+	// Parse the verbosity level
+	switch strings.ToLower(logLevelString) {
+	case "debug":
+		cla.logLevelValue = int(slog.LevelDebug)
+	case "info":
+		cla.logLevelValue = int(slog.LevelInfo)
+	case "warn":
+		cla.logLevelValue = int(slog.LevelWarn)
+	case "error":
+		cla.logLevelValue = int(slog.LevelError)
+	default:
+		return fmt.Errorf("Invalid log level specified: %s", logLevelString)
+	}
+	return nil
+}
+
+// /////////////////////////////////////////////////////////////////////////////
+// publishingStats
+type PublishingStats struct {
+	totalTootCount    uint
+	renderedTootCount uint
+	filteredTootCount uint
+	mediaFilesCount   uint
+	replyThreadsCount uint
+	tagPagesCount     uint
+	seriesPagesCount  uint
+	sectionPagesCount uint
+	draftPagesCount   uint
+	missingAltCount   uint
+}
+
+// PageStats are the counts for a hugo page that the frontmatter template can
+// reference as .Stats
+type PageStats struct {
+	TootCount  int
+	ReplyCount int
+	MediaCount int
+	WordCount  int
+}
+
+// /////////////////////////////////////////////////////////////////////////////
+// ActivityObjectAttachment
+type ActivityObjectAttachment struct {
+	Type         string `json:"type"`
+	MediaType    string `json:"mediaType"`
+	URL          string `json:"url"`
+	Name         string `json:"name"`
+	BaseFilename string
+	// Set when the media was downloaded because it isn't in the archive
+	SourcePath string
+	// Set when the media was transcoded and the original kept as a fallback
+	FallbackFilename  string
+	FallbackMediaType string
+	// Set when smaller copies were made for the srcset, largest last
+	Srcset []*SrcsetImage
+	// Set when the blurhash was decoded into a placeholder image
+	PlaceholderFilename string
+	Blurhash            string `json:"blurhash"`
+	// Horizontal and vertical focus, each from -1 (left, bottom) to 1
+	FocalPoint []float64 `json:"focalPoint"`
+	AtomURI    string    `json:"atomUri"`
+	Width      uint      `json:"width"`
+	Height     uint      `json:"height"`
+}
+
+// HasFocalPoint returns true if the image has a focal point other than the
+// center, which Mastodon also uses when no focal point was set
+func (aoa *ActivityObjectAttachment) HasFocalPoint() bool {
+	return len(aoa.FocalPoint) == 2 && (aoa.FocalPoint[0] != 0 || aoa.FocalPoint[1] != 0)
+}
+
+// FocusX returns the horizontal focal point
+func (aoa *ActivityObjectAttachment) FocusX() float64 {
+	if !aoa.HasFocalPoint() {
+		return 0
+	}
+	return aoa.FocalPoint[0]
+}
+
+// FocusY returns the vertical focal point
+func (aoa *ActivityObjectAttachment) FocusY() float64 {
+	if !aoa.HasFocalPoint() {
+		return 0
+	}
+	return aoa.FocalPoint[1]
+}
+
+// FocalAnchor returns the Hugo image processing anchor, e.g. TopLeft, for the
+// third of the image the focal point is in
+func (aoa *ActivityObjectAttachment) FocalAnchor() string {
+	thirdOf := func(focus float64, low string, high string) string {
+		switch {
+		case focus < -1.0/3:
+			return low
+		case focus > 1.0/3:
+			return high
+		default:
+			return ""
+		}
+	}
+	anchor := thirdOf(aoa.FocusY(), "Bottom", "Top") + thirdOf(aoa.FocusX(), "Left", "Right")
+	if len(anchor) <= 0 {
+		return "Center"
+	}
+	return anchor
+}
+
+// SrcsetImage is a single width of a responsive image
+type SrcsetImage struct {
+	Filename string `json:"filename"`
+	Width    int    `json:"width"`
+}
+
+// SrcsetValue returns the srcset attribute value, or an empty string if the
+// image has a single size
+func (aoa *ActivityObjectAttachment) SrcsetValue() string {
+	candidates := []string{}
+	for _, eachImage := range aoa.Srcset {
+		candidates = append(candidates, fmt.Sprintf("%s %dw", eachImage.Filename, eachImage.Width))
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// IsImage returns true for image attachments
+func (aoa *ActivityObjectAttachment) IsImage() bool {
+	return strings.HasPrefix(aoa.MediaType, "image/")
+}
+
+// IsAudio returns true for audio attachments, e.g. audio/mpeg or audio/ogg
+func (aoa *ActivityObjectAttachment) IsAudio() bool {
+	return strings.HasPrefix(aoa.MediaType, "audio/")
+}
+
+// /////////////////////////////////////////////////////////////////////////////
+// ActivityObjectTag
+type ActivityObjectTag struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	HREF string `json:"href"`
+	// The image of an Emoji tag
+	Icon *ActivityObjectTagIcon `json:"icon"`
+}
+
+type ActivityObjectTagIcon struct {
+	MediaType string `json:"mediaType"`
+	URL       string `json:"url"`
+	// Set when the image was found in the archive or downloaded
+	SourcePath string
+}
+
+// EmojiFilename returns the page bundle filename of the custom emoji image
+func (aot *ActivityObjectTag) EmojiFilename() string {
+	emojiExt := ""
+	if parsedURL, parsedURLErr := url.Parse(aot.Icon.URL); parsedURLErr == nil {
+		emojiExt = path.Ext(parsedURL.Path)
+	}
+	return "emoji-" + strings.Trim(aot.Name, ":") + emojiExt
+}
+
+// /////////////////////////////////////////////////////////////////////////////
+// ActivityObject
+type ActivityObject struct {
+	Announcement string
+	Language     string
+	ID           string                      `json:"id"`
+	Type         string                      `json:"type"`
+	InReplyTo    string                      `json:"inReplyTo"`
+	Published    string                      `json:"published"`
+	Updated      string                      `json:"updated"`
+	URL          string                      `json:"url"`
+	To           []string                    `json:"to"`
+	CC           []string                    `json:"cc"`
+	AtomURI      string                      `json:"atomUri"`
+	Summary      string                      `json:"summary"`
+	Sensitive    bool                        `json:"sensitive"`
+	Content      string                      `json:"content"`
+	Attachments  []*ActivityObjectAttachment `json:"attachment"`
+	Tags         []*ActivityObjectTag        `json:"tag"`
+	// Set by --link-previews
+	LinkPreview *LinkPreview
+	// Page bundle directory name, set by --slug-style. The status ID when
+	// empty.
+	Slug string
+}
+
+// ImageAttachments returns the image attachments, in attachment order
+func (ao *ActivityObject) ImageAttachments() []*ActivityObjectAttachment {
+	images := []*ActivityObjectAttachment{}
+	for _, eachAttachment := range ao.Attachments {
+		if eachAttachment.IsImage() {
+			images = append(images, eachAttachment)
+		}
+	}
+	return images
+}
+
+// addressedTo returns true if any of the recipients is in the address list
+func addressedTo(addressList []string, recipients []string) bool {
+	return slices.ContainsFunc(addressList, func(address string) bool {
+		return slices.Contains(recipients, address)
+	})
+}
+
+// repliesToOtherUser returns true if the toot is a reply to a toot by
+// someone else
+func (ao *ActivityObject) repliesToOtherUser() bool {
+	selfReplyToURL := fmt.Sprintf("https://%s/users/%s", HOST, USER)
+	return len(ao.InReplyTo) != 0 && !strings.HasPrefix(ao.InReplyTo, selfReplyToURL)
+}
+
+// addressingVisibility interprets the to/cc addressing using the Mastodon
+// audience rules. It returns one of the VISIBILITIES
+func addressingVisibility(to []string, cc []string) string {
+	switch {
+	case addressedTo(to, ACTIVITY_STREAMS_PUBLIC):
+		return "public"
+	case addressedTo(cc, ACTIVITY_STREAMS_PUBLIC):
+		return "unlisted"
+	case slices.Contains(to, MY_FOLLOWERS_URL):
+		return "followers"
+	default:
+		return "direct"
+	}
+}
+
+// Visibility returns the audience of the toot
+func (ao *ActivityObject) Visibility() string {
+	return addressingVisibility(ao.To, ao.CC)
+}
+
+func (ao *ActivityObject) UnmarshalJSON(data []byte) error {
+	var s string
+	stringUnmarshalErr := json.Unmarshal(data, &s)
+	// If this succeeded, we need to ignore the rest of the data
+	if stringUnmarshalErr == nil {
+		ao.Announcement = s
+	} else {
+		dictMap := map[string]interface{}{}
+		objUnmarshalErr := json.Unmarshal(data, &dictMap)
+		if objUnmarshalErr != nil {
+			return objUnmarshalErr
+		}
+		ao.ID = jsonScalar[string]("id", dictMap)
+		ao.Type = jsonScalar[string]("type", dictMap)
+		ao.InReplyTo = jsonScalar[string]("inReplyTo", dictMap)
+		ao.Published = jsonScalar[string]("published", dictMap)
+		ao.Updated = jsonScalar[string]("updated", dictMap)
+		ao.URL = jsonScalar[string]("url", dictMap)
+		ao.AtomURI = jsonScalar[string]("atomUri", dictMap)
+		ao.Content = jsonScalar[string]("content", dictMap)
+		ao.Summary = jsonScalar[string]("summary", dictMap)
+		ao.Sensitive = jsonScalar[bool]("sensitive", dictMap)
+
+		// The language is the key of the contentMap, e.g. {"en": "<p>..."}
+		contentMap, contentMapExists := dictMap["contentMap"].(map[string]interface{})
+		if contentMapExists && len(contentMap) != 0 {
+			ao.Language = slices.Sorted(maps.Keys(contentMap))[0]
+		}
+
+		fieldValue, fieldValueExists := dictMap["to"]
+		if fieldValueExists {
+			jsonBytes, _ := json.Marshal(fieldValue)
+			fieldUnmarshalErr := json.Unmarshal(jsonBytes, &ao.To)
+			if fieldUnmarshalErr != nil {
+				return fieldUnmarshalErr
+			}
+		}
+		fieldValue, fieldValueExists = dictMap["cc"]
+		if fieldValueExists {
+			jsonBytes, _ := json.Marshal(fieldValue)
+			fieldUnmarshalErr := json.Unmarshal(jsonBytes, &ao.CC)
+			if fieldUnmarshalErr != nil {
+				return fieldUnmarshalErr
+			}
+		}
+
+		fieldValue, fieldValueExists = dictMap["attachment"]
+		if fieldValueExists {
+			jsonBytes, _ := json.Marshal(fieldValue)
+			fieldUnmarshalErr := json.Unmarshal(jsonBytes, &ao.Attachments)
+			if fieldUnmarshalErr != nil {
+				return fieldUnmarshalErr
+			}
+			// For each one, update the BaseFilename to make the template
+			// easier
+			for _, eachAttachment := range ao.Attachments {
+				urlPathParts := strings.Split(eachAttachment.URL, "/")
+				eachAttachment.BaseFilename = urlPathParts[len(urlPathParts)-1]
+			}
+		}
+		fieldValue, fieldValueExists = dictMap["tag"]
+		if fieldValueExists {
+			jsonBytes, _ := json.Marshal(fieldValue)
+			fieldUnmarshalErr := json.Unmarshal(jsonBytes, &ao.Tags)
+			if fieldUnmarshalErr != nil {
+				return fieldUnmarshalErr
+			}
+			// Remove any hashtags from the tags...
+			for _, eachTag := range ao.Tags {
+				eachTag.Name = strings.Replace(eachTag.Name, "#", "", -1)
+			}
+		}
+		// Always add a "Social Media" tag
+		if len(ao.Tags) <= 0 {
+			ao.Tags = make([]*ActivityObjectTag, 0)
+		}
+		ao.Tags = append(ao.Tags, &ActivityObjectTag{
+			Type: "Hashtag",
+			HREF: fmt.Sprintf("https://%s/tags/social%%20media", HOST),
+			Name: "Social Media",
+		})
+	}
+	return nil
+}
+
+// /////////////////////////////////////////////////////////////////////////////
+// ActivityEntry
+type ActivityEntry struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Published string          `json:"published"`
+	To        []string        `json:"to"`
+	CC        []string        `json:"cc"`
+	Object    *ActivityObject `json:"object"`
+}
+
+// LastModified returns the time the toot was last edited, or the publish
+// time for toots that were never edited
+func (ae *ActivityEntry) LastModified() string {
+	if ae.Object == nil {
+		return ae.Published
+	}
+	updatedTime, updatedErr := time.Parse(time.RFC3339, ae.Object.Updated)
+	publishedTime, publishedErr := time.Parse(time.RFC3339, ae.Published)
+	if updatedErr != nil || publishedErr != nil || !updatedTime.After(publishedTime) {
+		return ae.Published
+	}
+	return ae.Object.Updated
+}
+
+// pageLastModified returns the latest LastModified time of the toots
+func pageLastModified(toots []*ActivityEntry) string {
+	lastModified := ""
+	lastModifiedTime := time.Time{}
+	for _, eachItem := range toots {
+		eachLastModified := eachItem.LastModified()
+		eachTime, eachTimeErr := time.Parse(time.RFC3339, eachLastModified)
+		if len(lastModified) <= 0 || (eachTimeErr == nil && eachTime.After(lastModifiedTime)) {
+			lastModified = eachLastModified
+			lastModifiedTime = eachTime
+		}
+	}
+	return lastModified
+}
+
+// Visibility returns the audience of the activity. Unlike the object, this
+// is available for boosts too
+func (ae *ActivityEntry) Visibility() string {
+	return addressingVisibility(ae.To, ae.CC)
+}
+
+// /////////////////////////////////////////////////////////////////////////////
+// JSONFeed (https://www.jsonfeed.org/version/1.1/)
+type JSONFeedAttachment struct {
+	URL      string `json:"url"`
+	MimeType string `json:"mime_type"`
+	Title    string `json:"title,omitempty"`
+}
+
+type JSONFeedItem struct {
+	ID            string                `json:"id"`
+	URL           string                `json:"url"`
+	ContentHTML   string                `json:"content_html"`
+	DatePublished string                `json:"date_published"`
+	DateModified  string                `json:"date_modified,omitempty"`
+	Tags          []string              `json:"tags,omitempty"`
+	Attachments   []*JSONFeedAttachment `json:"attachments,omitempty"`
+}
+
+type JSONFeed struct {
+	Version     string          `json:"version"`
+	Title       string          `json:"title"`
+	HomePageURL string          `json:"home_page_url"`
+	Items       []*JSONFeedItem `json:"items"`
+}
+
+// /////////////////////////////////////////////////////////////////////////////
+// AtomFeed (RFC 4287)
+type AtomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+	Title  string `xml:"title,attr,omitempty"`
+}
+
+type AtomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type AtomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type AtomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri"`
+}
+
+type AtomEntry struct {
+	ID         string          `xml:"id"`
+	Title      string          `xml:"title"`
+	Published  string          `xml:"published"`
+	Updated    string          `xml:"updated"`
+	Links      []*AtomLink     `xml:"link"`
+	Categories []*AtomCategory `xml:"category"`
+	Content    *AtomContent    `xml:"content"`
+}
+
+type AtomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated string       `xml:"updated"`
+	Author  *AtomAuthor  `xml:"author"`
+	Links   []*AtomLink  `xml:"link"`
+	Entries []*AtomEntry `xml:"entry"`
+}
+
+// /////////////////////////////////////////////////////////////////////////////
+// ActivityPub
+type ActivityPubAttachment struct {
+	Type      string `json:"type"`
+	MediaType string `json:"mediaType"`
+	URL       string `json:"url"`
+	Name      string `json:"name,omitempty"`
+	Width     uint   `json:"width,omitempty"`
+	Height    uint   `json:"height,omitempty"`
+}
+
+type ActivityPubNote struct {
+	Context      string                   `json:"@context"`
+	ID           string                   `json:"id"`
+	Type         string                   `json:"type"`
+	URL          string                   `json:"url"`
+	AttributedTo string                   `json:"attributedTo"`
+	InReplyTo    string                   `json:"inReplyTo,omitempty"`
+	Published    string                   `json:"published"`
+	To           []string                 `json:"to"`
+	CC           []string                 `json:"cc"`
+	Content      string                   `json:"content"`
+	Attachments  []*ActivityPubAttachment `json:"attachment"`
+	Tags         []*ActivityObjectTag     `json:"tag"`
+}
+
+type ActivityPubMapping struct {
+	OriginalID  string `json:"originalId"`
+	OriginalURL string `json:"originalUrl"`
+	ID          string `json:"id"`
+	URL         string `json:"url"`
+}
+
+// /////////////////////////////////////////////////////////////////////////////
+// htmlNode is a minimal DOM for toot content. The content is parsed with the
+// non-strict encoding/xml decoder so that the script stays dependency free.
+type htmlNode struct {
+	Tag      string
+	Attrs    map[string]string
+	Text     string
+	Children []*htmlNode
+}
+
+// /////////////////////////////////////////////////////////////////////////////
+// HugoData files are written to data/mastodon/YYYY-MM.json
+type HugoDataAttachment struct {
+	URL       string `json:"url"`
+	MediaType string `json:"mediaType"`
+	Name      string `json:"name"`
+	Width     uint   `json:"width"`
+	Height    uint   `json:"height"`
+	// Omitted for images focused on the center
+	FocalPoint []float64 `json:"focalPoint,omitempty"`
+}
+
+type HugoDataToot struct {
+	ID          string                `json:"id"`
+	URL         string                `json:"url"`
+	Published   string                `json:"published"`
+	InReplyTo   string                `json:"inReplyTo,omitempty"`
+	ThreadRoot  string                `json:"threadRoot"`
+	Content     string                `json:"content"`
+	Tags        []string              `json:"tags"`
+	Attachments []*HugoDataAttachment `json:"attachments"`
+}
+
+type HugoDataMonth struct {
+	Month string          `json:"month"`
+	Toots []*HugoDataToot `json:"toots"`
+}
+
+// /////////////////////////////////////////////////////////////////////////////
+// GhostImport (https://ghost.org/docs/migration/custom/)
+type GhostPost struct {
+	ID           int    `json:"id"`
+	Title        string `json:"title"`
+	Slug         string `json:"slug"`
+	Lexical      string `json:"lexical"`
+	Status       string `json:"status"`
+	Type         string `json:"type"`
+	CanonicalURL string `json:"canonical_url"`
+	PublishedAt  string `json:"published_at"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+type GhostTag struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type GhostPostTag struct {
+	PostID int `json:"post_id"`
+	TagID  int `json:"tag_id"`
+}
+
+type GhostData struct {
+	Posts     []*GhostPost    `json:"posts"`
+	Tags      []*GhostTag     `json:"tags"`
+	PostsTags []*GhostPostTag `json:"posts_tags"`
+}
+
+type GhostMeta struct {
+	ExportedOn int64  `json:"exported_on"`
+	Version    string `json:"version"`
+}
+
+type GhostDB struct {
+	Meta *GhostMeta `json:"meta"`
+	Data *GhostData `json:"data"`
+}
+
+type GhostImport struct {
+	DB []*GhostDB `json:"db"`
+}
+
+// /////////////////////////////////////////////////////////////////////////////
+// DayOne journal import
+type DayOnePhoto struct {
+	Identifier   string `json:"identifier"`
+	MD5          string `json:"md5"`
+	Type         string `json:"type"`
+	Width        uint   `json:"width,omitempty"`
+	Height       uint   `json:"height,omitempty"`
+	OrderInEntry int    `json:"orderInEntry"`
+}
+
+type DayOneEntry struct {
+	UUID         string         `json:"uuid"`
+	CreationDate string         `json:"creationDate"`
+	ModifiedDate string         `json:"modifiedDate"`
+	TimeZone     string         `json:"timeZone"`
+	Text         string         `json:"text"`
+	Tags         []string       `json:"tags,omitempty"`
+	Starred      bool           `json:"starred"`
+	Photos       []*DayOnePhoto `json:"photos,omitempty"`
+}
+
+type DayOneJournal struct {
+	Metadata map[string]string `json:"metadata"`
+	Entries  []*DayOneEntry    `json:"entries"`
+}
+
+// /////////////////////////////////////////////////////////////////////////////
+// Outbox
+type Outbox struct {
+	TotalItems           uint             `json:"totalItems"`
+	OrderedItems         []*ActivityEntry `json:"orderedItems"`
+	ArchiveDirectoryRoot string
+	ThreadIDChain        map[string]*ActivityEntry
+	// Object IDs of the pinned toots in the actor's featured collection
+	FeaturedIDs map[string]bool
+	// Number of toots each named filter removed, in filter order
+	SkippedCounts []*skippedCount
+	// Every removed toot, with the filter that removed it
+	SkippedToots []*skippedToot
+	// Steps applied to every media file after it's copied to the output
+	MediaProcessors []mediaProcessorFunc
+	// Content hash to the first output copy of each media file, when media
+	// deduplication is enabled
+	MediaHashes       map[string]string
+	DedupedMediaCount uint
+	DedupedMediaBytes int64
+	// Previously copied media, when unchanged media isn't copied again
+	MediaManifest *mediaManifest
+	// Previously written pages, when only changed pages are written
+	RenderState *renderState
+	// Files generated by the previous and current runs
+	OutputManifest *outputManifest
+	// One of MEDIA_MODES
+	MediaMode string
+	// Optional --media-hook command and arguments, run instead of MediaMode
+	MediaHook []string
+	// Check the size of each media file copy
+	VerifyMedia bool
+}
+
+type skippedToot struct {
+	entry      *ActivityEntry
+	filterName string
+}
+
+type skippedCount struct {
+	filterName string
+	count      uint
+}
+
+func (ob *Outbox) filterToots(filterName string, filterFunc FilterTootFunc) {
+	filteredToots := []*ActivityEntry{}
+	for _, eachEntry := range ob.OrderedItems {
+		if filterFunc(eachEntry) {
+			filteredToots = append(filteredToots, eachEntry)
+		} else {
+			ob.SkippedToots = append(ob.SkippedToots, &skippedToot{
+				entry:      eachEntry,
+				filterName: filterName,
+			})
+		}
+	}
+	ob.SkippedCounts = append(ob.SkippedCounts, &skippedCount{
+		filterName: filterName,
+		count:      uint(len(ob.OrderedItems) - len(filteredToots)),
+	})
+	ob.OrderedItems = filteredToots
+}
+
+// skippedCountLogArgs returns the per-filter skipped counts as log key/value
+// pairs, e.g. dateRangeSkippedCount=12
+func (ob *Outbox) skippedCountLogArgs() []any {
+	logArgs := []any{}
+	for _, eachSkipped := range ob.SkippedCounts {
+		logArgs = append(logArgs, eachSkipped.filterName+"SkippedCount", eachSkipped.count)
+	}
+	return logArgs
+}
+
+// threadRoot walks the replyTo chain for the given entry and returns the
+// root activity together with the number of hops it took to get there
+func (ob *Outbox) threadRoot(entry *ActivityEntry) (*ActivityEntry, uint, error) {
+	threadRootActivityItem := entry
+	hopCount := uint(0)
+	for {
+		replyToID := threadRootActivityItem.Object.InReplyTo
+		if len(replyToID) <= 0 {
+			break
+		}
+		parentActivityItem, parentActivityItemExists := ob.ThreadIDChain[replyToID]
+		if !parentActivityItemExists {
+			break
+		}
+		if parentActivityItem == threadRootActivityItem {
+			return nil, 0, fmt.Errorf("Loop detected for item: %s", threadRootActivityItem.Object.ID)
+		}
+		threadRootActivityItem = parentActivityItem
+		hopCount += 1
+	}
+	return threadRootActivityItem, hopCount, nil
+}
+
+// parsePublished parses the activity's RFC3339 publish time
+// Sample date: 2024-02-02T17:40:31Z
+func parsePublished(entry *ActivityEntry) (time.Time, error) {
+	parsedDate, parsedDateErr := time.Parse(time.RFC3339, entry.Published)
+	if parsedDateErr != nil {
+		return parsedDate, fmt.Errorf("Failed to parse date: %s. Error: %s", entry.Published, parsedDateErr)
+	}
+	return parsedDate, nil
+}
+
+// tootBundlePath returns the YYYY/MM/<id> page bundle directory, relative to
+// the output root, for the given toot (typically a thread root)
+func tootBundlePath(threadRootActivityItem *ActivityEntry) (string, error) {
+	// Add a bit of structure to the output
+	parsedDate, parsedDateErr := parsePublished(threadRootActivityItem)
+	if parsedDateErr != nil {
+		return "", parsedDateErr
+	}
+	slug := threadRootActivityItem.Object.Slug
+	if len(slug) <= 0 {
+		slug = tootFileID(threadRootActivityItem)
+	}
+	return path.Join(fmt.Sprintf("%d", parsedDate.Year()),
+		fmt.Sprintf("%.2d", parsedDate.Month()),
+		slug,
+	), nil
+}
+
+// assignSlugs names the page bundle directory of every toot using the
+// --slug-style. Names that are already used in the same month directory get
+// a -2, -3, ... suffix, in publish order. It returns the number of suffixed
+// names.
+func (ob *Outbox) assignSlugs(style string) uint {
+	collisionCount := uint(0)
+	usedPaths := map[string]bool{}
+	for _, eachEntry := range ob.OrderedItems {
+		eachEntry.Object.Slug = ""
+		monthPath, monthPathErr := tootBundlePath(eachEntry)
+		if monthPathErr != nil {
+			continue
+		}
+		monthPath = path.Dir(monthPath)
+		baseSlug := SLUG_STYLES[style](eachEntry)
+		slug := baseSlug
+		for suffix := 2; usedPaths[path.Join(monthPath, slug)]; suffix++ {
+			slug = fmt.Sprintf("%s-%d", baseSlug, suffix)
+		}
+		if slug != baseSlug {
+			collisionCount += 1
+		}
+		usedPaths[path.Join(monthPath, slug)] = true
+		eachEntry.Object.Slug = slug
+	}
+	return collisionCount
+}
+
+// tootFileID returns the trailing status ID of the toot's object ID
+func tootFileID(entry *ActivityEntry) string {
+	idParts := strings.Split(entry.Object.ID, "/")
+	return idParts[len(idParts)-1]
+}
+
+func parseContentHTML(content string) *htmlNode {
+	rootNode := &htmlNode{}
+	nodeStack := []*htmlNode{rootNode}
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+	for {
+		token, tokenErr := decoder.Token()
+		if tokenErr != nil {
+			// Either EOF or something we can't make sense of. Either way, keep
+			// whatever was parsed up to this point
+			break
+		}
+		parentNode := nodeStack[len(nodeStack)-1]
+		switch typedToken := token.(type) {
+		case xml.StartElement:
+			childNode := &htmlNode{
+				Tag:   strings.ToLower(typedToken.Name.Local),
+				Attrs: map[string]string{},
+			}
+			for _, eachAttr := range typedToken.Attr {
+				childNode.Attrs[strings.ToLower(eachAttr.Name.Local)] = eachAttr.Value
+			}
+			parentNode.Children = append(parentNode.Children, childNode)
+			nodeStack = append(nodeStack, childNode)
+		case xml.EndElement:
+			// Unwind to the matching element, ignoring stray end tags
+			endTag := strings.ToLower(typedToken.Name.Local)
+			for i := len(nodeStack) - 1; i > 0; i-- {
+				if nodeStack[i].Tag == endTag {
+					nodeStack = nodeStack[:i]
+					break
+				}
+			}
+		case xml.CharData:
+			parentNode.Children = append(parentNode.Children, &htmlNode{Text: string(typedToken)})
+		}
+	}
+	return rootNode
+}
+
+// xmlEscapeString escapes the text for use in XML character data and
+// attribute values
+func xmlEscapeString(text string) string {
+	var escapedBuilder strings.Builder
+	xml.EscapeText(&escapedBuilder, []byte(text))
+	return escapedBuilder.String()
+}
+
+// htmlToXHTML re-serializes toot HTML as well-formed XHTML. HTML entities are
+// resolved by the parser and void elements are self-closed.
+func htmlToXHTML(content string) string {
+	voidElements := []string{"br", "hr", "img", "input", "meta", "link", "source", "wbr"}
+	var xhtmlBuilder strings.Builder
+	var walkNode func(node *htmlNode)
+	walkNode = func(node *htmlNode) {
+		if len(node.Tag) <= 0 {
+			xhtmlBuilder.WriteString(xmlEscapeString(node.Text))
+			for _, eachChild := range node.Children {
+				walkNode(eachChild)
+			}
+			return
+		}
+		fmt.Fprintf(&xhtmlBuilder, "<%s", node.Tag)
+		for _, eachAttrName := range slices.Sorted(maps.Keys(node.Attrs)) {
+			fmt.Fprintf(&xhtmlBuilder, " %s=\"%s\"", eachAttrName, xmlEscapeString(node.Attrs[eachAttrName]))
+		}
+		if slices.Contains(voidElements, node.Tag) {
+			xhtmlBuilder.WriteString("/>")
+			return
+		}
+		xhtmlBuilder.WriteString(">")
+		for _, eachChild := range node.Children {
+			walkNode(eachChild)
+		}
+		fmt.Fprintf(&xhtmlBuilder, "</%s>", node.Tag)
+	}
+	walkNode(parseContentHTML(content))
+	return xhtmlBuilder.String()
+}
+
+// Elements, and their attributes, that sanitizeContentHTML keeps. This is the
+// markup Mastodon itself allows in toots.
+var SANITIZED_HTML_ELEMENTS = map[string][]string{
+	"a":          {"href", "rel", "class"},
+	"b":          {},
+	"blockquote": {},
+	"br":         {},
+	"code":       {},
+	"del":        {},
+	"em":         {},
+	"h1":         {},
+	"h2":         {},
+	"h3":         {},
+	"h4":         {},
+	"h5":         {},
+	"h6":         {},
+	"i":          {},
+	"li":         {},
+	"ol":         {},
+	"p":          {},
+	"pre":        {},
+	"s":          {},
+	"span":       {"class"},
+	"strong":     {},
+	"u":          {},
+	"ul":         {},
+}
+
+// sanitizeContentHTML re-serializes HTML from a remote server with only the
+// SANITIZED_HTML_ELEMENTS and their attributes. Other elements are replaced
+// by their text, except scripts and styles, which are dropped, and links
+// that aren't http, https or mailto URLs lose their href.
+func sanitizeContentHTML(content string) string {
+	var sanitizedBuilder strings.Builder
+	var walkNode func(node *htmlNode)
+	walkNode = func(node *htmlNode) {
+		allowedAttrs, isAllowed := SANITIZED_HTML_ELEMENTS[node.Tag]
+		switch {
+		case len(node.Tag) <= 0:
+			sanitizedBuilder.WriteString(html.EscapeString(node.Text))
+		case node.Tag == "script" || node.Tag == "style":
+			return
+		case isAllowed:
+			fmt.Fprintf(&sanitizedBuilder, "<%s", node.Tag)
+			for _, eachAttrName := range slices.Sorted(maps.Keys(node.Attrs)) {
+				attrValue := node.Attrs[eachAttrName]
+				if !slices.Contains(allowedAttrs, eachAttrName) {
+					continue
+				}
+				if eachAttrName == "href" {
+					parsedURL, parsedURLErr := url.Parse(strings.TrimSpace(attrValue))
+					if parsedURLErr != nil || !slices.Contains([]string{"http", "https", "mailto"}, strings.ToLower(parsedURL.Scheme)) {
+						continue
+					}
+				}
+				fmt.Fprintf(&sanitizedBuilder, " %s=\"%s\"", eachAttrName, html.EscapeString(attrValue))
+			}
+			sanitizedBuilder.WriteString(">")
+			if node.Tag == "br" {
+				return
+			}
+		}
+		for _, eachChild := range node.Children {
+			walkNode(eachChild)
+		}
+		if isAllowed {
+			fmt.Fprintf(&sanitizedBuilder, "</%s>", node.Tag)
+		}
+	}
+	walkNode(parseContentHTML(content))
+	return sanitizedBuilder.String()
+}
+
+// htmlLinkFunc renders an anchor element in the target markup
+type htmlLinkFunc func(href string, text string) string
+
+// htmlToMarkup converts toot HTML to a lightweight markup. Paragraphs are
+// separated by blank lines, anchors are rendered by the linkFunc and images,
+// e.g. custom emoji, by their alt text.
+func htmlToMarkup(content string, linkFunc htmlLinkFunc) string {
+	var textBuilder strings.Builder
+	var nodeText func(node *htmlNode) string
+	nodeText = func(node *htmlNode) string {
+		text := node.Text
+		for _, eachChild := range node.Children {
+			text += nodeText(eachChild)
+		}
+		return text
+	}
+	var walkNode func(node *htmlNode)
+	walkNode = func(node *htmlNode) {
+		switch node.Tag {
+		case "br":
+			textBuilder.WriteString("\n")
+			return
+		case "a":
+			textBuilder.WriteString(linkFunc(node.Attrs["href"], nodeText(node)))
+			return
+		case "img":
+			textBuilder.WriteString(node.Attrs["alt"])
+			return
+		case "p", "div", "blockquote", "pre", "ul", "ol", "h1", "h2", "h3", "h4", "h5", "h6":
+			if textBuilder.Len() != 0 {
+				textBuilder.WriteString("\n\n")
+			}
+		case "li":
+			if textBuilder.Len() != 0 {
+				textBuilder.WriteString("\n")
+			}
+		}
+		textBuilder.WriteString(node.Text)
+		for _, eachChild := range node.Children {
+			walkNode(eachChild)
+		}
+	}
+	walkNode(parseContentHTML(content))
+	return strings.TrimSpace(textBuilder.String())
+}
+
+// htmlToText converts toot HTML to plain text.
+func htmlToText(content string) string {
+	return htmlToMarkup(content, func(href string, text string) string {
+		return text
+	})
+}
+
+// escapeMarkdownText backslash escapes the text so Goldmark renders it as
+// written. Escapes for characters that are only significant at the start of
+// a line are marked for resolveMarkdownEscapes.
+func escapeMarkdownText(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, eachLine := range lines {
+		eachLine = MARKDOWN_INLINE_ESCAPER.Replace(eachLine)
+		lineStart := MARKDOWN_LINE_START_PATTERN.FindStringSubmatch(eachLine)
+		if lineStart != nil {
+			// 1. is escaped as 1\.
+			blockMarker := lineStart[2]
+			escapedMarker := MARKDOWN_LINE_START_ESCAPE + blockMarker
+			if len(blockMarker) > 1 {
+				escapedMarker = blockMarker[:len(blockMarker)-1] + MARKDOWN_LINE_START_ESCAPE + blockMarker[len(blockMarker)-1:]
+			}
+			eachLine = lineStart[1] + escapedMarker + eachLine[len(lineStart[0]):]
+		}
+		lines[i] = eachLine
+	}
+	return strings.Join(lines, "\n")
+}
+
+// resolveMarkdownEscapes turns the escapeMarkdownText marks at the start of
+// a line into backslashes and drops the others
+func resolveMarkdownEscapes(text string) string {
+	text = MARKDOWN_LINE_START_ESCAPE_PATTERN.ReplaceAllString(text, `${1}\`)
+	return strings.ReplaceAll(text, MARKDOWN_LINE_START_ESCAPE, "")
+}
+
+// htmlToMarkdown converts toot HTML to Markdown, keeping the inline
+// formatting, headings, lists, block quotes and code of rich toots, such as
+// those from servers that accept Markdown. Line breaks become backslash hard
+// line breaks, and text outside code is escaped.
+func htmlToMarkdown(content string) string {
+	var nodeText func(node *htmlNode) string
+	nodeText = func(node *htmlNode) string {
+		text := node.Text
+		for _, eachChild := range node.Children {
+			text += nodeText(eachChild)
+		}
+		return text
+	}
+	// Blocks are separated by blank lines, collapsed once the children of the
+	// enclosing block are converted
+	block := func(text string) string {
+		return "\n\n" + text + "\n\n"
+	}
+	tidyBlocks := func(text string) string {
+		lines := strings.Split(resolveMarkdownEscapes(text), "\n")
+		for i, eachLine := range lines {
+			lines[i] = strings.TrimRight(eachLine, " \t")
+		}
+		return strings.TrimSpace(MARKDOWN_BLANK_LINES_PATTERN.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+	}
+	// Emphasis markers must be next to the text they enclose
+	inline := func(marker string, text string) string {
+		trimmedText := strings.TrimSpace(text)
+		if len(trimmedText) <= 0 {
+			return text
+		}
+		leading := text[:strings.Index(text, trimmedText)]
+		trailing := text[len(leading)+len(trimmedText):]
+		return leading + marker + trimmedText + marker + trailing
+	}
+	var convertNode func(node *htmlNode) string
+	convertChildren := func(node *htmlNode) string {
+		var childBuilder strings.Builder
+		for _, eachChild := range node.Children {
+			childBuilder.WriteString(convertNode(eachChild))
+		}
+		return childBuilder.String()
+	}
+	convertNode = func(node *htmlNode) string {
+		switch node.Tag {
+		case "":
+			return escapeMarkdownText(HTML_WHITESPACE_PATTERN.ReplaceAllString(node.Text, " ")) + convertChildren(node)
+		case "br":
+			return "\\\n"
+		case "strong", "b":
+			return inline("**", convertChildren(node))
+		case "em", "i":
+			return inline("*", convertChildren(node))
+		case "del", "s", "strike":
+			return inline("~~", convertChildren(node))
+		case "code":
+			codeText := nodeText(node)
+			fence := "`"
+			for strings.Contains(codeText, fence) {
+				fence += "`"
+			}
+			if strings.HasPrefix(codeText, "`") || strings.HasSuffix(codeText, "`") {
+				codeText = " " + codeText + " "
+			}
+			return fence + codeText + fence
+		case "a":
+			linkText := strings.TrimSpace(convertChildren(node))
+			if len(linkText) <= 0 {
+				linkText = escapeMarkdownText(node.Attrs["href"])
+			}
+			return markdownLink(MARKDOWN_URL_ESCAPER.Replace(node.Attrs["href"]), linkText)
+		case "img":
+			return fmt.Sprintf("![%s](%s)", MARKDOWN_INLINE_ESCAPER.Replace(node.Attrs["alt"]), MARKDOWN_URL_ESCAPER.Replace(node.Attrs["src"]))
+		case "hr":
+			return block("---")
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			headingLevel, _ := strconv.Atoi(node.Tag[1:])
+			headingText := strings.ReplaceAll(tidyBlocks(convertChildren(node)), "\n", " ")
+			return block(strings.Repeat("#", headingLevel) + " " + headingText)
+		case "pre":
+			codeText := strings.TrimSuffix(nodeText(node), "\n")
+			fence := "```"
+			for strings.Contains(codeText, fence) {
+				fence += "`"
+			}
+			language := ""
+			for _, eachChild := range node.Children {
+				if eachChild.Tag == "code" {
+					language = strings.TrimPrefix(eachChild.Attrs["class"], "language-")
+				}
+			}
+			return block(fence + language + "\n" + codeText + "\n" + fence)
+		case "blockquote":
+			quoteLines := strings.Split(tidyBlocks(convertChildren(node)), "\n")
+			for i, eachLine := range quoteLines {
+				quoteLines[i] = strings.TrimRight("> "+eachLine, " ")
+			}
+			return block(strings.Join(quoteLines, "\n"))
+		case "ul", "ol":
+			itemNumber, _ := strconv.Atoi(node.Attrs["start"])
+			itemNumber = max(itemNumber, 1)
+			listItems := []string{}
+			for _, eachChild := range node.Children {
+				if eachChild.Tag != "li" {
+					continue
+				}
+				itemMarker := "- "
+				if node.Tag == "ol" {
+					itemMarker = fmt.Sprintf("%d. ", itemNumber)
+					itemNumber += 1
+				}
+				// Items are kept tight, so paragraphs within an item are
+				// joined by a line break
+				itemLines := []string{}
+				for _, eachLine := range strings.Split(tidyBlocks(convertChildren(eachChild)), "\n") {
+					if len(eachLine) != 0 {
+						itemLines = append(itemLines, eachLine)
+					}
+				}
+				continuation := "\n" + strings.Repeat(" ", len(itemMarker))
+				listItems = append(listItems, itemMarker+strings.Join(itemLines, continuation))
+			}
+			return block(strings.Join(listItems, "\n"))
+		case "p", "div", "li":
+			return block(convertChildren(node))
+		default:
+			return convertChildren(node)
+		}
+	}
+	return tidyBlocks(convertNode(parseContentHTML(content)))
+}
+
+// truncateText shortens the text to at most maxLength runes, preferring to
+// break on a word boundary. Newlines are collapsed to spaces.
+func truncateText(text string, maxLength int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= maxLength {
+		return text
+	}
+	truncated := string(runes[:maxLength])
+	lastSpace := strings.LastIndex(truncated, " ")
+	if lastSpace > 0 {
+		truncated = truncated[:lastSpace]
+	}
+	return truncated + "…"
+}
+
+// headerExcerpt returns the start of the toot content as plain text for a
+// heading, without the URLs, link URLs and Markdown syntax that read poorly
+// there, truncated at a word boundary to at most maxLength characters
+func headerExcerpt(content string, maxLength int) string {
+	text := htmlToMarkup(content, func(href string, text string) string {
+		if URL_TEXT_PATTERN.MatchString(strings.TrimSpace(text)) {
+			return ""
+		}
+		return text
+	})
+	text = TEXT_URL_PATTERN.ReplaceAllString(text, "")
+	text = MARKDOWN_LINK_SYNTAX_PATTERN.ReplaceAllString(text, "$1")
+	text = MARKDOWN_EMPHASIS_SYNTAX_PATTERN.ReplaceAllString(text, "")
+	text = MARKDOWN_BLOCK_SYNTAX_PATTERN.ReplaceAllString(text, "")
+	return truncateText(text, maxLength)
+}
+
+// pageKeywords returns the hashtags of the toots followed by up to
+// keywordCount of the words used most often in their text. Mentions,
+// hashtags, URLs, custom emoji, stopwords and short words aren't counted.
+func pageKeywords(toots []*ActivityEntry, keywordCount int) []string {
+	keywords := []string{}
+	usedKeywords := map[string]bool{}
+	for _, eachItem := range toots {
+		for _, eachTag := range eachItem.Object.Tags {
+			if eachTag.Type == "Hashtag" && !usedKeywords[strings.ToLower(eachTag.Name)] {
+				usedKeywords[strings.ToLower(eachTag.Name)] = true
+				keywords = append(keywords, eachTag.Name)
+			}
+		}
+	}
+	wordCounts := map[string]int{}
+	words := []string{}
+	for _, eachItem := range toots {
+		text := htmlToText(MENTION_LINK_PATTERN.ReplaceAllString(eachItem.Object.Content, ""))
+		text = TEXT_URL_PATTERN.ReplaceAllString(text, "")
+		text = CUSTOM_EMOJI_TEXT_PATTERN.ReplaceAllString(text, "")
+		for _, eachWord := range KEYWORD_WORD_PATTERN.FindAllString(text, -1) {
+			word := strings.ReplaceAll(strings.ToLower(eachWord), "’", "'")
+			if utf8.RuneCountInString(word) < KEYWORD_MIN_LENGTH || KEYWORD_STOPWORDS[word] || usedKeywords[word] {
+				continue
+			}
+			if wordCounts[word] == 0 {
+				words = append(words, word)
+			}
+			wordCounts[word] += 1
+		}
+	}
+	// Ties keep the order the words first appear in
+	slices.SortStableFunc(words, func(a string, b string) int {
+		return wordCounts[b] - wordCounts[a]
+	})
+	return append(keywords, words[:min(len(words), keywordCount)]...)
+}
+
+// threadOrderedToots returns the toots ordered so that every thread is
+// contiguous, starting with the thread root
+func (ob *Outbox) threadOrderedToots() ([]*ActivityEntry, error) {
+	threadGroups, threadGroupsErr := groupToots(ob.OrderedItems, func(entry *ActivityEntry) (string, error) {
+		threadRootActivityItem, _, threadRootErr := ob.threadRoot(entry)
+		if threadRootErr != nil {
+			return "", threadRootErr
+		}
+		return threadRootActivityItem.Object.ID, nil
+	})
+	if threadGroupsErr != nil {
+		return nil, threadGroupsErr
+	}
+	orderedToots := []*ActivityEntry{}
+	for _, eachGroup := range threadGroups {
+		orderedToots = append(orderedToots, eachGroup.Toots...)
+	}
+	return orderedToots, nil
+}
+
+// groupToots buckets the toots by the keyFunc value. Groups, and the toots
+// within each group, retain the input order.
+func groupToots(entries []*ActivityEntry, keyFunc func(*ActivityEntry) (string, error)) ([]*tootGroup, error) {
+	groups := []*tootGroup{}
+	groupsByKey := map[string]*tootGroup{}
+	for _, eachItem := range entries {
+		groupKey, groupKeyErr := keyFunc(eachItem)
+		if groupKeyErr != nil {
+			return nil, groupKeyErr
+		}
+		group, groupExists := groupsByKey[groupKey]
+		if !groupExists {
+			group = &tootGroup{Key: groupKey}
+			groupsByKey[groupKey] = group
+			groups = append(groups, group)
+		}
+		group.Toots = append(group.Toots, eachItem)
+	}
+	return groups, nil
+}
+
+func jsonScalar[V any](key string, dict map[string]interface{}) V {
+	curVal, curValOk := dict[key]
+	if !curValOk {
+		curVal = new(V)
+	}
+	typedVal, typedValOk := curVal.(V)
+	if !typedValOk {
+		return *new(V)
+	}
+	return typedVal
+}
+
+// yamlNumber is a plain YAML or TOML scalar that reads as a number. Its source
+// text is kept, so it's read into a string as written, e.g. a 2024 tag.
+type yamlNumber string
+
+// value returns the number as an int64, or as a float64 if it isn't an
+// integer
+func (yn yamlNumber) value() interface{} {
+	if intValue, intErr := strconv.ParseInt(string(yn), 10, 64); intErr == nil {
+		return intValue
+	}
+	floatValue, _ := strconv.ParseFloat(string(yn), 64)
+	return floatValue
+}
+
+// MarshalJSON encodes the number as a JSON number
+func (yn yamlNumber) MarshalJSON() ([]byte, error) {
+	return json.Marshal(yn.value())
+}
+
+// yamlLine is a significant line of a YAML document
+type yamlLine struct {
+	indent int
+	text   string
+}
+
+// convertFrontmatter converts the leading YAML frontmatter of the page to the
+// TOML (+++ delimited) or JSON --frontmatter format. Pages in the yaml format,
+// or that don't start with YAML frontmatter, are returned unchanged.
+func convertFrontmatter(page string, format string) (string, error) {
+	if format == "yaml" || !strings.HasPrefix(page, "---\n") {
+		return page, nil
+	}
+	frontmatter, body, found := strings.Cut(strings.TrimPrefix(page, "---"), "\n---\n")
+	if !found {
+		return page, nil
+	}
+	parsed, parsedErr := parseYAML(frontmatter)
+	if parsedErr != nil {
+		return "", fmt.Errorf("Failed to parse frontmatter: %s", parsedErr)
+	}
+	values, _ := parsed.(map[string]interface{})
+	// The YAML key order and top level comments are kept
+	keys := []string{}
+	comments := []string{}
+	for _, eachLine := range strings.Split(frontmatter, "\n") {
+		if strings.HasPrefix(eachLine, "#") {
+			comments = append(comments, eachLine)
+			continue
+		}
+		if len(eachLine) == 0 || eachLine[0] == ' ' || eachLine[0] == '-' {
+			continue
+		}
+		key, _, isMapping := splitYAMLKey(stripYAMLComment(eachLine))
+		if isMapping && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	var converted strings.Builder
+	switch format {
+	case "toml":
+		converted.WriteString("+++\n")
+		for _, eachKey := range keys {
+			if value, hasValue := tomlValue(values[eachKey], slices.Contains(HUGO_DATE_KEYS, eachKey)); hasValue {
+				fmt.Fprintf(&converted, "%s = %s\n", tomlKey(eachKey), value)
+			}
+		}
+		for _, eachComment := range comments {
+			converted.WriteString(eachComment + "\n")
+		}
+		converted.WriteString("+++\n")
+	case "json":
+		converted.WriteString("{\n")
+		for index, eachKey := range keys {
+			var valueBuffer bytes.Buffer
+			encoder := json.NewEncoder(&valueBuffer)
+			encoder.SetEscapeHTML(false)
+			encoder.SetIndent("  ", "  ")
+			if encodeErr := encoder.Encode(values[eachKey]); encodeErr != nil {
+				return "", encodeErr
+			}
+			keyData, _ := json.Marshal(eachKey)
+			separator := ","
+			if index == len(keys)-1 {
+				separator = ""
+			}
+			fmt.Fprintf(&converted, "  %s: %s%s\n", keyData, strings.TrimSpace(valueBuffer.String()), separator)
+		}
+		converted.WriteString("}\n")
+	default:
+		return "", fmt.Errorf("Invalid frontmatter format specified: %s", format)
+	}
+	converted.WriteString(body)
+	return converted.String(), nil
+}
+
+// writePageFile writes the Hugo page with its frontmatter converted to the
+// --frontmatter format
+func writePageFile(pagePath string, page string, frontmatterFormat string) error {
+	convertedPage, convertErr := convertFrontmatter(page, frontmatterFormat)
+	if convertErr != nil {
+		return fmt.Errorf("Failed to convert %s frontmatter: %s", pagePath, convertErr)
+	}
+	return writeFileAtomic(pagePath, []byte(convertedPage), 0600)
+}
+
+// tomlValue encodes a parsed YAML value as a TOML value, with maps as inline
+// tables. TOML has no null, so it returns false for nil, which drops the key.
+func tomlValue(value interface{}, isDate bool) (string, bool) {
+	switch typedValue := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		if _, dateErr := time.Parse(time.RFC3339, typedValue); isDate && dateErr == nil {
+			return typedValue, true
+		}
+		return tomlString(typedValue), true
+	case bool:
+		return strconv.FormatBool(typedValue), true
+	case yamlNumber:
+		return tomlValue(typedValue.value(), isDate)
+	case int64:
+		return strconv.FormatInt(typedValue, 10), true
+	case float64:
+		floatText := strconv.FormatFloat(typedValue, 'f', -1, 64)
+		if !strings.Contains(floatText, ".") {
+			floatText += ".0"
+		}
+		return floatText, true
+	case []interface{}:
+		items := []string{}
+		for _, eachValue := range typedValue {
+			if item, hasItem := tomlValue(eachValue, false); hasItem {
+				items = append(items, item)
+			}
+		}
+		return "[" + strings.Join(items, ", ") + "]", true
+	case map[string]interface{}:
+		entries := []string{}
+		for _, eachKey := range slices.Sorted(maps.Keys(typedValue)) {
+			if entry, hasEntry := tomlValue(typedValue[eachKey], false); hasEntry {
+				entries = append(entries, fmt.Sprintf("%s = %s", tomlKey(eachKey), entry))
+			}
+		}
+		if len(entries) == 0 {
+			return "{}", true
+		}
+		return "{ " + strings.Join(entries, ", ") + " }", true
+	}
+	return tomlString(fmt.Sprint(value)), true
+}
+
+// tomlKey returns the key bare or, when needed, quoted
+func tomlKey(key string) string {
+	if TOML_BARE_KEY_PATTERN.MatchString(key) {
+		return key
+	}
+	return tomlString(key)
+}
+
+// tomlString returns the text as a TOML basic string
+func tomlString(text string) string {
+	var quoted strings.Builder
+	quoted.WriteString(`"`)
+	for _, eachRune := range text {
+		switch eachRune {
+		case '"':
+			quoted.WriteString(`\"`)
+		case '\\':
+			quoted.WriteString(`\\`)
+		case '\n':
+			quoted.WriteString(`\n`)
+		case '\t':
+			quoted.WriteString(`\t`)
+		case '\r':
+			quoted.WriteString(`\r`)
+		case '\b':
+			quoted.WriteString(`\b`)
+		case '\f':
+			quoted.WriteString(`\f`)
+		default:
+			if eachRune < 0x20 || eachRune == 0x7f {
+				fmt.Fprintf(&quoted, `\u%04X`, eachRune)
+			} else {
+				quoted.WriteRune(eachRune)
+			}
+		}
+	}
+	quoted.WriteString(`"`)
+	return quoted.String()
+}
+
+// applyConfigFile sets the flags of the flagSet that weren't given on the
+// command line from the --config file. The file maps flag names to values,
+// which may be grouped in sections, e.g. filters: {since: 2023-01-01}. Lists
+// set a repeatable flag once per item, and other flags to the comma joined
+// items. Numbers set the flag to their text as written.
+func applyConfigFile(configPath string, flagSet *flag.FlagSet) error {
+	document, readErr := readConfigDocument(configPath)
+	if readErr != nil {
+		return readErr
+	}
+	config, isMapping := document.(map[string]interface{})
+	if !isMapping && document != nil {
+		return fmt.Errorf("Invalid %s settings: must be a mapping of flag names to values", configPath)
+	}
+	values := map[string]interface{}{}
+	for _, eachKey := range slices.Sorted(maps.Keys(config)) {
+		section, isSection := config[eachKey].(map[string]interface{})
+		if !isSection {
+			section = map[string]interface{}{eachKey: config[eachKey]}
+		}
+		for eachName, eachValue := range section {
+			if _, exists := values[eachName]; exists {
+				return fmt.Errorf("Invalid %s setting: %s is set more than once", configPath, eachName)
+			}
+			values[eachName] = eachValue
+		}
+	}
+	commandLineFlags := map[string]bool{}
+	flagSet.Visit(func(eachFlag *flag.Flag) {
+		commandLineFlags[eachFlag.Name] = true
+	})
+	for _, eachName := range slices.Sorted(maps.Keys(values)) {
+		configFlag := flagSet.Lookup(eachName)
+		if configFlag == nil || eachName == "config" {
+			return fmt.Errorf("Invalid %s setting: %s. Must be a flag name, or a section of them", configPath, eachName)
+		}
+		if commandLineFlags[eachName] {
+			continue
+		}
+		items, isList := values[eachName].([]interface{})
+		if !isList {
+			items = []interface{}{values[eachName]}
+		}
+		flagValues := []string{}
+		for _, eachItem := range items {
+			switch typedItem := eachItem.(type) {
+			case string:
+				flagValues = append(flagValues, typedItem)
+			case yamlNumber:
+				flagValues = append(flagValues, string(typedItem))
+			case json.Number:
+				flagValues = append(flagValues, typedItem.String())
+			case bool:
+				flagValues = append(flagValues, strconv.FormatBool(typedItem))
+			default:
+				return fmt.Errorf("Invalid %s setting: %s must be a string, number, boolean or list of them", configPath, eachName)
+			}
+		}
+		if _, isRepeatable := configFlag.Value.(*regexpListFlag); !isRepeatable {
+			flagValues = []string{strings.Join(flagValues, ",")}
+		}
+		for _, eachValue := range flagValues {
+			setErr := flagSet.Set(eachName, eachValue)
+			if setErr != nil {
+				return fmt.Errorf("Invalid %s setting: %s: %s", configPath, eachName, setErr)
+			}
+		}
+	}
+	return nil
+}
+
+// readConfigFile unmarshals a JSON, YAML (.yaml, .yml) or TOML (.toml) file
+// into value. The document is converted to JSON first, so value uses json
+// struct tags, with numbers read into strings as written.
+func readConfigFile(configPath string, value interface{}) error {
+	documentValue, documentErr := readConfigDocument(configPath)
+	if documentErr != nil {
+		return documentErr
+	}
+	jsonData, jsonErr := json.Marshal(configValueFor(documentValue, reflect.TypeOf(value)))
+	if jsonErr != nil {
+		return jsonErr
+	}
+	unmarshalErr := json.Unmarshal(jsonData, value)
+	if unmarshalErr != nil {
+		return fmt.Errorf("Failed to parse %s: %s", configPath, unmarshalErr)
+	}
+	return nil
+}
+
+// readConfigDocument parses a JSON, YAML (.yaml, .yml) or TOML (.toml) file.
+// Numbers are yamlNumber or json.Number values with their source text.
+func readConfigDocument(configPath string) (interface{}, error) {
+	configData, configDataErr := os.ReadFile(configPath)
+	if configDataErr != nil {
+		return nil, configDataErr
+	}
+	var documentValue interface{}
+	var documentErr error
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".yaml", ".yml":
+		documentValue, documentErr = parseYAML(string(configData))
+	case ".toml":
+		documentValue, documentErr = parseTOML(string(configData))
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(configData))
+		decoder.UseNumber()
+		documentErr = decoder.Decode(&documentValue)
+	}
+	if documentErr != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %s", configPath, documentErr)
+	}
+	return documentValue, nil
+}
+
+// configValueFor returns the parsed document value with the numbers that are
+// read into a string field, element or map value of the target type replaced
+// by their source text. Other numbers are left as is.
+func configValueFor(value interface{}, target reflect.Type) interface{} {
+	for target != nil && target.Kind() == reflect.Pointer {
+		target = target.Elem()
+	}
+	elementType := func(key string) reflect.Type {
+		switch {
+		case target == nil:
+			return nil
+		case target.Kind() == reflect.Map, target.Kind() == reflect.Slice, target.Kind() == reflect.Array:
+			return target.Elem()
+		case target.Kind() == reflect.Struct:
+			for eachIndex := range target.NumField() {
+				field := target.Field(eachIndex)
+				fieldName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+				if len(fieldName) == 0 {
+					fieldName = field.Name
+				}
+				if field.IsExported() && strings.EqualFold(fieldName, key) {
+					return field.Type
+				}
+			}
+		}
+		return nil
+	}
+	switch typedValue := value.(type) {
+	case yamlNumber:
+		if target != nil && target.Kind() == reflect.String {
+			return string(typedValue)
+		}
+	case json.Number:
+		if target != nil && target.Kind() == reflect.String {
+			return typedValue.String()
+		}
+	case []interface{}:
+		items := []interface{}{}
+		for _, eachItem := range typedValue {
+			items = append(items, configValueFor(eachItem, elementType("")))
+		}
+		return items
+	case map[string]interface{}:
+		entries := map[string]interface{}{}
+		for eachKey, eachValue := range typedValue {
+			entries[eachKey] = configValueFor(eachValue, elementType(eachKey))
+		}
+		return entries
+	}
+	return value
+}
+
+// parseYAML parses the block mapping and sequence subset of YAML used by the
+// configuration files: nested mappings and "- " sequences, flow [lists] and
+// {maps}, quoted and plain scalars, | and > block scalars, and comments.
+// Anchors, tags and multiple documents aren't supported.
+func parseYAML(document string) (interface{}, error) {
+	yamlLines := []*yamlLine{}
+	rawLines := strings.Split(strings.ReplaceAll(document, "\r\n", "\n"), "\n")
+	for _, eachLine := range rawLines {
+		if strings.HasPrefix(eachLine, "---") || strings.HasPrefix(eachLine, "...") {
+			continue
+		}
+		trimmed := strings.TrimLeft(eachLine, " ")
+		yamlLines = append(yamlLines, &yamlLine{
+			indent: len(eachLine) - len(trimmed),
+			text:   strings.TrimRight(trimmed, " \t"),
+		})
+	}
+	parser := &yamlParser{lines: yamlLines}
+	parser.skipBlank()
+	if parser.done() {
+		return nil, nil
+	}
+	parsed, parsedErr := parser.parseBlock(parser.lines[parser.index].indent)
+	if parsedErr != nil {
+		return nil, parsedErr
+	}
+	parser.skipBlank()
+	if !parser.done() {
+		return nil, fmt.Errorf("line %d: unexpected indentation", parser.index+1)
+	}
+	return parsed, nil
+}
+
+type yamlParser struct {
+	lines []*yamlLine
+	index int
+}
+
+func (yp *yamlParser) done() bool {
+	return yp.index >= len(yp.lines)
+}
+
+// skipBlank advances past empty and comment only lines
+func (yp *yamlParser) skipBlank() {
+	for !yp.done() {
+		text := stripYAMLComment(yp.lines[yp.index].text)
+		if len(text) != 0 {
+			return
+		}
+		yp.index += 1
+	}
+}
+
+func (yp *yamlParser) parseBlock(indent int) (interface{}, error) {
+	text := yp.lines[yp.index].text
+	if text == "-" || strings.HasPrefix(text, "- ") {
+		return yp.parseSequence(indent)
+	}
+	return yp.parseMapping(indent)
+}
+
+func (yp *yamlParser) parseSequence(indent int) (interface{}, error) {
+	sequence := []interface{}{}
+	for yp.skipBlank(); !yp.done(); yp.skipBlank() {
+		line := yp.lines[yp.index]
+		if line.indent != indent || !(line.text == "-" || strings.HasPrefix(line.text, "- ")) {
+			break
+		}
+		itemText := stripYAMLComment(strings.TrimPrefix(strings.TrimPrefix(line.text, "-"), " "))
+		itemIndent := indent + len(line.text) - len(strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " "))
+		if len(itemText) == 0 {
+			// The item is the nested block on the following lines
+			yp.index += 1
+			yp.skipBlank()
+			if yp.done() || yp.lines[yp.index].indent <= indent {
+				sequence = append(sequence, nil)
+				continue
+			}
+			item, itemErr := yp.parseBlock(yp.lines[yp.index].indent)
+			if itemErr != nil {
+				return nil, itemErr
+			}
+			sequence = append(sequence, item)
+			continue
+		}
+		if _, _, isMapping := splitYAMLKey(itemText); isMapping || strings.HasPrefix(itemText, "- ") {
+			// "- key: value" starts a mapping indented at the key
+			yp.lines[yp.index] = &yamlLine{indent: itemIndent, text: strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")}
+			item, itemErr := yp.parseBlock(itemIndent)
+			if itemErr != nil {
+				return nil, itemErr
+			}
+			sequence = append(sequence, item)
+			continue
+		}
+		item, itemErr := parseYAMLScalar(itemText)
+		if itemErr != nil {
+			return nil, fmt.Errorf("line %d: %s", yp.index+1, itemErr)
+		}
+		sequence = append(sequence, item)
+		yp.index += 1
+	}
+	return sequence, nil
+}
+
+func (yp *yamlParser) parseMapping(indent int) (interface{}, error) {
+	mapping := map[string]interface{}{}
+	for yp.skipBlank(); !yp.done(); yp.skipBlank() {
+		line := yp.lines[yp.index]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", yp.index+1)
+		}
+		key, valueText, isMapping := splitYAMLKey(stripYAMLComment(line.text))
+		if !isMapping {
+			return nil, fmt.Errorf("line %d: expected a key: value pair", yp.index+1)
+		}
+		yp.index += 1
+		switch {
+		case valueText == "|" || valueText == ">" || valueText == "|-" || valueText == ">-":
+			mapping[key] = yp.parseBlockScalar(indent, valueText)
+		case len(valueText) == 0:
+			yp.skipBlank()
+			nextIndent := -1
+			if !yp.done() {
+				nextIndent = yp.lines[yp.index].indent
+			}
+			nextText := ""
+			if !yp.done() {
+				nextText = yp.lines[yp.index].text
+			}
+			if nextIndent > indent ||
+				(nextIndent == indent && (nextText == "-" || strings.HasPrefix(nextText, "- "))) {
+				value, valueErr := yp.parseBlock(nextIndent)
+				if valueErr != nil {
+					return nil, valueErr
+				}
+				mapping[key] = value
+			} else {
+				mapping[key] = nil
+			}
+		default:
+			value, valueErr := parseYAMLScalar(valueText)
+			if valueErr != nil {
+				return nil, fmt.Errorf("line %d: %s", yp.index, valueErr)
+			}
+			mapping[key] = value
+		}
+	}
+	return mapping, nil
+}
+
+// parseBlockScalar collects the literal (|) or folded (>) lines indented
+// deeper than the key
+func (yp *yamlParser) parseBlockScalar(indent int, style string) string {
+	blockLines := []string{}
+	blockIndent := -1
+	for ; !yp.done(); yp.index += 1 {
+		line := yp.lines[yp.index]
+		if len(line.text) != 0 && line.indent <= indent {
+			break
+		}
+		if blockIndent < 0 && len(line.text) != 0 {
+			blockIndent = line.indent
+		}
+		blockLines = append(blockLines, strings.Repeat(" ", max(0, line.indent-blockIndent))+line.text)
+	}
+	for len(blockLines) != 0 && len(blockLines[len(blockLines)-1]) == 0 {
+		blockLines = blockLines[:len(blockLines)-1]
+	}
+	separator := "\n"
+	if strings.HasPrefix(style, ">") {
+		separator = " "
+	}
+	blockText := strings.Join(blockLines, separator)
+	if !strings.HasSuffix(style, "-") {
+		blockText += "\n"
+	}
+	return blockText
+}
+
+// stripYAMLComment removes a trailing # comment outside of quotes
+func stripYAMLComment(text string) string {
+	quote := rune(0)
+	escaped := false
+	for index, eachRune := range text {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && eachRune == '\\':
+			escaped = true
+		case quote != 0:
+			if eachRune == quote {
+				quote = 0
+			}
+		case eachRune == '"' || eachRune == '\'':
+			quote = eachRune
+		case eachRune == '#' && (index == 0 || text[index-1] == ' ' || text[index-1] == '\t'):
+			return strings.TrimRight(text[:index], " \t")
+		}
+	}
+	return text
+}
+
+// splitYAMLKey splits a "key: value" line. The key may be quoted.
+func splitYAMLKey(text string) (string, string, bool) {
+	quote := rune(0)
+	for index, eachRune := range text {
+		switch {
+		case quote != 0:
+			if eachRune == quote {
+				quote = 0
+			}
+		case index == 0 && (eachRune == '"' || eachRune == '\''):
+			quote = eachRune
+		case index == 0 && (eachRune == '[' || eachRune == '{'):
+			return "", "", false
+		case eachRune == ':' && (index == len(text)-1 || text[index+1] == ' ' || (index > 0 && text[index-1] == text[0] && (text[0] == '"' || text[0] == '\''))):
+			// A quoted key may be followed by the colon without a space, as
+			// in JSON
+			key, keyErr := parseYAMLScalar(strings.TrimSpace(text[:index]))
+			if keyErr != nil {
+				return "", "", false
+			}
+			return fmt.Sprint(key), strings.TrimSpace(text[index+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// splitYAMLFlow splits the items of a flow collection on top level commas
+func splitYAMLFlow(text string) []string {
+	items := []string{}
+	depth := 0
+	quote := rune(0)
+	escaped := false
+	itemStart := 0
+	for index, eachRune := range text {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && eachRune == '\\':
+			escaped = true
+		case quote != 0:
+			if eachRune == quote {
+				quote = 0
+			}
+		case eachRune == '"' || eachRune == '\'':
+			quote = eachRune
+		case eachRune == '[' || eachRune == '{':
+			depth += 1
+		case eachRune == ']' || eachRune == '}':
+			depth -= 1
+		case eachRune == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(text[itemStart:index]))
+			itemStart = index + 1
+		}
+	}
+	if lastItem := strings.TrimSpace(text[itemStart:]); len(lastItem) != 0 {
+		items = append(items, lastItem)
+	}
+	return items
+}
+
+// parseYAMLScalar parses a scalar or flow collection value
+func parseYAMLScalar(text string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("unterminated flow sequence: %s", text)
+		}
+		sequence := []interface{}{}
+		for _, eachItem := range splitYAMLFlow(text[1 : len(text)-1]) {
+			item, itemErr := parseYAMLScalar(eachItem)
+			if itemErr != nil {
+				return nil, itemErr
+			}
+			sequence = append(sequence, item)
+		}
+		return sequence, nil
+	case strings.HasPrefix(text, "{"):
+		if !strings.HasSuffix(text, "}") {
+			return nil, fmt.Errorf("unterminated flow mapping: %s", text)
+		}
+		mapping := map[string]interface{}{}
+		for _, eachItem := range splitYAMLFlow(text[1 : len(text)-1]) {
+			key, valueText, isMapping := splitYAMLKey(eachItem)
+			if !isMapping {
+				return nil, fmt.Errorf("expected a key: value pair: %s", eachItem)
+			}
+			value, valueErr := parseYAMLScalar(valueText)
+			if valueErr != nil {
+				return nil, valueErr
+			}
+			mapping[key] = value
+		}
+		return mapping, nil
+	case strings.HasPrefix(text, "\""):
+		return strconv.Unquote(text)
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("unterminated string: %s", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+	switch text {
+	case "", "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if _, intErr := strconv.ParseInt(text, 10, 64); intErr == nil {
+		return yamlNumber(text), nil
+	}
+	if floatValue, floatErr := strconv.ParseFloat(text, 64); floatErr == nil && !math.IsInf(floatValue, 0) && !math.IsNaN(floatValue) {
+		return yamlNumber(text), nil
+	}
+	return text, nil
+}
+
+// parseTOML parses the subset of TOML used by the configuration files:
+// [table] headers, key = value pairs with dotted keys, strings, numbers,
+// booleans, arrays, which may span several lines, and comments. Inline
+// tables, multi-line strings and arrays of tables aren't supported.
+func parseTOML(document string) (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	table := root
+	lines := strings.Split(strings.ReplaceAll(document, "\r\n", "\n"), "\n")
+	for lineIndex := 0; lineIndex < len(lines); lineIndex++ {
+		line, _ := scanTOML(lines[lineIndex])
+		lineNumber := lineIndex + 1
+		switch {
+		case len(line) == 0:
+			continue
+		case strings.HasPrefix(line, "[["):
+			return nil, fmt.Errorf("line %d: arrays of tables aren't supported", lineNumber)
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated table header: %s", lineNumber, line)
+			}
+			var tableErr error
+			table, tableErr = tomlTable(root, line[1:len(line)-1])
+			if tableErr != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNumber, tableErr)
+			}
+			continue
+		}
+		key, valueText, isPair := strings.Cut(line, "=")
+		if !isPair {
+			return nil, fmt.Errorf("line %d: expected a key = value pair: %s", lineNumber, line)
+		}
+		valueText = strings.TrimSpace(valueText)
+		// An array continues until its brackets are closed
+		_, depth := scanTOML(valueText)
+		for depth > 0 && lineIndex+1 < len(lines) {
+			lineIndex += 1
+			nextLine, nextDepth := scanTOML(lines[lineIndex])
+			valueText += " " + nextLine
+			depth += nextDepth
+		}
+		if strings.HasPrefix(valueText, "{") || strings.HasPrefix(valueText, `"""`) || strings.HasPrefix(valueText, "'''") {
+			return nil, fmt.Errorf("line %d: inline tables and multi-line strings aren't supported", lineNumber)
+		}
+		// TOML scalars and arrays read like YAML flow values
+		value, valueErr := parseYAMLScalar(valueText)
+		if valueErr != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, valueErr)
+		}
+		keyParts := strings.Split(strings.TrimSpace(key), ".")
+		valueTable, tableErr := tomlTable(table, strings.Join(keyParts[:len(keyParts)-1], "."))
+		if tableErr != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, tableErr)
+		}
+		valueTable[strings.Trim(strings.TrimSpace(keyParts[len(keyParts)-1]), `"'`)] = value
+	}
+	return root, nil
+}
+
+// scanTOML returns the TOML line without its comment and surrounding space,
+// and the change in array bracket depth over the line
+func scanTOML(line string) (string, int) {
+	depth := 0
+	quote := rune(0)
+	escaped := false
+	for index, eachRune := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && eachRune == '\\':
+			escaped = true
+		case quote != 0:
+			if eachRune == quote {
+				quote = 0
+			}
+		case eachRune == '"' || eachRune == '\'':
+			quote = eachRune
+		case eachRune == '[':
+			depth += 1
+		case eachRune == ']':
+			depth -= 1
+		case eachRune == '#':
+			return strings.TrimSpace(line[:index]), depth
+		}
+	}
+	return strings.TrimSpace(line), depth
+}
+
+// tomlTable returns the table at the dotted path below root, creating the
+// tables that don't exist
+func tomlTable(root map[string]interface{}, dottedPath string) (map[string]interface{}, error) {
+	table := root
+	if len(strings.TrimSpace(dottedPath)) == 0 {
+		return table, nil
+	}
+	for _, eachKey := range strings.Split(dottedPath, ".") {
+		eachKey = strings.Trim(strings.TrimSpace(eachKey), `"'`)
+		existing, exists := table[eachKey]
+		if !exists {
+			childTable := map[string]interface{}{}
+			table[eachKey] = childTable
+			table = childTable
+			continue
+		}
+		childTable, isTable := existing.(map[string]interface{})
+		if !isTable {
+			return nil, fmt.Errorf("%s is already a value, not a table", eachKey)
+		}
+		table = childTable
+	}
+	return table, nil
+}
+
+func selfPublishFilter(includeReplies bool, includeBoosts bool, visibilities []string) FilterTootFunc {
+	return func(entry *ActivityEntry) bool {
+		// Include only Create toots, and boosts if requested
+		if entry.Type != "Create" && !(entry.Type == "Announce" && includeBoosts) {
+			return false
+		}
+		// Include self-replies only, unless replies to other users are
+		// explicitly requested
+		if entry.Type == "Create" && entry.Object.repliesToOtherUser() && !includeReplies {
+			return false
+		}
+		// ok, what about the audience
+		return slices.Contains(visibilities, entry.Visibility())
+	}
+}
+
+// tagFilter includes toots with at least one of the onlyTags, if any, and
+// none of the excludeTags. Tags are compared lowercased without the #.
+func tagFilter(onlyTags []string, excludeTags []string) FilterTootFunc {
+	return func(entry *ActivityEntry) bool {
+		hasOnlyTag := false
+		for _, eachTag := range entry.Object.Tags {
+			if eachTag.Type != "Hashtag" {
+				continue
+			}
+			tagName := normalizeTagFlag(eachTag.Name)
+			if slices.Contains(excludeTags, tagName) {
+				return false
+			}
+			hasOnlyTag = hasOnlyTag || slices.Contains(onlyTags, tagName)
+		}
+		return len(onlyTags) == 0 || hasOnlyTag
+	}
+}
+
+// contentFilter includes toots whose plain text matches at least one of the
+// includePatterns, if any, and none of the excludePatterns
+func contentFilter(includePatterns []*regexp.Regexp, excludePatterns []*regexp.Regexp) FilterTootFunc {
+	return func(entry *ActivityEntry) bool {
+		plainText := htmlToText(entry.Object.Content)
+		matchesText := func(pattern *regexp.Regexp) bool {
+			return pattern.MatchString(plainText)
+		}
+		if slices.ContainsFunc(excludePatterns, matchesText) {
+			return false
+		}
+		return len(includePatterns) == 0 || slices.ContainsFunc(includePatterns, matchesText)
+	}
+}
+
+// languageFilter includes toots whose language is one of the languages. A
+// regional code such as en-GB also matches its base language, en.
+func languageFilter(languages []string) FilterTootFunc {
+	return func(entry *ActivityEntry) bool {
+		tootLanguage := strings.ToLower(entry.Object.Language)
+		baseLanguage, _, _ := strings.Cut(tootLanguage, "-")
+		return slices.Contains(languages, tootLanguage) || slices.Contains(languages, baseLanguage)
+	}
+}
+
+// contentWarningFilter excludes toots with a content warning
+func contentWarningFilter(entry *ActivityEntry) bool {
+	return len(entry.Object.Summary) == 0
+}
+
+// sensitiveMediaFilter excludes toots with media marked sensitive
+func sensitiveMediaFilter(entry *ActivityEntry) bool {
+	return !entry.Object.Sensitive || len(entry.Object.Attachments) == 0
+}
+
+// newMessageCatalog returns the --lang messages with the --messages file
+// overrides applied
+func newMessageCatalog(lang string, messagesPath string) (messageCatalog, error) {
+	catalog, catalogExists := MESSAGE_CATALOGS[lang]
+	if !catalogExists {
+		return nil, fmt.Errorf("Invalid message language specified: %s", lang)
+	}
+	messages := maps.Clone(MESSAGE_CATALOGS["en"])
+	maps.Copy(messages, catalog)
+	if len(messagesPath) != 0 {
+		overrides := map[string]string{}
+		readErr := readConfigFile(messagesPath, &overrides)
+		if readErr != nil {
+			return nil, readErr
+		}
+		for eachKey, eachMessage := range overrides {
+			if _, keyExists := messages[eachKey]; !keyExists {
+				return nil, fmt.Errorf("Invalid message specified: %s. Must be one of: {%s}",
+					eachKey,
+					strings.Join(slices.Sorted(maps.Keys(messages)), ", "))
+			}
+			messages[eachKey] = eachMessage
+		}
+	}
+	return messages, nil
+}
+
+// count formats the message with the count and args, using the <key>One
+// message for a count of one
+func (mc messageCatalog) count(key string, count int, args ...interface{}) string {
+	if _, oneExists := mc[key+"One"]; oneExists && count == 1 {
+		key += "One"
+	}
+	return fmt.Sprintf(mc[key], append([]interface{}{count}, args...)...)
+}
+
+// newFrontmatterParams reads the --frontmatter-params file and compiles its
+// templates. Keys in reservedKeys are rejected.
+func newFrontmatterParams(paramsPath string, reservedKeys []string) (*frontmatterParams, error) {
+	params := &frontmatterParams{
+		values:    map[string]interface{}{},
+		templates: map[string]*template.Template{},
+	}
+	readErr := readConfigFile(paramsPath, &params.values)
+	if readErr != nil {
+		return nil, readErr
+	}
+	params.keys = slices.Sorted(maps.Keys(params.values))
+	for _, eachKey := range params.keys {
+		if !FRONTMATTER_KEY_PATTERN.MatchString(eachKey) {
+			return nil, fmt.Errorf("Invalid frontmatter param specified: %s", eachKey)
+		}
+		if slices.Contains(reservedKeys, eachKey) {
+			return nil, fmt.Errorf("Invalid frontmatter param specified: %s is written by the built-in frontmatter, use --frontmatter-template to replace it", eachKey)
+		}
+		compileErr := params.compile(params.values[eachKey])
+		if compileErr != nil {
+			return nil, fmt.Errorf("Invalid frontmatter param specified: %s. Error: %s", eachKey, compileErr)
+		}
+	}
+	return params, nil
+}
+
+// compile parses the strings in the value that contain template actions
+func (fp *frontmatterParams) compile(value interface{}) error {
+	switch typedValue := value.(type) {
+	case string:
+		if !strings.Contains(typedValue, "{{") {
+			return nil
+		}
+		valueTemplate, parseErr := template.New("frontmatterParam").Parse(typedValue)
+		if parseErr != nil {
+			return parseErr
+		}
+		fp.templates[typedValue] = valueTemplate
+	case []interface{}:
+		for _, eachValue := range typedValue {
+			if compileErr := fp.compile(eachValue); compileErr != nil {
+				return compileErr
+			}
+		}
+	case map[string]interface{}:
+		for _, eachValue := range typedValue {
+			if compileErr := fp.compile(eachValue); compileErr != nil {
+				return compileErr
+			}
+		}
+	}
+	return nil
+}
+
+// render returns the keys in order with their values rendered for the page
+// and encoded as JSON, which YAML frontmatter accepts as flow values
+func (fp *frontmatterParams) render(templateParams map[string]interface{}) ([]*renderedFrontmatterParam, error) {
+	rendered := []*renderedFrontmatterParam{}
+	for _, eachKey := range fp.keys {
+		value, valueErr := fp.renderValue(fp.values[eachKey], templateParams)
+		if valueErr != nil {
+			return nil, fmt.Errorf("Failed to render frontmatter param %s: %s", eachKey, valueErr)
+		}
+		renderedParam, encodeErr := newRenderedFrontmatterParam(eachKey, value)
+		if encodeErr != nil {
+			return nil, encodeErr
+		}
+		rendered = append(rendered, renderedParam)
+	}
+	return rendered, nil
+}
+
+// newRenderedFrontmatterParam encodes the value as JSON, which YAML
+// frontmatter accepts as a flow value
+func newRenderedFrontmatterParam(key string, value interface{}) (*renderedFrontmatterParam, error) {
+	var valueBuffer bytes.Buffer
+	encoder := json.NewEncoder(&valueBuffer)
+	encoder.SetEscapeHTML(false)
+	if encodeErr := encoder.Encode(value); encodeErr != nil {
+		return nil, encodeErr
+	}
+	return &renderedFrontmatterParam{
+		Key:   key,
+		Value: strings.TrimSpace(valueBuffer.String()),
+	}, nil
+}
+
+// themePresetParams returns the page's params followed by the --theme-preset
+// keys they don't already set
+func themePresetParams(presetValues map[string]interface{}, pageParams []*renderedFrontmatterParam) ([]*renderedFrontmatterParam, error) {
+	presetParams := slices.Clone(pageParams)
+	for _, eachKey := range slices.Sorted(maps.Keys(presetValues)) {
+		if slices.ContainsFunc(pageParams, func(param *renderedFrontmatterParam) bool {
+			return param.Key == eachKey
+		}) {
+			continue
+		}
+		renderedParam, encodeErr := newRenderedFrontmatterParam(eachKey, presetValues[eachKey])
+		if encodeErr != nil {
+			return nil, encodeErr
+		}
+		presetParams = append(presetParams, renderedParam)
+	}
+	return presetParams, nil
+}
+
+// renderValue returns a copy of the value with its templates executed
+func (fp *frontmatterParams) renderValue(value interface{}, templateParams map[string]interface{}) (interface{}, error) {
+	switch typedValue := value.(type) {
+	case string:
+		valueTemplate, isTemplate := fp.templates[typedValue]
+		if !isTemplate {
+			return typedValue, nil
+		}
+		var valueBuilder strings.Builder
+		if executeErr := valueTemplate.Execute(&valueBuilder, templateParams); executeErr != nil {
+			return nil, executeErr
+		}
+		return valueBuilder.String(), nil
+	case []interface{}:
+		renderedList := []interface{}{}
+		for _, eachValue := range typedValue {
+			renderedValue, renderErr := fp.renderValue(eachValue, templateParams)
+			if renderErr != nil {
+				return nil, renderErr
+			}
+			renderedList = append(renderedList, renderedValue)
+		}
+		return renderedList, nil
+	case map[string]interface{}:
+		renderedMap := map[string]interface{}{}
+		for eachKey, eachValue := range typedValue {
+			renderedValue, renderErr := fp.renderValue(eachValue, templateParams)
+			if renderErr != nil {
+				return nil, renderErr
+			}
+			renderedMap[eachKey] = renderedValue
+		}
+		return renderedMap, nil
+	}
+	return value, nil
+}
+
+// newFilterRules reads and validates the --filters file
+func newFilterRules(rulesPath string) (*filterRules, error) {
+	rules := &filterRules{}
+	readErr := readConfigFile(rulesPath, rules)
+	if readErr != nil {
+		return nil, readErr
+	}
+	if len(rules.Default) == 0 {
+		rules.Default = "include"
+	}
+	if rules.Default != "include" && rules.Default != "exclude" {
+		return nil, fmt.Errorf("Invalid default action specified: %s", rules.Default)
+	}
+	for index, eachRule := range rules.Rules {
+		if eachRule.Action != "include" && eachRule.Action != "exclude" {
+			return nil, fmt.Errorf("Invalid action specified for rule %d: %s", index+1, eachRule.Action)
+		}
+		for eachIndex, eachVisibility := range eachRule.Visibility {
+			eachRule.Visibility[eachIndex] = strings.ToLower(eachVisibility)
+			if !slices.Contains(VISIBILITIES, eachRule.Visibility[eachIndex]) {
+				return nil, fmt.Errorf("Invalid visibility specified for rule %d: %s", index+1, eachVisibility)
+			}
+		}
+		for eachIndex, eachTag := range eachRule.Tags {
+			eachRule.Tags[eachIndex] = normalizeTagFlag(eachTag)
+		}
+		if len(eachRule.Matching) != 0 {
+			compiled, compiledErr := regexp.Compile(eachRule.Matching)
+			if compiledErr != nil {
+				return nil, fmt.Errorf("Invalid matching expression specified for rule %d: %s", index+1, compiledErr)
+			}
+			eachRule.matchingRegexp = compiled
+		}
+		var parseDateErr error
+		eachRule.sinceTime, parseDateErr = parseDateFlag(eachRule.Since, false)
+		if parseDateErr != nil {
+			return nil, fmt.Errorf("Invalid since date specified for rule %d: %s", index+1, eachRule.Since)
+		}
+		eachRule.untilTime, parseDateErr = parseDateFlag(eachRule.Until, true)
+		if parseDateErr != nil {
+			return nil, fmt.Errorf("Invalid until date specified for rule %d: %s", index+1, eachRule.Until)
+		}
+		if !slices.Contains([]string{"", "none", "self", "others"}, eachRule.Replies) {
+			return nil, fmt.Errorf("Invalid replies policy specified for rule %d: %s", index+1, eachRule.Replies)
+		}
+	}
+	return rules, nil
+}
+
+// matches returns true if the toot satisfies every condition of the rule
+func (fr *filterRule) matches(entry *ActivityEntry) bool {
+	if len(fr.Visibility) != 0 && !slices.Contains(fr.Visibility, entry.Visibility()) {
+		return false
+	}
+	if len(fr.Tags) != 0 && !tagFilter(fr.Tags, nil)(entry) {
+		return false
+	}
+	if fr.matchingRegexp != nil && !contentFilter([]*regexp.Regexp{fr.matchingRegexp}, nil)(entry) {
+		return false
+	}
+	if !dateRangeFilter(fr.sinceTime, fr.untilTime)(entry) {
+		return false
+	}
+	switch fr.Replies {
+	case "none":
+		return len(entry.Object.InReplyTo) == 0
+	case "self":
+		return len(entry.Object.InReplyTo) != 0 && !entry.Object.repliesToOtherUser()
+	case "others":
+		return entry.Object.repliesToOtherUser()
+	}
+	return true
+}
+
+// decidingRule returns the 1-based number of the first matching rule, or 0
+// if the default action applies
+func (fr *filterRules) decidingRule(entry *ActivityEntry) int {
+	for index, eachRule := range fr.Rules {
+		if eachRule.matches(entry) {
+			return index + 1
+		}
+	}
+	return 0
+}
+
+// filter applies the first matching rule, or the default action
+func (fr *filterRules) filter(entry *ActivityEntry) bool {
+	ruleNumber := fr.decidingRule(entry)
+	if ruleNumber == 0 {
+		return fr.Default == "include"
+	}
+	return fr.Rules[ruleNumber-1].Action == "include"
+}
+
+// mentionFilter excludes toots with a Mention tag for any of the accounts
+func mentionFilter(excludeAccounts []string) FilterTootFunc {
+	return func(entry *ActivityEntry) bool {
+		for _, eachTag := range entry.Object.Tags {
+			if eachTag.Type != "Mention" {
+				continue
+			}
+			// Local mentions may omit the domain, which is then the host of
+			// the profile URL
+			mentionDomain := HOST
+			profileURL, profileURLErr := url.Parse(eachTag.HREF)
+			if profileURLErr == nil && len(profileURL.Host) != 0 {
+				mentionDomain = profileURL.Host
+			}
+			if slices.Contains(excludeAccounts, normalizeAccount(eachTag.Name, mentionDomain)) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// normalizeAccount returns the lowercased user@domain form of an account
+// handle, using defaultDomain for handles without one
+func normalizeAccount(account string, defaultDomain string) string {
+	account = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(account), "@"))
+	if !strings.Contains(account, "@") {
+		account += "@" + strings.ToLower(defaultDomain)
+	}
+	return account
+}
+
+// mediaFilter includes toots with attachments when withMedia is set, and
+// toots without them otherwise
+func mediaFilter(withMedia bool) FilterTootFunc {
+	return func(entry *ActivityEntry) bool {
+		return (len(entry.Object.Attachments) != 0) == withMedia
+	}
+}
+
+// idFilter includes toots whose status ID is in onlyIDs, if set, and not
+// in excludeIDs
+func idFilter(onlyIDs map[string]bool, excludeIDs map[string]bool) FilterTootFunc {
+	return func(entry *ActivityEntry) bool {
+		tootID := tootFileID(entry)
+		if excludeIDs[tootID] {
+			return false
+		}
+		return onlyIDs == nil || onlyIDs[tootID]
+	}
+}
+
+// readIDList reads a file of status IDs or URLs, one per line. URLs are
+// reduced to the status ID, their last path segment. Blank lines and lines
+// starting with # are ignored.
+func readIDList(listPath string) (map[string]bool, error) {
+	listData, listDataErr := os.ReadFile(listPath)
+	if listDataErr != nil {
+		return nil, listDataErr
+	}
+	ids := map[string]bool{}
+	for _, eachLine := range strings.Split(string(listData), "\n") {
+		eachLine = strings.TrimSpace(eachLine)
+		if len(eachLine) == 0 || strings.HasPrefix(eachLine, "#") {
+			continue
+		}
+		lineParts := strings.Split(strings.TrimSuffix(eachLine, "/"), "/")
+		ids[lineParts[len(lineParts)-1]] = true
+	}
+	return ids, nil
+}
+
+// lengthFilter excludes text toots whose plain text, excluding leading and
+// trailing whitespace, is shorter than minChars characters
+func lengthFilter(minChars int) FilterTootFunc {
+	return func(entry *ActivityEntry) bool {
+		if len(entry.Object.Attachments) != 0 {
+			return true
+		}
+		return utf8.RuneCountInString(strings.TrimSpace(htmlToText(entry.Object.Content))) >= minChars
+	}
+}
+
+// readRedactTerms reads a file of terms, one per line, into a single case
+// insensitive pattern. Terms that start or end with a word character only
+// match on word boundaries. Blank lines and lines starting with # are ignored.
+func readRedactTerms(termsPath string) (*regexp.Regexp, error) {
+	termsData, termsDataErr := os.ReadFile(termsPath)
+	if termsDataErr != nil {
+		return nil, termsDataErr
+	}
+	termPatterns := []string{}
+	isWordByte := func(char byte) bool {
+		return char == '_' || ('0' <= char && char <= '9') || ('a' <= char && char <= 'z') || ('A' <= char && char <= 'Z')
+	}
+	for _, eachLine := range strings.Split(string(termsData), "\n") {
+		eachLine = strings.TrimSpace(eachLine)
+		if len(eachLine) == 0 || strings.HasPrefix(eachLine, "#") {
+			continue
+		}
+		termPattern := regexp.QuoteMeta(eachLine)
+		if isWordByte(eachLine[0]) {
+			termPattern = `\b` + termPattern
+		}
+		if isWordByte(eachLine[len(eachLine)-1]) {
+			termPattern += `\b`
+		}
+		termPatterns = append(termPatterns, termPattern)
+	}
+	if len(termPatterns) == 0 {
+		return nil, fmt.Errorf("No terms found")
+	}
+	return regexp.Compile("(?i)" + strings.Join(termPatterns, "|"))
+}
+
+// anonymizeMentions replaces the mention links and handles of other users
+// with the placeholder, and removes their Mention tags and reply targets
+func (ob *Outbox) anonymizeMentions(placeholder string) uint {
+	selfProfileURLs := []string{
+		fmt.Sprintf("https://%s/@%s", HOST, USER),
+		fmt.Sprintf("https://%s/users/%s", HOST, USER),
+	}
+	anonymizedCount := uint(0)
+	for _, eachEntry := range ob.OrderedItems {
+		// The parent toot URL identifies the other account too
+		if eachEntry.Object.repliesToOtherUser() {
+			eachEntry.Object.InReplyTo = ""
+		}
+		handlePatterns := []string{}
+		keptTags := []*ActivityObjectTag{}
+		for _, eachTag := range eachEntry.Object.Tags {
+			if eachTag.Type != "Mention" || slices.Contains(selfProfileURLs, eachTag.HREF) {
+				keptTags = append(keptTags, eachTag)
+				continue
+			}
+			// Both @user@domain and the short @user form may appear in the text
+			fullHandle := "@" + strings.TrimPrefix(eachTag.Name, "@")
+			shortHandle, _, _ := strings.Cut(fullHandle[1:], "@")
+			handlePatterns = append(handlePatterns, regexp.QuoteMeta(fullHandle), regexp.QuoteMeta("@"+shortHandle)+`\b`)
+		}
+		if len(handlePatterns) == 0 {
+			continue
+		}
+		eachEntry.Object.Tags = keptTags
+		// Addressing only keeps the audience collections
+		isOtherAccount := func(address string) bool {
+			return !slices.Contains(ACTIVITY_STREAMS_PUBLIC, address) && address != MY_FOLLOWERS_URL
+		}
+		eachEntry.Object.To = slices.DeleteFunc(slices.Clone(eachEntry.Object.To), isOtherAccount)
+		eachEntry.Object.CC = slices.DeleteFunc(slices.Clone(eachEntry.Object.CC), isOtherAccount)
+		content := MENTION_LINK_PATTERN.ReplaceAllStringFunc(eachEntry.Object.Content, func(mentionLink string) string {
+			if strings.Contains(mentionLink, "hashtag") {
+				return mentionLink
+			}
+			for _, eachSelfURL := range selfProfileURLs {
+				if strings.Contains(mentionLink, `"`+eachSelfURL+`"`) {
+					return mentionLink
+				}
+			}
+			return xmlEscapeString(placeholder)
+		})
+		handlePattern := regexp.MustCompile("(?i)" + strings.Join(handlePatterns, "|"))
+		eachEntry.Object.Content = replaceHTMLText(content, handlePattern, xmlEscapeString(placeholder))
+		eachEntry.Object.Summary = handlePattern.ReplaceAllLiteralString(eachEntry.Object.Summary, placeholder)
+		anonymizedCount += 1
+	}
+	return anonymizedCount
+}
+
+// redactFilter excludes toots that contain a --redact term
+func redactFilter(pattern *regexp.Regexp) FilterTootFunc {
+	redactor := &tootRedactor{pattern: pattern}
+	return func(entry *ActivityEntry) bool {
+		return !redactor.redact(entry.Object, false)
+	}
+}
+
+// stripTrackingParameters removes the tracking query parameters from the
+// link targets in the content of every toot. Links that display their URL
+// are given the new URL's text. It returns the number of toots changed.
+func (ob *Outbox) stripTrackingParameters(trackingParams []string) uint {
+	strippedCount := uint(0)
+	for _, eachEntry := range ob.OrderedItems {
+		content := rewriteContentLinks(eachEntry.Object.Content, func(href string) string {
+			return stripTrackingURL(href, trackingParams)
+		})
+		if content != eachEntry.Object.Content {
+			eachEntry.Object.Content = content
+			strippedCount += 1
+		}
+	}
+	return strippedCount
+}
+
+// rewriteContentLinks replaces the target of every link in the toot HTML
+// with the rewriteFunc result. A link whose text is its URL gets the new text.
+func rewriteContentLinks(content string, rewriteFunc func(href string) string) string {
+	return HTML_ANCHOR_PATTERN.ReplaceAllStringFunc(content, func(anchor string) string {
+		anchorParts := HTML_ANCHOR_PATTERN.FindStringSubmatch(anchor)
+		href := html.UnescapeString(anchorParts[2])
+		rewrittenHref := rewriteFunc(href)
+		if rewrittenHref == href {
+			return anchor
+		}
+		anchorText := anchorParts[4]
+		if htmlToText(anchorText) == href {
+			anchorText = mastodonLinkText(rewrittenHref)
+		}
+		return fmt.Sprintf(`<a %shref="%s"%s>%s</a>`, anchorParts[1], xmlEscapeString(rewrittenHref), anchorParts[3], anchorText)
+	})
+}
+
+// mastodonLinkText renders the URL as the text of a link the way Mastodon
+// does, hiding the scheme and shortening long URLs with an ellipsis
+func mastodonLinkText(linkURL string) string {
+	scheme, address, hasScheme := strings.Cut(linkURL, "://")
+	if !hasScheme {
+		return xmlEscapeString(linkURL)
+	}
+	prefix := scheme + "://"
+	if strings.HasPrefix(address, "www.") {
+		prefix += "www."
+		address = strings.TrimPrefix(address, "www.")
+	}
+	visibleText := address
+	hiddenText := ""
+	visibleClass := ""
+	if addressRunes := []rune(address); len(addressRunes) > MASTODON_LINK_TEXT_LENGTH {
+		visibleText = string(addressRunes[:MASTODON_LINK_TEXT_LENGTH])
+		hiddenText = string(addressRunes[MASTODON_LINK_TEXT_LENGTH:])
+		visibleClass = "ellipsis"
+	}
+	return fmt.Sprintf(`<span class="invisible">%s</span><span class="%s">%s</span><span class="invisible">%s</span>`,
+		xmlEscapeString(prefix),
+		visibleClass,
+		xmlEscapeString(visibleText),
+		xmlEscapeString(hiddenText))
+}
+
+// stripTrackingURL removes the tracking parameters from the URL's query,
+// keeping the order of the other parameters
+func stripTrackingURL(linkURL string, trackingParams []string) string {
+	parsedURL, parsedURLErr := url.Parse(linkURL)
+	if parsedURLErr != nil || len(parsedURL.RawQuery) <= 0 {
+		return linkURL
+	}
+	isTracking := func(queryParam string) bool {
+		paramName, _, _ := strings.Cut(queryParam, "=")
+		paramName, _ = url.QueryUnescape(paramName)
+		paramName = strings.ToLower(paramName)
+		return slices.ContainsFunc(trackingParams, func(trackingParam string) bool {
+			if prefix, isPrefix := strings.CutSuffix(trackingParam, "*"); isPrefix {
+				return strings.HasPrefix(paramName, prefix)
+			}
+			return paramName == trackingParam
+		})
+	}
+	queryParams := strings.Split(parsedURL.RawQuery, "&")
+	keptParams := slices.DeleteFunc(slices.Clone(queryParams), isTracking)
+	if len(keptParams) == len(queryParams) {
+		return linkURL
+	}
+	parsedURL.RawQuery = strings.Join(keptParams, "&")
+	parsedURL.ForceQuery = false
+	return parsedURL.String()
+}
+
+// rewriteHashtags renders the hashtag links in the content of every toot
+// for the --hashtag-mode. Site tag pages are linked under the tagsURL of the
+// toot. It returns the number of toots changed.
+func (ob *Outbox) rewriteHashtags(mode string, tagsURL func(*ActivityEntry) string, normalizer *tagNormalizer) uint {
+	rewrittenCount := uint(0)
+	for _, eachEntry := range ob.OrderedItems {
+		content := MENTION_LINK_PATTERN.ReplaceAllStringFunc(eachEntry.Object.Content, func(mentionLink string) string {
+			if !strings.Contains(mentionLink, "hashtag") {
+				return mentionLink
+			}
+			hashtagText := htmlToText(mentionLink)
+			switch mode {
+			case "site":
+				// Link to the page of the tag's normalized name, if it's kept
+				tagName := normalizer.normalize(hashtagText)
+				if len(tagName) <= 0 {
+					return xmlEscapeString(hashtagText)
+				}
+				tagURL := tagsURL(eachEntry) + tagSlug(tagName) + "/"
+				return fmt.Sprintf(`<a href="%s" class="mention hashtag" rel="tag">%s</a>`, xmlEscapeString(tagURL), xmlEscapeString(hashtagText))
+			case "text":
+				return xmlEscapeString(hashtagText)
+			case "strip":
+				return ""
+			default:
+				return mentionLink
+			}
+		})
+		if mode == "strip" && content != eachEntry.Object.Content {
+			content = PARAGRAPH_TRAILING_SPACE_PATTERN.ReplaceAllString(content, "</p>")
+			content = EMPTY_PARAGRAPH_PATTERN.ReplaceAllString(content, "")
+		}
+		if content != eachEntry.Object.Content {
+			eachEntry.Object.Content = content
+			rewrittenCount += 1
+		}
+	}
+	return rewrittenCount
+}
+
+// rewriteMentions renders the mention links in the content of every toot for
+// the --mention-mode. It returns the number of toots changed.
+func (ob *Outbox) rewriteMentions(mode string) uint {
+	rewrittenCount := uint(0)
+	for _, eachEntry := range ob.OrderedItems {
+		// Mention tags name the account, but link to the /users/ profile URL
+		tagHandles := map[string]string{}
+		for _, eachTag := range eachEntry.Object.Tags {
+			if eachTag.Type == "Mention" {
+				tagHandles[eachTag.HREF] = "@" + strings.TrimPrefix(eachTag.Name, "@")
+			}
+		}
+		content := MENTION_LINK_PATTERN.ReplaceAllStringFunc(eachEntry.Object.Content, func(mentionLink string) string {
+			if strings.Contains(mentionLink, "hashtag") {
+				return mentionLink
+			}
+			shortHandle := htmlToText(mentionLink)
+			switch mode {
+			case "handle":
+				return xmlEscapeString(mentionHandle(mentionLink, shortHandle, tagHandles))
+			case "short":
+				handle, _, _ := strings.Cut(strings.TrimPrefix(shortHandle, "@"), "@")
+				return xmlEscapeString("@" + handle)
+			case "remove":
+				return ""
+			default:
+				return mentionLink
+			}
+		})
+		if mode == "remove" && content != eachEntry.Object.Content {
+			content = EMPTY_HCARD_PATTERN.ReplaceAllString(content, "")
+			content = PARAGRAPH_LEADING_SPACE_PATTERN.ReplaceAllString(content, "<p>")
+			content = PARAGRAPH_TRAILING_SPACE_PATTERN.ReplaceAllString(content, "</p>")
+			content = EMPTY_PARAGRAPH_PATTERN.ReplaceAllString(content, "")
+		}
+		if content != eachEntry.Object.Content {
+			eachEntry.Object.Content = content
+			rewrittenCount += 1
+		}
+	}
+	return rewrittenCount
+}
+
+// mentionHandle returns the @user@domain handle of the mention link. The
+// domain comes from the Mastodon style https://domain/@user link, else the
+// Mention tag for the link.
+func mentionHandle(mentionLink string, linkText string, tagHandles map[string]string) string {
+	parsedNode := parseContentHTML(mentionLink)
+	for len(parsedNode.Children) != 0 && parsedNode.Tag != "a" {
+		parsedNode = parsedNode.Children[0]
+	}
+	profileURL, profileURLErr := url.Parse(parsedNode.Attrs["href"])
+	if profileURLErr == nil && strings.HasPrefix(profileURL.Path, "/@") {
+		user, _, _ := strings.Cut(strings.TrimPrefix(profileURL.Path, "/@"), "/")
+		return fmt.Sprintf("@%s@%s", user, profileURL.Host)
+	}
+	if tagHandle, tagHandleExists := tagHandles[parsedNode.Attrs["href"]]; tagHandleExists && strings.Count(tagHandle, "@") == 2 {
+		return tagHandle
+	}
+	return linkText
+}
+
+// replaceHTMLText replaces the matches in the text of the HTML with the
+// replacement, leaving the markup, including link targets, unchanged
+func replaceHTMLText(content string, pattern *regexp.Regexp, replacement string) string {
+	var replaced strings.Builder
+	for len(content) != 0 {
+		tagStart := strings.Index(content, "<")
+		if tagStart < 0 {
+			tagStart = len(content)
+		}
+		replaced.WriteString(pattern.ReplaceAllLiteralString(content[:tagStart], replacement))
+		content = content[tagStart:]
+		tagEnd := strings.Index(content, ">")
+		if tagEnd < 0 {
+			tagEnd = len(content) - 1
+		}
+		replaced.WriteString(content[:tagEnd+1])
+		content = content[tagEnd+1:]
+	}
+	return replaced.String()
+}
+
+// tootRedactor matches the --redact terms in what a toot shows: the text of
+// the content with its entities decoded, the link targets, the content
+// warning, the media descriptions and the link preview. Both --redact-mode
+// skip and replace use it, so they agree on which toots contain a term.
+type tootRedactor struct {
+	pattern *regexp.Regexp
+}
+
+// redactHTML returns the content with the term matches in its text replaced
+// by REDACTED_TEXT and the links whose target matches replaced by their
+// text. Content without a match is returned unchanged.
+func (tr *tootRedactor) redactHTML(content string) (string, bool) {
+	voidElements := []string{"br", "hr", "img", "input", "meta", "link", "source", "wbr"}
+	matched := false
+	var redactedBuilder strings.Builder
+	var walkNode func(node *htmlNode)
+	walkNode = func(node *htmlNode) {
+		if len(node.Tag) <= 0 {
+			if tr.pattern.MatchString(node.Text) {
+				matched = true
+			}
+			redactedBuilder.WriteString(html.EscapeString(tr.pattern.ReplaceAllLiteralString(node.Text, REDACTED_TEXT)))
+		}
+		unlinked := node.Tag == "a" && tr.pattern.MatchString(node.Attrs["href"])
+		if unlinked {
+			matched = true
+		}
+		if len(node.Tag) != 0 && !unlinked {
+			fmt.Fprintf(&redactedBuilder, "<%s", node.Tag)
+			for _, eachAttrName := range slices.Sorted(maps.Keys(node.Attrs)) {
+				fmt.Fprintf(&redactedBuilder, " %s=\"%s\"", eachAttrName, html.EscapeString(node.Attrs[eachAttrName]))
+			}
+			redactedBuilder.WriteString(">")
+			if slices.Contains(voidElements, node.Tag) {
+				return
+			}
+		}
+		for _, eachChild := range node.Children {
+			walkNode(eachChild)
+		}
+		if len(node.Tag) != 0 && !unlinked {
+			fmt.Fprintf(&redactedBuilder, "</%s>", node.Tag)
+		}
+	}
+	walkNode(parseContentHTML(content))
+	if !matched {
+		return content, false
+	}
+	return redactedBuilder.String(), true
+}
+
+// redactText returns the text with the term matches replaced by
+// REDACTED_TEXT
+func (tr *tootRedactor) redactText(text string) (string, bool) {
+	return tr.pattern.ReplaceAllLiteralString(text, REDACTED_TEXT), tr.pattern.MatchString(text)
+}
+
+// redact returns true if the toot contains a term. With apply, the matches
+// are redacted, and a link preview of a matching page is removed.
+func (tr *tootRedactor) redact(object *ActivityObject, apply bool) bool {
+	redactedContent, contentMatched := tr.redactHTML(object.Content)
+	redactedSummary, summaryMatched := tr.redactText(object.Summary)
+	tootMatched := contentMatched || summaryMatched
+	if apply {
+		object.Content = redactedContent
+		object.Summary = redactedSummary
+	}
+	for _, eachAttachment := range object.Attachments {
+		redactedName, nameMatched := tr.redactText(eachAttachment.Name)
+		tootMatched = tootMatched || nameMatched
+		if apply {
+			eachAttachment.Name = redactedName
+		}
+	}
+	if preview := object.LinkPreview; preview != nil {
+		redactedTitle, titleMatched := tr.redactText(preview.Title)
+		redactedDescription, descriptionMatched := tr.redactText(preview.Description)
+		linkMatched := tr.pattern.MatchString(preview.URL) || tr.pattern.MatchString(preview.Image)
+		tootMatched = tootMatched || titleMatched || descriptionMatched || linkMatched
+		if apply && linkMatched {
+			object.LinkPreview = nil
+		} else if apply {
+			// The cached preview is shared by every toot linking the page
+			object.LinkPreview = &LinkPreview{
+				URL:         preview.URL,
+				Title:       redactedTitle,
+				Description: redactedDescription,
+				Image:       preview.Image,
+				// The image is of the page, which didn't match
+				ImageSourcePath: preview.ImageSourcePath,
+			}
+		}
+	}
+	return tootMatched
+}
+
+// redactToots replaces the pattern matches in every toot. It returns the
+// number of toots redacted.
+func (ob *Outbox) redactToots(pattern *regexp.Regexp) uint {
+	redactor := &tootRedactor{pattern: pattern}
+	redactedCount := uint(0)
+	for _, eachEntry := range ob.OrderedItems {
+		if redactor.redact(eachEntry.Object, true) {
+			redactedCount += 1
+		}
+	}
+	return redactedCount
+}
+
+// duplicateIDFilter excludes repeated activities for the same object, which
+// re-imported archives contain
+func duplicateIDFilter() FilterTootFunc {
+	seenIDs := map[string]bool{}
+	return func(entry *ActivityEntry) bool {
+		objectID := entry.ID
+		if entry.Object != nil && len(entry.Object.ID) != 0 {
+			objectID = entry.Object.ID
+		}
+		if seenIDs[objectID] {
+			return false
+		}
+		seenIDs[objectID] = true
+		return true
+	}
+}
+
+// duplicateContentFilter excludes toots with the same content and content
+// warning as a kept toot published at most window earlier, e.g. by
+// cross-posting tools. Dropped duplicates don't extend the window.
+func duplicateContentFilter(window time.Duration) FilterTootFunc {
+	lastPublished := map[string]time.Time{}
+	return func(entry *ActivityEntry) bool {
+		if len(strings.TrimSpace(entry.Object.Content)) == 0 {
+			return true
+		}
+		parsedDate, parsedDateErr := parsePublished(entry)
+		if parsedDateErr != nil {
+			return true
+		}
+		contentKey := entry.Object.Summary + "\x00" + entry.Object.Content
+		previousDate, previousExists := lastPublished[contentKey]
+		if previousExists && parsedDate.Sub(previousDate).Abs() <= window {
+			return false
+		}
+		lastPublished[contentKey] = parsedDate
+		return true
+	}
+}
+
+// normalizeTagFlag returns the comparison form of a tag name
+func normalizeTagFlag(tagName string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tagName), "#"))
+}
+
+// splitListFlag splits a comma separated flag value, dropping empty items
+func splitListFlag(value string) []string {
+	listItems := []string{}
+	for _, eachItem := range strings.Split(value, ",") {
+		eachItem = strings.TrimSpace(eachItem)
+		if len(eachItem) != 0 {
+			listItems = append(listItems, eachItem)
+		}
+	}
+	return listItems
+}
+
+// dateRangeFilter includes toots published in [since, until). Zero times
+// leave that end of the range open.
+func dateRangeFilter(since time.Time, until time.Time) FilterTootFunc {
+	return func(entry *ActivityEntry) bool {
+		parsedDate, parsedDateErr := parsePublished(entry)
+		if parsedDateErr != nil {
+			return false
+		}
+		if !since.IsZero() && parsedDate.Before(since) {
+			return false
+		}
+		if !until.IsZero() && !parsedDate.Before(until) {
+			return false
+		}
+		return true
+	}
+}
+
+// parseDateFlag parses a YYYY-MM-DD date or RFC3339 timestamp. Dates are
+// UTC midnight, or the following midnight when endOfDay is set so that an
+// --until date includes that whole day.
+func parseDateFlag(value string, endOfDay bool) (time.Time, error) {
+	if len(value) <= 0 {
+		return time.Time{}, nil
+	}
+	parsedDate, parsedDateErr := time.Parse(time.DateOnly, value)
+	if parsedDateErr == nil {
+		if endOfDay {
+			parsedDate = parsedDate.AddDate(0, 0, 1)
+		}
+		return parsedDate, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func newOutbox(inputFile string) (*Outbox, error) {
+	inputData, inputDataErr := os.ReadFile(inputFile)
+	if inputDataErr != nil {
+		return nil, inputDataErr
+	}
+	outbox := Outbox{}
+	err := json.Unmarshal(inputData, &outbox)
+	if err != nil {
+		return nil, err
+	}
+	// Get the input file source. That's the root directory
+	// for all media references
+	outbox.ArchiveDirectoryRoot = path.Dir(inputFile)
+
+	// For each activity, find the root thread element, which may be empty...
+	outbox.ThreadIDChain = map[string]*ActivityEntry{}
+	for _, eachActivity := range outbox.OrderedItems {
+		outbox.ThreadIDChain[eachActivity.Object.ID] = eachActivity
+	}
+	return &outbox, nil
+}
+
+// fetchActivityJSON requests the ActivityStreams representation of the
+// object from its origin server
+func fetchActivityJSON(objectURL string) ([]byte, error) {
+	request, requestErr := http.NewRequest(http.MethodGet, objectURL, nil)
+	if requestErr != nil {
+		return nil, requestErr
+	}
+	request.Header.Set("Accept", "application/activity+json")
+	client := http.Client{Timeout: 30 * time.Second}
+	response, responseErr := client.Do(request)
+	if responseErr != nil {
+		return nil, responseErr
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to fetch %s: %s", objectURL, response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// loadFeatured reads the pinned toot IDs from the featured collection the
+// archive's actor.json references. Archives include it as a file, although
+// older exports only have the collection URL, which is fetched if fetchRemote
+// is set.
+func (ob *Outbox) loadFeatured(fetchRemote bool, log *slog.Logger) error {
+	ob.FeaturedIDs = map[string]bool{}
+	actorPath := path.Join(ob.ArchiveDirectoryRoot, "actor.json")
+	actorData, actorDataErr := os.ReadFile(actorPath)
+	if actorDataErr != nil {
+		log.Debug("No actor file, skipping pinned toots", "path", actorPath)
+		return nil
+	}
+	actor := map[string]interface{}{}
+	actorErr := json.Unmarshal(actorData, &actor)
+	if actorErr != nil {
+		return actorErr
+	}
+	featuredLocation := jsonScalar[string]("featured", actor)
+	if len(featuredLocation) == 0 {
+		return nil
+	}
+	var featuredData []byte
+	if isRemoteURL(featuredLocation) {
+		if !fetchRemote {
+			log.Debug("Featured collection is remote, skipping pinned toots", "url", featuredLocation)
+			return nil
+		}
+		remoteData, remoteDataErr := fetchActivityJSON(featuredLocation)
+		if remoteDataErr != nil {
+			return remoteDataErr
+		}
+		featuredData = remoteData
+	} else {
+		localData, localDataErr := os.ReadFile(path.Join(ob.ArchiveDirectoryRoot, featuredLocation))
+		if localDataErr != nil {
+			log.Warn("Failed to read featured collection", "path", featuredLocation, "error", localDataErr)
+			return nil
+		}
+		featuredData = localData
+	}
+	// Items are either object IDs or the objects themselves
+	featured := struct {
+		OrderedItems []json.RawMessage `json:"orderedItems"`
+	}{}
+	featuredErr := json.Unmarshal(featuredData, &featured)
+	if featuredErr != nil {
+		return featuredErr
+	}
+	for _, eachItem := range featured.OrderedItems {
+		itemID := ""
+		if json.Unmarshal(eachItem, &itemID) != nil {
+			itemObject := map[string]interface{}{}
+			if json.Unmarshal(eachItem, &itemObject) == nil {
+				itemID = jsonScalar[string]("id", itemObject)
+			}
+		}
+		if len(itemID) != 0 {
+			ob.FeaturedIDs[itemID] = true
+		}
+	}
+	log.Info("Pinned toots", "count", len(ob.FeaturedIDs))
+	return nil
+}
+
+// resolveBoosts replaces the object URL of every Announce with a Note that
+// renders the boost in the given style, so the writers can treat boosts like
+// any other toot. The quote and full styles render the link when the
+// resolver doesn't have the boosted toot.
+func (ob *Outbox) resolveBoosts(boostStyle string, boostedLabel string, resolver *boostResolver, log *slog.Logger) {
+	for _, eachEntry := range ob.OrderedItems {
+		if eachEntry.Type != "Announce" {
+			continue
+		}
+		boostedURL := eachEntry.Object.Announcement
+		boostObject := &ActivityObject{
+			ID:        strings.TrimSuffix(eachEntry.ID, "/activity"),
+			Type:      "Note",
+			Published: eachEntry.Published,
+			URL:       boostedURL,
+			To:        eachEntry.To,
+			CC:        eachEntry.CC,
+			Content:   fmt.Sprintf("<p>%s: <a href=\"%s\">%s</a></p>", html.EscapeString(boostedLabel), html.EscapeString(boostedURL), html.EscapeString(boostedURL)),
+			Tags: []*ActivityObjectTag{{
+				Type: "Hashtag",
+				HREF: fmt.Sprintf("https://%s/tags/social%%20media", HOST),
+				Name: "Social Media",
+			}},
+		}
+		if boostStyle != "link" {
+			if boostedObject := resolver.object(boostedURL, log); boostedObject != nil {
+				if len(boostedObject.URL) != 0 {
+					boostObject.URL = boostedObject.URL
+				}
+				// The remote server's HTML is untrusted
+				boostedContent := sanitizeContentHTML(boostedObject.Content)
+				if boostStyle == "quote" {
+					boostedContent = fmt.Sprintf("<p>%s</p>", xmlEscapeString(truncateText(htmlToText(boostedContent), 280)))
+				} else {
+					// Remote attachment URLs are downloaded into the page bundle
+					boostObject.Attachments = boostedObject.Attachments
+					boostObject.Tags = boostedObject.Tags
+				}
+				boostObject.Content = fmt.Sprintf("<p>%s: <a href=\"%s\">%s</a></p><blockquote>%s</blockquote>",
+					html.EscapeString(boostedLabel),
+					html.EscapeString(boostObject.URL),
+					html.EscapeString(boostObject.URL),
+					boostedContent)
+			}
+		}
+		ob.ThreadIDChain[boostObject.ID] = eachEntry
+		eachEntry.Object = boostObject
+	}
+}
+
+// boostResolver fetches boosted toots, caching their JSON in a file so later
+// runs, including --offline ones, don't fetch them again
+type boostResolver struct {
+	cachePath    string
+	offline      bool
+	objects      map[string]json.RawMessage
+	fetchedCount uint
+}
+
+// newBoostResolver reads the previous runs' boosted toots
+func newBoostResolver(cachePath string, offline bool) (*boostResolver, error) {
+	resolver := &boostResolver{
+		cachePath: cachePath,
+		offline:   offline,
+		objects:   map[string]json.RawMessage{},
+	}
+	readErr := readJSONCache(cachePath, &resolver.objects)
+	if readErr != nil {
+		return nil, readErr
+	}
+	return resolver, nil
+}
+
+// object returns the boosted toot, or nil if it isn't cached and can't be
+// fetched. Toots that can't be fetched are tried again by the next run.
+func (br *boostResolver) object(objectURL string, log *slog.Logger) *ActivityObject {
+	objectJSON, cached := br.objects[objectURL]
+	if !cached {
+		if br.offline {
+			return nil
+		}
+		var fetchErr error
+		objectJSON, fetchErr = fetchActivityJSON(objectURL)
+		if fetchErr != nil {
+			log.Warn("Failed to fetch boosted toot, rendering link", "url", objectURL, "error", fetchErr)
+			return nil
+		}
+	}
+	boostedObject := &ActivityObject{}
+	unmarshalErr := json.Unmarshal(objectJSON, boostedObject)
+	if unmarshalErr != nil {
+		log.Warn("Failed to parse boosted toot, rendering link", "url", objectURL, "error", unmarshalErr)
+		return nil
+	}
+	if !cached {
+		br.objects[objectURL] = objectJSON
+		br.fetchedCount += 1
+	}
+	return boostedObject
+}
+
+// write saves the boosted toots for the next run
+func (br *boostResolver) write() error {
+	return writeJSONCache(br.cachePath, br.objects)
+}
+
+type cleanupFunc func(log *slog.Logger)
+
+// /////////////////////////////////////////////////////////////////////////////
+//  __              _   _
+// / _|_  _ _ _  __| |_(_)___ _ _  ___
+// |  _| || | ' \/ _|  _| / _ \ ' \(_-<
+// |_|  \_,_|_||_\__|\__|_\___/_||_/__/
+//
+// /////////////////////////////////////////////////////////////////////////////
+
+func ensureDirectory(root string, deleteExisting bool, log *slog.Logger) error {
+	_, emptyDirectoryStatErr := os.Stat(root)
+	log.Debug("Ensuring directory", "path", root, "deleteExisting", deleteExisting)
+	if emptyDirectoryStatErr == nil && deleteExisting {
+		removeAllErr := os.RemoveAll(root)
+		log.Info("Deleting existing directory contents", "path", root)
+		if removeAllErr != nil {
+			return removeAllErr
+		}
+	}
+	return os.MkdirAll(root, os.ModePerm)
+}
+
+// atomicFile writes an output file to a temporary file beside it, which
+// commit renames into place, so an interrupted run never leaves a partial
+// page or media file
+type atomicFile struct {
+	*os.File
+	filePath string
+}
+
+func createAtomicFile(filePath string, perm os.FileMode) (*atomicFile, error) {
+	tempFile, tempFileErr := os.OpenFile(filePath+ATOMIC_WRITE_SUFFIX, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if tempFileErr != nil {
+		return nil, tempFileErr
+	}
+	return &atomicFile{
+		File:     tempFile,
+		filePath: filePath,
+	}, nil
+}
+
+// commit closes the temporary file and renames it to the output file
+func (af *atomicFile) commit() error {
+	closeErr := af.Close()
+	if closeErr != nil {
+		os.Remove(af.Name())
+		return closeErr
+	}
+	return os.Rename(af.Name(), af.filePath)
+}
+
+// discard deletes the temporary file, leaving the output file as it was.
+// It does nothing once the file is committed.
+func (af *atomicFile) discard() {
+	if af.Close() == nil {
+		os.Remove(af.Name())
+	}
+}
+
+// writeFileAtomic is os.WriteFile through an atomicFile
+func writeFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	outputFile, outputFileErr := createAtomicFile(filePath, perm)
+	if outputFileErr != nil {
+		return outputFileErr
+	}
+	defer outputFile.discard()
+	if _, writeErr := outputFile.Write(data); writeErr != nil {
+		return writeErr
+	}
+	return outputFile.commit()
+}
+
+func copyMediaFile(sourceFilePath string, destFilePath string) (int64, error) {
+	srcFile, srcFileErr := os.Open(sourceFilePath)
+	if srcFileErr != nil {
+		return 0, srcFileErr
+	}
+	defer srcFile.Close()
+
+	destFile, destFileErr := createAtomicFile(destFilePath, 0644)
+	if destFileErr != nil {
+		return 0, destFileErr
+	}
+	defer destFile.discard()
+	//copy the contents of source to destination file
+	copiedBytes, copyErr := io.Copy(destFile, srcFile)
+	if copyErr != nil {
+		return copiedBytes, copyErr
+	}
+	return copiedBytes, destFile.commit()
+}
+
+// mediaFileSize returns the size of the file, following symlinks
+func mediaFileSize(filePath string) (int64, error) {
+	fileInfo, fileInfoErr := os.Stat(filePath)
+	if fileInfoErr != nil {
+		return 0, fileInfoErr
+	}
+	return fileInfo.Size(), nil
+}
+
+// splitHookCommand splits a hook command into its arguments the way a POSIX
+// shell would, without expanding anything. Single quotes keep their contents
+// as is, double quotes allow backslash escapes of \, ", $ and `, and a
+// backslash outside quotes escapes the next character.
+func splitHookCommand(command string) ([]string, error) {
+	commandArguments := []string{}
+	var argumentBuilder strings.Builder
+	inArgument := false
+	var quote rune
+	escaped := false
+	for _, eachRune := range command {
+		switch {
+		case escaped:
+			if quote == '"' && !strings.ContainsRune("\\\"$`", eachRune) {
+				argumentBuilder.WriteRune('\\')
+			}
+			argumentBuilder.WriteRune(eachRune)
+			escaped = false
+		case quote == '\'':
+			if eachRune == '\'' {
+				quote = 0
+			} else {
+				argumentBuilder.WriteRune(eachRune)
+			}
+		case eachRune == '\\' && quote != '\'':
+			escaped = true
+			inArgument = true
+		case quote == '"':
+			if eachRune == '"' {
+				quote = 0
+			} else {
+				argumentBuilder.WriteRune(eachRune)
+			}
+		case eachRune == '\'' || eachRune == '"':
+			quote = eachRune
+			inArgument = true
+		case unicode.IsSpace(eachRune):
+			if inArgument {
+				commandArguments = append(commandArguments, argumentBuilder.String())
+				argumentBuilder.Reset()
+				inArgument = false
+			}
+		default:
+			argumentBuilder.WriteRune(eachRune)
+			inArgument = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or escape in %s", command)
+	}
+	if inArgument {
+		commandArguments = append(commandArguments, argumentBuilder.String())
+	}
+	return commandArguments, nil
+}
+
+// runMediaHook runs the --media-hook command for the media file. It's an
+// error if the command fails or doesn't write the destination file.
+func runMediaHook(hookArguments []string, sourceFilePath string, destFilePath string) (int64, error) {
+	commandArguments := []string{}
+	for _, eachArgument := range hookArguments {
+		eachArgument = strings.ReplaceAll(eachArgument, "{src}", sourceFilePath)
+		commandArguments = append(commandArguments, strings.ReplaceAll(eachArgument, "{dest}", destFilePath))
+	}
+	command := exec.Command(commandArguments[0], commandArguments[1:]...)
+	commandOutput, commandErr := command.CombinedOutput()
+	if commandErr != nil {
+		return 0, fmt.Errorf("%s failed: %s. Output: %s", commandArguments[0], commandErr, commandOutput)
+	}
+	destSize, destSizeErr := mediaFileSize(destFilePath)
+	if destSizeErr != nil {
+		return 0, fmt.Errorf("%s didn't write %s", commandArguments[0], destFilePath)
+	}
+	return destSize, nil
+}
+
+// userCachePath returns the path of the named file or directory in the
+// mastodon-to-hugo user cache directory
+func userCachePath(name string) string {
+	userCacheDirectory, userCacheDirectoryErr := os.UserCacheDir()
+	if userCacheDirectoryErr != nil {
+		userCacheDirectory = os.TempDir()
+	}
+	return filepath.Join(userCacheDirectory, "mastodon-to-hugo", name)
+}
+
+// readJSONCache reads the JSON cache file into the value. A missing file
+// leaves the value unchanged.
+func readJSONCache(cachePath string, value interface{}) error {
+	cacheData, cacheDataErr := os.ReadFile(cachePath)
+	if os.IsNotExist(cacheDataErr) {
+		return nil
+	} else if cacheDataErr != nil {
+		return cacheDataErr
+	}
+	unmarshalErr := json.Unmarshal(cacheData, value)
+	if unmarshalErr != nil {
+		return fmt.Errorf("Failed to parse %s: %s", cachePath, unmarshalErr)
+	}
+	return nil
+}
+
+// writeJSONCache writes the value to the JSON cache file, creating its
+// directory
+func writeJSONCache(cachePath string, value interface{}) error {
+	mkdirErr := os.MkdirAll(filepath.Dir(cachePath), os.ModePerm)
+	if mkdirErr != nil {
+		return mkdirErr
+	}
+	return writeJSONFile(cachePath, value)
+}
+
+// urlExpander resolves shortened URLs to their final destination, caching
+// them in a JSON file so later runs, including --offline ones, reuse them
+type urlExpander struct {
+	cachePath     string
+	offline       bool
+	timeout       time.Duration
+	shorteners    []string
+	expanded      map[string]string
+	resolvedCount uint
+}
+
+// newURLExpander reads the previous runs' expanded URLs
+func newURLExpander(cachePath string, offline bool, timeout time.Duration, shorteners []string) (*urlExpander, error) {
+	expander := &urlExpander{
+		cachePath:  cachePath,
+		offline:    offline,
+		timeout:    timeout,
+		shorteners: shorteners,
+		expanded:   map[string]string{},
+	}
+	readErr := readJSONCache(cachePath, &expander.expanded)
+	if readErr != nil {
+		return nil, readErr
+	}
+	return expander, nil
+}
+
+// expand returns the destination of a URL on one of the shortener hosts, or
+// the URL itself. URLs that can't be resolved are tried again by the next run.
+func (ue *urlExpander) expand(linkURL string, log *slog.Logger) string {
+	parsedURL, parsedURLErr := url.Parse(linkURL)
+	if parsedURLErr != nil || !slices.Contains(ue.shorteners, strings.TrimPrefix(strings.ToLower(parsedURL.Hostname()), "www.")) {
+		return linkURL
+	}
+	if expandedURL, cached := ue.expanded[linkURL]; cached {
+		return expandedURL
+	}
+	if ue.offline {
+		return linkURL
+	}
+	expandedURL, resolveErr := resolveRedirects(linkURL, ue.timeout)
+	if resolveErr != nil {
+		log.Warn("Failed to expand URL", "url", linkURL, "error", resolveErr)
+		return linkURL
+	}
+	log.Debug("Expanded URL", "url", linkURL, "expandedURL", expandedURL)
+	ue.expanded[linkURL] = expandedURL
+	ue.resolvedCount += 1
+	return expandedURL
+}
+
+// write saves the expanded URLs for the next run
+func (ue *urlExpander) write() error {
+	return writeJSONCache(ue.cachePath, ue.expanded)
+}
+
+// resolveRedirects follows the redirects of the URL and returns the final
+// URL. Shorteners that don't answer HEAD requests are sent a GET.
+func resolveRedirects(linkURL string, timeout time.Duration) (string, error) {
+	client := http.Client{Timeout: timeout}
+	var lastErr error
+	for _, eachMethod := range []string{http.MethodHead, http.MethodGet} {
+		request, requestErr := http.NewRequest(eachMethod, linkURL, nil)
+		if requestErr != nil {
+			return "", requestErr
+		}
+		request.Header.Set("User-Agent", "mastodon-to-hugo")
+		response, responseErr := client.Do(request)
+		if responseErr != nil {
+			lastErr = responseErr
+			continue
+		}
+		response.Body.Close()
+		if response.StatusCode >= 400 {
+			lastErr = fmt.Errorf("Failed to resolve %s: %s", linkURL, response.Status)
+			continue
+		}
+		return response.Request.URL.String(), nil
+	}
+	return "", lastErr
+}
+
+// expandShortURLs replaces the shortened link targets in the content of
+// every toot with their destination. It returns the number of toots changed.
+func (ob *Outbox) expandShortURLs(expander *urlExpander, log *slog.Logger) uint {
+	expandedCount := uint(0)
+	for _, eachEntry := range ob.OrderedItems {
+		content := rewriteContentLinks(eachEntry.Object.Content, func(href string) string {
+			return expander.expand(href, log)
+		})
+		if content != eachEntry.Object.Content {
+			eachEntry.Object.Content = content
+			expandedCount += 1
+		}
+	}
+	return expandedCount
+}
+
+// LinkPreview is the OpenGraph metadata of a page linked by a toot. Pages
+// without a title get no card.
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	// Set when the image was downloaded
+	ImageSourcePath string `json:"-"`
+}
+
+// ImageFilename returns the page bundle filename of the downloaded preview
+// image, or an empty string if there is none. Pages aren't linked to the
+// remote image, which would tell its server about every visitor.
+func (lp *LinkPreview) ImageFilename() string {
+	if len(lp.ImageSourcePath) <= 0 {
+		return ""
+	}
+	imageHash := sha256.Sum256([]byte(lp.Image))
+	return "preview-" + hex.EncodeToString(imageHash[:6]) + path.Ext(lp.ImageSourcePath)
+}
+
+// Host returns the host name of the linked page, without a www. prefix
+func (lp *LinkPreview) Host() string {
+	parsedURL, parsedURLErr := url.Parse(lp.URL)
+	if parsedURLErr != nil {
+		return ""
+	}
+	return strings.TrimPrefix(parsedURL.Hostname(), "www.")
+}
+
+// linkPreviewer fetches link previews, caching them in a JSON file so later
+// runs, including --offline ones, don't fetch the pages again
+type linkPreviewer struct {
+	cachePath    string
+	offline      bool
+	previews     map[string]*LinkPreview
+	fetchedCount uint
+}
+
+// newLinkPreviewer reads the previous runs' link previews
+func newLinkPreviewer(cachePath string, offline bool) (*linkPreviewer, error) {
+	previewer := &linkPreviewer{
+		cachePath: cachePath,
+		offline:   offline,
+		previews:  map[string]*LinkPreview{},
+	}
+	readErr := readJSONCache(cachePath, &previewer.previews)
+	if readErr != nil {
+		return nil, readErr
+	}
+	return previewer, nil
+}
+
+// preview returns the link preview of the page, or nil if the page has no
+// title or can't be fetched. Pages that can't be fetched are tried again by
+// the next run.
+func (lp *linkPreviewer) preview(pageURL string, log *slog.Logger) *LinkPreview {
+	preview, cached := lp.previews[pageURL]
+	if !cached {
+		if lp.offline {
+			return nil
+		}
+		var fetchErr error
+		preview, fetchErr = fetchLinkPreview(pageURL)
+		if fetchErr != nil {
+			log.Warn("Failed to fetch link preview", "url", pageURL, "error", fetchErr)
+			return nil
+		}
+		lp.previews[pageURL] = preview
+		lp.fetchedCount += 1
+	}
+	if len(preview.Title) <= 0 {
+		return nil
+	}
+	return preview
+}
+
+// write saves the link previews for the next run
+func (lp *linkPreviewer) write() error {
+	return writeJSONCache(lp.cachePath, lp.previews)
+}
+
+// fetchLinkPreview reads the OpenGraph title, description and image of the
+// page, falling back to the <title> and description <meta> elements
+func fetchLinkPreview(pageURL string) (*LinkPreview, error) {
+	client := http.Client{Timeout: 15 * time.Second}
+	request, requestErr := http.NewRequest(http.MethodGet, pageURL, nil)
+	if requestErr != nil {
+		return nil, requestErr
+	}
+	request.Header.Set("User-Agent", "mastodon-to-hugo")
+	request.Header.Set("Accept", "text/html")
+	response, responseErr := client.Do(request)
+	if responseErr != nil {
+		return nil, responseErr
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to fetch %s: %s", pageURL, response.Status)
+	}
+	preview := &LinkPreview{URL: pageURL}
+	if !strings.Contains(response.Header.Get("Content-Type"), "html") {
+		return preview, nil
+	}
+	pageData, readErr := io.ReadAll(io.LimitReader(response.Body, LINK_PREVIEW_MAX_PAGE_BYTES))
+	if readErr != nil {
+		return nil, readErr
+	}
+	metaContent := map[string]string{}
+	for _, eachMeta := range HTML_META_PATTERN.FindAllString(string(pageData), -1) {
+		metaAttrs := map[string]string{}
+		for _, eachAttr := range HTML_ATTRIBUTE_PATTERN.FindAllStringSubmatch(eachMeta, -1) {
+			metaAttrs[strings.ToLower(eachAttr[1])] = eachAttr[2] + eachAttr[3]
+		}
+		metaName := strings.ToLower(metaAttrs["property"] + metaAttrs["name"])
+		if _, exists := metaContent[metaName]; !exists && len(metaName) != 0 {
+			metaContent[metaName] = strings.TrimSpace(html.UnescapeString(metaAttrs["content"]))
+		}
+	}
+	firstOf := func(values ...string) string {
+		for _, eachValue := range values {
+			if len(eachValue) != 0 {
+				return eachValue
+			}
+		}
+		return ""
+	}
+	pageTitle := ""
+	if titleMatch := HTML_TITLE_PATTERN.FindStringSubmatch(string(pageData)); titleMatch != nil {
+		pageTitle = strings.TrimSpace(html.UnescapeString(titleMatch[1]))
+	}
+	preview.Title = truncateText(firstOf(metaContent["og:title"], metaContent["twitter:title"], pageTitle), 120)
+	preview.Description = truncateText(firstOf(metaContent["og:description"], metaContent["twitter:description"], metaContent["description"]), 200)
+	// Images may be relative to the page
+	if imageURL := firstOf(metaContent["og:image"], metaContent["og:image:url"], metaContent["twitter:image"]); len(imageURL) != 0 {
+		resolvedURL, resolvedURLErr := response.Request.URL.Parse(imageURL)
+		if resolvedURLErr == nil && isRemoteURL(resolvedURL.String()) {
+			preview.Image = resolvedURL.String()
+		}
+	}
+	return preview, nil
+}
+
+// linkPreviewURL returns the first link of toot HTML that is mostly a URL,
+// meaning it has at most LINK_PREVIEW_MAX_TEXT_LENGTH characters of text
+// besides its links. Mentions and hashtags aren't previewed.
+func linkPreviewURL(content string) string {
+	previewURL := ""
+	var textBuilder strings.Builder
+	var walkNode func(node *htmlNode)
+	walkNode = func(node *htmlNode) {
+		if node.Tag == "a" {
+			href := node.Attrs["href"]
+			if len(previewURL) <= 0 && isRemoteURL(href) && !strings.Contains(node.Attrs["class"], "mention") {
+				previewURL = href
+			}
+			return
+		}
+		textBuilder.WriteString(node.Text)
+		for _, eachChild := range node.Children {
+			walkNode(eachChild)
+		}
+	}
+	walkNode(parseContentHTML(content))
+	if utf8.RuneCountInString(strings.Join(strings.Fields(textBuilder.String()), " ")) > LINK_PREVIEW_MAX_TEXT_LENGTH {
+		return ""
+	}
+	return previewURL
+}
+
+// addLinkPreviews sets the link preview of every toot that is mostly a URL.
+// Toots with media aren't previewed, as on Mastodon. It returns the number of
+// toots with a preview.
+func (ob *Outbox) addLinkPreviews(previewer *linkPreviewer, fetcher *mediaFetcher, log *slog.Logger) uint {
+	previewCount := uint(0)
+	for _, eachEntry := range ob.OrderedItems {
+		if len(eachEntry.Object.Attachments) != 0 {
+			continue
+		}
+		previewURL := linkPreviewURL(eachEntry.Object.Content)
+		if len(previewURL) <= 0 {
+			continue
+		}
+		eachEntry.Object.LinkPreview = previewer.preview(previewURL, log)
+		if eachEntry.Object.LinkPreview != nil {
+			previewCount += 1
+		}
+		if preview := eachEntry.Object.LinkPreview; preview != nil && len(preview.Image) != 0 && len(preview.ImageSourcePath) <= 0 {
+			imagePath, fetchErr := fetcher.fetch(preview.Image, log)
+			if fetchErr != nil {
+				log.Warn("Link preview image unavailable, leaving it out", "url", preview.Image, "error", fetchErr)
+				continue
+			}
+			preview.ImageSourcePath = imagePath
+		}
+	}
+	return previewCount
+}
+
+// isRemoteURL returns true for http(s) URLs, as opposed to archive relative
+// media paths
+func isRemoteURL(mediaURL string) bool {
+	return strings.HasPrefix(mediaURL, "https://") || strings.HasPrefix(mediaURL, "http://")
+}
+
+// downloadMediaFile saves the remote media file to the destination path
+func downloadMediaFile(sourceURL string, destFilePath string) (int64, error) {
+	client := http.Client{Timeout: 5 * time.Minute}
+	response, responseErr := client.Get(sourceURL)
+	if responseErr != nil {
+		return 0, responseErr
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("Failed to download %s: %s", sourceURL, response.Status)
+	}
+	destFile, destFileErr := os.Create(destFilePath)
+	if destFileErr != nil {
+		return 0, destFileErr
+	}
+	defer destFile.Close()
+	return io.Copy(destFile, response.Body)
+}
+
+// mediaFetcher downloads the attachments that aren't in the archive into a
+// cache directory, so later runs, including --offline ones, reuse them
+type mediaFetcher struct {
+	baseURL        string
+	cacheDirectory string
+	retries        int
+	offline        bool
+}
+
+// fetch returns the path of the cached copy of the media URL, downloading it
+// if necessary
+func (mf *mediaFetcher) fetch(mediaURL string, log *slog.Logger) (string, error) {
+	urlHash := sha256.Sum256([]byte(mediaURL))
+	cachePath := filepath.Join(mf.cacheDirectory, hex.EncodeToString(urlHash[:]))
+	if parsedURL, parsedURLErr := url.Parse(mediaURL); parsedURLErr == nil {
+		cachePath += path.Ext(parsedURL.Path)
+	}
+	if _, statErr := os.Stat(cachePath); statErr == nil {
+		log.Debug("Using cached media file", "url", mediaURL, "path", cachePath)
+		return cachePath, nil
+	}
+	if mf.offline {
+		return "", fmt.Errorf("Media file isn't cached and --offline is set")
+	}
+	mkdirErr := os.MkdirAll(mf.cacheDirectory, os.ModePerm)
+	if mkdirErr != nil {
+		return "", mkdirErr
+	}
+	// Download to a temporary file so an interrupted run doesn't cache a
+	// partial file
+	tempPath := cachePath + ".tmp"
+	var downloadErr error
+	for attempt := 0; attempt <= mf.retries; attempt++ {
+		if attempt != 0 {
+			retryDelay := time.Duration(1<<(attempt-1)) * time.Second
+			log.Debug("Retrying media download", "url", mediaURL, "attempt", attempt, "delay", retryDelay, "error", downloadErr)
+			time.Sleep(retryDelay)
+		}
+		var bytesDownloaded int64
+		bytesDownloaded, downloadErr = downloadMediaFile(mediaURL, tempPath)
+		if downloadErr == nil {
+			log.Debug("Downloaded media file", "url", mediaURL, "bytes", bytesDownloaded)
+			return cachePath, os.Rename(tempPath, cachePath)
+		}
+	}
+	os.Remove(tempPath)
+	return "", downloadErr
+}
+
+// fetchMissingMedia points the attachments that are missing from the archive,
+// or are only remote URLs, at downloaded copies. Attachments that can't be
+// downloaded are removed from their toot rather than rendered as broken
+// links. It returns the number of downloaded and removed attachments.
+func (ob *Outbox) fetchMissingMedia(fetcher *mediaFetcher, log *slog.Logger) (uint, uint) {
+	downloadedCount := uint(0)
+	removedCount := uint(0)
+	for _, eachEntry := range ob.OrderedItems {
+		availableAttachments := []*ActivityObjectAttachment{}
+		for _, eachAttachment := range eachEntry.Object.Attachments {
+			mediaURL := eachAttachment.URL
+			if !isRemoteURL(mediaURL) {
+				_, statErr := os.Stat(path.Join(ob.ArchiveDirectoryRoot, mediaURL))
+				if statErr == nil {
+					availableAttachments = append(availableAttachments, eachAttachment)
+					continue
+				}
+				mediaURL = fetcher.baseURL + "/" + strings.TrimPrefix(mediaURL, "/")
+			}
+			cachePath, fetchErr := fetcher.fetch(mediaURL, log)
+			if fetchErr != nil {
+				log.Warn("Removing unavailable attachment",
+					"id", eachEntry.Object.ID,
+					"url", mediaURL,
+					"error", fetchErr)
+				removedCount += 1
+				continue
+			}
+			eachAttachment.SourcePath = cachePath
+			availableAttachments = append(availableAttachments, eachAttachment)
+			downloadedCount += 1
+		}
+		eachEntry.Object.Attachments = availableAttachments
+	}
+	return downloadedCount, removedCount
+}
+
+// mediaSourcePath returns the path of the attachment's media file: the
+// download of media missing from the archive, or the archive's copy
+func (ob *Outbox) mediaSourcePath(attachment *ActivityObjectAttachment) string {
+	if len(attachment.SourcePath) != 0 {
+		return attachment.SourcePath
+	}
+	return path.Join(ob.ArchiveDirectoryRoot, attachment.URL)
+}
+
+// removeCorruptMedia removes the attachments whose media file is empty,
+// truncated or not of the attachment's media type. It returns the number of
+// attachments checked and removed.
+func (ob *Outbox) removeCorruptMedia(log *slog.Logger) (uint, uint) {
+	checkedCount := uint(0)
+	removedCount := uint(0)
+	for _, eachEntry := range ob.OrderedItems {
+		validAttachments := []*ActivityObjectAttachment{}
+		for _, eachAttachment := range eachEntry.Object.Attachments {
+			sourceFilePath := ob.mediaSourcePath(eachAttachment)
+			checkedCount += 1
+			verifyErr := verifyMediaFile(sourceFilePath, eachAttachment.MediaType)
+			if verifyErr != nil {
+				log.Warn("Removing corrupt attachment",
+					"id", eachEntry.Object.ID,
+					"path", sourceFilePath,
+					"error", verifyErr)
+				removedCount += 1
+				continue
+			}
+			validAttachments = append(validAttachments, eachAttachment)
+		}
+		eachEntry.Object.Attachments = validAttachments
+	}
+	return checkedCount, removedCount
+}
+
+// validate logs a warning for each toot with an invalid publish time, and
+// each attachment whose media file is missing from the archive or corrupt.
+// Remote only media isn't checked. It returns the number of media files
+// checked and problems found.
+func (ob *Outbox) validate(log *slog.Logger) (uint, uint) {
+	checkedCount := uint(0)
+	problemCount := uint(0)
+	for _, eachEntry := range ob.OrderedItems {
+		if _, publishedErr := time.Parse(time.RFC3339, eachEntry.Published); publishedErr != nil {
+			log.Warn("Invalid publish time", "id", eachEntry.Object.ID, "published", eachEntry.Published)
+			problemCount += 1
+		}
+		for _, eachAttachment := range eachEntry.Object.Attachments {
+			if isRemoteURL(eachAttachment.URL) {
+				continue
+			}
+			sourceFilePath := path.Join(ob.ArchiveDirectoryRoot, eachAttachment.URL)
+			checkedCount += 1
+			if _, statErr := os.Stat(sourceFilePath); statErr != nil {
+				log.Warn("Attachment missing from the archive", "id", eachEntry.Object.ID, "path", sourceFilePath)
+				problemCount += 1
+			} else if verifyErr := verifyMediaFile(sourceFilePath, eachAttachment.MediaType); verifyErr != nil {
+				log.Warn("Corrupt attachment", "id", eachEntry.Object.ID, "path", sourceFilePath, "error", verifyErr)
+				problemCount += 1
+			}
+		}
+	}
+	return checkedCount, problemCount
+}
+
+// logStatistics logs the counts of the toots, their media and hashtags, then
+// the toots per year and the STATS_HASHTAG_COUNT most used hashtags
+func (ob *Outbox) logStatistics(log *slog.Logger) {
+	yearCounts := map[int]uint{}
+	hashtagCounts := map[string]int{}
+	replyCount := uint(0)
+	mediaFilesCount := uint(0)
+	missingAltCount := uint(0)
+	for _, eachEntry := range ob.OrderedItems {
+		if publishedTime, publishedErr := time.Parse(time.RFC3339, eachEntry.Published); publishedErr == nil {
+			yearCounts[publishedTime.Year()] += 1
+		}
+		if len(eachEntry.Object.InReplyTo) != 0 {
+			replyCount += 1
+		}
+		for _, eachAttachment := range eachEntry.Object.Attachments {
+			mediaFilesCount += 1
+			if strings.HasPrefix(eachAttachment.MediaType, "image/") && !hasAltText(eachAttachment) {
+				missingAltCount += 1
+			}
+		}
+		for _, eachTag := range eachEntry.Object.Tags {
+			if eachTag.Type == "Hashtag" {
+				hashtagCounts[eachTag.Name] += 1
+			}
+		}
+	}
+	log.Info("Archive statistics", append([]any{
+		"totalTootCount", ob.TotalItems,
+		"publishedTootCount", len(ob.OrderedItems),
+		"replyCount", replyCount,
+		"mediaFilesCount", mediaFilesCount,
+		"missingAltTextCount", missingAltCount,
+		"hashtagCount", len(hashtagCounts)},
+		ob.skippedCountLogArgs()...)...)
+	for _, eachYear := range slices.Sorted(maps.Keys(yearCounts)) {
+		log.Info("Toots per year", "year", eachYear, "tootCount", yearCounts[eachYear])
+	}
+	// Ties are in name order
+	hashtags := slices.Sorted(maps.Keys(hashtagCounts))
+	slices.SortStableFunc(hashtags, func(a string, b string) int {
+		return hashtagCounts[b] - hashtagCounts[a]
+	})
+	for _, eachHashtag := range hashtags[:min(len(hashtags), STATS_HASHTAG_COUNT)] {
+		log.Info("Most used hashtags", "hashtag", eachHashtag, "tootCount", hashtagCounts[eachHashtag])
+	}
+}
+
+// verifyMediaFile returns an error if the media file is empty or isn't of
+// the media type. JPEG and PNG images are decoded in full to find truncated
+// files. Other media only have their leading bytes checked, as archives
+// sometimes contain an HTML error page saved in place of the media.
+func verifyMediaFile(filePath string, mediaType string) error {
+	mediaData, readErr := os.ReadFile(filePath)
+	if readErr != nil {
+		return readErr
+	}
+	if len(mediaData) <= 0 {
+		return fmt.Errorf("Empty media file")
+	}
+	sniffedType := http.DetectContentType(mediaData)
+	switch mediaType {
+	case "image/jpeg", "image/png":
+		if sniffedType != mediaType {
+			return fmt.Errorf("Contents are %s, not %s", sniffedType, mediaType)
+		}
+		_, _, decodeErr := image.Decode(bytes.NewReader(mediaData))
+		if decodeErr != nil {
+			return fmt.Errorf("Failed to decode %s: %s", mediaType, decodeErr)
+		}
+	default:
+		if strings.HasPrefix(sniffedType, "text/") && !strings.HasPrefix(mediaType, "text/") {
+			return fmt.Errorf("Contents are %s, not %s", sniffedType, mediaType)
+		}
+	}
+	return nil
+}
+
+// fetchCustomEmoji finds the image of every custom emoji tag, either in the
+// archive or through the fetcher. Emoji without an image keep their
+// :shortcode: text. It returns the number of emoji images found and missing.
+func (ob *Outbox) fetchCustomEmoji(fetcher *mediaFetcher, log *slog.Logger) (uint, uint) {
+	foundCount := uint(0)
+	missingCount := uint(0)
+	resolvedPaths := map[string]string{}
+	for _, eachEntry := range ob.OrderedItems {
+		for _, eachTag := range eachEntry.Object.Tags {
+			if eachTag.Type != "Emoji" || eachTag.Icon == nil || !CUSTOM_EMOJI_NAME_PATTERN.MatchString(eachTag.Name) {
+				continue
+			}
+			emojiPath, resolved := resolvedPaths[eachTag.Icon.URL]
+			if !resolved {
+				// Archives that bundle the images keep the server's path,
+				// with or without the /system prefix
+				emojiPath = ""
+				urlPath := eachTag.Icon.URL
+				if parsedURL, parsedURLErr := url.Parse(eachTag.Icon.URL); parsedURLErr == nil {
+					urlPath = parsedURL.Path
+				}
+				for _, eachArchivePath := range []string{urlPath, strings.TrimPrefix(urlPath, "/system")} {
+					archivePath := path.Join(ob.ArchiveDirectoryRoot, eachArchivePath)
+					if _, statErr := os.Stat(archivePath); statErr == nil {
+						emojiPath = archivePath
+						break
+					}
+				}
+				if len(emojiPath) <= 0 && isRemoteURL(eachTag.Icon.URL) {
+					cachePath, fetchErr := fetcher.fetch(eachTag.Icon.URL, log)
+					if fetchErr != nil {
+						log.Warn("Custom emoji image unavailable, keeping the shortcode",
+							"name", eachTag.Name,
+							"url", eachTag.Icon.URL,
+							"error", fetchErr)
+					}
+					emojiPath = cachePath
+				}
+				resolvedPaths[eachTag.Icon.URL] = emojiPath
+			}
+			if len(emojiPath) <= 0 {
+				missingCount += 1
+				continue
+			}
+			eachTag.Icon.SourcePath = emojiPath
+			foundCount += 1
+		}
+	}
+	return foundCount, missingCount
+}
+
+// copyLinkPreviewImage copies the downloaded link preview image of the toot,
+// if any, into destDirectory
+func copyLinkPreviewImage(entry *ActivityEntry, destDirectory string) error {
+	preview := entry.Object.LinkPreview
+	if preview == nil || len(preview.ImageFilename()) <= 0 {
+		return nil
+	}
+	destFilePath := path.Join(destDirectory, preview.ImageFilename())
+	if _, statErr := os.Stat(destFilePath); statErr == nil {
+		return nil
+	}
+	_, copyErr := copyMediaFile(preview.ImageSourcePath, destFilePath)
+	return copyErr
+}
+
+// copyCustomEmoji copies the custom emoji images of the toot into
+// destDirectory
+func copyCustomEmoji(entry *ActivityEntry, destDirectory string) (uint, error) {
+	copiedCount := uint(0)
+	for _, eachTag := range entry.Object.Tags {
+		if eachTag.Icon == nil || len(eachTag.Icon.SourcePath) <= 0 {
+			continue
+		}
+		destFilePath := path.Join(destDirectory, eachTag.EmojiFilename())
+		if _, statErr := os.Stat(destFilePath); statErr == nil {
+			continue
+		}
+		_, copyErr := copyMediaFile(eachTag.Icon.SourcePath, destFilePath)
+		if copyErr != nil {
+			return copiedCount, copyErr
+		}
+		copiedCount += 1
+	}
+	return copiedCount, nil
+}
+
+// customEmojiContent returns the toot HTML with the :shortcode: of each custom
+// emoji copied by copyCustomEmoji replaced by an inline image
+func customEmojiContent(entry *ActivityEntry) string {
+	content := entry.Object.Content
+	for _, eachTag := range entry.Object.Tags {
+		if eachTag.Icon == nil || len(eachTag.Icon.SourcePath) <= 0 {
+			continue
+		}
+		emojiImage := fmt.Sprintf(`<img src="%s" alt="%s" title="%s" class="custom-emoji" style="height:1.2em;width:auto;vertical-align:middle" />`,
+			eachTag.EmojiFilename(),
+			eachTag.Name,
+			eachTag.Name)
+		content = replaceHTMLText(content, regexp.MustCompile(regexp.QuoteMeta(eachTag.Name)), emojiImage)
+	}
+	return content
+}
+
+// hashMediaFile returns the hex SHA-256 digest and size of the file
+func hashMediaFile(filePath string) (string, int64, error) {
+	mediaFile, mediaFileErr := os.Open(filePath)
+	if mediaFileErr != nil {
+		return "", 0, mediaFileErr
+	}
+	defer mediaFile.Close()
+	hasher := sha256.New()
+	size, hashErr := io.Copy(hasher, mediaFile)
+	if hashErr != nil {
+		return "", 0, hashErr
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+// dedupeMediaFile replaces the media file with a hard link to an earlier
+// output file with identical contents, so each distinct file is stored once.
+// The copy is kept if the file system doesn't support links.
+func (ob *Outbox) dedupeMediaFile(mediaFilePath string) error {
+	contentHash, size, hashErr := hashMediaFile(mediaFilePath)
+	if hashErr != nil {
+		return hashErr
+	}
+	canonicalPath, exists := ob.MediaHashes[contentHash]
+	if !exists || canonicalPath == mediaFilePath {
+		ob.MediaHashes[contentHash] = mediaFilePath
+		return nil
+	}
+	linkPath := mediaFilePath + ".link"
+	if os.Link(canonicalPath, linkPath) != nil {
+		return nil
+	}
+	renameErr := os.Rename(linkPath, mediaFilePath)
+	if renameErr != nil {
+		return renameErr
+	}
+	ob.DedupedMediaCount += 1
+	ob.DedupedMediaBytes += size
+	return nil
+}
+
+// MediaManifestEntry records a copied attachment with the attachment fields
+// the media processors set, so an unchanged one can be reused as is
+type MediaManifestEntry struct {
+	Source              string               `json:"source"`
+	SourceSize          int64                `json:"sourceSize"`
+	SourceModTime       time.Time            `json:"sourceModTime"`
+	Settings            string               `json:"settings"`
+	BaseFilename        string               `json:"baseFilename"`
+	MediaType           string               `json:"mediaType"`
+	FallbackFilename    string               `json:"fallbackFilename,omitempty"`
+	FallbackMediaType   string               `json:"fallbackMediaType,omitempty"`
+	PlaceholderFilename string               `json:"placeholderFilename,omitempty"`
+	Srcset              []*SrcsetImage       `json:"srcset,omitempty"`
+	Width               uint                 `json:"width"`
+	Height              uint                 `json:"height"`
+	Files               []*MediaManifestFile `json:"files"`
+}
+
+// MediaManifestFile is a single output file, relative to the output root
+type MediaManifestFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Hash string `json:"hash"`
+}
+
+// mediaManifest tracks the media copied to the output root by the previous
+// and current runs, keyed by the output path relative to the root
+type mediaManifest struct {
+	root         string
+	settings     string
+	previous     map[string]*MediaManifestEntry
+	current      map[string]*MediaManifestEntry
+	skippedCount uint
+}
+
+// newMediaManifest reads the previous run's manifest from the output root.
+// Entries made with other media processing settings are never reused.
+func newMediaManifest(root string, settings string) (*mediaManifest, error) {
+	manifest := &mediaManifest{
+		root:     root,
+		settings: settings,
+		previous: map[string]*MediaManifestEntry{},
+		current:  map[string]*MediaManifestEntry{},
+	}
+	manifestData, manifestDataErr := os.ReadFile(path.Join(root, MEDIA_MANIFEST_FILENAME))
+	if os.IsNotExist(manifestDataErr) {
+		return manifest, nil
+	} else if manifestDataErr != nil {
+		return nil, manifestDataErr
+	}
+	unmarshalErr := json.Unmarshal(manifestData, &manifest.previous)
+	if unmarshalErr != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %s", MEDIA_MANIFEST_FILENAME, unmarshalErr)
+	}
+	return manifest, nil
+}
+
+// previousFiles returns the absolute paths of every file the previous run
+// copied, which the output purge keeps
+func (mm *mediaManifest) previousFiles() map[string]bool {
+	keepPaths := map[string]bool{path.Join(mm.root, MEDIA_MANIFEST_FILENAME): true}
+	for _, eachEntry := range mm.previous {
+		for _, eachFile := range eachEntry.Files {
+			keepPaths[path.Join(mm.root, eachFile.Path)] = true
+		}
+	}
+	return keepPaths
+}
+
+// reuse restores the attachment from the previous run's entry if the source
+// and every output file are unchanged, comparing the output files by their
+// recorded hash, returning false if it must be copied
+func (mm *mediaManifest) reuse(attachment *ActivityObjectAttachment, sourceFilePath string, destFilePath string) (*MediaManifestEntry, bool) {
+	manifestKey, _ := filepath.Rel(mm.root, destFilePath)
+	entry, entryExists := mm.previous[manifestKey]
+	if !entryExists || entry.Source != sourceFilePath || entry.Settings != mm.settings {
+		return nil, false
+	}
+	sourceInfo, sourceInfoErr := os.Stat(sourceFilePath)
+	if sourceInfoErr != nil ||
+		sourceInfo.Size() != entry.SourceSize ||
+		!sourceInfo.ModTime().Equal(entry.SourceModTime) {
+		return nil, false
+	}
+	for _, eachFile := range entry.Files {
+		contentHash, size, hashErr := hashMediaFile(path.Join(mm.root, eachFile.Path))
+		if hashErr != nil || size != eachFile.Size || contentHash != eachFile.Hash {
+			return nil, false
+		}
+	}
+	attachment.BaseFilename = entry.BaseFilename
+	attachment.MediaType = entry.MediaType
+	attachment.FallbackFilename = entry.FallbackFilename
+	attachment.FallbackMediaType = entry.FallbackMediaType
+	attachment.PlaceholderFilename = entry.PlaceholderFilename
+	attachment.Srcset = entry.Srcset
+	attachment.Width = entry.Width
+	attachment.Height = entry.Height
+	mm.current[manifestKey] = entry
+	mm.skippedCount += 1
+	return entry, true
+}
+
+// record adds the freshly copied and processed attachment to the manifest
+func (mm *mediaManifest) record(attachment *ActivityObjectAttachment, sourceFilePath string, destDirectory string, originalFilename string) error {
+	sourceInfo, sourceInfoErr := os.Stat(sourceFilePath)
+	if sourceInfoErr != nil {
+		return sourceInfoErr
+	}
+	manifestKey, _ := filepath.Rel(mm.root, path.Join(destDirectory, originalFilename))
+	entry := &MediaManifestEntry{
+		Source:              sourceFilePath,
+		SourceSize:          sourceInfo.Size(),
+		SourceModTime:       sourceInfo.ModTime(),
+		Settings:            mm.settings,
+		BaseFilename:        attachment.BaseFilename,
+		MediaType:           attachment.MediaType,
+		FallbackFilename:    attachment.FallbackFilename,
+		FallbackMediaType:   attachment.FallbackMediaType,
+		PlaceholderFilename: attachment.PlaceholderFilename,
+		Srcset:              attachment.Srcset,
+		Width:               attachment.Width,
+		Height:              attachment.Height,
+	}
+	outputFilenames := []string{attachment.BaseFilename,
+		attachment.FallbackFilename,
+		attachment.PlaceholderFilename,
+		path.Join("originals", originalFilename)}
+	for _, eachImage := range attachment.Srcset {
+		outputFilenames = append(outputFilenames, eachImage.Filename)
+	}
+	for _, eachFilename := range outputFilenames {
+		filePath := path.Join(destDirectory, eachFilename)
+		if len(eachFilename) <= 0 || slices.ContainsFunc(entry.Files, func(file *MediaManifestFile) bool {
+			return path.Join(mm.root, file.Path) == filePath
+		}) {
+			continue
+		}
+		contentHash, size, hashErr := hashMediaFile(filePath)
+		if os.IsNotExist(hashErr) {
+			continue
+		} else if hashErr != nil {
+			return hashErr
+		}
+		relativePath, _ := filepath.Rel(mm.root, filePath)
+		entry.Files = append(entry.Files, &MediaManifestFile{
+			Path: relativePath,
+			Size: size,
+			Hash: contentHash,
+		})
+	}
+	mm.current[manifestKey] = entry
+	return nil
+}
+
+// write saves the media copied by this run as the next run's manifest, and
+// deletes the files the previous run copied that this run didn't use
+func (mm *mediaManifest) write() error {
+	currentFiles := map[string]bool{}
+	for _, eachEntry := range mm.current {
+		for _, eachFile := range eachEntry.Files {
+			currentFiles[eachFile.Path] = true
+		}
+	}
+	for _, eachEntry := range mm.previous {
+		for _, eachFile := range eachEntry.Files {
+			if !currentFiles[eachFile.Path] {
+				os.Remove(path.Join(mm.root, eachFile.Path))
+			}
+		}
+	}
+	return writeJSONFile(path.Join(mm.root, MEDIA_MANIFEST_FILENAME), mm.current)
+}
+
+// archiveTootIDs returns the IDs of every toot in the archive, including
+// those the filters skipped
+func (ob *Outbox) archiveTootIDs() map[string]bool {
+	tootIDs := map[string]bool{}
+	for _, eachEntry := range ob.OrderedItems {
+		tootIDs[eachEntry.Object.ID] = true
+	}
+	for _, eachSkipped := range ob.SkippedToots {
+		tootIDs[eachSkipped.entry.Object.ID] = true
+	}
+	return tootIDs
+}
+
+// keptFiles returns the absolute paths of the media and pages the previous
+// run wrote that this run may reuse, which --clean keeps
+func (ob *Outbox) keptFiles() map[string]bool {
+	keepPaths := map[string]bool{}
+	if ob.MediaManifest != nil {
+		maps.Copy(keepPaths, ob.MediaManifest.previousFiles())
+	}
+	if ob.RenderState != nil {
+		maps.Copy(keepPaths, ob.RenderState.previousFiles())
+	}
+	return keepPaths
+}
+
+// RenderStateEntry records a page written to the output and the toots on it
+type RenderStateEntry struct {
+	TootIDs []string `json:"tootIds"`
+	// Content hash of the page, without the time it was generated
+	Hash string `json:"hash"`
+	// Content hash of the page file as written
+	FileHash string `json:"fileHash"`
+}
+
+// renderState tracks the pages written to the output root by the previous
+// and current runs, keyed by the page path relative to the root
+type renderState struct {
+	root         string
+	previous     map[string]*RenderStateEntry
+	current      map[string]*RenderStateEntry
+	skippedCount uint
+	// Keep pages edited since they were written
+	noOverwrite bool
+	editedCount uint
+}
+
+// newRenderState reads the previous run's state file from the output root.
+// With noOverwrite, pages edited since the previous run are kept as is.
+func newRenderState(root string, noOverwrite bool) (*renderState, error) {
+	state := &renderState{
+		root:        root,
+		previous:    map[string]*RenderStateEntry{},
+		current:     map[string]*RenderStateEntry{},
+		noOverwrite: noOverwrite,
+	}
+	stateData, stateDataErr := os.ReadFile(path.Join(root, RENDER_STATE_FILENAME))
+	if os.IsNotExist(stateDataErr) {
+		return state, nil
+	} else if stateDataErr != nil {
+		return nil, stateDataErr
+	}
+	unmarshalErr := json.Unmarshal(stateData, &state.previous)
+	if unmarshalErr != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %s", RENDER_STATE_FILENAME, unmarshalErr)
+	}
+	return state, nil
+}
+
+// previousFiles returns the absolute paths of every page the previous run
+// wrote, which the output purge keeps
+func (rs *renderState) previousFiles() map[string]bool {
+	keepPaths := map[string]bool{path.Join(rs.root, RENDER_STATE_FILENAME): true}
+	for eachPath := range rs.previous {
+		keepPaths[path.Join(rs.root, eachPath)] = true
+	}
+	return keepPaths
+}
+
+// edited returns true if the page the previous run wrote was changed since.
+// A deleted page isn't edited.
+func (rs *renderState) edited(stateKey string) bool {
+	previousEntry, previousEntryExists := rs.previous[stateKey]
+	if !previousEntryExists {
+		return false
+	}
+	fileHash, _, hashErr := hashMediaFile(path.Join(rs.root, stateKey))
+	return hashErr == nil && fileHash != previousEntry.FileHash
+}
+
+// keepEdited keeps the previous run's entry for the page if it was edited
+// and --no-overwrite is set, returning false if it must be written
+func (rs *renderState) keepEdited(stateKey string, log *slog.Logger) bool {
+	if !rs.noOverwrite || !rs.edited(stateKey) {
+		return false
+	}
+	log.Warn("Keeping edited page", "path", path.Join(rs.root, stateKey))
+	rs.current[stateKey] = rs.previous[stateKey]
+	rs.editedCount += 1
+	return true
+}
+
+// writePage writes the Hugo page like writePageFile, unless the previous run
+// wrote the same page, apart from the executionTime it was generated at, and
+// the file is unchanged since. An edited page is kept with --no-overwrite.
+func (rs *renderState) writePage(pagePath string, page string, frontmatterFormat string, executionTime string, tootIDs []string, log *slog.Logger) error {
+	stateKey, _ := filepath.Rel(rs.root, pagePath)
+	if rs.keepEdited(stateKey, log) {
+		return nil
+	}
+	convertedPage, convertErr := convertFrontmatter(page, frontmatterFormat)
+	if convertErr != nil {
+		return fmt.Errorf("Failed to convert %s frontmatter: %s", pagePath, convertErr)
+	}
+	pageHash := sha256.Sum256([]byte(strings.ReplaceAll(convertedPage, executionTime, "")))
+	entry := &RenderStateEntry{
+		TootIDs: tootIDs,
+		Hash:    hex.EncodeToString(pageHash[:]),
+	}
+	previousEntry, previousEntryExists := rs.previous[stateKey]
+	if previousEntryExists && previousEntry.Hash == entry.Hash {
+		fileHash, _, hashErr := hashMediaFile(pagePath)
+		if hashErr == nil && fileHash == previousEntry.FileHash {
+			entry.FileHash = fileHash
+			rs.current[stateKey] = entry
+			rs.skippedCount += 1
+			return nil
+		}
+	}
+	writeErr := writeFileAtomic(pagePath, []byte(convertedPage), 0600)
+	if writeErr != nil {
+		return writeErr
+	}
+	fileHash := sha256.Sum256([]byte(convertedPage))
+	entry.FileHash = hex.EncodeToString(fileHash[:])
+	rs.current[stateKey] = entry
+	return nil
+}
+
+// write saves the pages written by this run as the next run's state, and
+// deletes the pages the previous run wrote that this run didn't
+func (rs *renderState) write(log *slog.Logger) error {
+	for eachPath := range rs.previous {
+		if _, currentExists := rs.current[eachPath]; !currentExists && !rs.keepEdited(eachPath, log) {
+			os.Remove(path.Join(rs.root, eachPath))
+		}
+	}
+	return writeJSONFile(path.Join(rs.root, RENDER_STATE_FILENAME), rs.current)
+}
+
+// OutputManifest lists the files a run generated in the output directories
+type OutputManifest struct {
+	Files []*OutputManifestFile `json:"files"`
+}
+
+// OutputManifestFile is a generated file, relative to the output root, with
+// its content hash and the toots of the page it was generated for
+type OutputManifestFile struct {
+	Path    string   `json:"path"`
+	Hash    string   `json:"hash"`
+	Size    int64    `json:"size"`
+	TootIDs []string `json:"tootIds,omitempty"`
+}
+
+// outputManifest tracks the files generated in the output directories by
+// the previous and current runs
+type outputManifest struct {
+	root        string
+	directories []string
+	// Files the previous run generated, by relative path
+	previous map[string]*OutputManifestFile
+	// Files in the output directories the previous run didn't generate, with
+	// their modification time before this run
+	foreign map[string]time.Time
+	// Temporary files left by an interrupted run
+	tempFiles []string
+	// Page directory to the toots of the page, which the files in the
+	// directory were generated for
+	sources map[string][]string
+}
+
+// newOutputManifest reads the previous run's manifest from the output root
+// and notes the other files in the outputDirectories
+func newOutputManifest(root string, outputDirectories []string) (*outputManifest, error) {
+	manifest := &outputManifest{
+		root:        root,
+		directories: outputDirectories,
+		previous:    map[string]*OutputManifestFile{},
+		foreign:     map[string]time.Time{},
+		sources:     map[string][]string{},
+	}
+	manifestData, manifestDataErr := os.ReadFile(path.Join(root, OUTPUT_MANIFEST_FILENAME))
+	if manifestDataErr != nil && !os.IsNotExist(manifestDataErr) {
+		return nil, manifestDataErr
+	} else if manifestDataErr == nil {
+		previousManifest := OutputManifest{}
+		unmarshalErr := json.Unmarshal(manifestData, &previousManifest)
+		if unmarshalErr != nil {
+			return nil, fmt.Errorf("Failed to parse %s: %s", OUTPUT_MANIFEST_FILENAME, unmarshalErr)
+		}
+		for _, eachFile := range previousManifest.Files {
+			manifest.previous[eachFile.Path] = eachFile
+		}
+	}
+	walkErr := walkOutputFiles(outputDirectories, func(filePath string, fileInfo os.FileInfo) error {
+		relativePath, _ := filepath.Rel(root, filePath)
+		if strings.HasSuffix(filePath, ATOMIC_WRITE_SUFFIX) {
+			manifest.tempFiles = append(manifest.tempFiles, filePath)
+		} else if _, isPrevious := manifest.previous[relativePath]; !isPrevious && relativePath != OUTPUT_MANIFEST_FILENAME && relativePath != OUTPUT_LOCK_FILENAME {
+			manifest.foreign[filePath] = fileInfo.ModTime()
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	return manifest, nil
+}
+
+// walkOutputFiles calls fileFunc for every file in the directories that
+// exist, stopping at the first error it returns
+func walkOutputFiles(directories []string, fileFunc func(filePath string, fileInfo os.FileInfo) error) error {
+	for _, eachDirectory := range directories {
+		walkErr := filepath.WalkDir(eachDirectory, func(walkPath string, dirEntry os.DirEntry, err error) error {
+			if err != nil || dirEntry.IsDir() {
+				return err
+			}
+			fileInfo, fileInfoErr := dirEntry.Info()
+			if fileInfoErr != nil {
+				return fileInfoErr
+			}
+			return fileFunc(walkPath, fileInfo)
+		})
+		if walkErr != nil && !os.IsNotExist(walkErr) {
+			return walkErr
+		}
+	}
+	return nil
+}
+
+// foreignFiles returns the sorted paths of the files in the output
+// directories that the previous run didn't generate
+func (om *outputManifest) foreignFiles() []string {
+	return slices.Sorted(maps.Keys(om.foreign))
+}
+
+// removeTempFiles deletes the temporary files an interrupted run left
+func (om *outputManifest) removeTempFiles(log *slog.Logger) {
+	for _, eachPath := range om.tempFiles {
+		if os.Remove(eachPath) == nil {
+			log.Debug("Deleted temporary file", "path", eachPath)
+		}
+	}
+}
+
+// clean deletes the files the previous run generated, except the keepPaths
+// files, then any directories left empty within the output directories. It
+// returns the number of files deleted.
+func (om *outputManifest) clean(keepPaths map[string]bool, log *slog.Logger) (uint, error) {
+	deletedCount := uint(0)
+	for _, eachPath := range slices.Sorted(maps.Keys(om.previous)) {
+		filePath := path.Join(om.root, eachPath)
+		if keepPaths[filePath] {
+			continue
+		}
+		removed, removeErr := om.remove(filePath)
+		if removeErr != nil {
+			return deletedCount, removeErr
+		} else if removed {
+			deletedCount += 1
+		}
+	}
+	log.Info("Deleted generated files", "path", om.root, "deletedCount", deletedCount, "keptFileCount", len(keepPaths))
+	return deletedCount, nil
+}
+
+// remove deletes the generated file, then any directories it leaves empty
+// within the output directories. It returns false if the file didn't exist.
+func (om *outputManifest) remove(filePath string) (bool, error) {
+	removeErr := os.Remove(filePath)
+	if os.IsNotExist(removeErr) {
+		return false, nil
+	} else if removeErr != nil {
+		return false, removeErr
+	}
+	for eachDirectory := path.Dir(filePath); !slices.Contains(om.directories, eachDirectory); eachDirectory = path.Dir(eachDirectory) {
+		if os.Remove(eachDirectory) != nil {
+			break
+		}
+	}
+	return true, nil
+}
+
+// addSources records the toots of the page written to the pageDirectory,
+// which the files in the directory are generated for
+func (om *outputManifest) addSources(pageDirectory string, tootIDs []string) {
+	if om == nil {
+		return
+	}
+	om.sources[pageDirectory] = append(om.sources[pageDirectory], tootIDs...)
+}
+
+// sourceTootIDs returns the toots of the page this run wrote to the file's
+// directory, or the nearest directory above it within the output directories
+func (om *outputManifest) sourceTootIDs(filePath string) []string {
+	for eachDirectory := filepath.Dir(filePath); !slices.Contains(om.directories, eachDirectory) && eachDirectory != filepath.Dir(eachDirectory); eachDirectory = filepath.Dir(eachDirectory) {
+		if tootIDs, exists := om.sources[eachDirectory]; exists {
+			return tootIDs
+		}
+	}
+	return nil
+}
+
+// orphanedFiles returns the sorted absolute paths of the existing files the
+// previous run generated for pages whose toots are all gone from the
+// archive. Files edited since they were generated aren't orphans.
+func (om *outputManifest) orphanedFiles(archiveTootIDs map[string]bool) []string {
+	orphanedFiles := []string{}
+	for _, eachPath := range slices.Sorted(maps.Keys(om.previous)) {
+		previousFile := om.previous[eachPath]
+		if len(previousFile.TootIDs) == 0 || slices.ContainsFunc(previousFile.TootIDs, func(tootID string) bool { return archiveTootIDs[tootID] }) {
+			continue
+		}
+		filePath := path.Join(om.root, eachPath)
+		fileHash, _, hashErr := hashMediaFile(filePath)
+		if hashErr == nil && (len(previousFile.Hash) == 0 || fileHash == previousFile.Hash) {
+			orphanedFiles = append(orphanedFiles, filePath)
+		}
+	}
+	return orphanedFiles
+}
+
+// write lists the files in the outputDirectories as the next run's manifest.
+// Files that weren't generated are left out unless this run changed them.
+func (om *outputManifest) write(outputDirectories []string) error {
+	manifest := OutputManifest{
+		Files: []*OutputManifestFile{},
+	}
+	walkErr := walkOutputFiles(outputDirectories, func(filePath string, fileInfo os.FileInfo) error {
+		relativePath, _ := filepath.Rel(om.root, filePath)
+		if relativePath == OUTPUT_MANIFEST_FILENAME || relativePath == OUTPUT_LOCK_FILENAME {
+			return nil
+		}
+		if modTime, isForeign := om.foreign[filePath]; isForeign && fileInfo.ModTime().Equal(modTime) {
+			return nil
+		}
+		tootIDs := om.sourceTootIDs(filePath)
+		if previousFile, isPrevious := om.previous[relativePath]; isPrevious && len(tootIDs) == 0 && len(previousFile.TootIDs) != 0 {
+			// Not written again, e.g. an orphan, so it keeps the hash it was
+			// generated with
+			manifest.Files = append(manifest.Files, previousFile)
+			return nil
+		}
+		// A --media-mode symlink to a deleted archive file has no hash
+		fileHash, fileSize, hashErr := hashMediaFile(filePath)
+		if hashErr != nil && !os.IsNotExist(hashErr) {
+			return hashErr
+		}
+		manifest.Files = append(manifest.Files, &OutputManifestFile{
+			Path:    relativePath,
+			Hash:    fileHash,
+			Size:    fileSize,
+			TootIDs: tootIDs,
+		})
+		return nil
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+	return writeJSONFile(path.Join(om.root, OUTPUT_MANIFEST_FILENAME), manifest)
+}
+
+// saveBackup backs up the output directories to the --backup directory. A
+// --multilingual backup is named relative to the content directory.
+func (cla *commandLineArgs) saveBackup(log *slog.Logger) error {
+	baseDirectory := cla.outputRootPathHugoAssets
+	if cla.multilingual {
+		baseDirectory = filepath.Dir(filepath.Dir(baseDirectory))
+	}
+	_, backupErr := backupOutput(cla.backupDirectory, baseDirectory, cla.outputDirectories(), cla.backupCount, log)
+	return backupErr
+}
+
+// backupOutput saves the files in the output directories to a timestamped
+// tarball in the backupDirectory, named relative to the baseDirectory, then
+// deletes all but the newest retainCount tarballs. It returns the tarball
+// path.
+func backupOutput(backupDirectory string, baseDirectory string, outputDirectories []string, retainCount int, log *slog.Logger) (string, error) {
+	mkdirErr := os.MkdirAll(backupDirectory, os.ModePerm)
+	if mkdirErr != nil {
+		return "", mkdirErr
+	}
+	backupPath := path.Join(backupDirectory, BACKUP_FILENAME_PREFIX+time.Now().UTC().Format("20060102T150405Z")+".tar.gz")
+	backupFile, backupFileErr := createAtomicFile(backupPath, 0600)
+	if backupFileErr != nil {
+		return "", backupFileErr
+	}
+	defer backupFile.discard()
+	gzipWriter := gzip.NewWriter(backupFile)
+	tarWriter := tar.NewWriter(gzipWriter)
+	fileCount := 0
+	walkErr := walkOutputFiles(outputDirectories, func(filePath string, fileInfo os.FileInfo) error {
+		if path.Base(filePath) == OUTPUT_LOCK_FILENAME || strings.HasSuffix(filePath, ATOMIC_WRITE_SUFFIX) {
+			return nil
+		}
+		linkTarget := ""
+		if fileInfo.Mode()&os.ModeSymlink != 0 {
+			linkTarget, _ = os.Readlink(filePath)
+		}
+		header, headerErr := tar.FileInfoHeader(fileInfo, linkTarget)
+		if headerErr != nil {
+			return headerErr
+		}
+		relativePath, _ := filepath.Rel(baseDirectory, filePath)
+		header.Name = filepath.ToSlash(relativePath)
+		if writeErr := tarWriter.WriteHeader(header); writeErr != nil {
+			return writeErr
+		}
+		fileCount += 1
+		if !fileInfo.Mode().IsRegular() {
+			return nil
+		}
+		sourceFile, sourceFileErr := os.Open(filePath)
+		if sourceFileErr != nil {
+			return sourceFileErr
+		}
+		defer sourceFile.Close()
+		_, copyErr := io.Copy(tarWriter, sourceFile)
+		return copyErr
+	})
+	if walkErr != nil {
+		return "", walkErr
+	}
+	if closeErr := errors.Join(tarWriter.Close(), gzipWriter.Close()); closeErr != nil {
+		return "", closeErr
+	}
+	if commitErr := backupFile.commit(); commitErr != nil {
+		return "", commitErr
+	}
+	// The time in the names sorts them oldest first
+	backupPaths, _ := filepath.Glob(path.Join(backupDirectory, BACKUP_FILENAME_PREFIX+"*.tar.gz"))
+	slices.Sort(backupPaths)
+	deletedCount := 0
+	for len(backupPaths)-deletedCount > retainCount {
+		if os.Remove(backupPaths[deletedCount]) != nil {
+			break
+		}
+		deletedCount += 1
+	}
+	log.Info("Backed up output", "path", backupPath, "fileCount", fileCount, "deletedBackupCount", deletedCount)
+	return backupPath, nil
+}
+
+// outputLock is the lockfile a run holds on the output directory
+type outputLock struct {
+	path string
+	file *os.File
+}
+
+// lockOutput creates the lockfile in the output root and holds an exclusive
+// flock on it, writing the process ID. The flock is released with the
+// process, so the lockfile of a run that was killed is taken over.
+func lockOutput(root string, log *slog.Logger) (*outputLock, error) {
+	lock := &outputLock{
+		path: path.Join(root, OUTPUT_LOCK_FILENAME),
+	}
+	for attempt := 0; attempt < 3; attempt++ {
+		lockFile, lockFileErr := os.OpenFile(lock.path, os.O_RDWR|os.O_CREATE, 0644)
+		if lockFileErr != nil {
+			return nil, lockFileErr
+		}
+		flockErr := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if errors.Is(flockErr, syscall.EWOULDBLOCK) {
+			lockData, _ := io.ReadAll(lockFile)
+			lockFile.Close()
+			lockPID, _ := strconv.Atoi(strings.TrimSpace(string(lockData)))
+			return nil, fmt.Errorf("Another run (process %d) is using %s", lockPID, root)
+		} else if flockErr != nil {
+			lockFile.Close()
+			return nil, fmt.Errorf("Failed to lock %s: %s", lock.path, flockErr)
+		}
+		// A run that released the lockfile deleted it after it was opened,
+		// so the next run would create and lock a new one
+		lockFileInfo, lockFileInfoErr := lockFile.Stat()
+		pathInfo, pathInfoErr := os.Stat(lock.path)
+		if lockFileInfoErr != nil || pathInfoErr != nil || !os.SameFile(lockFileInfo, pathInfo) {
+			lockFile.Close()
+			continue
+		}
+		lockData, _ := io.ReadAll(lockFile)
+		if lockPID := strings.TrimSpace(string(lockData)); len(lockPID) != 0 {
+			log.Warn("Taking over the lockfile of a run that's no longer running", "path", lock.path, "pid", lockPID)
+		}
+		lock.file = lockFile
+		truncateErr := lockFile.Truncate(0)
+		_, writeErr := lockFile.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+		if lockErr := errors.Join(truncateErr, writeErr); lockErr != nil {
+			lock.release(log)
+			return nil, lockErr
+		}
+		return lock, nil
+	}
+	return nil, fmt.Errorf("Failed to lock %s: other runs kept replacing the lockfile", root)
+}
+
+// release deletes the lockfile and releases its flock. A nil lock, e.g. of
+// a --dry-run, has nothing to release.
+func (ol *outputLock) release(log *slog.Logger) {
+	if ol == nil {
+		return
+	}
+	// The lockfile is deleted while it's locked, and lockOutput retries a
+	// lock taken on a deleted lockfile
+	removeErr := os.Remove(ol.path)
+	if removeErr != nil {
+		log.Warn("Failed to delete the lockfile", "path", ol.path, "error", removeErr)
+	}
+	ol.file.Close()
+}
+
+// dryRun stands a staging directory in for the output directory and files
+// of a --dry-run, and reports how the staged outputs differ from them
+type dryRun struct {
+	stagingRoot string
+	// The directory containing the --lang directory of the --multilingual
+	// trees, which the staging tree mirrors
+	treeRoot     string
+	outputRoot   string
+	multilingual bool
+	diff         bool
+	// The previous run's manifest of the real output
+	manifest *outputManifest
+	// Staged output file to the real output file
+	files map[string]string
+	// The generated files --clean deletes, and the number of other files
+	// that stop it without --force
+	cleanedFiles []string
+	foreignCount int
+}
+
+// dryRunOutput is an output directory or file and the staged copy
+type dryRunOutput struct {
+	stagedPath string
+	realPath   string
+}
+
+// newDryRun creates the staging directory and points the output directory
+// and the optional output files of the run into it
+func newDryRun(cla *commandLineArgs) (*dryRun, error) {
+	stagingRoot, stagingErr := os.MkdirTemp("", "mastodon-to-hugo-")
+	if stagingErr != nil {
+		return nil, stagingErr
+	}
+	run := &dryRun{
+		stagingRoot:  stagingRoot,
+		treeRoot:     filepath.Dir(filepath.Dir(cla.outputRootPathHugoAssets)),
+		multilingual: cla.multilingual,
+		diff:         cla.dryRunDiff,
+		files:        map[string]string{},
+	}
+	manifest, manifestErr := newOutputManifest(cla.outputRootPathHugoAssets, cla.outputDirectories())
+	if manifestErr != nil {
+		return nil, manifestErr
+	}
+	run.manifest = manifest
+	if cla.clean {
+		for _, eachPath := range slices.Sorted(maps.Keys(manifest.previous)) {
+			run.cleanedFiles = append(run.cleanedFiles, filepath.Join(cla.outputRootPathHugoAssets, eachPath))
+		}
+		if !cla.force {
+			run.foreignCount = len(manifest.foreign)
+		}
+	}
+	relativeOutput, _ := filepath.Rel(run.treeRoot, cla.outputRootPathHugoAssets)
+	run.outputRoot = filepath.Join(stagingRoot, "tree", relativeOutput)
+	cla.outputRootPathHugoAssets = run.outputRoot
+	for eachIndex, eachOutputPath := range []*string{&cla.jsonFeedPath, &cla.atomFeedPath, &cla.sqlitePath, &cla.csvPath, &cla.searchIndexPath, &cla.shortcodesDirectory, &cla.redirectsPath, &cla.hugoConfigPath, &cla.reportPath, &cla.altTextReportPath} {
+		if len(*eachOutputPath) == 0 {
+			continue
+		}
+		stagedDirectory := filepath.Join(stagingRoot, "files", strconv.Itoa(eachIndex))
+		mkdirErr := os.MkdirAll(stagedDirectory, os.ModePerm)
+		if mkdirErr != nil {
+			return nil, mkdirErr
+		}
+		stagedPath := filepath.Join(stagedDirectory, filepath.Base(*eachOutputPath))
+		run.files[stagedPath] = *eachOutputPath
+		*eachOutputPath = stagedPath
+	}
+	return run, nil
+}
+
+// outputs returns the staged output directories, one per language tree with
+// --multilingual, and output files with the real ones they stand in for
+func (run *dryRun) outputs() []*dryRunOutput {
+	stagedRoots := []string{run.outputRoot}
+	if run.multilingual {
+		stagedRoots, _ = filepath.Glob(filepath.Join(filepath.Dir(filepath.Dir(run.outputRoot)), "*", filepath.Base(run.outputRoot)))
+	}
+	outputs := []*dryRunOutput{}
+	for _, eachRoot := range stagedRoots {
+		relativeRoot, _ := filepath.Rel(filepath.Join(run.stagingRoot, "tree"), eachRoot)
+		outputs = append(outputs, &dryRunOutput{
+			stagedPath: eachRoot,
+			realPath:   filepath.Join(run.treeRoot, relativeRoot),
+		})
+	}
+	for _, eachStagedPath := range slices.Sorted(maps.Keys(run.files)) {
+		outputs = append(outputs, &dryRunOutput{
+			stagedPath: eachStagedPath,
+			realPath:   run.files[eachStagedPath],
+		})
+	}
+	return outputs
+}
+
+// report logs every file the run would create, update or delete, with the
+// diffs for --dry-run-diff, then deletes the staging directory. Files that
+// only differ in the generation time are unchanged.
+func (run *dryRun) report(log *slog.Logger) {
+	if run.foreignCount != 0 {
+		log.Warn("The run would stop, as the output has files the previous run didn't generate. Use --force to clean it anyway",
+			"fileCount", run.foreignCount)
+	}
+	changeCounts := map[string]int{}
+	for _, eachOutput := range run.outputs() {
+		walkErr := filepath.WalkDir(eachOutput.stagedPath, func(walkPath string, dirEntry os.DirEntry, err error) error {
+			if err != nil || dirEntry.IsDir() {
+				return err
+			}
+			relativePath, _ := filepath.Rel(eachOutput.stagedPath, walkPath)
+			realPath := filepath.Join(eachOutput.realPath, relativePath)
+			stagedData, stagedDataErr := os.ReadFile(walkPath)
+			if stagedDataErr != nil {
+				return stagedDataErr
+			}
+			realData, realDataErr := os.ReadFile(realPath)
+			change := "update"
+			if os.IsNotExist(realDataErr) {
+				change = "create"
+			} else if realDataErr != nil {
+				return realDataErr
+			} else if bytes.Equal(GENERATED_COMMENT_PATTERN.ReplaceAll(stagedData, nil), GENERATED_COMMENT_PATTERN.ReplaceAll(realData, nil)) {
+				change = "unchanged"
+			}
+			changeCounts[change] += 1
+			if change == "unchanged" {
+				return nil
+			}
+			log.Info("Dry run change", "change", change, "path", realPath)
+			if run.diff {
+				fmt.Print(unifiedDiff(realPath, string(realData), string(stagedData)))
+			}
+			return nil
+		})
+		if walkErr != nil && !os.IsNotExist(walkErr) {
+			log.Error("Failed to compare dry run output", "path", eachOutput.realPath, "error", walkErr)
+		}
+	}
+	// --clean and --remove-orphans delete the generated files the run
+	// doesn't write again
+	for _, eachPath := range run.cleanedFiles {
+		relativePath, _ := filepath.Rel(run.treeRoot, eachPath)
+		_, stagedErr := os.Stat(filepath.Join(run.stagingRoot, "tree", relativePath))
+		if _, realErr := os.Stat(eachPath); realErr == nil && os.IsNotExist(stagedErr) {
+			changeCounts["delete"] += 1
+			log.Info("Dry run change", "change", "delete", "path", eachPath)
+		}
+	}
+	log.Info("Dry run complete",
+		"createdCount", changeCounts["create"],
+		"updatedCount", changeCounts["update"],
+		"deletedCount", changeCounts["delete"],
+		"unchangedCount", changeCounts["unchanged"])
+	removeErr := os.RemoveAll(run.stagingRoot)
+	if removeErr != nil {
+		log.Warn("Failed to delete dry run directory", "path", run.stagingRoot, "error", removeErr)
+	}
+}
+
+// unifiedDiff returns the unified diff of the text files, with the fromText
+// of the file at filePath, or empty if it doesn't exist, changed to toText
+func unifiedDiff(filePath string, fromText string, toText string) string {
+	if !utf8.ValidString(fromText) || !utf8.ValidString(toText) || strings.ContainsRune(fromText+toText, 0) {
+		return fmt.Sprintf("Binary files %s differ\n", filePath)
+	}
+	fromLines := strings.SplitAfter(fromText, "\n")
+	if len(fromLines[len(fromLines)-1]) == 0 {
+		fromLines = fromLines[:len(fromLines)-1]
+	}
+	toLines := strings.SplitAfter(toText, "\n")
+	if len(toLines[len(toLines)-1]) == 0 {
+		toLines = toLines[:len(toLines)-1]
+	}
+	if (len(fromLines)+1)*(len(toLines)+1) > DRY_RUN_DIFF_MAX_CELLS {
+		return fmt.Sprintf("Files %s differ\n", filePath)
+	}
+	// Longest common subsequence lengths of the remaining lines
+	commonLengths := make([][]int, len(fromLines)+1)
+	for eachIndex := range commonLengths {
+		commonLengths[eachIndex] = make([]int, len(toLines)+1)
+	}
+	for fromIndex := len(fromLines) - 1; fromIndex >= 0; fromIndex-- {
+		for toIndex := len(toLines) - 1; toIndex >= 0; toIndex-- {
+			if fromLines[fromIndex] == toLines[toIndex] {
+				commonLengths[fromIndex][toIndex] = commonLengths[fromIndex+1][toIndex+1] + 1
+			} else {
+				commonLengths[fromIndex][toIndex] = max(commonLengths[fromIndex+1][toIndex], commonLengths[fromIndex][toIndex+1])
+			}
+		}
+	}
+	type diffLine struct {
+		prefix    string
+		text      string
+		fromIndex int
+		toIndex   int
+	}
+	diffLines := []*diffLine{}
+	fromIndex, toIndex := 0, 0
+	for fromIndex < len(fromLines) || toIndex < len(toLines) {
+		line := &diffLine{fromIndex: fromIndex, toIndex: toIndex}
+		switch {
+		case fromIndex < len(fromLines) && toIndex < len(toLines) && fromLines[fromIndex] == toLines[toIndex]:
+			line.prefix, line.text = " ", fromLines[fromIndex]
+			fromIndex, toIndex = fromIndex+1, toIndex+1
+		case fromIndex < len(fromLines) && (toIndex == len(toLines) || commonLengths[fromIndex+1][toIndex] >= commonLengths[fromIndex][toIndex+1]):
+			line.prefix, line.text = "-", fromLines[fromIndex]
+			fromIndex += 1
+		default:
+			line.prefix, line.text = "+", toLines[toIndex]
+			toIndex += 1
+		}
+		diffLines = append(diffLines, line)
+	}
+	var diffBuilder strings.Builder
+	fmt.Fprintf(&diffBuilder, "--- %s\n+++ %s\n", filePath, filePath)
+	for hunkStart := 0; hunkStart < len(diffLines); {
+		if diffLines[hunkStart].prefix == " " {
+			hunkStart += 1
+			continue
+		}
+		// The hunk runs until the context around two changes doesn't overlap
+		hunkEnd := hunkStart + 1
+		for lineIndex := hunkStart + 1; lineIndex < len(diffLines) && lineIndex <= hunkEnd+2*DRY_RUN_DIFF_CONTEXT; lineIndex++ {
+			if diffLines[lineIndex].prefix != " " {
+				hunkEnd = lineIndex + 1
+			}
+		}
+		contextStart := max(0, hunkStart-DRY_RUN_DIFF_CONTEXT)
+		contextEnd := min(len(diffLines), hunkEnd+DRY_RUN_DIFF_CONTEXT)
+		fromCount, toCount := 0, 0
+		for _, eachLine := range diffLines[contextStart:contextEnd] {
+			if eachLine.prefix != "+" {
+				fromCount += 1
+			}
+			if eachLine.prefix != "-" {
+				toCount += 1
+			}
+		}
+		fromStart, toStart := diffLines[contextStart].fromIndex, diffLines[contextStart].toIndex
+		if fromCount != 0 {
+			fromStart += 1
+		}
+		if toCount != 0 {
+			toStart += 1
+		}
+		fmt.Fprintf(&diffBuilder, "@@ -%d,%d +%d,%d @@\n", fromStart, fromCount, toStart, toCount)
+		for _, eachLine := range diffLines[contextStart:contextEnd] {
+			diffBuilder.WriteString(eachLine.prefix + eachLine.text)
+			if !strings.HasSuffix(eachLine.text, "\n") {
+				diffBuilder.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		hunkStart = contextEnd
+	}
+	return diffBuilder.String()
+}
+
+// imageResizer returns a media processor that downsizes JPEG and PNG images
+// wider than maxWidth, preserving the aspect ratio. A JPEG is turned upright
+// first, so the width is the displayed one. With keepOriginals the full
+// size file is first copied to originals/ next to it.
+func imageResizer(maxWidth int, keepOriginals bool) mediaProcessorFunc {
+	return func(attachment *ActivityObjectAttachment, mediaFilePath string, log *slog.Logger) (string, error) {
+		if attachment.MediaType != "image/jpeg" && attachment.MediaType != "image/png" {
+			return mediaFilePath, nil
+		}
+		sourceImage, imageFormat, decodeErr := decodeImageFile(mediaFilePath)
+		if decodeErr != nil {
+			return mediaFilePath, decodeErr
+		}
+		sourceBounds := sourceImage.Bounds()
+		if sourceBounds.Dx() <= maxWidth {
+			return mediaFilePath, nil
+		}
+		if keepOriginals {
+			originalsDirectory := path.Join(path.Dir(mediaFilePath), "originals")
+			errDirectory := ensureDirectory(originalsDirectory, false, log)
+			if errDirectory != nil {
+				return mediaFilePath, errDirectory
+			}
+			_, copyErr := copyMediaFile(mediaFilePath, path.Join(originalsDirectory, path.Base(mediaFilePath)))
+			if copyErr != nil {
+				return mediaFilePath, copyErr
+			}
+		}
+		resizedHeight := max(1, sourceBounds.Dy()*maxWidth/sourceBounds.Dx())
+		resizedImage := resizeImage(sourceImage, maxWidth, resizedHeight)
+		encodeErr := encodeImageFile(mediaFilePath, resizedImage, imageFormat, 85)
+		if encodeErr != nil {
+			return mediaFilePath, encodeErr
+		}
+		log.Debug("Resized image",
+			"path", mediaFilePath,
+			"fromWidth", sourceBounds.Dx(),
+			"toWidth", maxWidth)
+		attachment.Width = uint(maxWidth)
+		attachment.Height = uint(resizedHeight)
+		return mediaFilePath, nil
+	}
+}
+
+// srcsetGenerator returns a media processor that writes a <name>-<width>w
+// copy of JPEG and PNG images for each width smaller than the image, and
+// lists them with the image itself in the attachment Srcset
+func srcsetGenerator(widths []int) mediaProcessorFunc {
+	return func(attachment *ActivityObjectAttachment, mediaFilePath string, log *slog.Logger) (string, error) {
+		if attachment.MediaType != "image/jpeg" && attachment.MediaType != "image/png" {
+			return mediaFilePath, nil
+		}
+		sourceImage, imageFormat, decodeErr := decodeImageFile(mediaFilePath)
+		if decodeErr != nil {
+			return mediaFilePath, decodeErr
+		}
+		sourceBounds := sourceImage.Bounds()
+		srcset := []*SrcsetImage{}
+		for _, eachWidth := range widths {
+			if eachWidth >= sourceBounds.Dx() {
+				break
+			}
+			variantHeight := max(1, sourceBounds.Dy()*eachWidth/sourceBounds.Dx())
+			variantPath := fmt.Sprintf("%s-%dw%s",
+				strings.TrimSuffix(mediaFilePath, path.Ext(mediaFilePath)),
+				eachWidth,
+				path.Ext(mediaFilePath))
+			encodeErr := encodeImageFile(variantPath, resizeImage(sourceImage, eachWidth, variantHeight), imageFormat, 85)
+			if encodeErr != nil {
+				return mediaFilePath, encodeErr
+			}
+			srcset = append(srcset, &SrcsetImage{
+				Filename: path.Base(variantPath),
+				Width:    eachWidth,
+			})
+		}
+		if len(srcset) == 0 {
+			return mediaFilePath, nil
+		}
+		log.Debug("Wrote srcset images", "path", mediaFilePath, "count", len(srcset))
+		attachment.Srcset = append(srcset, &SrcsetImage{
+			Filename: attachment.BaseFilename,
+			Width:    sourceBounds.Dx(),
+		})
+		return mediaFilePath, nil
+	}
+}
+
+// metadataStripper is a media processor that removes EXIF, XMP, IPTC and
+// comment metadata from JPEG images, and the text and EXIF chunks from PNG
+// images, without re-encoding them. A JPEG's orientation is kept so photos
+// still display upright.
+func metadataStripper(attachment *ActivityObjectAttachment, mediaFilePath string, log *slog.Logger) (string, error) {
+	var stripFunc func([]byte) ([]byte, error)
+	switch attachment.MediaType {
+	case "image/jpeg":
+		stripFunc = stripJPEGMetadata
+	case "image/png":
+		stripFunc = stripPNGMetadata
+	default:
+		return mediaFilePath, nil
+	}
+	imageData, imageDataErr := os.ReadFile(mediaFilePath)
+	if imageDataErr != nil {
+		return mediaFilePath, imageDataErr
+	}
+	strippedData, stripErr := stripFunc(imageData)
+	if stripErr != nil {
+		return mediaFilePath, stripErr
+	}
+	if len(strippedData) == len(imageData) {
+		return mediaFilePath, nil
+	}
+	log.Debug("Stripped image metadata", "path", mediaFilePath, "bytes", len(imageData)-len(strippedData))
+	return mediaFilePath, writeFileAtomic(mediaFilePath, strippedData, 0644)
+}
+
+// stripJPEGMetadata removes the APP1 (EXIF, XMP), APP13 (IPTC) and COM
+// segments that precede the image data, replacing the EXIF with a minimal
+// one holding just the orientation, if the original had one
+func stripJPEGMetadata(imageData []byte) ([]byte, error) {
+	if len(imageData) < 4 || imageData[0] != 0xFF || imageData[1] != 0xD8 {
+		return nil, fmt.Errorf("Not a JPEG file")
+	}
+	stripped := []byte{0xFF, 0xD8}
+	orientation := uint16(0)
+	offset := 2
+	for offset+4 <= len(imageData) {
+		if imageData[offset] != 0xFF {
+			return nil, fmt.Errorf("Invalid JPEG marker at offset %d", offset)
+		}
+		marker := imageData[offset+1]
+		// Start of scan: the entropy coded data and the rest of the file
+		// are copied as is
+		if marker == 0xDA {
+			break
+		}
+		segmentLength := int(imageData[offset+2])<<8 | int(imageData[offset+3])
+		segmentEnd := offset + 2 + segmentLength
+		if segmentLength < 2 || segmentEnd > len(imageData) {
+			return nil, fmt.Errorf("Invalid JPEG segment length at offset %d", offset)
+		}
+		segment := imageData[offset:segmentEnd]
+		switch marker {
+		case 0xE1:
+			if bytes.HasPrefix(segment[4:], []byte("Exif\x00\x00")) {
+				orientation = max(orientation, exifOrientation(segment[10:]))
+			}
+		case 0xED, 0xFE:
+		default:
+			stripped = append(stripped, segment...)
+		}
+		offset = segmentEnd
+	}
+	if orientation > 1 {
+		// Big endian TIFF header, then IFD0 with the single orientation entry
+		minimalExif := []byte{0xFF, 0xE1, 0x00, 0x22,
+			'E', 'x', 'i', 'f', 0x00, 0x00,
+			'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08,
+			0x00, 0x01,
+			0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, byte(orientation >> 8), byte(orientation), 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00}
+		// Keep the APP0 JFIF segment, if any, first
+		insertAt := 2
+		if len(stripped) > 4 && stripped[3] == 0xE0 {
+			insertAt += 2 + (int(stripped[4])<<8 | int(stripped[5]))
+		}
+		stripped = append(stripped[:insertAt], append(minimalExif, stripped[insertAt:]...)...)
+	}
+	return append(stripped, imageData[offset:]...), nil
+}
+
+// exifOrientation returns the IFD0 orientation of the TIFF structured EXIF
+// data, or 0 if there is none
+func exifOrientation(tiffData []byte) uint16 {
+	if len(tiffData) < 8 {
+		return 0
+	}
+	var byteOrder binary.ByteOrder
+	switch string(tiffData[:2]) {
+	case "II":
+		byteOrder = binary.LittleEndian
+	case "MM":
+		byteOrder = binary.BigEndian
+	default:
+		return 0
+	}
+	ifdOffset := int(byteOrder.Uint32(tiffData[4:8]))
+	if ifdOffset+2 > len(tiffData) {
+		return 0
+	}
+	entryCount := int(byteOrder.Uint16(tiffData[ifdOffset:]))
+	for index := 0; index < entryCount; index++ {
+		entryOffset := ifdOffset + 2 + index*12
+		if entryOffset+12 > len(tiffData) {
+			return 0
+		}
+		if byteOrder.Uint16(tiffData[entryOffset:]) == 0x0112 {
+			return byteOrder.Uint16(tiffData[entryOffset+8:])
+		}
+	}
+	return 0
+}
+
+// stripPNGMetadata removes the eXIf, tEXt, zTXt, iTXt and tIME chunks
+func stripPNGMetadata(imageData []byte) ([]byte, error) {
+	pngSignature := []byte("\x89PNG\r\n\x1a\n")
+	if !bytes.HasPrefix(imageData, pngSignature) {
+		return nil, fmt.Errorf("Not a PNG file")
+	}
+	stripped := slices.Clone(pngSignature)
+	for offset := len(pngSignature); offset < len(imageData); {
+		if offset+12 > len(imageData) {
+			return nil, fmt.Errorf("Truncated PNG chunk at offset %d", offset)
+		}
+		chunkEnd := offset + 12 + int(binary.BigEndian.Uint32(imageData[offset:]))
+		if chunkEnd > len(imageData) {
+			return nil, fmt.Errorf("Invalid PNG chunk length at offset %d", offset)
+		}
+		switch string(imageData[offset+4 : offset+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			stripped = append(stripped, imageData[offset:chunkEnd]...)
+		}
+		offset = chunkEnd
+	}
+	return stripped, nil
+}
+
+// imageTranscoder returns a media processor that converts JPEG and PNG images
+// to the format. The original is removed, unless keepFallback is set, in which
+// case the attachment records it as the fallback.
+func imageTranscoder(formatName string, quality int, keepFallback bool) mediaProcessorFunc {
+	transcodeFormat := IMAGE_FORMATS[formatName]
+	return func(attachment *ActivityObjectAttachment, mediaFilePath string, log *slog.Logger) (string, error) {
+		if attachment.MediaType != "image/jpeg" && attachment.MediaType != "image/png" {
+			return mediaFilePath, nil
+		}
+		transcodedPath := strings.TrimSuffix(mediaFilePath, path.Ext(mediaFilePath)) + "." + formatName
+		command := exec.Command(transcodeFormat.command, transcodeFormat.arguments(quality, mediaFilePath, transcodedPath)...)
+		commandOutput, commandErr := command.CombinedOutput()
+		if commandErr != nil {
+			return mediaFilePath, fmt.Errorf("%s failed: %s. Output: %s", transcodeFormat.command, commandErr, commandOutput)
+		}
+		log.Debug("Transcoded image", "path", mediaFilePath, "format", formatName)
+		if keepFallback {
+			attachment.FallbackFilename = attachment.BaseFilename
+			attachment.FallbackMediaType = attachment.MediaType
+		} else {
+			removeErr := os.Remove(mediaFilePath)
+			if removeErr != nil {
+				return transcodedPath, removeErr
+			}
+		}
+		attachment.BaseFilename = path.Base(transcodedPath)
+		attachment.MediaType = transcodeFormat.mediaType
+		return transcodedPath, nil
+	}
+}
+
+// blurhashPlaceholder is a media processor that decodes the attachment's
+// blurhash into a PNG placeholder next to the media file
+func blurhashPlaceholder(attachment *ActivityObjectAttachment, mediaFilePath string, log *slog.Logger) (string, error) {
+	if len(attachment.Blurhash) <= 0 {
+		return mediaFilePath, nil
+	}
+	placeholderWidth := BLURHASH_PLACEHOLDER_WIDTH
+	placeholderHeight := BLURHASH_PLACEHOLDER_WIDTH
+	if attachment.Width != 0 && attachment.Height != 0 {
+		placeholderHeight = max(1, int(attachment.Height)*placeholderWidth/int(attachment.Width))
+	}
+	placeholderImage, decodeErr := decodeBlurhash(attachment.Blurhash, placeholderWidth, placeholderHeight)
+	if decodeErr != nil {
+		log.Warn("Skipping invalid blurhash", "path", mediaFilePath, "blurhash", attachment.Blurhash, "error", decodeErr)
+		return mediaFilePath, nil
+	}
+	placeholderPath := strings.TrimSuffix(mediaFilePath, path.Ext(mediaFilePath)) + "-blurhash.png"
+	encodeErr := encodeImageFile(placeholderPath, placeholderImage, "png", 0)
+	if encodeErr != nil {
+		return mediaFilePath, encodeErr
+	}
+	attachment.PlaceholderFilename = path.Base(placeholderPath)
+	return mediaFilePath, nil
+}
+
+// decodeBlurhash renders the blurhash (https://blurha.sh) at the given size
+func decodeBlurhash(blurhash string, width int, height int) (image.Image, error) {
+	decodeBase83 := func(encoded string) (int, error) {
+		value := 0
+		for _, eachChar := range encoded {
+			digit := strings.IndexRune(BLURHASH_BASE83_CHARACTERS, eachChar)
+			if digit < 0 {
+				return 0, fmt.Errorf("Invalid blurhash character: %c", eachChar)
+			}
+			value = value*83 + digit
+		}
+		return value, nil
+	}
+	if len(blurhash) < 6 {
+		return nil, fmt.Errorf("Blurhash is too short")
+	}
+	sizeFlag, sizeFlagErr := decodeBase83(blurhash[:1])
+	if sizeFlagErr != nil {
+		return nil, sizeFlagErr
+	}
+	componentsX := sizeFlag%9 + 1
+	componentsY := sizeFlag/9 + 1
+	if len(blurhash) != 4+2*componentsX*componentsY {
+		return nil, fmt.Errorf("Blurhash length doesn't match its %dx%d components", componentsX, componentsY)
+	}
+	quantizedMaximum, quantizedMaximumErr := decodeBase83(blurhash[1:2])
+	if quantizedMaximumErr != nil {
+		return nil, quantizedMaximumErr
+	}
+	maximumValue := float64(quantizedMaximum+1) / 166
+	srgbToLinear := func(value int) float64 {
+		normalized := float64(value) / 255
+		if normalized <= 0.04045 {
+			return normalized / 12.92
+		}
+		return math.Pow((normalized+0.055)/1.055, 2.4)
+	}
+	signedSquare := func(value float64) float64 {
+		return math.Copysign(value*value, value)
+	}
+	colors := make([][3]float64, componentsX*componentsY)
+	for index := range colors {
+		if index == 0 {
+			dcValue, dcValueErr := decodeBase83(blurhash[2:6])
+			if dcValueErr != nil {
+				return nil, dcValueErr
+			}
+			colors[index] = [3]float64{srgbToLinear(dcValue >> 16), srgbToLinear((dcValue >> 8) & 255), srgbToLinear(dcValue & 255)}
+			continue
+		}
+		acValue, acValueErr := decodeBase83(blurhash[4+index*2 : 6+index*2])
+		if acValueErr != nil {
+			return nil, acValueErr
+		}
+		colors[index] = [3]float64{
+			signedSquare(float64(acValue/(19*19)-9)/9) * maximumValue,
+			signedSquare(float64((acValue/19)%19-9)/9) * maximumValue,
+			signedSquare(float64(acValue%19-9)/9) * maximumValue,
+		}
+	}
+	linearToSRGB := func(value float64) uint8 {
+		value = math.Max(0, math.Min(1, value))
+		if value <= 0.0031308 {
+			return uint8(value*12.92*255 + 0.5)
+		}
+		return uint8((1.055*math.Pow(value, 1/2.4)-0.055)*255 + 0.5)
+	}
+	decoded := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixel := [3]float64{}
+			for componentY := 0; componentY < componentsY; componentY++ {
+				for componentX := 0; componentX < componentsX; componentX++ {
+					basis := math.Cos(math.Pi*float64(x*componentX)/float64(width)) *
+						math.Cos(math.Pi*float64(y*componentY)/float64(height))
+					componentColor := colors[componentX+componentY*componentsX]
+					for channel := range pixel {
+						pixel[channel] += componentColor[channel] * basis
+					}
+				}
+			}
+			decoded.SetRGBA(x, y, color.RGBA{linearToSRGB(pixel[0]), linearToSRGB(pixel[1]), linearToSRGB(pixel[2]), 255})
+		}
+	}
+	return decoded, nil
+}
+
+// writeOGImage writes the --og-images share card with the text and footer.
+// The --og-image-hook renders the card from HTML when set, and the built-in
+// drawing is the fallback when it fails.
+func writeOGImage(outputPath string, text string, footer string, cla *commandLineArgs, log *slog.Logger) error {
+	if len(cla.ogImageHook) != 0 {
+		hookErr := renderOGImageHTML(outputPath, text, footer, cla)
+		if hookErr == nil {
+			return nil
+		}
+		log.Warn("Share card hook failed, drawing the card instead", "path", outputPath, "error", hookErr)
+	}
+	return encodeImageFile(outputPath, drawOGImage(text, footer, cla.ogImageBackground), "png", 0)
+}
+
+// renderOGImageHTML runs the --og-image-hook with the share card HTML
+func renderOGImageHTML(outputPath string, text string, footer string, cla *commandLineArgs) error {
+	cardTemplate, cardTemplateErr := htmltemplate.New("ogImageCard").Parse(TEMPLATE_OG_IMAGE_CARD)
+	if cardTemplateErr != nil {
+		return cardTemplateErr
+	}
+	cardFile, cardFileErr := os.CreateTemp("", "og-image-*.html")
+	if cardFileErr != nil {
+		return cardFileErr
+	}
+	defer os.Remove(cardFile.Name())
+	executeErr := cardTemplate.Execute(cardFile, map[string]interface{}{
+		"Text":       text,
+		"Footer":     footer,
+		"Background": htmltemplate.CSS(cla.ogImageBackground),
+		"Width":      OG_IMAGE_WIDTH,
+		"Height":     OG_IMAGE_HEIGHT,
+	})
+	cardFile.Close()
+	if executeErr != nil {
+		return executeErr
+	}
+	_, hookErr := runMediaHook(cla.ogImageHook, cardFile.Name(), outputPath)
+	return hookErr
+}
+
+// drawOGImage draws the share card: the text word wrapped on the background
+// color above a darker footer band
+func drawOGImage(text string, footer string, background string) image.Image {
+	backgroundColor := color.RGBA{A: 0xff}
+	fmt.Sscanf(background, "#%02x%02x%02x", &backgroundColor.R, &backgroundColor.G, &backgroundColor.B)
+	footerColor := color.RGBA{
+		R: backgroundColor.R / 10 * 7,
+		G: backgroundColor.G / 10 * 7,
+		B: backgroundColor.B / 10 * 7,
+		A: 0xff,
+	}
+	textColor := color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	footerHeight := 110
+	margin := 80
+	card := image.NewRGBA(image.Rect(0, 0, OG_IMAGE_WIDTH, OG_IMAGE_HEIGHT))
+	draw.Draw(card, card.Bounds(), image.NewUniform(backgroundColor), image.Point{}, draw.Src)
+	draw.Draw(card,
+		image.Rect(0, OG_IMAGE_HEIGHT-footerHeight, OG_IMAGE_WIDTH, OG_IMAGE_HEIGHT),
+		image.NewUniform(footerColor),
+		image.Point{},
+		draw.Src)
+
+	// Glyphs are 6 columns wide, with spacing, and lines 10 rows high
+	textScale := 6
+	lineHeight := 10 * textScale
+	maxLineLength := (OG_IMAGE_WIDTH - 2*margin) / (6 * textScale)
+	maxLines := (OG_IMAGE_HEIGHT - footerHeight - margin) / lineHeight
+	lines := wrapOGImageText(foldOGImageText(text), maxLineLength, maxLines)
+	textTop := (OG_IMAGE_HEIGHT - footerHeight - len(lines)*lineHeight) / 2
+	for index, eachLine := range lines {
+		drawOGImageText(card, eachLine, margin, textTop+index*lineHeight, textScale, textColor)
+	}
+	footerScale := 4
+	drawOGImageText(card,
+		foldOGImageText(footer),
+		margin,
+		OG_IMAGE_HEIGHT-footerHeight+(footerHeight-8*footerScale)/2,
+		footerScale,
+		textColor)
+	return card
+}
+
+// foldOGImageText replaces the characters OG_IMAGE_FONT doesn't have with
+// ASCII stand-ins, leaving out the rest
+func foldOGImageText(text string) string {
+	return strings.Map(func(eachRune rune) rune {
+		if eachRune < ' ' || eachRune > '~' {
+			return -1
+		}
+		return eachRune
+	}, OG_IMAGE_FOLDED_RUNES.Replace(text))
+}
+
+// wrapOGImageText breaks the text into at most maxLines lines of up to
+// maxLineLength characters at word boundaries. Text that doesn't fit ends
+// with an ellipsis.
+func wrapOGImageText(text string, maxLineLength int, maxLines int) []string {
+	lines := []string{}
+	activeLine := ""
+	for _, eachWord := range strings.Fields(text) {
+		for len(eachWord) > maxLineLength {
+			if len(activeLine) != 0 {
+				lines = append(lines, activeLine)
+				activeLine = ""
+			}
+			lines = append(lines, eachWord[:maxLineLength])
+			eachWord = eachWord[maxLineLength:]
+		}
+		if len(activeLine) == 0 {
+			activeLine = eachWord
+		} else if len(activeLine)+1+len(eachWord) <= maxLineLength {
+			activeLine += " " + eachWord
+		} else {
+			lines = append(lines, activeLine)
+			activeLine = eachWord
+		}
+	}
+	if len(activeLine) != 0 {
+		lines = append(lines, activeLine)
+	}
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		lastLine := strings.TrimSuffix(lines[maxLines-1], "...")
+		if len(lastLine) > maxLineLength-3 {
+			lastLine = lastLine[:maxLineLength-3]
+			if spaceIndex := strings.LastIndex(lastLine, " "); spaceIndex > 0 {
+				lastLine = lastLine[:spaceIndex]
+			}
+		}
+		lines[maxLines-1] = lastLine + "..."
+	}
+	return lines
+}
+
+// drawOGImageText draws the ASCII text with its top left corner at x, y,
+// scaling each OG_IMAGE_FONT pixel to a scale by scale square
+func drawOGImageText(card *image.RGBA, text string, x int, y int, scale int, textColor color.Color) {
+	for index, eachRune := range text {
+		glyph := OG_IMAGE_FONT[eachRune-' ']
+		glyphLeft := x + index*6*scale
+		for column, eachColumn := range glyph {
+			for row := 0; row < 8; row++ {
+				if eachColumn&(1<<row) == 0 {
+					continue
+				}
+				pixelLeft := glyphLeft + column*scale
+				pixelTop := y + row*scale
+				draw.Draw(card,
+					image.Rect(pixelLeft, pixelTop, pixelLeft+scale, pixelTop+scale),
+					image.NewUniform(textColor),
+					image.Point{},
+					draw.Src)
+			}
+		}
+	}
+}
+
+// decodeImageFile decodes a JPEG or PNG file, returning the format name. A
+// JPEG is turned upright by its EXIF orientation, which re-encoding drops.
+func decodeImageFile(imagePath string) (image.Image, string, error) {
+	imageData, imageDataErr := os.ReadFile(imagePath)
+	if imageDataErr != nil {
+		return nil, "", imageDataErr
+	}
+	decodedImage, imageFormat, decodeErr := image.Decode(bytes.NewReader(imageData))
+	if decodeErr != nil || imageFormat != "jpeg" {
+		return decodedImage, imageFormat, decodeErr
+	}
+	return orientImage(decodedImage, jpegOrientation(imageData)), imageFormat, nil
+}
+
+// jpegOrientation returns the EXIF orientation of the JPEG, or 0 if it has
+// none
+func jpegOrientation(imageData []byte) uint16 {
+	offset := 2
+	for offset+4 <= len(imageData) && imageData[offset] == 0xFF && imageData[offset+1] != 0xDA {
+		segmentEnd := offset + 2 + (int(imageData[offset+2])<<8 | int(imageData[offset+3]))
+		if segmentEnd > len(imageData) {
+			break
+		}
+		segment := imageData[offset:segmentEnd]
+		if imageData[offset+1] == 0xE1 && bytes.HasPrefix(segment[4:], []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[10:])
+		}
+		offset = segmentEnd
+	}
+	return 0
+}
+
+// orientImage returns the image as displayed with the EXIF orientation: 2
+// to 8 are the mirrored and rotated variants, with 5 to 8 swapping the width
+// and height
+func orientImage(sourceImage image.Image, orientation uint16) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return sourceImage
+	}
+	sourceBounds := sourceImage.Bounds()
+	width, height := sourceBounds.Dx(), sourceBounds.Dy()
+	if orientation >= 5 {
+		width, height = height, width
+	}
+	oriented := image.NewRGBA(image.Rect(0, 0, width, height))
+	for sourceY := 0; sourceY < sourceBounds.Dy(); sourceY++ {
+		for sourceX := 0; sourceX < sourceBounds.Dx(); sourceX++ {
+			// The displayed position of each source pixel
+			destX, destY := sourceX, sourceY
+			switch orientation {
+			case 2:
+				destX = width - 1 - sourceX
+			case 3:
+				destX, destY = width-1-sourceX, height-1-sourceY
+			case 4:
+				destY = height - 1 - sourceY
+			case 5:
+				destX, destY = sourceY, sourceX
+			case 6:
+				destX, destY = width-1-sourceY, sourceX
+			case 7:
+				destX, destY = width-1-sourceY, height-1-sourceX
+			case 8:
+				destX, destY = sourceY, height-1-sourceX
+			}
+			oriented.Set(destX, destY, sourceImage.At(sourceBounds.Min.X+sourceX, sourceBounds.Min.Y+sourceY))
+		}
+	}
+	return oriented
+}
+
+// encodeImageFile replaces the file with the image in the given format. The
+// image is written to a temporary file first, so a hard linked original
+// is left intact.
+func encodeImageFile(imagePath string, outputImage image.Image, imageFormat string, jpegQuality int) error {
+	var imageBuffer bytes.Buffer
+	var encodeErr error
+	switch imageFormat {
+	case "jpeg":
+		encodeErr = jpeg.Encode(&imageBuffer, outputImage, &jpeg.Options{Quality: jpegQuality})
+	case "png":
+		encodeErr = png.Encode(&imageBuffer, outputImage)
+	default:
+		encodeErr = fmt.Errorf("Unsupported image format: %s", imageFormat)
+	}
+	if encodeErr != nil {
+		return encodeErr
+	}
+	return writeFileAtomic(imagePath, imageBuffer.Bytes(), 0644)
+}
+
+// resizeImage downsamples the image to width x height by averaging the
+// source pixels that each destination pixel covers
+func resizeImage(sourceImage image.Image, width int, height int) *image.RGBA {
+	sourceBounds := sourceImage.Bounds()
+	source := image.NewRGBA(image.Rect(0, 0, sourceBounds.Dx(), sourceBounds.Dy()))
+	draw.Draw(source, source.Bounds(), sourceImage, sourceBounds.Min, draw.Src)
+	sourceWidth := source.Bounds().Dx()
+	sourceHeight := source.Bounds().Dy()
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	for destY := 0; destY < height; destY++ {
+		y0 := destY * sourceHeight / height
+		y1 := max(y0+1, (destY+1)*sourceHeight/height)
+		for destX := 0; destX < width; destX++ {
+			x0 := destX * sourceWidth / width
+			x1 := max(x0+1, (destX+1)*sourceWidth/width)
+			var sums [4]int
+			for y := y0; y < y1; y++ {
+				rowOffset := source.PixOffset(x0, y)
+				for x := x0; x < x1; x++ {
+					for channel := 0; channel < 4; channel++ {
+						sums[channel] += int(source.Pix[rowOffset+channel])
+					}
+					rowOffset += 4
+				}
+			}
+			pixelCount := (y1 - y0) * (x1 - x0)
+			destOffset := resized.PixOffset(destX, destY)
+			for channel := 0; channel < 4; channel++ {
+				resized.Pix[destOffset+channel] = uint8(sums[channel] / pixelCount)
+			}
+		}
+	}
+	return resized
+}
+
+// copyTootAttachments copies every attachment of the toot into destDirectory
+// using the attachment BaseFilename. It returns the number of copied files.
+func copyTootAttachments(filteredOutbox *Outbox, entry *ActivityEntry, destDirectory string, log *slog.Logger) (uint, error) {
+	copiedCount := uint(0)
+	for _, eachAttachment := range entry.Object.Attachments {
+		copyErr := copyAttachment(filteredOutbox, entry, eachAttachment, destDirectory, log)
+		if copyErr != nil {
+			return copiedCount, copyErr
+		}
+		copiedCount += 1
+	}
+	return copiedCount, nil
+}
+
+// copyAttachment copies a single attachment of the toot into destDirectory
+func copyAttachment(filteredOutbox *Outbox,
+	entry *ActivityEntry,
+	attachment *ActivityObjectAttachment,
+	destDirectory string,
+	log *slog.Logger) error {
+	errDirectory := ensureDirectory(destDirectory, false, log)
+	if errDirectory != nil {
+		return errDirectory
+	}
+	destFilePath := path.Join(destDirectory, attachment.BaseFilename)
+	sourceFilePath := filteredOutbox.mediaSourcePath(attachment)
+	manifest := filteredOutbox.MediaManifest
+	if manifest != nil {
+		if entry, reused := manifest.reuse(attachment, sourceFilePath, destFilePath); reused {
+			for _, eachFile := range entry.Files {
+				if _, exists := filteredOutbox.MediaHashes[eachFile.Hash]; filteredOutbox.MediaHashes != nil && !exists {
+					filteredOutbox.MediaHashes[eachFile.Hash] = path.Join(manifest.root, eachFile.Path)
+				}
+			}
+			log.Debug("Skipping unchanged media file", "name", attachment.BaseFilename, "source", entry.Source)
+			return nil
+		}
+	}
+	// A previous file may be a link to another media file, or the archive
+	os.Remove(destFilePath)
+	originalFilename := attachment.BaseFilename
+	bytesCopied := int64(-1)
+	if len(filteredOutbox.MediaHook) != 0 {
+		hookBytes, hookErr := runMediaHook(filteredOutbox.MediaHook, sourceFilePath, destFilePath)
+		if hookErr != nil {
+			log.Warn("Media hook failed, copying the media file instead",
+				"name", attachment.BaseFilename,
+				"error", hookErr)
+			os.Remove(destFilePath)
+		} else {
+			bytesCopied = hookBytes
+		}
+	}
+	if bytesCopied < 0 {
+		mediaMode := filteredOutbox.MediaMode
+		if len(mediaMode) <= 0 {
+			mediaMode = "copy"
+		}
+		copiedBytes, copyErr := MEDIA_MODES[mediaMode](sourceFilePath, destFilePath)
+		if copyErr != nil {
+			return copyErr
+		}
+		if filteredOutbox.VerifyMedia {
+			sourceSize, sourceSizeErr := mediaFileSize(sourceFilePath)
+			if sourceSizeErr != nil {
+				return sourceSizeErr
+			}
+			destSize, destSizeErr := mediaFileSize(destFilePath)
+			if destSizeErr != nil {
+				return destSizeErr
+			}
+			if destSize != sourceSize {
+				return fmt.Errorf("Incomplete copy of %s: %d of %d bytes", sourceFilePath, destSize, sourceSize)
+			}
+		}
+		bytesCopied = copiedBytes
+	}
+	for _, eachProcessor := range filteredOutbox.MediaProcessors {
+		processedPath, processErr := eachProcessor(attachment, destFilePath, log)
+		if processErr != nil {
+			return fmt.Errorf("Failed to process %s: %s", destFilePath, processErr)
+		}
+		destFilePath = processedPath
+	}
+	if filteredOutbox.MediaHashes != nil {
+		dedupeErr := filteredOutbox.dedupeMediaFile(destFilePath)
+		if dedupeErr != nil {
+			return dedupeErr
+		}
+	}
+	if manifest != nil {
+		recordErr := manifest.record(attachment, sourceFilePath, destDirectory, originalFilename)
+		if recordErr != nil {
+			return recordErr
+		}
+	}
+	log.Debug("Copied media file to source",
+		"type", attachment.MediaType,
+		"name", attachment.BaseFilename,
+		"bytes", bytesCopied,
+		"id", entry.Object.ID)
+	return nil
+}
+
+// stagedMediaBytes copies the attachment to a directory of the stagingRoot,
+// so the media processors apply as they do to the output copies, and
+// returns the processed file. The formats that embed media in an archive use
+// it. The media manifest and deduplication only track output copies, so
+// they're left out.
+func stagedMediaBytes(filteredOutbox *Outbox,
+	entry *ActivityEntry,
+	attachment *ActivityObjectAttachment,
+	stagingRoot string,
+	log *slog.Logger) ([]byte, error) {
+	stagingOutbox := &Outbox{
+		ArchiveDirectoryRoot: filteredOutbox.ArchiveDirectoryRoot,
+		MediaProcessors:      filteredOutbox.MediaProcessors,
+		MediaHook:            filteredOutbox.MediaHook,
+		VerifyMedia:          filteredOutbox.VerifyMedia,
+	}
+	stagingDirectory := path.Join(stagingRoot, tootFileID(entry))
+	copyErr := copyAttachment(stagingOutbox, entry, attachment, stagingDirectory, log)
+	if copyErr != nil {
+		return nil, copyErr
+	}
+	return os.ReadFile(path.Join(stagingDirectory, attachment.BaseFilename))
+}
+
+// splitCamelCase inserts a space at the humps of CamelCase text, including
+// before the last capital of an acronym, e.g. HTMLParser becomes HTML Parser
+func splitCamelCase(text string) string {
+	runes := []rune(text)
+	var split strings.Builder
+	for index, eachRune := range runes {
+		if index > 0 && unicode.IsUpper(eachRune) {
+			previous := runes[index-1]
+			nextIsLower := index+1 < len(runes) && unicode.IsLower(runes[index+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextIsLower) {
+				split.WriteRune(' ')
+			}
+		}
+		split.WriteRune(eachRune)
+	}
+	return split.String()
+}
+
+// tagNormalizer renames the hashtags of the toots for --tag-style and the
+// --tag-map file
+type tagNormalizer struct {
+	style func(tagName string) string
+	// Lowercased tag names without the # to the replacement name. An empty
+	// replacement removes the tag.
+	mapping map[string]string
+}
+
+// newTagNormalizer reads the optional --tag-map file
+func newTagNormalizer(style string, mapPath string) (*tagNormalizer, error) {
+	normalizer := &tagNormalizer{
+		style:   TAG_STYLES[style],
+		mapping: map[string]string{},
+	}
+	if len(mapPath) <= 0 {
+		return normalizer, nil
+	}
+	mapping := map[string]string{}
+	readErr := readConfigFile(mapPath, &mapping)
+	if readErr != nil {
+		return nil, readErr
+	}
+	// Sorted, so the same replacement wins every run when several tags
+	// normalize to the same name
+	for _, eachTag := range slices.Sorted(maps.Keys(mapping)) {
+		normalizer.mapping[normalizeTagFlag(eachTag)] = strings.TrimPrefix(strings.TrimSpace(mapping[eachTag]), "#")
+	}
+	return normalizer, nil
+}
+
+// normalize returns the new name of the tag, or an empty string when the tag
+// is removed. Mapped tags are renamed as given, the others get the style.
+func (tn *tagNormalizer) normalize(tagName string) string {
+	tagName = strings.TrimPrefix(tagName, "#")
+	if replacement, isMapped := tn.mapping[normalizeTagFlag(tagName)]; isMapped {
+		return replacement
+	}
+	return tn.style(tagName)
+}
+
+// normalizeTags renames the hashtags of every toot, merging the tags that
+// end up with the same name. It returns the number of toots changed.
+func (ob *Outbox) normalizeTags(normalizer *tagNormalizer) uint {
+	changedCount := uint(0)
+	for _, eachEntry := range ob.OrderedItems {
+		changed := false
+		tags := []*ActivityObjectTag{}
+		tagNames := map[string]bool{}
+		for _, eachTag := range eachEntry.Object.Tags {
+			if eachTag.Type != "Hashtag" {
+				tags = append(tags, eachTag)
+				continue
+			}
+			normalizedName := normalizer.normalize(eachTag.Name)
+			if normalizedName != eachTag.Name {
+				eachTag.Name = normalizedName
+				changed = true
+			}
+			if len(normalizedName) <= 0 || tagNames[strings.ToLower(normalizedName)] {
+				changed = true
+				continue
+			}
+			tagNames[strings.ToLower(normalizedName)] = true
+			tags = append(tags, eachTag)
+		}
+		eachEntry.Object.Tags = tags
+		if changed {
+			changedCount += 1
+		}
+	}
+	return changedCount
+}
+
+// tagSlug returns the lowercase, hyphenated, URL safe form of the tag name
+func tagSlug(tagName string) string {
+	slugParts := strings.FieldsFunc(strings.ToLower(tagName), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(slugParts, "-")
+}
+
+// writeTagIndexPages writes a tags/<slug>/_index.md page for every hashtag
+// with the toot count and relref links to the pages containing the toots
+func writeTagIndexPages(outputRoot string, pages []*tootGroup, headerLength int, frontmatterFormat string, messages messageCatalog, log *slog.Logger) (uint, error) {
+	tagIndexTemplate, tagIndexTemplateErr := template.New("tagIndex").Parse(TEMPLATE_TAG_INDEX)
+	if tagIndexTemplateErr != nil {
+		return 0, tagIndexTemplateErr
+	}
+	type tagPageLink struct {
+		Title string
+		Path  string
+	}
+	type tagPage struct {
+		Tag         string
+		Count       int
+		Description string
+		Links       []*tagPageLink
+	}
+	tagPages := []*tagPage{}
+	tagPagesBySlug := map[string]*tagPage{}
+	for _, eachPage := range pages {
+		for _, eachItem := range eachPage.Toots {
+			for _, eachTag := range eachItem.Object.Tags {
+				slug := tagSlug(eachTag.Name)
+				if eachTag.Type != "Hashtag" || len(slug) <= 0 {
+					continue
+				}
+				page, pageExists := tagPagesBySlug[slug]
+				if !pageExists {
+					page = &tagPage{Tag: eachTag.Name}
+					tagPagesBySlug[slug] = page
+					tagPages = append(tagPages, page)
+				}
+				page.Count += 1
+				// Links are relative to the tags/<slug> directory
+				pagePath := path.Join("..", "..", eachPage.Key, "index.md")
+				if len(page.Links) == 0 || page.Links[len(page.Links)-1].Path != pagePath {
+					page.Links = append(page.Links, &tagPageLink{
+						Title: tootAnchorTitle(eachItem, headerLength),
+						Path:  pagePath,
+					})
+				}
+			}
+		}
+	}
+	for _, eachSlug := range slices.Sorted(maps.Keys(tagPagesBySlug)) {
+		tagDirectory := path.Join(outputRoot, "tags", eachSlug)
+		errDirectory := ensureDirectory(tagDirectory, false, log)
+		if errDirectory != nil {
+			return 0, errDirectory
+		}
+		page := tagPagesBySlug[eachSlug]
+		page.Description = messages.count("TagDescription", page.Count, page.Tag)
+		var tagBuffer bytes.Buffer
+		if err := tagIndexTemplate.Execute(&tagBuffer, page); err != nil {
+			return 0, err
+		}
+		writeErr := writePageFile(path.Join(tagDirectory, "_index.md"), tagBuffer.String(), frontmatterFormat)
+		if writeErr != nil {
+			return 0, writeErr
+		}
+	}
+	return uint(len(tagPages)), nil
+}
+
+// threadSeriesName returns the --series taxonomy term of the thread
+func threadSeriesName(threadRoot *ActivityEntry) string {
+	return "thread-" + tootFileID(threadRoot)
+}
+
+// writeSeriesIndexPages writes a series/<name>/_index.md page for every
+// thread spread over more than one of the pages, with relref links to the
+// pages in thread order
+func writeSeriesIndexPages(outputRoot string, pages []*tootGroup, threadRoots map[*ActivityEntry]*ActivityEntry, headerLength int, frontmatterFormat string, messages messageCatalog, log *slog.Logger) (uint, error) {
+	seriesIndexTemplate, seriesIndexTemplateErr := template.New("seriesIndex").Parse(TEMPLATE_SERIES_INDEX)
+	if seriesIndexTemplateErr != nil {
+		return 0, seriesIndexTemplateErr
+	}
+	type seriesPageLink struct {
+		Title string
+		Path  string
+	}
+	type seriesPage struct {
+		Title       string
+		Description string
+		Links       []*seriesPageLink
+	}
+	seriesPagesByName := map[string]*seriesPage{}
+	for _, eachPage := range pages {
+		threadRoot := threadRoots[eachPage.Toots[0]]
+		seriesName := threadSeriesName(threadRoot)
+		page, pageExists := seriesPagesByName[seriesName]
+		if !pageExists {
+			seriesTitle := headerExcerpt(threadRoot.Object.Content, headerLength)
+			if len(seriesTitle) <= 0 {
+				seriesTitle = tootAnchorTitle(threadRoot, headerLength)
+			}
+			page = &seriesPage{Title: fmt.Sprintf(messages["Thread"], seriesTitle)}
+			seriesPagesByName[seriesName] = page
+		}
+		// Links are relative to the series/<name> directory
+		page.Links = append(page.Links, &seriesPageLink{
+			Title: tootAnchorTitle(eachPage.Toots[0], headerLength),
+			Path:  path.Join("..", "..", eachPage.Key, "index.md"),
+		})
+	}
+	seriesCount := uint(0)
+	for _, eachName := range slices.Sorted(maps.Keys(seriesPagesByName)) {
+		if len(seriesPagesByName[eachName].Links) <= 1 {
+			continue
+		}
+		seriesDirectory := path.Join(outputRoot, "series", eachName)
+		errDirectory := ensureDirectory(seriesDirectory, false, log)
+		if errDirectory != nil {
+			return 0, errDirectory
+		}
+		page := seriesPagesByName[eachName]
+		page.Description = messages.count("ThreadDescription", len(page.Links))
+		var seriesBuffer bytes.Buffer
+		if err := seriesIndexTemplate.Execute(&seriesBuffer, page); err != nil {
+			return 0, err
+		}
+		writeErr := writePageFile(path.Join(seriesDirectory, "_index.md"), seriesBuffer.String(), frontmatterFormat)
+		if writeErr != nil {
+			return 0, writeErr
+		}
+		seriesCount += 1
+	}
+	return seriesCount, nil
+}
+
+// writePinnedPage writes a pinned/index.md page with relref links to the
+// pages containing the pinned toots
+func writePinnedPage(outputRoot string, pages []*tootGroup, featuredIDs map[string]bool, headerLength int, frontmatterFormat string, messages messageCatalog, nowTime string, log *slog.Logger) error {
+	pinnedTemplate, pinnedTemplateErr := template.New("pinned").Parse(TEMPLATE_PINNED_PAGE)
+	if pinnedTemplateErr != nil {
+		return pinnedTemplateErr
+	}
+	type pinnedLink struct {
+		Title string
+		Path  string
+	}
+	pinnedLinks := []*pinnedLink{}
+	for _, eachPage := range pages {
+		for _, eachItem := range eachPage.Toots {
+			if featuredIDs[eachItem.Object.ID] {
+				pinnedLinks = append(pinnedLinks, &pinnedLink{
+					Title: tootAnchorTitle(eachItem, headerLength),
+					Path:  path.Join("..", eachPage.Key, "index.md"),
+				})
+			}
+		}
+	}
+	pinnedDirectory := path.Join(outputRoot, "pinned")
+	errDirectory := ensureDirectory(pinnedDirectory, false, log)
+	if errDirectory != nil {
+		return errDirectory
+	}
+	var pinnedBuffer bytes.Buffer
+	if err := pinnedTemplate.Execute(&pinnedBuffer, map[string]interface{}{
+		"ExecutionTime": nowTime,
+		"Title":         messages["Pinned"],
+		"Description":   messages.count("PinnedDescription", len(pinnedLinks)),
+		"Links":         pinnedLinks,
+	}); err != nil {
+		return err
+	}
+	pinnedOutputPath := path.Join(pinnedDirectory, "index.md")
+	log.Info("Writing pinned page", "path", pinnedOutputPath, "count", len(pinnedLinks))
+	return writePageFile(pinnedOutputPath, pinnedBuffer.String(), frontmatterFormat)
+}
+
+// writeSectionIndexPages writes the _index.md section page for the output root
+// and, when pages are nested in year directories, for every year
+func writeSectionIndexPages(outputRoot string, pages []*tootGroup, sectionTitle string, frontmatterFormat string, messages messageCatalog, nowTime string, log *slog.Logger) (uint, error) {
+	sectionIndexTemplate, sectionIndexTemplateErr := template.New("sectionIndex").Parse(TEMPLATE_SECTION_INDEX)
+	if sectionIndexTemplateErr != nil {
+		return 0, sectionIndexTemplateErr
+	}
+	if len(sectionTitle) <= 0 {
+		sectionTitle = messages["SectionTitle"]
+	}
+	totalCount := 0
+	yearCounts := map[string]int{}
+	for _, eachPage := range pages {
+		totalCount += len(eachPage.Toots)
+		pathParts := strings.Split(eachPage.Key, "/")
+		if len(pathParts) > 1 {
+			yearCounts[pathParts[0]] += len(eachPage.Toots)
+		}
+	}
+	sectionDirectories := map[string]map[string]interface{}{
+		"": {
+			"Title": fmt.Sprintf("Mastodon - @%s@%s", USER, HOST),
+			"Count": totalCount,
+		},
+	}
+	for eachYear, eachCount := range yearCounts {
+		sectionDirectories[eachYear] = map[string]interface{}{
+			"Title": fmt.Sprintf(sectionTitle, eachYear),
+			"Count": eachCount,
+		}
+	}
+	for eachDirectory, eachParams := range sectionDirectories {
+		eachParams["ExecutionTime"] = nowTime
+		eachParams["Description"] = messages.count("SectionDescription", eachParams["Count"].(int))
+		var sectionBuffer bytes.Buffer
+		if err := sectionIndexTemplate.Execute(&sectionBuffer, eachParams); err != nil {
+			return 0, err
+		}
+		sectionOutputPath := path.Join(outputRoot, eachDirectory, "_index.md")
+		log.Debug("Rendering section page", "path", sectionOutputPath)
+		writeErr := writePageFile(sectionOutputPath, sectionBuffer.String(), frontmatterFormat)
+		if writeErr != nil {
+			return 0, writeErr
+		}
+	}
+	return uint(len(sectionDirectories)), nil
+}
+
+// tootAnchorID returns the in-page anchor for the toot
+func tootAnchorID(entry *ActivityEntry) string {
+	return "toot-" + tootFileID(entry)
+}
+
+// tootAnchorTitle returns the publish time and an excerpt of the toot
+func tootAnchorTitle(entry *ActivityEntry, headerLength int) string {
+	parsedDate, _ := parsePublished(entry)
+	excerpt := headerExcerpt(entry.Object.Content, headerLength)
+	if len(excerpt) <= 0 {
+		return parsedDate.Format("2006-01-02 15:04")
+	}
+	return fmt.Sprintf("%s — %s", parsedDate.Format("2006-01-02 15:04"), excerpt)
+}
+
+// markdownAnchorTitle returns the tootAnchorTitle escaped for the Markdown
+// headings and table of contents links, so the excerpt's text, e.g. a
+// <script> typed into the toot, can't become markup
+func markdownAnchorTitle(entry *ActivityEntry, headerLength int) string {
+	escapedTitle := resolveMarkdownEscapes(escapeMarkdownText(tootAnchorTitle(entry, headerLength)))
+	return strings.NewReplacer("&", `\&`, "{", `\{`, "}", `\}`).Replace(escapedTitle)
+}
+
+func renderTootsToDisk(cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	return renderHugoPagesToDisk(cla, filteredOutbox, TEMPLATE_TOOT_FRONTMATTER, TEMPLATE_TOOT, log)
+}
+
+// renderMicroblogToDisk renders one Hugo page bundle per toot using the
+// micro.blog micropost conventions, plus a feed.json JSON Feed
+func renderMicroblogToDisk(cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	microblogArgs := *cla
+	microblogArgs.groupBy = "toot"
+	renderErr := renderHugoPagesToDisk(&microblogArgs,
+		filteredOutbox,
+		TEMPLATE_MICROBLOG_FRONTMATTER,
+		TEMPLATE_MICROBLOG_TOOT,
+		log)
+	if renderErr != nil {
+		return renderErr
+	}
+	return writeJSONFeed(path.Join(cla.outputRootPathHugoAssets, "feed.json"), &microblogArgs, filteredOutbox, log)
+}
+
+// renderHugoPagesToDisk renders the Hugo page bundles for the --group-by mode
+// using the frontmatter and toot templates
+func renderHugoPagesToDisk(cla *commandLineArgs,
+	filteredOutbox *Outbox,
+	frontmatterTemplate string,
+	tootTemplateText string,
+	log *slog.Logger) error {
+	// When rendering out, use the current time as the lastModTime. It's
+	// left out with --reproducible.
+	nowTime := ""
+	if !cla.reproducible {
+		nowTime = time.Now().Format(time.RFC3339)
+	}
+
+	publishingStats := PublishingStats{
+		totalTootCount:    filteredOutbox.TotalItems,
+		renderedTootCount: uint(len(filteredOutbox.OrderedItems)),
+		filteredTootCount: filteredOutbox.TotalItems - uint(len(filteredOutbox.OrderedItems)),
+	}
+	if len(cla.frontmatterTemplate) != 0 {
+		frontmatterTemplate = cla.frontmatterTemplate
+	}
+	tootRootTemplate, tootRootTemplateErr := template.New("tootRoot").Parse(frontmatterTemplate)
+	if tootRootTemplateErr != nil {
+		return tootRootTemplateErr
+	}
+	tootTemplate, tootTemplateErr := template.New("toot").Parse(tootTemplateText)
+	if tootTemplateErr != nil {
+		return tootTemplateErr
+	}
+
+	// Bucket the toots into pages, keeping each thread contiguous
+	groupByMode := HUGO_GROUP_BY_MODES[cla.groupBy]
+	orderedToots, orderedTootsErr := filteredOutbox.threadOrderedToots()
+	if orderedTootsErr != nil {
+		return orderedTootsErr
+	}
+	threadRoots := map[*ActivityEntry]*ActivityEntry{}
+	pages, pagesErr := cla.groupLanguagePages(filteredOutbox, orderedToots, func(entry *ActivityEntry) (string, error) {
+		// By default, each toot is it's own root. If there is a replyTo chain,
+		// recurse that to the root which becomes the active root
+		threadRootActivityItem, hopCount, threadRootErr := filteredOutbox.threadRoot(entry)
+		if threadRootErr != nil {
+			return "", threadRootErr
+		}
+		publishingStats.replyThreadsCount += hopCount
+		threadRoots[entry] = threadRootActivityItem
+		return groupByMode.bundlePath(entry, threadRootActivityItem)
+	})
+	if pagesErr != nil {
+		return pagesErr
+	}
+
+	// Pages with a toot in one of the --drafts audiences are drafts, which
+	// the tag, pinned and section pages don't link to
+	draftToots := map[*ActivityEntry]bool{}
+	publishedPages := []*tootGroup{}
+	for _, eachPage := range pages {
+		pageDraft := slices.ContainsFunc(eachPage.Toots, func(entry *ActivityEntry) bool {
+			return slices.Contains(cla.draftVisibilities, entry.Visibility())
+		})
+		for _, eachItem := range eachPage.Toots {
+			draftToots[eachItem] = pageDraft
+		}
+		if !pageDraft {
+			publishedPages = append(publishedPages, eachPage)
+		}
+	}
+	// Threads spread over several pages are a --series, named for the root
+	threadPages := map[*ActivityEntry][]*tootGroup{}
+	for _, eachPage := range pages {
+		for _, eachItem := range eachPage.Toots {
+			rootPages := threadPages[threadRoots[eachItem]]
+			if len(rootPages) == 0 || rootPages[len(rootPages)-1] != eachPage {
+				threadPages[threadRoots[eachItem]] = append(rootPages, eachPage)
+			}
+		}
+	}
+	pageSeries := func(page *tootGroup) string {
+		if !cla.series || len(threadPages[threadRoots[page.Toots[0]]]) <= 1 {
+			return ""
+		}
+		return threadSeriesName(threadRoots[page.Toots[0]])
+	}
+	languages := []string{cla.lang}
+	for _, eachPage := range pages {
+		if !slices.Contains(languages, eachPage.Language) {
+			languages = append(languages, eachPage.Language)
+		}
+	}
+
+	for _, eachPage := range pages {
+		pageSectionURL := cla.languageSectionURL(eachPage.Language)
+		tootRootBundleDirectory := path.Join(cla.languageOutputRoot(eachPage.Language), eachPage.Key)
+		errDirectory := ensureDirectory(tootRootBundleDirectory, false, log)
+		if errDirectory != nil {
+			return errDirectory
+		}
+		tootOutputPath := path.Join(tootRootBundleDirectory, "index.md")
+
+		// Any media objects we need to move? We're just going to use the basename for the
+		// attachment and put it in the page bundle directory. This happens first as
+		// processing the media may update the attachments the templates reference.
+		for _, eachItem := range eachPage.Toots {
+			copiedCount, copyErr := copyTootAttachments(filteredOutbox, eachItem, tootRootBundleDirectory, log)
+			if copyErr != nil {
+				return copyErr
+			}
+			publishingStats.mediaFilesCount += copiedCount
+			_, emojiErr := copyCustomEmoji(eachItem, tootRootBundleDirectory)
+			if emojiErr != nil {
+				return emojiErr
+			}
+			previewErr := copyLinkPreviewImage(eachItem, tootRootBundleDirectory)
+			if previewErr != nil {
+				return previewErr
+			}
+		}
+
+		// The frontmatter is rendered from the first toot on the page
+		var pageBuffer bytes.Buffer
+		pagePhotos := []string{}
+		for _, eachItem := range eachPage.Toots {
+			for _, eachAttachment := range eachItem.Object.Attachments {
+				if strings.HasPrefix(eachAttachment.MediaType, "image/") {
+					pagePhotos = append(pagePhotos, eachAttachment.BaseFilename)
+					if !hasAltText(eachAttachment) {
+						publishingStats.missingAltCount += 1
+					}
+				}
+			}
+		}
+		pagePlaceholders := []*ActivityObjectAttachment{}
+		for _, eachItem := range eachPage.Toots {
+			for _, eachAttachment := range eachItem.Object.Attachments {
+				if len(eachAttachment.PlaceholderFilename) != 0 {
+					pagePlaceholders = append(pagePlaceholders, eachAttachment)
+				}
+			}
+		}
+		// Page resource metadata for the bundled media, so themes can use
+		// .Resources rather than the markdown
+		pageResources := []*ActivityObjectAttachment{}
+		for _, eachItem := range eachPage.Toots {
+			for _, eachAttachment := range eachItem.Object.Attachments {
+				if len(eachAttachment.BaseFilename) != 0 && !slices.Contains(pageResources, eachAttachment) {
+					pageResources = append(pageResources, eachAttachment)
+				}
+			}
+		}
+		// The images, audio and videos on the page, from toots not marked
+		// sensitive, for the link preview metadata of Hugo's opengraph and
+		// twitter_cards templates. The first image is the page's image.
+		pageImage := "/images/mastodon.png"
+		pageImageAlt := ""
+		pageImages := []string{}
+		pageAudio := []string{}
+		pageVideos := []string{}
+		for _, eachItem := range eachPage.Toots {
+			if eachItem.Object.Sensitive {
+				continue
+			}
+			for _, eachAttachment := range eachItem.Object.Attachments {
+				// Prefer the fallback of transcoded images, which previews
+				// are more likely to support
+				mediaFilename := eachAttachment.BaseFilename
+				if len(eachAttachment.FallbackFilename) != 0 {
+					mediaFilename = eachAttachment.FallbackFilename
+				}
+				if len(mediaFilename) <= 0 {
+					continue
+				}
+				mediaPath := pageSectionURL + path.Join(eachPage.Key, mediaFilename)
+				switch {
+				case eachAttachment.IsImage():
+					if len(pageImages) == 0 {
+						pageImage = mediaPath
+						pageImageAlt = strings.TrimSpace(eachAttachment.Name)
+					}
+					pageImages = append(pageImages, mediaPath)
+				case eachAttachment.IsAudio():
+					pageAudio = append(pageAudio, mediaPath)
+				case strings.HasPrefix(eachAttachment.MediaType, "video/"):
+					pageVideos = append(pageVideos, mediaPath)
+				}
+			}
+		}
+		pageFeatured := slices.ContainsFunc(eachPage.Toots, func(entry *ActivityEntry) bool {
+			return filteredOutbox.FeaturedIDs[entry.Object.ID]
+		})
+		pageAliases := []string{}
+		if cla.aliases {
+			for _, eachItem := range eachPage.Toots {
+				statusURL, statusURLErr := url.Parse(eachItem.Object.URL)
+				if statusURLErr == nil && len(statusURL.Path) > 1 {
+					pageAliases = append(pageAliases, statusURL.Path)
+				}
+			}
+		}
+		pageStats := PageStats{
+			TootCount:  len(eachPage.Toots),
+			MediaCount: len(pageResources),
+		}
+		for _, eachItem := range eachPage.Toots {
+			if len(eachItem.Object.InReplyTo) != 0 {
+				pageStats.ReplyCount += 1
+			}
+			pageStats.WordCount += len(strings.Fields(htmlToText(eachItem.Object.Content)))
+		}
+		pageDate, _ := parsePublished(eachPage.Toots[0])
+		plainText := htmlToText(eachPage.Toots[0].Object.Content)
+		templateParamMap := map[string]interface{}{
+			"ExecutionTime":    nowTime,
+			"Title":            groupByMode.pageTitle(eachPage.Toots[0]),
+			"Toot":             eachPage.Toots[0],
+			"Thread":           eachPage.Toots,
+			"ThreadRoot":       threadRoots[eachPage.Toots[0]],
+			"Date":             pageDate,
+			"Stats":            pageStats,
+			"Draft":            draftToots[eachPage.Toots[0]],
+			"Series":           pageSeries(eachPage),
+			"Keywords":         []string{},
+			"LastMod":          pageLastModified(eachPage.Toots),
+			"PlainText":        plainText,
+			"Excerpt":          truncateText(plainText, 80),
+			"Photos":           pagePhotos,
+			"Placeholders":     pagePlaceholders,
+			"Resources":        pageResources,
+			"Image":            pageImage,
+			"ImageAlt":         pageImageAlt,
+			"Images":           pageImages,
+			"Audio":            pageAudio,
+			"Videos":           pageVideos,
+			"Aliases":          pageAliases,
+			"CWMode":           cla.cwMode,
+			"Featured":         pageFeatured,
+			"Weight":           cla.pinnedWeight,
+			"AudioShortcode":   cla.audioShortcode,
+			"Gallery":          cla.gallery,
+			"SrcsetSizes":      cla.srcsetSizes,
+			"GalleryShortcode": cla.galleryShortcode,
+			"LayoutShortcodes": cla.layoutShortcodes,
+			"Messages":         cla.languageMessages(eachPage.Language),
+			"SensitiveMedia":   cla.sensitiveMedia,
+			"SensitiveClass":   cla.sensitiveClass,
+		}
+		if cla.keywordCount > 0 {
+			templateParamMap["Keywords"] = pageKeywords(eachPage.Toots, cla.keywordCount)
+		}
+		// The content warning describes the page without revealing it
+		templateParamMap["Description"] = headerExcerpt(eachPage.Toots[0].Object.Content, cla.descriptionLength)
+		if summary := strings.TrimSpace(eachPage.Toots[0].Object.Summary); len(summary) != 0 {
+			templateParamMap["Description"] = summary
+		}
+		if cla.descriptionTemplate != nil {
+			var descriptionBuilder strings.Builder
+			if err := cla.descriptionTemplate.Execute(&descriptionBuilder, templateParamMap); err != nil {
+				return err
+			}
+			templateParamMap["Description"] = strings.TrimSpace(descriptionBuilder.String())
+		}
+		// Text-only pages get a share card of their description
+		if cla.ogImages && len(pageImages) == 0 {
+			cardFooter := fmt.Sprintf("@%s@%s · %s", USER, HOST, pageDate.Format("2006-01-02"))
+			cardErr := writeOGImage(path.Join(tootRootBundleDirectory, OG_IMAGE_FILENAME),
+				templateParamMap["Description"].(string),
+				cardFooter,
+				cla,
+				log)
+			if cardErr != nil {
+				return cardErr
+			}
+			templateParamMap["Image"] = pageSectionURL + path.Join(eachPage.Key, OG_IMAGE_FILENAME)
+			templateParamMap["Images"] = []string{templateParamMap["Image"].(string)}
+		}
+		templateParamMap["Params"] = []*renderedFrontmatterParam{}
+		if cla.frontmatterParams != nil {
+			pageParams, pageParamsErr := cla.frontmatterParams.render(templateParamMap)
+			if pageParamsErr != nil {
+				return pageParamsErr
+			}
+			templateParamMap["Params"] = pageParams
+		}
+		if themePreset, hasThemePreset := THEME_PRESETS[cla.themePreset]; hasThemePreset {
+			pageParams, pageParamsErr := themePresetParams(themePreset(templateParamMap),
+				templateParamMap["Params"].([]*renderedFrontmatterParam))
+			if pageParamsErr != nil {
+				return pageParamsErr
+			}
+			templateParamMap["Params"] = pageParams
+		}
+		if err := tootRootTemplate.Execute(&pageBuffer, templateParamMap); err != nil {
+			return err
+		}
+		if groupByMode.tableOfContents {
+			pageBuffer.WriteString("\n")
+			for _, eachItem := range eachPage.Toots {
+				listIndent := ""
+				if eachItem != threadRoots[eachItem] {
+					listIndent = "  "
+				}
+				fmt.Fprintf(&pageBuffer, "%s- [%s](#%s)\n", listIndent, markdownAnchorTitle(eachItem, cla.headerLength), tootAnchorID(eachItem))
+			}
+		}
+		activeSectionHeading := ""
+		for _, eachItem := range eachPage.Toots {
+			log.Debug("Rendering toot",
+				"id", eachItem.ID,
+				"replyTo", eachItem.Object.InReplyTo,
+				"path", tootOutputPath)
+			sectionHeading := groupByMode.sectionHeading(eachItem, threadRoots[eachItem])
+			if sectionHeading != activeSectionHeading {
+				fmt.Fprintf(&pageBuffer, "\n## %s\n", sectionHeading)
+				activeSectionHeading = sectionHeading
+			}
+			if groupByMode.tableOfContents {
+				fmt.Fprintf(&pageBuffer, "\n### %s {#%s}\n", markdownAnchorTitle(eachItem, cla.headerLength), tootAnchorID(eachItem))
+			}
+			templateParamMap["Toot"] = eachItem
+			templateParamMap["Content"] = CONTENT_MODES[cla.contentMode](customEmojiContent(eachItem))
+			templateParamMap["InReplyTo"] = ""
+			if eachItem.Object.repliesToOtherUser() {
+				templateParamMap["InReplyTo"] = eachItem.Object.InReplyTo
+			}
+			if err := tootTemplate.Execute(&pageBuffer, templateParamMap); err != nil {
+				return err
+			}
+		}
+		tootIDs := []string{}
+		for _, eachItem := range eachPage.Toots {
+			tootIDs = append(tootIDs, eachItem.Object.ID)
+		}
+		filteredOutbox.OutputManifest.addSources(tootRootBundleDirectory, tootIDs)
+		var writeErr error
+		if filteredOutbox.RenderState != nil {
+			writeErr = filteredOutbox.RenderState.writePage(tootOutputPath, pageBuffer.String(), cla.frontmatterFormat, nowTime, tootIDs, log)
+		} else {
+			writeErr = writePageFile(tootOutputPath, pageBuffer.String(), cla.frontmatterFormat)
+		}
+		if writeErr != nil {
+			return writeErr
+		}
+	}
+	// Each language tree has its own tag, series, pinned and section pages
+	for _, eachLanguage := range languages {
+		languageRoot := cla.languageOutputRoot(eachLanguage)
+		languageMessages := cla.languageMessages(eachLanguage)
+		languagePages := []*tootGroup{}
+		for _, eachPage := range publishedPages {
+			if eachPage.Language == eachLanguage {
+				languagePages = append(languagePages, eachPage)
+			}
+		}
+		if cla.tagPages {
+			tagPageCount, tagPagesErr := writeTagIndexPages(languageRoot, languagePages, cla.headerLength, cla.frontmatterFormat, languageMessages, log)
+			if tagPagesErr != nil {
+				return tagPagesErr
+			}
+			publishingStats.tagPagesCount += tagPageCount
+		}
+		if cla.series {
+			seriesPageCount, seriesPagesErr := writeSeriesIndexPages(languageRoot, languagePages, threadRoots, cla.headerLength, cla.frontmatterFormat, languageMessages, log)
+			if seriesPagesErr != nil {
+				return seriesPagesErr
+			}
+			publishingStats.seriesPagesCount += seriesPageCount
+		}
+		if cla.pinnedPage {
+			pinnedErr := writePinnedPage(languageRoot, languagePages, filteredOutbox.FeaturedIDs, cla.headerLength, cla.frontmatterFormat, languageMessages, nowTime, log)
+			if pinnedErr != nil {
+				return pinnedErr
+			}
+		}
+		if cla.sectionPages {
+			sectionPageCount, sectionPagesErr := writeSectionIndexPages(languageRoot, languagePages, cla.sectionTitle, cla.frontmatterFormat, languageMessages, nowTime, log)
+			if sectionPagesErr != nil {
+				return sectionPagesErr
+			}
+			publishingStats.sectionPagesCount += sectionPageCount
+		}
+	}
+	// The feeds, indexes and other outputs written after the pages only
+	// include the published toots
+	publishingStats.draftPagesCount = uint(len(pages) - len(publishedPages))
+	if len(cla.draftVisibilities) != 0 {
+		filteredOutbox.filterToots("drafts", func(entry *ActivityEntry) bool {
+			return !draftToots[entry]
+		})
+	}
+	// All done
+	log.Info("Publishing statistics", append([]any{
+		"totalTootCount", publishingStats.totalTootCount,
+		"renderedTootCount", publishingStats.renderedTootCount,
+		"filteredTootCount", publishingStats.filteredTootCount,
+		"replyThreadCount", publishingStats.replyThreadsCount,
+		"mediaFilesCount", publishingStats.mediaFilesCount,
+		"tagPagesCount", publishingStats.tagPagesCount,
+		"seriesPagesCount", publishingStats.seriesPagesCount,
+		"sectionPagesCount", publishingStats.sectionPagesCount,
+		"draftPagesCount", publishingStats.draftPagesCount,
+		"missingAltTextCount", publishingStats.missingAltCount},
+		filteredOutbox.skippedCountLogArgs()...)...)
+	return nil
+}
+
+// writeJSONFile marshals the value to an indented JSON file. HTML escaping is
+// disabled so that toot content remains readable.
+func writeJSONFile(outputPath string, value interface{}) error {
+	jsonBytes, jsonBytesErr := marshalJSON(value)
+	if jsonBytesErr != nil {
+		return jsonBytesErr
+	}
+	return writeFileAtomic(outputPath, jsonBytes, 0644)
+}
+
+// marshalJSON is json.MarshalIndent without HTML escaping
+func marshalJSON(value interface{}) ([]byte, error) {
+	var jsonBuffer bytes.Buffer
+	encoder := json.NewEncoder(&jsonBuffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return jsonBuffer.Bytes(), nil
+}
+
+// sanitizeTag replaces every rune of the hashtag name that is not a letter,
+// digit or one of the extraRunes with an underscore
+func sanitizeTag(tagName string, extraRunes string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(extraRunes, r) {
+			return r
+		}
+		return '_'
+	}, tagName)
+}
+
+// publishedDayKey returns the YYYY-MM-DD publish date of the toot
+func publishedDayKey(entry *ActivityEntry) (string, error) {
+	parsedDate, parsedDateErr := parsePublished(entry)
+	if parsedDateErr != nil {
+		return "", parsedDateErr
+	}
+	return parsedDate.Format(time.DateOnly), nil
+}
+
+// renderOrgToDisk writes one org-mode file per day, with a heading for each
+// toot. Self-replies published on the same day as their parent are nested
+// below the parent heading. Media is copied to a shared media directory.
+func renderOrgToDisk(cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	outputRoot := cla.outputRootPathHugoAssets
+	dayGroups, dayGroupsErr := groupToots(filteredOutbox.OrderedItems, publishedDayKey)
+	if dayGroupsErr != nil {
+		return dayGroupsErr
+	}
+	mediaDirectory := path.Join(outputRoot, "media")
+	orgLink := func(href string, text string) string {
+		if len(text) <= 0 || text == href {
+			return fmt.Sprintf("[[%s]]", href)
+		}
+		return fmt.Sprintf("[[%s][%s]]", href, text)
+	}
+	mediaFilesCount := uint(0)
+	for _, eachGroup := range dayGroups {
+		var orgBuilder strings.Builder
+		fileTags := []string{}
+		tootIDs := map[string]bool{}
+		for _, eachItem := range eachGroup.Toots {
+			tootIDs[eachItem.Object.ID] = true
+			for _, eachTag := range eachItem.Object.Tags {
+				tagName := sanitizeTag(eachTag.Name, "_@#%")
+				if eachTag.Type == "Hashtag" && !slices.Contains(fileTags, tagName) {
+					fileTags = append(fileTags, tagName)
+				}
+			}
+		}
+		parsedDate, _ := time.Parse(time.DateOnly, eachGroup.Key)
+		fmt.Fprintf(&orgBuilder, "#+TITLE: Mastodon - %s\n", eachGroup.Key)
+		fmt.Fprintf(&orgBuilder, "#+DATE: [%s]\n", parsedDate.Format("2006-01-02 Mon"))
+		if len(fileTags) != 0 {
+			fmt.Fprintf(&orgBuilder, "#+FILETAGS: :%s:\n", strings.Join(fileTags, ":"))
+		}
+		for _, eachItem := range eachGroup.Toots {
+			publishedDate, _ := time.Parse(time.RFC3339, eachItem.Published)
+			bodyText := htmlToMarkup(eachItem.Object.Content, orgLink)
+			headingLevel := "*"
+			if tootIDs[eachItem.Object.InReplyTo] {
+				headingLevel = "**"
+			}
+			fmt.Fprintf(&orgBuilder, "\n%s %s %s\n", headingLevel, publishedDate.Format("15:04"), headerExcerpt(eachItem.Object.Content, cla.headerLength))
+			orgBuilder.WriteString(":PROPERTIES:\n")
+			fmt.Fprintf(&orgBuilder, ":ID: %s\n", eachItem.Object.ID)
+			fmt.Fprintf(&orgBuilder, ":URL: %s\n", eachItem.Object.URL)
+			fmt.Fprintf(&orgBuilder, ":PUBLISHED: %s\n", eachItem.Published)
+			orgBuilder.WriteString(":END:\n")
+			fmt.Fprintf(&orgBuilder, "%s\n", bodyText)
+			copiedCount, copyErr := copyTootAttachments(filteredOutbox, eachItem, mediaDirectory, log)
+			if copyErr != nil {
+				return copyErr
+			}
+			mediaFilesCount += copiedCount
+			for _, eachAttachment := range eachItem.Object.Attachments {
+				fmt.Fprintf(&orgBuilder, "\n%s\n", orgLink("file:media/"+eachAttachment.BaseFilename, eachAttachment.Name))
+			}
+			fmt.Fprintf(&orgBuilder, "\n%s\n", orgLink(eachItem.Object.URL, cla.messages["Source"]))
+		}
+		orgOutputPath := path.Join(outputRoot, eachGroup.Key+".org")
+		log.Debug("Rendering org file", "path", orgOutputPath, "tootCount", len(eachGroup.Toots))
+		writeErr := writeFileAtomic(orgOutputPath, []byte(orgBuilder.String()), 0600)
+		if writeErr != nil {
+			return writeErr
+		}
+	}
+	log.Info("Publishing statistics",
+		"totalTootCount", filteredOutbox.TotalItems,
+		"renderedTootCount", len(filteredOutbox.OrderedItems),
+		"orgFileCount", len(dayGroups),
+		"mediaFilesCount", mediaFilesCount)
+	return nil
+}
+
+// markdownLink renders an anchor as an inline markdown link
+func markdownLink(href string, text string) string {
+	if len(text) <= 0 {
+		text = href
+	}
+	return fmt.Sprintf("[%s](%s)", text, href)
+}
+
+// renderObsidianToDisk writes daily notes (YYYY-MM-DD.md) with a section per
+// toot. Each section carries a block ID so that thread parts can wiki-link to
+// each other across days. Media is copied to the attachments/ folder.
+func renderObsidianToDisk(cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	outputRoot := cla.outputRootPathHugoAssets
+	dayGroups, dayGroupsErr := groupToots(filteredOutbox.OrderedItems, publishedDayKey)
+	if dayGroupsErr != nil {
+		return dayGroupsErr
+	}
+	// Wiki-link targets for every toot, plus the self-replies to each toot
+	blockLinks := map[string]string{}
+	replyIDs := map[string][]string{}
+	for _, eachGroup := range dayGroups {
+		for _, eachItem := range eachGroup.Toots {
+			blockLinks[eachItem.Object.ID] = fmt.Sprintf("%s#^%s", eachGroup.Key, tootFileID(eachItem))
+			replyIDs[eachItem.Object.InReplyTo] = append(replyIDs[eachItem.Object.InReplyTo], eachItem.Object.ID)
+		}
+	}
+	attachmentsDirectory := path.Join(outputRoot, "attachments")
+	mediaFilesCount := uint(0)
+	for _, eachGroup := range dayGroups {
+		var noteBuilder strings.Builder
+		noteTags := []string{}
+		for _, eachItem := range eachGroup.Toots {
+			for _, eachTag := range eachItem.Object.Tags {
+				tagName := sanitizeTag(eachTag.Name, "_-/")
+				if eachTag.Type == "Hashtag" && !slices.Contains(noteTags, tagName) {
+					noteTags = append(noteTags, tagName)
+				}
+			}
+		}
+		noteBuilder.WriteString("---\n")
+		fmt.Fprintf(&noteBuilder, "date: %s\n", eachGroup.Key)
+		fmt.Fprintf(&noteBuilder, "tags: [%s]\n", strings.Join(noteTags, ", "))
+		noteBuilder.WriteString("source: mastodon\n")
+		noteBuilder.WriteString("---\n")
+		for _, eachItem := range eachGroup.Toots {
+			publishedDate, _ := time.Parse(time.RFC3339, eachItem.Published)
+			fmt.Fprintf(&noteBuilder, "\n## %s\n\n", publishedDate.Format("15:04"))
+			if parentLink, parentLinkExists := blockLinks[eachItem.Object.InReplyTo]; parentLinkExists {
+				fmt.Fprintf(&noteBuilder, "↩ [[%s|Previous in thread]]\n\n", parentLink)
+			}
+			fmt.Fprintf(&noteBuilder, "%s\n", htmlToMarkdown(eachItem.Object.Content))
+			copiedCount, copyErr := copyTootAttachments(filteredOutbox, eachItem, attachmentsDirectory, log)
+			if copyErr != nil {
+				return copyErr
+			}
+			mediaFilesCount += copiedCount
+			for _, eachAttachment := range eachItem.Object.Attachments {
+				fmt.Fprintf(&noteBuilder, "\n![[%s]]\n", eachAttachment.BaseFilename)
+			}
+			for _, eachReplyID := range replyIDs[eachItem.Object.ID] {
+				fmt.Fprintf(&noteBuilder, "\n↪ [[%s|Next in thread]]\n", blockLinks[eachReplyID])
+			}
+			fmt.Fprintf(&noteBuilder, "\n%s ^%s\n", markdownLink(eachItem.Object.URL, cla.messages["Source"]), tootFileID(eachItem))
+		}
+		noteOutputPath := path.Join(outputRoot, eachGroup.Key+".md")
+		log.Debug("Rendering daily note", "path", noteOutputPath, "tootCount", len(eachGroup.Toots))
+		writeErr := writeFileAtomic(noteOutputPath, []byte(noteBuilder.String()), 0600)
+		if writeErr != nil {
+			return writeErr
+		}
+	}
+	log.Info("Publishing statistics",
+		"totalTootCount", filteredOutbox.TotalItems,
+		"renderedTootCount", len(filteredOutbox.OrderedItems),
+		"dailyNoteCount", len(dayGroups),
+		"mediaFilesCount", mediaFilesCount)
+	return nil
+}
+
+// logseqTag renders a hashtag as a Logseq inline tag
+func logseqTag(tagName string) string {
+	if strings.ContainsAny(tagName, " \t") {
+		return fmt.Sprintf("#[[%s]]", tagName)
+	}
+	return "#" + tagName
+}
+
+// logseqBlock is a bullet of a Logseq page with the property and text lines
+// that follow it
+type logseqBlock struct {
+	depth  int
+	lines  []string
+	source string
+}
+
+// logseqChunk is a top level toot block of a journal page, with the blocks
+// of its replies, keyed by the toot's source:: URL
+type logseqChunk struct {
+	source string
+	text   string
+}
+
+// parseLogseqBlocks splits a Logseq page into the lines before the first
+// bullet and the bullets, nested by their tab indentation
+func parseLogseqBlocks(page string) ([]string, []*logseqBlock) {
+	preamble := []string{}
+	blocks := []*logseqBlock{}
+	for _, eachLine := range strings.Split(strings.TrimSuffix(page, "\n"), "\n") {
+		unindented := strings.TrimLeft(eachLine, "\t")
+		if unindented == "-" || strings.HasPrefix(unindented, "- ") {
+			blocks = append(blocks, &logseqBlock{
+				depth: len(eachLine) - len(unindented),
+				lines: []string{eachLine},
+			})
+			continue
+		}
+		if len(blocks) == 0 {
+			if len(eachLine) != 0 {
+				preamble = append(preamble, eachLine)
+			}
+			continue
+		}
+		currentBlock := blocks[len(blocks)-1]
+		currentBlock.lines = append(currentBlock.lines, eachLine)
+		if source, isSource := strings.CutPrefix(strings.TrimSpace(eachLine), "source:: "); isSource {
+			currentBlock.source = source
+		}
+	}
+	return preamble, blocks
+}
+
+// mergeLogseqJournal merges the toot chunks into an existing journal page.
+// Blocks whose source:: is one of the tootSources were written by an earlier
+// run and are replaced by their chunk, or removed. Every other block, and
+// the blocks nested below it, is kept where it is. Chunks that weren't on
+// the page are inserted before the next chunk in time order that was.
+func mergeLogseqJournal(page string, chunks []*logseqChunk, tootSources map[string]bool) string {
+	preamble, blocks := parseLogseqBlocks(page)
+	chunkIndexes := map[string]int{}
+	for eachIndex, eachChunk := range chunks {
+		chunkIndexes[eachChunk.source] = eachIndex
+	}
+	var pageBuilder strings.Builder
+	for _, eachLine := range preamble {
+		pageBuilder.WriteString(eachLine + "\n")
+	}
+	writtenChunks := map[int]bool{}
+	writeChunksUpTo := func(lastIndex int) {
+		for eachIndex := 0; eachIndex <= lastIndex; eachIndex++ {
+			if !writtenChunks[eachIndex] {
+				pageBuilder.WriteString(chunks[eachIndex].text)
+				writtenChunks[eachIndex] = true
+			}
+		}
+	}
+	// Nested blocks that weren't written by a run are kept below the chunk
+	// that replaces their parent
+	keptBelowDepth := -1
+	for _, eachBlock := range blocks {
+		if keptBelowDepth >= 0 && eachBlock.depth > keptBelowDepth {
+			pageBuilder.WriteString(strings.Join(eachBlock.lines, "\n") + "\n")
+			continue
+		}
+		keptBelowDepth = -1
+		if !tootSources[eachBlock.source] {
+			pageBuilder.WriteString(strings.Join(eachBlock.lines, "\n") + "\n")
+			keptBelowDepth = eachBlock.depth
+			continue
+		}
+		if chunkIndex, hasChunk := chunkIndexes[eachBlock.source]; hasChunk && eachBlock.depth == 0 {
+			writeChunksUpTo(chunkIndex)
+		}
+	}
+	writeChunksUpTo(len(chunks) - 1)
+	return pageBuilder.String()
+}
+
+// renderLogseqToDisk writes Logseq journal pages (journals/YYYY_MM_DD.md)
+// with a block per toot. Self-replies published on the same day are nested
+// below their parent block. Media is copied to the graph's assets/ folder.
+// Existing journal pages keep the blocks the toots weren't written to.
+func renderLogseqToDisk(cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	outputRoot := cla.outputRootPathHugoAssets
+	dayGroups, dayGroupsErr := groupToots(filteredOutbox.OrderedItems, publishedDayKey)
+	if dayGroupsErr != nil {
+		return dayGroupsErr
+	}
+	journalsDirectory := path.Join(outputRoot, "journals")
+	errDirectory := ensureDirectory(journalsDirectory, false, log)
+	if errDirectory != nil {
+		return errDirectory
+	}
+	assetsDirectory := path.Join(outputRoot, "assets")
+	mediaFilesCount := uint(0)
+	for _, eachGroup := range dayGroups {
+		var journalBuilder strings.Builder
+		chunks := []*logseqChunk{}
+		tootSources := map[string]bool{}
+		blockDepth := map[string]int{}
+		for _, eachItem := range eachGroup.Toots {
+			depth := 0
+			if parentDepth, parentExists := blockDepth[eachItem.Object.InReplyTo]; parentExists {
+				depth = parentDepth + 1
+			}
+			blockDepth[eachItem.Object.ID] = depth
+			if depth == 0 {
+				if len(chunks) != 0 {
+					chunks[len(chunks)-1].text = journalBuilder.String()
+				}
+				journalBuilder.Reset()
+				chunks = append(chunks, &logseqChunk{source: eachItem.Object.URL})
+			}
+			tootSources[eachItem.Object.URL] = true
+			bulletIndent := strings.Repeat("\t", depth)
+			blockIndent := bulletIndent + "  "
+
+			publishedDate, _ := time.Parse(time.RFC3339, eachItem.Published)
+			blockLines := strings.Split(htmlToMarkdown(eachItem.Object.Content), "\n")
+			tagNames := []string{}
+			for _, eachTag := range eachItem.Object.Tags {
+				if eachTag.Type == "Hashtag" {
+					tagNames = append(tagNames, logseqTag(eachTag.Name))
+				}
+			}
+			if len(tagNames) != 0 {
+				// Tags can't follow the closing fence of a code block
+				if strings.HasPrefix(blockLines[len(blockLines)-1], "```") {
+					blockLines = append(blockLines, strings.Join(tagNames, " "))
+				} else {
+					blockLines[len(blockLines)-1] += " " + strings.Join(tagNames, " ")
+				}
+			}
+			fmt.Fprintf(&journalBuilder, "%s- %s %s\n", bulletIndent, publishedDate.Format("15:04"), blockLines[0])
+			for _, eachLine := range blockLines[1:] {
+				if len(eachLine) != 0 {
+					fmt.Fprintf(&journalBuilder, "%s%s\n", blockIndent, eachLine)
+				}
+			}
+			copiedCount, copyErr := copyTootAttachments(filteredOutbox, eachItem, assetsDirectory, log)
+			if copyErr != nil {
+				return copyErr
+			}
+			mediaFilesCount += copiedCount
+			for _, eachAttachment := range eachItem.Object.Attachments {
+				fmt.Fprintf(&journalBuilder, "%s![%s](../assets/%s)\n", blockIndent, eachAttachment.Name, eachAttachment.BaseFilename)
+			}
+			fmt.Fprintf(&journalBuilder, "%ssource:: %s\n", blockIndent, eachItem.Object.URL)
+		}
+		chunks[len(chunks)-1].text = journalBuilder.String()
+		journalOutputPath := path.Join(journalsDirectory, strings.ReplaceAll(eachGroup.Key, "-", "_")+".md")
+		log.Debug("Rendering journal page", "path", journalOutputPath, "tootCount", len(eachGroup.Toots))
+		existingPage, existingPageErr := os.ReadFile(journalOutputPath)
+		if existingPageErr != nil && !os.IsNotExist(existingPageErr) {
+			return existingPageErr
+		}
+		writeErr := writeFileAtomic(journalOutputPath, []byte(mergeLogseqJournal(string(existingPage), chunks, tootSources)), 0600)
+		if writeErr != nil {
+			return writeErr
+		}
+	}
+	log.Info("Publishing statistics",
+		"totalTootCount", filteredOutbox.TotalItems,
+		"renderedTootCount", len(filteredOutbox.OrderedItems),
+		"journalPageCount", len(dayGroups),
+		"mediaFilesCount", mediaFilesCount)
+	return nil
+}
+
+// renderHugoDataToDisk treats the output root as the Hugo site root. It writes
+// one data/mastodon/YYYY-MM.json file per month, copies media to
+// static/mastodon/ and installs the companion mastodon-toots shortcode.
+func renderHugoDataToDisk(cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	outputRoot := cla.outputRootPathHugoAssets
+	dataDirectory := path.Join(outputRoot, "data", "mastodon")
+	staticDirectory := path.Join(outputRoot, "static", "mastodon")
+	shortcodeDirectory := path.Join(outputRoot, "layouts", "shortcodes")
+	for _, eachDirectory := range []string{dataDirectory, staticDirectory} {
+		errDirectory := ensureDirectory(eachDirectory, false, log)
+		if errDirectory != nil {
+			return errDirectory
+		}
+	}
+	monthGroups, monthGroupsErr := groupToots(filteredOutbox.OrderedItems, func(entry *ActivityEntry) (string, error) {
+		dayKey, dayKeyErr := publishedDayKey(entry)
+		if dayKeyErr != nil {
+			return "", dayKeyErr
+		}
+		return dayKey[:len("2006-01")], nil
+	})
+	if monthGroupsErr != nil {
+		return monthGroupsErr
+	}
+	mediaFilesCount := uint(0)
+	for _, eachGroup := range monthGroups {
+		monthData := HugoDataMonth{
+			Month: eachGroup.Key,
+			Toots: []*HugoDataToot{},
+		}
+		for _, eachItem := range eachGroup.Toots {
+			threadRootActivityItem, _, threadRootErr := filteredOutbox.threadRoot(eachItem)
+			if threadRootErr != nil {
+				return threadRootErr
+			}
+			bundlePath, bundlePathErr := tootBundlePath(threadRootActivityItem)
+			if bundlePathErr != nil {
+				return bundlePathErr
+			}
+			dataToot := &HugoDataToot{
+				ID:          tootFileID(eachItem),
+				URL:         eachItem.Object.URL,
+				Published:   eachItem.Published,
+				InReplyTo:   eachItem.Object.InReplyTo,
+				ThreadRoot:  tootFileID(threadRootActivityItem),
+				Content:     eachItem.Object.Content,
+				Tags:        []string{},
+				Attachments: []*HugoDataAttachment{},
+			}
+			for _, eachTag := range eachItem.Object.Tags {
+				if eachTag.Type == "Hashtag" {
+					dataToot.Tags = append(dataToot.Tags, eachTag.Name)
+				}
+			}
+			copiedCount, copyErr := copyTootAttachments(filteredOutbox, eachItem, path.Join(staticDirectory, bundlePath), log)
+			if copyErr != nil {
+				return copyErr
+			}
+			mediaFilesCount += copiedCount
+			for _, eachAttachment := range eachItem.Object.Attachments {
+				dataAttachment := &HugoDataAttachment{
+					URL:       "/" + path.Join("mastodon", bundlePath, eachAttachment.BaseFilename),
+					MediaType: eachAttachment.MediaType,
+					Name:      eachAttachment.Name,
+					Width:     eachAttachment.Width,
+					Height:    eachAttachment.Height,
+				}
+				if eachAttachment.HasFocalPoint() {
+					dataAttachment.FocalPoint = eachAttachment.FocalPoint
+				}
+				dataToot.Attachments = append(dataToot.Attachments, dataAttachment)
+			}
+			monthData.Toots = append(monthData.Toots, dataToot)
+		}
+		dataOutputPath := path.Join(dataDirectory, eachGroup.Key+".json")
+		log.Debug("Rendering data file", "path", dataOutputPath, "tootCount", len(monthData.Toots))
+		writeErr := writeJSONFile(dataOutputPath, monthData)
+		if writeErr != nil {
+			return writeErr
+		}
+	}
+	shortcodeErr := writeShortcode(shortcodeDirectory, "mastodon-toots.html", TEMPLATE_HUGO_DATA_SHORTCODE, log)
+	if shortcodeErr != nil {
+		return shortcodeErr
+	}
+	log.Info("Publishing statistics",
+		"totalTootCount", filteredOutbox.TotalItems,
+		"renderedTootCount", len(filteredOutbox.OrderedItems),
+		"dataFileCount", len(monthGroups),
+		"mediaFilesCount", mediaFilesCount)
+	return nil
+}
+
+// renderHTMLToDisk writes a self-contained HTML page for every thread, with
+// its media alongside, plus an index.html page linking to every thread
+func renderHTMLToDisk(cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	outputRoot := cla.outputRootPathHugoAssets
+	pageTemplate, pageTemplateErr := htmltemplate.New("htmlPage").Parse(TEMPLATE_HTML_PAGE)
+	if pageTemplateErr != nil {
+		return pageTemplateErr
+	}
+	indexTemplate, indexTemplateErr := htmltemplate.New("htmlIndex").Parse(TEMPLATE_HTML_INDEX)
+	if indexTemplateErr != nil {
+		return indexTemplateErr
+	}
+	orderedToots, orderedTootsErr := filteredOutbox.threadOrderedToots()
+	if orderedTootsErr != nil {
+		return orderedTootsErr
+	}
+	threadGroups, threadGroupsErr := groupToots(orderedToots, func(entry *ActivityEntry) (string, error) {
+		threadRootActivityItem, _, threadRootErr := filteredOutbox.threadRoot(entry)
+		if threadRootErr != nil {
+			return "", threadRootErr
+		}
+		return tootBundlePath(threadRootActivityItem)
+	})
+	if threadGroupsErr != nil {
+		return threadGroupsErr
+	}
+	type htmlToot struct {
+		Entry    *ActivityEntry
+		AnchorID string
+		Content  htmltemplate.HTML
+	}
+	type htmlLink struct {
+		Title string
+		Path  string
+	}
+	style := htmltemplate.CSS(TEMPLATE_HTML_STYLE)
+	indexLinks := []*htmlLink{}
+	mediaFilesCount := uint(0)
+	for _, eachGroup := range threadGroups {
+		pageDirectory := path.Join(outputRoot, eachGroup.Key)
+		errDirectory := ensureDirectory(pageDirectory, false, log)
+		if errDirectory != nil {
+			return errDirectory
+		}
+		pageToots := []*htmlToot{}
+		for _, eachItem := range eachGroup.Toots {
+			pageToots = append(pageToots, &htmlToot{
+				Entry:    eachItem,
+				AnchorID: tootAnchorID(eachItem),
+				// Toot content comes from the archive owner's own server
+				Content: htmltemplate.HTML(eachItem.Object.Content),
+			})
+			copiedCount, copyErr := copyTootAttachments(filteredOutbox, eachItem, pageDirectory, log)
+			if copyErr != nil {
+				return copyErr
+			}
+			mediaFilesCount += copiedCount
+		}
+		pageTitle := tootAnchorTitle(eachGroup.Toots[0], cla.headerLength)
+		var pageBuffer bytes.Buffer
+		pageErr := pageTemplate.Execute(&pageBuffer, map[string]interface{}{
+			"Title":     pageTitle,
+			"Style":     style,
+			"IndexPath": strings.Repeat("../", strings.Count(eachGroup.Key, "/")+1) + "index.html",
+			"Toots":     pageToots,
+			"Lang":      cla.lang,
+			"Messages":  cla.messages,
+		})
+		if pageErr != nil {
+			return pageErr
+		}
+		pageOutputPath := path.Join(pageDirectory, "index.html")
+		log.Debug("Rendering HTML page", "path", pageOutputPath, "tootCount", len(pageToots))
+		writeErr := writeFileAtomic(pageOutputPath, pageBuffer.Bytes(), 0644)
+		if writeErr != nil {
+			return writeErr
+		}
+		indexLinks = append(indexLinks, &htmlLink{
+			Title: pageTitle,
+			Path:  path.Join(eachGroup.Key, "index.html"),
+		})
+	}
+	// Newest first on the index page
+	slices.Reverse(indexLinks)
+	var indexBuffer bytes.Buffer
+	indexErr := indexTemplate.Execute(&indexBuffer, map[string]interface{}{
+		"Title": fmt.Sprintf("Mastodon - @%s@%s", USER, HOST),
+		"Style": style,
+		"Links": indexLinks,
+		"Lang":  cla.lang,
+	})
+	if indexErr != nil {
+		return indexErr
+	}
+	writeErr := writeFileAtomic(path.Join(outputRoot, "index.html"), indexBuffer.Bytes(), 0644)
+	if writeErr != nil {
+		return writeErr
+	}
+	log.Info("Publishing statistics",
+		"totalTootCount", filteredOutbox.TotalItems,
+		"renderedTootCount", len(filteredOutbox.OrderedItems),
+		"htmlPageCount", len(threadGroups),
+		"mediaFilesCount", mediaFilesCount)
+	return nil
+}
+
+// GEMTEXT_LINE_PREFIXES are the line prefixes that give a gemtext line a
+// type other than text
+var GEMTEXT_LINE_PREFIXES = []string{"=>", "#", "*", ">", "```"}
+
+// escapeGemtextText indents the toot text lines that would otherwise be read
+// as gemtext links, headings, list items, quotes or preformatting toggles
+func escapeGemtextText(text string) string {
+	textLines := strings.Split(text, "\n")
+	for eachIndex, eachLine := range textLines {
+		if slices.ContainsFunc(GEMTEXT_LINE_PREFIXES, func(prefix string) bool {
+			return strings.HasPrefix(eachLine, prefix)
+		}) {
+			textLines[eachIndex] = " " + eachLine
+		}
+	}
+	return strings.Join(textLines, "\n")
+}
+
+// gemtextLinkLine returns a "=> URL text" line. Whitespace runs in the text,
+// including newlines from attachment descriptions, become single spaces.
+func gemtextLinkLine(linkURL string, text string) string {
+	return strings.TrimSpace(fmt.Sprintf("=> %s %s",
+		strings.Join(strings.Fields(linkURL), "%20"),
+		strings.Join(strings.Fields(text), " ")))
+}
+
+// renderGemtextToDisk writes one gemtext file per day (YYYY-MM-DD.gmi) plus
+// an index.gmi. Gemtext has no inline links, so the links in each toot are
+// listed as link lines after the toot text. Media is copied to media/.
+func renderGemtextToDisk(cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	outputRoot := cla.outputRootPathHugoAssets
+	dayGroups, dayGroupsErr := groupToots(filteredOutbox.OrderedItems, publishedDayKey)
+	if dayGroupsErr != nil {
+		return dayGroupsErr
+	}
+	mediaDirectory := path.Join(outputRoot, "media")
+	mediaFilesCount := uint(0)
+	var indexBuilder strings.Builder
+	fmt.Fprintf(&indexBuilder, "# Mastodon - @%s@%s\n\n", USER, HOST)
+	for _, eachGroup := range dayGroups {
+		var gemtextBuilder strings.Builder
+		fmt.Fprintf(&gemtextBuilder, "# Mastodon - %s\n", eachGroup.Key)
+		for _, eachItem := range eachGroup.Toots {
+			publishedDate, _ := parsePublished(eachItem)
+			linkLines := []string{}
+			bodyText := htmlToMarkup(eachItem.Object.Content, func(href string, text string) string {
+				linkLines = append(linkLines, gemtextLinkLine(href, text))
+				return text
+			})
+			copiedCount, copyErr := copyTootAttachments(filteredOutbox, eachItem, mediaDirectory, log)
+			if copyErr != nil {
+				return copyErr
+			}
+			mediaFilesCount += copiedCount
+			for _, eachAttachment := range eachItem.Object.Attachments {
+				attachmentTitle := eachAttachment.Name
+				if len(attachmentTitle) <= 0 {
+					attachmentTitle = eachAttachment.MediaType
+				}
+				linkLines = append(linkLines, gemtextLinkLine("media/"+eachAttachment.BaseFilename, attachmentTitle))
+			}
+			linkLines = append(linkLines, gemtextLinkLine(eachItem.Object.URL, cla.messages["Source"]))
+			fmt.Fprintf(&gemtextBuilder, "\n## %s\n\n%s\n\n%s\n",
+				publishedDate.Format("15:04"),
+				escapeGemtextText(bodyText),
+				strings.Join(linkLines, "\n"))
+		}
+		gemtextOutputPath := path.Join(outputRoot, eachGroup.Key+".gmi")
+		log.Debug("Rendering gemtext file", "path", gemtextOutputPath, "tootCount", len(eachGroup.Toots))
+		writeErr := writeFileAtomic(gemtextOutputPath, []byte(gemtextBuilder.String()), 0644)
+		if writeErr != nil {
+			return writeErr
+		}
+		fmt.Fprintf(&indexBuilder, "=> %s.gmi %s (%d)\n", eachGroup.Key, eachGroup.Key, len(eachGroup.Toots))
+	}
+	writeErr := writeFileAtomic(path.Join(outputRoot, "index.gmi"), []byte(indexBuilder.String()), 0644)
+	if writeErr != nil {
+		return writeErr
+	}
+	log.Info("Publishing statistics",
+		"totalTootCount", filteredOutbox.TotalItems,
+		"renderedTootCount", len(filteredOutbox.OrderedItems),
+		"gemtextFileCount", len(dayGroups),
+		"mediaFilesCount", mediaFilesCount)
+	return nil
+}
+
+// renderEPUBToDisk compiles each year of toots into a single EPUB 3 file
+// (mastodon-YYYY.epub) with a chapter per month and embedded images
+func renderEPUBToDisk(cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	outputRoot := cla.outputRootPathHugoAssets
+	orderedToots, orderedTootsErr := filteredOutbox.threadOrderedToots()
+	if orderedTootsErr != nil {
+		return orderedTootsErr
+	}
+	threadRootDate := func(entry *ActivityEntry) (time.Time, error) {
+		threadRootActivityItem, _, threadRootErr := filteredOutbox.threadRoot(entry)
+		if threadRootErr != nil {
+			return time.Time{}, threadRootErr
+		}
+		return parsePublished(threadRootActivityItem)
+	}
+	yearGroups, yearGroupsErr := groupToots(orderedToots, func(entry *ActivityEntry) (string, error) {
+		parsedDate, parsedDateErr := threadRootDate(entry)
+		return parsedDate.Format("2006"), parsedDateErr
+	})
+	if yearGroupsErr != nil {
+		return yearGroupsErr
+	}
+	// Images are processed in a staging directory before they're embedded
+	stagingRoot, stagingErr := os.MkdirTemp("", "mastodon-to-hugo-media-")
+	if stagingErr != nil {
+		return stagingErr
+	}
+	defer os.RemoveAll(stagingRoot)
+	for _, eachYear := range yearGroups {
+		modifiedTime := cla.generatedTime(eachYear.Toots).UTC().Format("2006-01-02T15:04:05Z")
+		monthGroups, monthGroupsErr := groupToots(eachYear.Toots, func(entry *ActivityEntry) (string, error) {
+			parsedDate, parsedDateErr := threadRootDate(entry)
+			return parsedDate.Format("January 2006"), parsedDateErr
+		})
+		if monthGroupsErr != nil {
+			return monthGroupsErr
+		}
+		epubOutputPath := path.Join(outputRoot, fmt.Sprintf("mastodon-%s.epub", eachYear.Key))
+		epubFile, epubFileErr := createAtomicFile(epubOutputPath, 0644)
+		if epubFileErr != nil {
+			return epubFileErr
+		}
+		defer epubFile.discard()
+		zipWriter := zip.NewWriter(epubFile)
+
+		// The mimetype entry must be first and uncompressed
+		mimetypeWriter, mimetypeWriterErr := zipWriter.CreateHeader(&zip.FileHeader{
+			Name:   "mimetype",
+			Method: zip.Store,
+		})
+		if mimetypeWriterErr != nil {
+			return mimetypeWriterErr
+		}
+		io.WriteString(mimetypeWriter, "application/epub+zip")
+		writeEntry := func(entryName string, entryContents []byte) error {
+			entryWriter, entryWriterErr := zipWriter.Create(entryName)
+			if entryWriterErr != nil {
+				return entryWriterErr
+			}
+			_, writeErr := entryWriter.Write(entryContents)
+			return writeErr
+		}
+		containerXML := xml.Header + `<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>
+`
+		if err := writeEntry("META-INF/container.xml", []byte(containerXML)); err != nil {
+			return err
+		}
+		var manifestBuilder strings.Builder
+		var spineBuilder strings.Builder
+		var navBuilder strings.Builder
+		mediaCount := 0
+		for chapterIndex, eachMonth := range monthGroups {
+			chapterName := fmt.Sprintf("chapter-%.2d", chapterIndex+1)
+			var chapterBuilder strings.Builder
+			fmt.Fprintf(&chapterBuilder, "<h1>%s</h1>\n", xmlEscapeString(eachMonth.Key))
+			for _, eachItem := range eachMonth.Toots {
+				publishedDate, _ := parsePublished(eachItem)
+				fmt.Fprintf(&chapterBuilder, "<section id=\"%s\">\n<h2>%s</h2>\n%s\n",
+					tootAnchorID(eachItem),
+					publishedDate.Format("2006-01-02 15:04"),
+					htmlToXHTML(eachItem.Object.Content))
+				for _, eachAttachment := range eachItem.Object.Attachments {
+					if !strings.HasPrefix(eachAttachment.MediaType, "image/") {
+						fmt.Fprintf(&chapterBuilder, "<p>[%s: %s]</p>\n",
+							xmlEscapeString(eachAttachment.MediaType),
+							xmlEscapeString(eachAttachment.Name))
+						continue
+					}
+					mediaBytes, mediaBytesErr := stagedMediaBytes(filteredOutbox, eachItem, eachAttachment, stagingRoot, log)
+					if mediaBytesErr != nil {
+						return mediaBytesErr
+					}
+					mediaCount += 1
+					mediaHref := "media/" + eachAttachment.BaseFilename
+					if err := writeEntry("OEBPS/"+mediaHref, mediaBytes); err != nil {
+						return err
+					}
+					fmt.Fprintf(&manifestBuilder, "<item id=\"media-%d\" href=\"%s\" media-type=\"%s\"/>\n",
+						mediaCount,
+						xmlEscapeString(mediaHref),
+						xmlEscapeString(eachAttachment.MediaType))
+					fmt.Fprintf(&chapterBuilder, "<img src=\"%s\" alt=\"%s\"/>\n",
+						xmlEscapeString(mediaHref),
+						xmlEscapeString(eachAttachment.Name))
+				}
+				fmt.Fprintf(&chapterBuilder, "<p><a href=\"%s\">%s</a></p>\n</section>\n",
+					xmlEscapeString(eachItem.Object.URL),
+					xmlEscapeString(cla.messages["Source"]))
+			}
+			chapterXHTML := epubXHTMLDocument(eachMonth.Key, chapterBuilder.String())
+			if err := writeEntry("OEBPS/"+chapterName+".xhtml", []byte(chapterXHTML)); err != nil {
+				return err
+			}
+			fmt.Fprintf(&manifestBuilder, "<item id=\"%s\" href=\"%s.xhtml\" media-type=\"application/xhtml+xml\"/>\n", chapterName, chapterName)
+			fmt.Fprintf(&spineBuilder, "<itemref idref=\"%s\"/>\n", chapterName)
+			fmt.Fprintf(&navBuilder, "<li><a href=\"%s.xhtml\">%s</a></li>\n", chapterName, xmlEscapeString(eachMonth.Key))
+		}
+		bookTitle := fmt.Sprintf("Mastodon - @%s@%s - %s", USER, HOST, eachYear.Key)
+		navXHTML := epubXHTMLDocument(bookTitle,
+			fmt.Sprintf("<nav epub:type=\"toc\" id=\"toc\"><h1>%s</h1><ol>\n%s</ol></nav>", xmlEscapeString(bookTitle), navBuilder.String()))
+		if err := writeEntry("OEBPS/nav.xhtml", []byte(navXHTML)); err != nil {
+			return err
+		}
+		contentOPF := xml.Header + fmt.Sprintf(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="bookid">urn:mastodon:%s:%s:%s</dc:identifier>
+<dc:title>%s</dc:title>
+<dc:language>%s</dc:language>
+<dc:creator>%s</dc:creator>
+<meta property="dcterms:modified">%s</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+%s</manifest>
+<spine>
+%s</spine>
+</package>
+`, HOST, USER, eachYear.Key, xmlEscapeString(bookTitle), xmlEscapeString(cla.lang), xmlEscapeString(USER), modifiedTime, manifestBuilder.String(), spineBuilder.String())
+		if err := writeEntry("OEBPS/content.opf", []byte(contentOPF)); err != nil {
+			return err
+		}
+		if err := zipWriter.Close(); err != nil {
+			return err
+		}
+		if err := epubFile.commit(); err != nil {
+			return err
+		}
+		log.Info("Wrote EPUB yearbook",
+			"path", epubOutputPath,
+			"tootCount", len(eachYear.Toots),
+			"chapterCount", len(monthGroups),
+			"imageCount", mediaCount)
+	}
+	return nil
+}
+
+// epubXHTMLDocument wraps the body markup in an EPUB 3 XHTML document
+func epubXHTMLDocument(title string, body string) string {
+	return xml.Header + fmt.Sprintf(`<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>%s</title></head>
+<body>
+%s
+</body>
+</html>
+`, xmlEscapeString(title), body)
+}
+
+// ghostMediaDirectory returns the content/ directory the Ghost importer
+// expects for the attachment's media type
+func ghostMediaDirectory(mediaType string) string {
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return "images"
+	case strings.HasPrefix(mediaType, "video/"), strings.HasPrefix(mediaType, "audio/"):
+		return "media"
+	default:
+		return "files"
+	}
+}
+
+// renderGhostToDisk writes ghost-import.json with one post per thread. Each
+// post body is a single lexical HTML card. Media is copied to
+// content/{images,media,files}/YYYY/MM so the output directory can be zipped
+// and uploaded to Ghost's importer as-is.
+func renderGhostToDisk(cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	outputRoot := cla.outputRootPathHugoAssets
+	orderedToots, orderedTootsErr := filteredOutbox.threadOrderedToots()
+	if orderedTootsErr != nil {
+		return orderedTootsErr
+	}
+	threadGroups, threadGroupsErr := groupToots(orderedToots, func(entry *ActivityEntry) (string, error) {
+		threadRootActivityItem, _, threadRootErr := filteredOutbox.threadRoot(entry)
+		if threadRootErr != nil {
+			return "", threadRootErr
+		}
+		return threadRootActivityItem.Object.ID, nil
+	})
+	if threadGroupsErr != nil {
+		return threadGroupsErr
+	}
+	ghostData := &GhostData{
+		Posts:     []*GhostPost{},
+		Tags:      []*GhostTag{},
+		PostsTags: []*GhostPostTag{},
+	}
+	tagIDs := map[string]int{}
+	mediaFilesCount := uint(0)
+	for postIndex, eachGroup := range threadGroups {
+		postID := postIndex + 1
+		threadRootActivityItem := eachGroup.Toots[0]
+		var htmlBuilder strings.Builder
+		for _, eachItem := range eachGroup.Toots {
+			parsedDate, parsedDateErr := parsePublished(eachItem)
+			if parsedDateErr != nil {
+				return parsedDateErr
+			}
+			htmlBuilder.WriteString(eachItem.Object.Content)
+			for _, eachAttachment := range eachItem.Object.Attachments {
+				mediaDirectory := path.Join("content",
+					ghostMediaDirectory(eachAttachment.MediaType),
+					parsedDate.Format("2006"),
+					parsedDate.Format("01"))
+				copyErr := copyAttachment(filteredOutbox, eachItem, eachAttachment, path.Join(outputRoot, mediaDirectory), log)
+				if copyErr != nil {
+					return copyErr
+				}
+				mediaFilesCount += 1
+				mediaURL := "/" + path.Join(mediaDirectory, eachAttachment.BaseFilename)
+				switch ghostMediaDirectory(eachAttachment.MediaType) {
+				case "images":
+					fmt.Fprintf(&htmlBuilder, "<img src=\"%s\" alt=\"%s\">", mediaURL, htmltemplate.HTMLEscapeString(eachAttachment.Name))
+				case "media":
+					mediaElement := "video"
+					if strings.HasPrefix(eachAttachment.MediaType, "audio/") {
+						mediaElement = "audio"
+					}
+					fmt.Fprintf(&htmlBuilder, "<%s controls src=\"%s\"></%s>", mediaElement, mediaURL, mediaElement)
+				default:
+					fmt.Fprintf(&htmlBuilder, "<a href=\"%s\">%s</a>", mediaURL, htmltemplate.HTMLEscapeString(eachAttachment.BaseFilename))
+				}
+			}
+			fmt.Fprintf(&htmlBuilder, "<p><a href=\"%s\">%s</a></p>", eachItem.Object.URL, xmlEscapeString(cla.messages["Source"]))
+			for _, eachTag := range eachItem.Object.Tags {
+				slug := tagSlug(eachTag.Name)
+				if eachTag.Type != "Hashtag" || len(slug) <= 0 {
+					continue
+				}
+				tagID, tagIDExists := tagIDs[slug]
+				if !tagIDExists {
+					tagID = len(tagIDs) + 1
+					tagIDs[slug] = tagID
+					ghostData.Tags = append(ghostData.Tags, &GhostTag{ID: tagID, Name: eachTag.Name, Slug: slug})
+				}
+				postTag := &GhostPostTag{PostID: postID, TagID: tagID}
+				if !slices.ContainsFunc(ghostData.PostsTags, func(existing *GhostPostTag) bool { return *existing == *postTag }) {
+					ghostData.PostsTags = append(ghostData.PostsTags, postTag)
+				}
+			}
+		}
+		lexicalBytes, lexicalBytesErr := json.Marshal(map[string]interface{}{
+			"root": map[string]interface{}{
+				"children": []interface{}{
+					map[string]interface{}{
+						"type":    "html",
+						"version": 1,
+						"html":    htmlBuilder.String(),
+					},
+				},
+				"direction": nil,
+				"format":    "",
+				"indent":    0,
+				"type":      "root",
+				"version":   1,
+			},
+		})
+		if lexicalBytesErr != nil {
+			return lexicalBytesErr
+		}
+		title := truncateText(htmlToText(threadRootActivityItem.Object.Content), 80)
+		if len(title) <= 0 {
+			title = fmt.Sprintf("Mastodon - %s", threadRootActivityItem.Published)
+		}
+		ghostData.Posts = append(ghostData.Posts, &GhostPost{
+			ID:           postID,
+			Title:        title,
+			Slug:         "mastodon-" + tootFileID(threadRootActivityItem),
+			Lexical:      string(lexicalBytes),
+			Status:       "published",
+			Type:         "post",
+			CanonicalURL: threadRootActivityItem.Object.URL,
+			PublishedAt:  threadRootActivityItem.Published,
+			CreatedAt:    threadRootActivityItem.Published,
+			UpdatedAt:    pageLastModified(eachGroup.Toots),
+		})
+	}
+	ghostImport := GhostImport{
+		DB: []*GhostDB{
+			{
+				Meta: &GhostMeta{
+					ExportedOn: cla.generatedTime(filteredOutbox.OrderedItems).UnixMilli(),
+					Version:    "5.0.0",
+				},
+				Data: ghostData,
+			},
+		},
+	}
+	importOutputPath := path.Join(outputRoot, "ghost-import.json")
+	writeErr := writeJSONFile(importOutputPath, ghostImport)
+	if writeErr != nil {
+		return writeErr
+	}
+	log.Info("Publishing statistics",
+		"totalTootCount", filteredOutbox.TotalItems,
+		"renderedTootCount", len(filteredOutbox.OrderedItems),
+		"ghostPostCount", len(ghostData.Posts),
+		"ghostTagCount", len(ghostData.Tags),
+		"mediaFilesCount", mediaFilesCount,
+		"path", importOutputPath)
+	return nil
+}
+
+// dayOneIdentifier returns the 32 character uppercase hex identifier Day One
+// uses for entries and photos, derived from the given seed
+func dayOneIdentifier(seed string) string {
+	return strings.ToUpper(fmt.Sprintf("%x", md5.Sum([]byte(seed))))
+}
+
+// renderDayOneToDisk writes mastodon-dayone.zip, a Day One import archive
+// holding a Mastodon.json journal with an entry per toot and the image
+// attachments under photos/. Entries have no location.
+func renderDayOneToDisk(cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	outputRoot := cla.outputRootPathHugoAssets
+	zipOutputPath := path.Join(outputRoot, "mastodon-dayone.zip")
+	zipFile, zipFileErr := createAtomicFile(zipOutputPath, 0644)
+	if zipFileErr != nil {
+		return zipFileErr
+	}
+	defer zipFile.discard()
+	zipWriter := zip.NewWriter(zipFile)
+	// Photos are processed in a staging directory before they're embedded
+	stagingRoot, stagingErr := os.MkdirTemp("", "mastodon-to-hugo-media-")
+	if stagingErr != nil {
+		return stagingErr
+	}
+	defer os.RemoveAll(stagingRoot)
+
+	journal := DayOneJournal{
+		Metadata: map[string]string{"version": "1.0"},
+		Entries:  []*DayOneEntry{},
+	}
+	photoCount := 0
+	writtenPhotos := map[string]bool{}
+	for _, eachItem := range filteredOutbox.OrderedItems {
+		entry := &DayOneEntry{
+			UUID:         dayOneIdentifier(eachItem.Object.ID),
+			CreationDate: eachItem.Published,
+			ModifiedDate: eachItem.Published,
+			TimeZone:     "UTC",
+			Tags:         []string{},
+			Photos:       []*DayOnePhoto{},
+		}
+		var textBuilder strings.Builder
+		textBuilder.WriteString(htmlToMarkdown(eachItem.Object.Content))
+		for _, eachTag := range eachItem.Object.Tags {
+			if eachTag.Type == "Hashtag" {
+				entry.Tags = append(entry.Tags, eachTag.Name)
+			}
+		}
+		for _, eachAttachment := range eachItem.Object.Attachments {
+			if !strings.HasPrefix(eachAttachment.MediaType, "image/") {
+				fmt.Fprintf(&textBuilder, "\n\n[%s: %s]", eachAttachment.MediaType, eachAttachment.Name)
+				continue
+			}
+			photoBytes, photoBytesErr := stagedMediaBytes(filteredOutbox, eachItem, eachAttachment, stagingRoot, log)
+			if photoBytesErr != nil {
+				return photoBytesErr
+			}
+			photo := &DayOnePhoto{
+				Identifier:   dayOneIdentifier(eachItem.Object.ID + eachAttachment.URL),
+				MD5:          fmt.Sprintf("%x", md5.Sum(photoBytes)),
+				Type:         strings.TrimPrefix(path.Ext(eachAttachment.BaseFilename), "."),
+				Width:        eachAttachment.Width,
+				Height:       eachAttachment.Height,
+				OrderInEntry: len(entry.Photos),
+			}
+			// Photos are stored by content hash, so identical images are
+			// only written once
+			photoName := fmt.Sprintf("photos/%s.%s", photo.MD5, photo.Type)
+			if !writtenPhotos[photoName] {
+				photoWriter, photoWriterErr := zipWriter.Create(photoName)
+				if photoWriterErr != nil {
+					return photoWriterErr
+				}
+				if _, err := photoWriter.Write(photoBytes); err != nil {
+					return err
+				}
+				writtenPhotos[photoName] = true
+			}
+			fmt.Fprintf(&textBuilder, "\n\n![](dayone-moment://%s)", photo.Identifier)
+			entry.Photos = append(entry.Photos, photo)
+			photoCount += 1
+		}
+		fmt.Fprintf(&textBuilder, "\n\n%s", markdownLink(eachItem.Object.URL, cla.messages["Source"]))
+		entry.Text = textBuilder.String()
+		journal.Entries = append(journal.Entries, entry)
+	}
+	journalBytes, journalBytesErr := marshalJSON(journal)
+	if journalBytesErr != nil {
+		return journalBytesErr
+	}
+	journalWriter, journalWriterErr := zipWriter.Create("Mastodon.json")
+	if journalWriterErr != nil {
+		return journalWriterErr
+	}
+	if _, err := journalWriter.Write(journalBytes); err != nil {
+		return err
+	}
+	if err := zipWriter.Close(); err != nil {
+		return err
+	}
+	if err := zipFile.commit(); err != nil {
+		return err
+	}
+	log.Info("Publishing statistics",
+		"totalTootCount", filteredOutbox.TotalItems,
+		"renderedTootCount", len(filteredOutbox.OrderedItems),
+		"dayOneEntryCount", len(journal.Entries),
+		"photoCount", photoCount,
+		"path", zipOutputPath)
+	return nil
+}
+
+// SearchDocument is a single toot in the search index. The fields follow the
+// Lunr document conventions, with the ref in the id field.
+type SearchDocument struct {
+	ID      string   `json:"id"`
+	URL     string   `json:"url"`
+	Title   string   `json:"title"`
+	Content string   `json:"content"`
+	Tags    []string `json:"tags"`
+	Date    string   `json:"date"`
+}
+
+// writeSearchIndex writes the search documents for every rendered toot and,
+// when a shortcodes directory is given, the mastodon-search shortcode
+func writeSearchIndex(outputPath string, cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	searchDocuments := []*SearchDocument{}
+	for _, eachItem := range filteredOutbox.OrderedItems {
+		permalink, permalinkErr := cla.tootPermalink(filteredOutbox, eachItem)
+		if permalinkErr != nil {
+			return permalinkErr
+		}
+		plainText := htmlToText(eachItem.Object.Content)
+		searchDocument := &SearchDocument{
+			ID:      tootFileID(eachItem),
+			URL:     permalink,
+			Title:   truncateText(plainText, 80),
+			Content: plainText,
+			Tags:    []string{},
+			Date:    eachItem.Published,
+		}
+		for _, eachTag := range eachItem.Object.Tags {
+			if eachTag.Type == "Hashtag" {
+				searchDocument.Tags = append(searchDocument.Tags, eachTag.Name)
+			}
+		}
+		searchDocuments = append(searchDocuments, searchDocument)
+	}
+	log.Info("Writing search index", "path", outputPath, "documentCount", len(searchDocuments))
+	writeErr := writeJSONFile(outputPath, searchDocuments)
+	if writeErr != nil {
+		return writeErr
+	}
+	if len(cla.shortcodesDirectory) != 0 {
+		return writeShortcode(cla.shortcodesDirectory, "mastodon-search.html", TEMPLATE_SEARCH_SHORTCODE, log)
+	}
+	return nil
+}
+
+// writeActivityPubObjects writes an ActivityStreams Note for every rendered toot
+// into its page bundle, so Hugo publishes it at <page>/<id>.json, and an
+// activitypub.json index mapping the original IDs to the new URLs
+func writeActivityPubObjects(cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	outputRoot := cla.outputRootPathHugoAssets
+	noteIDs := map[string]string{}
+	for _, eachItem := range filteredOutbox.OrderedItems {
+		bundlePath, bundlePathErr := cla.pageBundlePath(filteredOutbox, eachItem)
+		if bundlePathErr != nil {
+			return bundlePathErr
+		}
+		sectionURL := cla.languageSectionURL(cla.tootLanguage(filteredOutbox, eachItem))
+		noteIDs[eachItem.Object.ID] = fmt.Sprintf("%s%s%s/%s.json", cla.baseURL, sectionURL, bundlePath, tootFileID(eachItem))
+	}
+	mappings := []*ActivityPubMapping{}
+	for _, eachItem := range filteredOutbox.OrderedItems {
+		bundlePath, bundlePathErr := cla.pageBundlePath(filteredOutbox, eachItem)
+		if bundlePathErr != nil {
+			return bundlePathErr
+		}
+		permalink, permalinkErr := cla.tootPermalink(filteredOutbox, eachItem)
+		if permalinkErr != nil {
+			return permalinkErr
+		}
+		language := cla.tootLanguage(filteredOutbox, eachItem)
+		note := &ActivityPubNote{
+			Context:      "https://www.w3.org/ns/activitystreams",
+			ID:           noteIDs[eachItem.Object.ID],
+			Type:         eachItem.Object.Type,
+			URL:          cla.baseURL + permalink,
+			AttributedTo: fmt.Sprintf("https://%s/users/%s", HOST, USER),
+			InReplyTo:    eachItem.Object.InReplyTo,
+			Published:    eachItem.Object.Published,
+			To:           eachItem.Object.To,
+			CC:           eachItem.Object.CC,
+			Content:      eachItem.Object.Content,
+			Attachments:  []*ActivityPubAttachment{},
+			Tags:         eachItem.Object.Tags,
+		}
+		// Replies within the archive point at the static copy of the parent
+		if replyToID, replyToExists := noteIDs[eachItem.Object.InReplyTo]; replyToExists {
+			note.InReplyTo = replyToID
+		}
+		for _, eachAttachment := range eachItem.Object.Attachments {
+			note.Attachments = append(note.Attachments, &ActivityPubAttachment{
+				Type:      eachAttachment.Type,
+				MediaType: eachAttachment.MediaType,
+				URL:       fmt.Sprintf("%s%s%s/%s", cla.baseURL, cla.languageSectionURL(language), bundlePath, eachAttachment.BaseFilename),
+				Name:      eachAttachment.Name,
+				Width:     eachAttachment.Width,
+				Height:    eachAttachment.Height,
+			})
+		}
+		bundleDirectory := path.Join(cla.languageOutputRoot(language), bundlePath)
+		errDirectory := ensureDirectory(bundleDirectory, false, log)
+		if errDirectory != nil {
+			return errDirectory
+		}
+		notePath := path.Join(bundleDirectory, tootFileID(eachItem)+".json")
+		log.Debug("Writing ActivityPub object", "id", eachItem.ID, "path", notePath)
+		writeErr := writeJSONFile(notePath, note)
+		if writeErr != nil {
+			return writeErr
+		}
+		mappings = append(mappings, &ActivityPubMapping{
+			OriginalID:  eachItem.Object.ID,
+			OriginalURL: eachItem.Object.URL,
+			ID:          note.ID,
+			URL:         note.URL,
+		})
+	}
+	indexPath := path.Join(outputRoot, "activitypub.json")
+	log.Info("Writing ActivityPub objects", "path", indexPath, "objectCount", len(mappings))
+	return writeJSONFile(indexPath, mappings)
+}
+
+// writeRedirects writes a redirect rule from both the status page path
+// (/@user/<id>) and the ActivityPub object path (/users/user/statuses/<id>) of
+// every rendered toot to its Hugo permalink
+func writeRedirects(outputPath string, cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	redirectSyntax := REDIRECT_FORMATS[cla.redirectsFormat]
+	redirectLines := []string{}
+	if len(redirectSyntax.header) != 0 {
+		redirectLines = append(redirectLines, redirectSyntax.header)
+	}
+	ruleCount := 0
+	for _, eachItem := range filteredOutbox.OrderedItems {
+		permalink, permalinkErr := cla.tootPermalink(filteredOutbox, eachItem)
+		if permalinkErr != nil {
+			return permalinkErr
+		}
+		// Targets are site relative unless --base-url is set
+		permalink = cla.baseURL + permalink
+		for _, eachSourceURL := range []string{eachItem.Object.URL, eachItem.Object.ID} {
+			sourceURL, sourceURLErr := url.Parse(eachSourceURL)
+			if sourceURLErr != nil || len(sourceURL.Path) <= 1 {
+				continue
+			}
+			redirectLines = append(redirectLines, redirectSyntax.rule(sourceURL.Path, permalink))
+			ruleCount += 1
+		}
+	}
+	if len(redirectSyntax.footer) != 0 {
+		redirectLines = append(redirectLines, redirectSyntax.footer)
+	}
+	log.Info("Writing redirects", "path", outputPath, "format", cla.redirectsFormat, "ruleCount", ruleCount)
+	return writeFileAtomic(outputPath, []byte(strings.Join(redirectLines, "\n")+"\n"), 0644)
+}
+
+// SkipReportEntry is a line of the --report file
+type SkipReportEntry struct {
+	ID        string `json:"id"`
+	URL       string `json:"url,omitempty"`
+	Type      string `json:"type"`
+	Published string `json:"published"`
+	Filter    string `json:"filter"`
+	// The --filters rule number, or 0 for the default action
+	Rule *int `json:"rule,omitempty"`
+}
+
+// writeSkipReport writes a JSON Lines record for every toot the filters
+// removed, in filter order
+func writeSkipReport(outputPath string, cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	var reportBuffer bytes.Buffer
+	reportEncoder := json.NewEncoder(&reportBuffer)
+	reportEncoder.SetEscapeHTML(false)
+	for _, eachSkipped := range filteredOutbox.SkippedToots {
+		reportEntry := &SkipReportEntry{
+			ID:        eachSkipped.entry.ID,
+			Type:      eachSkipped.entry.Type,
+			Published: eachSkipped.entry.Published,
+			Filter:    eachSkipped.filterName,
+		}
+		if eachSkipped.entry.Object != nil && len(eachSkipped.entry.Object.ID) != 0 {
+			reportEntry.ID = eachSkipped.entry.Object.ID
+			reportEntry.URL = eachSkipped.entry.Object.URL
+		}
+		if eachSkipped.filterName == "rules" && cla.filterRules != nil {
+			ruleNumber := cla.filterRules.decidingRule(eachSkipped.entry)
+			reportEntry.Rule = &ruleNumber
+		}
+		if encodeErr := reportEncoder.Encode(reportEntry); encodeErr != nil {
+			return encodeErr
+		}
+	}
+	log.Info("Writing skip report", "path", outputPath, "skippedCount", len(filteredOutbox.SkippedToots))
+	return writeFileAtomic(outputPath, reportBuffer.Bytes(), 0644)
+}
+
+// AltTextReportEntry lists the images on a single page that have no alt text
+type AltTextReportEntry struct {
+	Page      string                     `json:"page"`
+	Permalink string                     `json:"permalink"`
+	Images    []*AltTextReportAttachment `json:"images"`
+}
+
+// AltTextReportAttachment is an image without alt text and the toot it's
+// attached to, which is where the description is edited
+type AltTextReportAttachment struct {
+	ID   string `json:"id"`
+	URL  string `json:"url"`
+	File string `json:"file"`
+}
+
+// hasAltText returns true if the attachment has a non blank description
+func hasAltText(attachment *ActivityObjectAttachment) bool {
+	return len(strings.TrimSpace(attachment.Name)) != 0
+}
+
+// writeAltTextReport writes a JSON Lines record for every page, in page
+// order, with rendered images that have no alt text
+func writeAltTextReport(outputPath string, cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	orderedToots, orderedTootsErr := filteredOutbox.threadOrderedToots()
+	if orderedTootsErr != nil {
+		return orderedTootsErr
+	}
+	pages, pagesErr := cla.groupLanguagePages(filteredOutbox, orderedToots, func(entry *ActivityEntry) (string, error) {
+		return cla.pageBundlePath(filteredOutbox, entry)
+	})
+	if pagesErr != nil {
+		return pagesErr
+	}
+	var reportBuffer bytes.Buffer
+	reportEncoder := json.NewEncoder(&reportBuffer)
+	reportEncoder.SetEscapeHTML(false)
+	imageCount := 0
+	missingCount := 0
+	for _, eachPage := range pages {
+		reportEntry := &AltTextReportEntry{
+			Page:      eachPage.Key,
+			Permalink: cla.languageSectionURL(eachPage.Language) + eachPage.Key + "/",
+		}
+		for _, eachItem := range eachPage.Toots {
+			for _, eachAttachment := range eachItem.Object.Attachments {
+				if !strings.HasPrefix(eachAttachment.MediaType, "image/") {
+					continue
+				}
+				imageCount += 1
+				if hasAltText(eachAttachment) {
+					continue
+				}
+				reportEntry.Images = append(reportEntry.Images, &AltTextReportAttachment{
+					ID:   eachItem.Object.ID,
+					URL:  eachItem.Object.URL,
+					File: eachAttachment.BaseFilename,
+				})
+			}
+		}
+		if len(reportEntry.Images) == 0 {
+			continue
+		}
+		missingCount += len(reportEntry.Images)
+		if encodeErr := reportEncoder.Encode(reportEntry); encodeErr != nil {
+			return encodeErr
+		}
+	}
+	log.Info("Writing alt text report",
+		"path", outputPath,
+		"imageCount", imageCount,
+		"missingAltTextCount", missingCount)
+	return writeFileAtomic(outputPath, reportBuffer.Bytes(), 0644)
+}
+
+// writeHugoConfig writes a TOML Hugo config snippet for the output section:
+// the taxonomies the frontmatter uses, a permalink pattern matching the page
+// bundle layout and a cascade that sets the page type. The --section-url path
+// is assumed to be the content directory the output is in. Hugo permalinks
+// apply to a whole top level section, so they're left out when it's nested.
+// With --multilingual it also sets up a language, with its own content
+// directory, for every toot language.
+func writeHugoConfig(outputPath string, cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	groupBy := cla.groupBy
+	if cla.outputFormat == "microblog" {
+		groupBy = "toot"
+	}
+	sectionPath := strings.Trim(cla.sectionURL, "/")
+	if len(sectionPath) <= 0 {
+		return fmt.Errorf("--hugo-config requires a --section-url below the site root")
+	}
+	sectionName := path.Base(sectionPath)
+	contentPath := sectionPath
+	if cla.multilingual {
+		contentPath = path.Join("<language>", sectionPath)
+	}
+	var configBuffer bytes.Buffer
+	fmt.Fprintf(&configBuffer, "# Hugo configuration for the Mastodon archive in content/%s/\n", contentPath)
+	fmt.Fprintf(&configBuffer, "# Merge it into hugo.toml, or pass both: hugo --config hugo.toml,%s\n", filepath.Base(outputPath))
+	if !cla.reproducible {
+		fmt.Fprintf(&configBuffer, "# generated: %s\n", time.Now().UTC().Format(time.RFC3339))
+	}
+	configBuffer.WriteString("\n")
+	languages := []string{}
+	if cla.multilingual {
+		fmt.Fprintf(&configBuffer, "defaultContentLanguage = %s\n\n", tomlString(cla.lang))
+		languages = append(languages, cla.lang)
+		for _, eachItem := range filteredOutbox.OrderedItems {
+			if language := cla.tootLanguage(filteredOutbox, eachItem); !slices.Contains(languages, language) {
+				languages = append(languages, language)
+			}
+		}
+		slices.Sort(languages)
+	}
+	configBuffer.WriteString("[taxonomies]\n")
+	configBuffer.WriteString("  category = \"categories\"\n")
+	if cla.series {
+		configBuffer.WriteString("  series = \"series\"\n")
+	}
+	configBuffer.WriteString("  tag = \"tags\"\n\n")
+	if sectionName == sectionPath {
+		configBuffer.WriteString("[permalinks]\n")
+		fmt.Fprintf(&configBuffer, "  %s = %s\n\n",
+			tomlKey(sectionName),
+			tomlString(cla.sectionURL+HUGO_GROUP_BY_MODES[groupBy].permalink))
+	}
+	configBuffer.WriteString("[[cascade]]\n")
+	fmt.Fprintf(&configBuffer, "  type = %s\n", tomlString(sectionName))
+	configBuffer.WriteString("  [cascade._target]\n")
+	fmt.Fprintf(&configBuffer, "    path = %s\n", tomlString("{/"+sectionPath+",/"+sectionPath+"/**}"))
+	for _, eachLanguage := range languages {
+		fmt.Fprintf(&configBuffer, "\n[languages.%s]\n", tomlKey(eachLanguage))
+		fmt.Fprintf(&configBuffer, "  contentDir = %s\n", tomlString(path.Join("content", eachLanguage)))
+	}
+	log.Info("Writing Hugo config", "path", outputPath, "section", sectionName)
+	return writeFileAtomic(outputPath, configBuffer.Bytes(), 0644)
+}
+
+// writeShortcode writes the shortcode template to the shortcodes directory
+func writeShortcode(shortcodesDirectory string, shortcodeName string, shortcodeTemplate string, log *slog.Logger) error {
+	errDirectory := ensureDirectory(shortcodesDirectory, false, log)
+	if errDirectory != nil {
+		return errDirectory
+	}
+	shortcodeOutputPath := path.Join(shortcodesDirectory, shortcodeName)
+	log.Info("Writing shortcode", "path", shortcodeOutputPath)
+	return writeFileAtomic(shortcodeOutputPath, []byte(shortcodeTemplate), 0644)
+}
+
+// installLayouts is the install-layouts command. It writes the companion
+// shortcodes the rendered pages can use into the layouts/shortcodes directory
+// of the Hugo site. Existing shortcodes, which may have been customized, are
+// kept unless --force is set.
+func installLayouts(args []string, log *slog.Logger) error {
+	installFlags := flag.NewFlagSet("install-layouts", flag.ContinueOnError)
+	siteRoot := installFlags.String("site", ".", "Path to the root directory of the Hugo site")
+	force := installFlags.Bool("force", false, "Overwrite shortcodes that already exist")
+	if parseErr := installFlags.Parse(args); parseErr != nil {
+		return parseErr
+	}
+	if installFlags.NArg() != 0 {
+		return fmt.Errorf("Invalid install-layouts argument specified: %s", installFlags.Arg(0))
+	}
+	shortcodesDirectory := filepath.Join(*siteRoot, "layouts", "shortcodes")
+	writtenCount := 0
+	for _, eachName := range slices.Sorted(maps.Keys(LAYOUT_SHORTCODES)) {
+		shortcodePath := filepath.Join(shortcodesDirectory, eachName)
+		if _, statErr := os.Stat(shortcodePath); statErr == nil && !*force {
+			log.Info("Keeping existing shortcode", "path", shortcodePath)
+			continue
+		}
+		writeErr := writeShortcode(shortcodesDirectory, eachName, LAYOUT_SHORTCODES[eachName], log)
+		if writeErr != nil {
+			return writeErr
+		}
+		writtenCount += 1
+	}
+	log.Info("Layouts installed", "path", shortcodesDirectory, "shortcodeCount", writtenCount)
+	return nil
+}
+
+// writeCSV writes one row of metadata per toot for spreadsheet analysis
+func writeCSV(outputPath string, filteredOutbox *Outbox, log *slog.Logger) error {
+	csvFile, csvFileErr := createAtomicFile(outputPath, 0644)
+	if csvFileErr != nil {
+		return csvFileErr
+	}
+	defer csvFile.discard()
+	csvWriter := csv.NewWriter(csvFile)
+	if strings.HasSuffix(outputPath, ".tsv") {
+		csvWriter.Comma = '\t'
+	}
+	csvWriter.Write([]string{"id", "date", "visibility", "reply_to", "hashtags", "media_count", "word_count", "url"})
+	for _, eachItem := range filteredOutbox.OrderedItems {
+		hashtags := []string{}
+		for _, eachTag := range eachItem.Object.Tags {
+			if eachTag.Type == "Hashtag" {
+				hashtags = append(hashtags, eachTag.Name)
+			}
 		}
+		csvWriter.Write([]string{
+			eachItem.Object.ID,
+			eachItem.Published,
+			eachItem.Object.Visibility(),
+			eachItem.Object.InReplyTo,
+			strings.Join(hashtags, ","),
+			fmt.Sprintf("%d", len(eachItem.Object.Attachments)),
+			fmt.Sprintf("%d", len(strings.Fields(htmlToText(eachItem.Object.Content)))),
+			eachItem.Object.URL,
+		})
 	}
-	return os.MkdirAll(root, os.ModePerm)
+	csvWriter.Flush()
+	if flushErr := csvWriter.Error(); flushErr != nil {
+		return flushErr
+	}
+	log.Info("Writing CSV", "path", outputPath, "rowCount", len(filteredOutbox.OrderedItems))
+	return csvFile.commit()
 }
 
-func renderTootsToDisk(outputRoot string, filteredOutbox *Outbox, log *slog.Logger) error {
-	// When rendering out, use the current time as the lastModTime
-	nowTime := time.Now().Format(time.RFC3339)
+// sqlQuote returns the value as a single quoted SQL string literal
+func sqlQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
 
-	publishingStats := PublishingStats{
-		totalTootCount:    filteredOutbox.TotalItems,
-		renderedTootCount: uint(len(filteredOutbox.OrderedItems)),
-		filteredTootCount: filteredOutbox.TotalItems - uint(len(filteredOutbox.OrderedItems)),
+// writeSQLite writes the toots, attachments, tags and threads tables. The
+// SQL is executed by the sqlite3 command so that no cgo driver is required.
+// A path ending in .sql writes the SQL script instead.
+func writeSQLite(outputPath string, filteredOutbox *Outbox, log *slog.Logger) error {
+	var sqlBuilder strings.Builder
+	sqlBuilder.WriteString("BEGIN TRANSACTION;\n")
+	sqlBuilder.WriteString(SQLITE_SCHEMA)
+	orderedToots, orderedTootsErr := filteredOutbox.threadOrderedToots()
+	if orderedTootsErr != nil {
+		return orderedTootsErr
 	}
-	tootRootTemplate, tootRootTemplateErr := template.New("tootRoot").Parse(TEMPLATE_TOOT_FRONTMATTER)
-	if tootRootTemplateErr != nil {
-		return tootRootTemplateErr
+	threadPositions := map[string]int{}
+	for _, eachItem := range orderedToots {
+		threadRootActivityItem, _, threadRootErr := filteredOutbox.threadRoot(eachItem)
+		if threadRootErr != nil {
+			return threadRootErr
+		}
+		inReplyTo := "NULL"
+		if len(eachItem.Object.InReplyTo) != 0 {
+			inReplyTo = sqlQuote(eachItem.Object.InReplyTo)
+		}
+		fmt.Fprintf(&sqlBuilder, "INSERT INTO toots VALUES (%s, %s, %s, %s, %s, %s);\n",
+			sqlQuote(eachItem.Object.ID),
+			sqlQuote(eachItem.Object.URL),
+			sqlQuote(eachItem.Published),
+			inReplyTo,
+			sqlQuote(eachItem.Object.Content),
+			sqlQuote(htmlToText(eachItem.Object.Content)))
+		for eachIndex, eachAttachment := range eachItem.Object.Attachments {
+			fmt.Fprintf(&sqlBuilder, "INSERT INTO attachments VALUES (%s, %d, %s, %s, %s, %d, %d);\n",
+				sqlQuote(eachItem.Object.ID),
+				eachIndex,
+				sqlQuote(eachAttachment.MediaType),
+				sqlQuote(eachAttachment.URL),
+				sqlQuote(eachAttachment.Name),
+				eachAttachment.Width,
+				eachAttachment.Height)
+		}
+		for _, eachTag := range eachItem.Object.Tags {
+			fmt.Fprintf(&sqlBuilder, "INSERT INTO tags VALUES (%s, %s, %s, %s);\n",
+				sqlQuote(eachItem.Object.ID),
+				sqlQuote(eachTag.Type),
+				sqlQuote(eachTag.Name),
+				sqlQuote(eachTag.HREF))
+		}
+		rootID := threadRootActivityItem.Object.ID
+		fmt.Fprintf(&sqlBuilder, "INSERT INTO threads VALUES (%s, %s, %d);\n",
+			sqlQuote(rootID),
+			sqlQuote(eachItem.Object.ID),
+			threadPositions[rootID])
+		threadPositions[rootID] += 1
 	}
-	tootTemplate, tootTemplateErr := template.New("toot").Parse(TEMPLATE_TOOT)
-	if tootTemplateErr != nil {
-		return tootTemplateErr
+	sqlBuilder.WriteString("COMMIT;\n")
+
+	if strings.HasSuffix(outputPath, ".sql") {
+		log.Info("Writing SQL script", "path", outputPath, "tootCount", len(orderedToots))
+		return writeFileAtomic(outputPath, []byte(sqlBuilder.String()), 0644)
+	}
+	sqliteCommand := exec.Command("sqlite3", outputPath)
+	sqliteCommand.Stdin = strings.NewReader(sqlBuilder.String())
+	commandOutput, commandErr := sqliteCommand.CombinedOutput()
+	if commandErr != nil {
+		return fmt.Errorf("sqlite3 failed: %s. Output: %s", commandErr, string(commandOutput))
 	}
+	log.Info("Wrote SQLite database", "path", outputPath, "tootCount", len(orderedToots))
+	return nil
+}
 
+func writeJSONFeed(outputPath string, cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	feed := JSONFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       fmt.Sprintf("Mastodon - @%s@%s", USER, HOST),
+		HomePageURL: fmt.Sprintf("https://%s/@%s", HOST, USER),
+		Items:       []*JSONFeedItem{},
+	}
 	for _, eachItem := range filteredOutbox.OrderedItems {
-		threadRootActivityItem := eachItem
-
-		// By default, each toot is it's own root. If there is a replyTo chain,
-		// recurse that to the root which becomes the active root
-		for {
-			replyToID := threadRootActivityItem.Object.InReplyTo
-			if len(replyToID) <= 0 {
-				break
-			}
-			parentActivityItem, parentActivityItemExists := filteredOutbox.ThreadIDChain[replyToID]
-			if !parentActivityItemExists {
-				break
-			}
-			if parentActivityItem == threadRootActivityItem {
-				return fmt.Errorf("Loop detected for item: %s", threadRootActivityItem.Object.ID)
-			}
-			threadRootActivityItem = parentActivityItem
-			publishingStats.replyThreadsCount += 1
+		bundlePath, bundlePathErr := cla.pageBundlePath(filteredOutbox, eachItem)
+		if bundlePathErr != nil {
+			return bundlePathErr
 		}
-		// Add a bit of structure to the output
-		// Sample date: 2024-02-02T17:40:31Z
-		parsedDate, parsedDateErr := time.Parse(time.RFC3339, threadRootActivityItem.Published)
-		if parsedDateErr != nil {
-			return fmt.Errorf("Failed to parse date: %s. Error: %s", threadRootActivityItem.Published, parsedDateErr)
-		}
-		idParts := strings.Split(threadRootActivityItem.Object.ID, "/")
-		fileID := idParts[len(idParts)-1]
-		tootRootBundleDirectory := path.Join(outputRoot,
-			fmt.Sprintf("%d", parsedDate.Year()),
-			fmt.Sprintf("%.2d", parsedDate.Month()),
-			fileID,
-		)
-		// Might be a reply, might not
-		errDirectory := ensureDirectory(tootRootBundleDirectory, false, log)
-		if errDirectory != nil {
-			return errDirectory
+		feedItem := &JSONFeedItem{
+			ID:            eachItem.Object.ID,
+			URL:           eachItem.Object.URL,
+			ContentHTML:   eachItem.Object.Content,
+			DatePublished: eachItem.Published,
+			Tags:          []string{},
+			Attachments:   []*JSONFeedAttachment{},
 		}
-		tootOutputPath := path.Join(tootRootBundleDirectory, "index.md")
-		log.Debug("Rendering toot", "id", eachItem.ID, "path", tootOutputPath)
-
-		// Setup the template param map
-		templateParamMap := map[string]interface{}{
-			"ExecutionTime": nowTime,
-			"Toot":          eachItem,
-		}
-		// Either create the file and write out the frontmatter, or just open
-		// the output in append mode and render the toot.
-		var tootFS *os.File = nil
-		_, fileExistsErr := os.Stat(tootOutputPath)
-		if os.IsNotExist(fileExistsErr) {
-			createFS, createFSErr := os.OpenFile(tootOutputPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
-			if createFSErr != nil {
-				return createFSErr
-			}
-			tootFS = createFS
-			// The file doesn't exist - render the toot header to the file...
-			if err := tootRootTemplate.Execute(tootFS, templateParamMap); err != nil {
-				return err
-			}
-		} else if fileExistsErr != nil {
-			return fileExistsErr
-		} else {
-			appendFS, appendFSErr := os.OpenFile(tootOutputPath, os.O_APPEND|os.O_WRONLY, 0600)
-			if appendFSErr != nil {
-				return appendFSErr
-			}
-			log.Debug("Appending toot to thread",
-				"replyTo", eachItem.Object.InReplyTo,
-				"tootPath", tootOutputPath,
-				"id", eachItem.Object.ID)
-			tootFS = appendFS
+		if lastModified := eachItem.LastModified(); lastModified != eachItem.Published {
+			feedItem.DateModified = lastModified
 		}
-
-		// Either way, render the toot to the open file as well
-		if err := tootTemplate.Execute(tootFS, templateParamMap); err != nil {
-			return err
+		for _, eachTag := range eachItem.Object.Tags {
+			feedItem.Tags = append(feedItem.Tags, eachTag.Name)
 		}
-		// Flush it
-		tootFS.Close()
-
-		// Any media objects we need to move? We're just going to use the basename for the
-		// attachment and put it in the page bundle directory
+		// Attachment URLs are relative to the output root, which is where
+		// the hugo format copies the media
 		for _, eachAttachment := range eachItem.Object.Attachments {
-			sourceFilePath := path.Join(filteredOutbox.ArchiveDirectoryRoot, eachAttachment.URL)
-			destFilePath := path.Join(tootRootBundleDirectory, eachAttachment.BaseFilename)
-			srcFile, srcFileErr := os.Open(sourceFilePath)
-			if srcFileErr != nil {
-				return srcFileErr
-			}
-			defer srcFile.Close()
+			feedItem.Attachments = append(feedItem.Attachments, &JSONFeedAttachment{
+				URL:      path.Join(bundlePath, eachAttachment.BaseFilename),
+				MimeType: eachAttachment.MediaType,
+				Title:    eachAttachment.Name,
+			})
+		}
+		feed.Items = append(feed.Items, feedItem)
+	}
+	log.Info("Writing JSON Feed", "path", outputPath, "itemCount", len(feed.Items))
+	return writeJSONFile(outputPath, feed)
+}
 
-			destFile, destFileErr := os.Create(destFilePath)
-			if destFileErr != nil {
-				return destFileErr
+func writeAtomFeed(outputPath string, cla *commandLineArgs, filteredOutbox *Outbox, log *slog.Logger) error {
+	profileURL := fmt.Sprintf("https://%s/@%s", HOST, USER)
+	feed := AtomFeed{
+		ID:    profileURL,
+		Title: fmt.Sprintf("Mastodon - @%s@%s", USER, HOST),
+		Author: &AtomAuthor{
+			Name: USER,
+			URI:  profileURL,
+		},
+		Links: []*AtomLink{
+			{Rel: "alternate", Href: profileURL},
+		},
+		Entries: []*AtomEntry{},
+	}
+	for _, eachItem := range filteredOutbox.OrderedItems {
+		bundlePath, bundlePathErr := cla.pageBundlePath(filteredOutbox, eachItem)
+		if bundlePathErr != nil {
+			return bundlePathErr
+		}
+		title := truncateText(htmlToText(eachItem.Object.Content), 80)
+		if len(title) <= 0 {
+			title = fmt.Sprintf("Mastodon - %s", eachItem.Published)
+		}
+		entry := &AtomEntry{
+			ID:        eachItem.Object.ID,
+			Title:     title,
+			Published: eachItem.Published,
+			Updated:   eachItem.LastModified(),
+			Links: []*AtomLink{
+				{Rel: "alternate", Href: eachItem.Object.URL, Type: "text/html"},
+			},
+			Categories: []*AtomCategory{},
+			Content: &AtomContent{
+				Type: "html",
+				Body: eachItem.Object.Content,
+			},
+		}
+		for _, eachTag := range eachItem.Object.Tags {
+			entry.Categories = append(entry.Categories, &AtomCategory{Term: eachTag.Name})
+		}
+		// Enclosures are relative to the output root, which is where
+		// the hugo format copies the media
+		for _, eachAttachment := range eachItem.Object.Attachments {
+			enclosure := &AtomLink{
+				Rel:   "enclosure",
+				Href:  path.Join(bundlePath, eachAttachment.BaseFilename),
+				Type:  eachAttachment.MediaType,
+				Title: eachAttachment.Name,
 			}
-			defer destFile.Close()
-			bytesCopied, copyErr := io.Copy(destFile, srcFile) //copy the contents of source to destination file
-			if copyErr != nil {
-				return copyErr
+			fileInfo, fileInfoErr := os.Stat(filteredOutbox.mediaSourcePath(eachAttachment))
+			if fileInfoErr == nil {
+				enclosure.Length = fileInfo.Size()
 			}
-			log.Debug("Copied media file to source",
-				"type", eachAttachment.MediaType,
-				"name", eachAttachment.BaseFilename,
-				"bytes", bytesCopied,
-				"id", eachItem.Object.ID)
-			publishingStats.mediaFilesCount += 1
+			entry.Links = append(entry.Links, enclosure)
 		}
+		// The newest toot or edit defines the feed update time
+		if entry.Updated > feed.Updated {
+			feed.Updated = entry.Updated
+		}
+		feed.Entries = append(feed.Entries, entry)
 	}
-	// All done
-	log.Info("Publishing statistics",
-		"totalTootCount", publishingStats.totalTootCount,
-		"renderedTootCount", publishingStats.renderedTootCount,
-		"filteredTootCount", publishingStats.filteredTootCount,
-		"replyThreadCount", publishingStats.replyThreadsCount,
-		"mediaFilesCount", publishingStats.mediaFilesCount)
-	return nil
+	xmlBytes, xmlBytesErr := xml.MarshalIndent(feed, "", "  ")
+	if xmlBytesErr != nil {
+		return xmlBytesErr
+	}
+	log.Info("Writing Atom feed", "path", outputPath, "entryCount", len(feed.Entries))
+	return writeFileAtomic(outputPath, append([]byte(xml.Header), xmlBytes...), 0644)
 }
 
 //
@@ -493,46 +10400,637 @@ func renderTootsToDisk(outputRoot string, filteredOutbox *Outbox, log *slog.Logg
 // |_|_|_\__,_|_|_||_|
 //
 // //////////////////////////////////////////////////////////////////////////////
-func main() {
-	lvl := &slog.LevelVar{}
-	lvl.Set(slog.LevelInfo)
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: lvl,
-	}))
-	cleanupFuncs := []cleanupFunc{}
+// COMMANDS are the subcommands named by the first argument. They share the
+// command line flags, and convert is run when no command is named.
+var COMMANDS = map[string]func(cla *commandLineArgs, log *slog.Logger){
+	"convert":  convertArchive,
+	"fetch":    fetchRemoteContent,
+	"stats":    logStatistics,
+	"validate": validateArchive,
+	"clean":    cleanOutput,
+}
 
-	cla := commandLineArgs{}
-	parseError := cla.parseCommandLine(logger)
-	if parseError != nil {
-		logger.Error("Failed to parse command line arguments", "error", parseError)
-		os.Exit(-1)
+func commandNames() []string {
+	return slices.Sorted(maps.Keys(COMMANDS))
+}
+
+// runCleanupFuncs runs the cleanup functions of a command that succeeded
+func runCleanupFuncs(cleanupFuncs []cleanupFunc, log *slog.Logger) {
+	for _, eachFunc := range cleanupFuncs {
+		eachFunc(log)
 	}
-	lvl.Set(slog.Level(cla.logLevelValue))
-	logger.Info("Welcome to Hugodon!")
+}
 
-	// Unmarshal the data and filter
+// readArchive unmarshals the archive outbox, then keeps the toots of the
+// audiences and dates the command line sets. filterArchive applies the other
+// filters.
+func readArchive(cla *commandLineArgs, log *slog.Logger) (*Outbox, error) {
 	outboxFilePath := path.Join(cla.inputRootPathExpandedArchive, "outbox.json")
 	outboxFeed, outboxFeedErr := newOutbox(outboxFilePath)
 	if outboxFeedErr != nil {
-		logger.Error("Failed to read output JSON", "path", outboxFilePath, "error", outboxFeedErr)
+		return nil, fmt.Errorf("Failed to read %s: %s", outboxFilePath, outboxFeedErr)
+	}
+	featuredErr := outboxFeed.loadFeatured(cla.fetchPinned, log)
+	if featuredErr != nil {
+		return nil, fmt.Errorf("Failed to read pinned toots: %s", featuredErr)
+	}
+	outboxFeed.filterToots("duplicateID", duplicateIDFilter())
+	outboxFeed.filterToots("audience", selfPublishFilter(cla.includeReplies, len(cla.boostStyle) != 0, cla.visibilities))
+	if !cla.since.IsZero() || !cla.until.IsZero() {
+		outboxFeed.filterToots("dateRange", dateRangeFilter(cla.since, cla.until))
+	}
+	return outboxFeed, nil
+}
+
+// filterArchive resolves the boosts, then applies the filters that inspect
+// the toot content, which boosts only have once they're resolved, and
+// rewrites the toots as the command line sets. Boosted toots are only
+// fetched when fetchBoosts is set, else the --boost-cache is used.
+func filterArchive(cla *commandLineArgs, outboxFeed *Outbox, fetchBoosts bool, log *slog.Logger) error {
+	if len(cla.boostStyle) != 0 {
+		resolver, resolverErr := newBoostResolver(cla.boostCachePath, !fetchBoosts)
+		if resolverErr != nil {
+			return fmt.Errorf("Failed to read boosted toots: %s", resolverErr)
+		}
+		outboxFeed.resolveBoosts(cla.boostStyle, cla.messages["Boosted"], resolver, log)
+		if resolver.fetchedCount != 0 {
+			if writeErr := resolver.write(); writeErr != nil {
+				return fmt.Errorf("Failed to write boosted toots: %s", writeErr)
+			}
+			log.Info("Boosted toots fetched", "fetchedCount", resolver.fetchedCount)
+		}
+	}
+	if len(cla.onlyTags) != 0 || len(cla.excludeTags) != 0 {
+		outboxFeed.filterToots("tags", tagFilter(cla.onlyTags, cla.excludeTags))
+	}
+	if len(cla.languages) != 0 {
+		outboxFeed.filterToots("language", languageFilter(cla.languages))
+	}
+	if cla.cwMode == "skip" {
+		outboxFeed.filterToots("contentWarning", contentWarningFilter)
+	}
+	if cla.sensitiveMedia == "skip" {
+		outboxFeed.filterToots("sensitiveMedia", sensitiveMediaFilter)
+	}
+	if len(cla.includeMatching) != 0 || len(cla.excludeMatching) != 0 {
+		outboxFeed.filterToots("content", contentFilter(cla.includeMatching, cla.excludeMatching))
+	}
+	if cla.dedupeWindow > 0 {
+		outboxFeed.filterToots("duplicateContent", duplicateContentFilter(cla.dedupeWindow))
+	}
+	if cla.onlyIDs != nil || cla.excludeIDs != nil {
+		outboxFeed.filterToots("ids", idFilter(cla.onlyIDs, cla.excludeIDs))
+	}
+	if cla.onlyMedia || cla.textOnly {
+		outboxFeed.filterToots("media", mediaFilter(cla.onlyMedia))
+	}
+	if cla.minChars > 0 {
+		outboxFeed.filterToots("length", lengthFilter(cla.minChars))
+	}
+	if len(cla.excludeMentions) != 0 {
+		outboxFeed.filterToots("mentions", mentionFilter(cla.excludeMentions))
+	}
+	if cla.filterRules != nil {
+		outboxFeed.filterToots("rules", cla.filterRules.filter)
+	}
+	log.Info("Toots filtered", append([]any{"totalCount", outboxFeed.TotalItems, "filteredCount", len(outboxFeed.OrderedItems)},
+		outboxFeed.skippedCountLogArgs()...)...)
+	if cla.anonymizeMentions {
+		log.Info("Mentions anonymized", "tootCount", outboxFeed.anonymizeMentions(cla.mentionPlaceholder))
+	}
+	if cla.mentionMode != "link" {
+		log.Info("Mentions rewritten", "tootCount", outboxFeed.rewriteMentions(cla.mentionMode))
+	}
+	// The tag filters match the tags as published
+	if cla.tagStyle != "as-is" || len(cla.tagNormalizer.mapping) != 0 {
+		log.Info("Hashtags normalized", "tootCount", outboxFeed.normalizeTags(cla.tagNormalizer))
+	}
+	if cla.hashtagMode != "mastodon" {
+		// Hugo's taxonomy pages, or the --tag-pages pages in the section, of
+		// the toot's language
+		tagsURL := func(entry *ActivityEntry) string {
+			language := cla.tootLanguage(outboxFeed, entry)
+			if cla.tagPages {
+				return cla.languageSectionURL(language) + "tags/"
+			}
+			return cla.languageURLPrefix(language) + "/tags/"
+		}
+		log.Info("Hashtags rewritten", "tootCount", outboxFeed.rewriteHashtags(cla.hashtagMode, tagsURL, cla.tagNormalizer))
+	}
+	return nil
+}
+
+// redactArchive applies the --redact terms, once the link previews and
+// expanded URLs they may match are resolved, then names the page bundles
+func redactArchive(cla *commandLineArgs, outboxFeed *Outbox, log *slog.Logger) {
+	if cla.redactPattern != nil && cla.redactMode == "skip" {
+		outboxFeed.filterToots("redact", redactFilter(cla.redactPattern))
+		log.Info("Toots filtered", append([]any{"filteredCount", len(outboxFeed.OrderedItems)},
+			outboxFeed.skippedCountLogArgs()...)...)
+	}
+	if cla.redactPattern != nil && cla.redactMode == "replace" {
+		log.Info("Toots redacted", "count", outboxFeed.redactToots(cla.redactPattern))
+	}
+	// Content slugs are named after the content once it's redacted
+	if cla.slugStyle != "id" {
+		log.Info("Page bundle slugs assigned", "style", cla.slugStyle, "collisionCount", outboxFeed.assignSlugs(cla.slugStyle))
+	}
+}
+
+// resolveContent fetches the boosted toots and filters the archive, then
+// downloads the media missing from the archive and, as the command line sets,
+// the link previews, expanded URLs and custom emoji the toots render with.
+// Each is cached for later runs, and only the caches are used --offline.
+func resolveContent(cla *commandLineArgs, outboxFeed *Outbox, log *slog.Logger) error {
+	filterErr := filterArchive(cla, outboxFeed, !cla.offline, log)
+	if filterErr != nil {
+		return filterErr
+	}
+	fetcher := &mediaFetcher{
+		baseURL:        cla.mediaBaseURL,
+		cacheDirectory: cla.mediaCacheDirectory,
+		retries:        cla.downloadRetries,
+		offline:        cla.offline,
+	}
+	downloadedCount, removedCount := outboxFeed.fetchMissingMedia(fetcher, log)
+	if downloadedCount != 0 || removedCount != 0 {
+		log.Info("Missing media resolved", "downloadedCount", downloadedCount, "removedCount", removedCount)
+	}
+	if cla.expandURLs {
+		expander, expanderErr := newURLExpander(cla.expandedURLsCachePath, cla.offline, cla.expandURLsTimeout, cla.urlShorteners)
+		if expanderErr != nil {
+			return fmt.Errorf("Failed to read expanded URLs: %s", expanderErr)
+		}
+		expandedCount := outboxFeed.expandShortURLs(expander, log)
+		if writeErr := expander.write(); writeErr != nil {
+			return fmt.Errorf("Failed to write expanded URLs: %s", writeErr)
+		}
+		log.Info("Short URLs expanded", "tootCount", expandedCount, "resolvedCount", expander.resolvedCount)
+	}
+	if cla.stripTracking {
+		log.Info("Tracking parameters stripped", "tootCount", outboxFeed.stripTrackingParameters(cla.trackingParams))
+	}
+	if cla.linkPreviews {
+		previewer, previewerErr := newLinkPreviewer(cla.linkPreviewCachePath, cla.offline)
+		if previewerErr != nil {
+			return fmt.Errorf("Failed to read link previews: %s", previewerErr)
+		}
+		previewCount := outboxFeed.addLinkPreviews(previewer, fetcher, log)
+		if writeErr := previewer.write(); writeErr != nil {
+			return fmt.Errorf("Failed to write link previews: %s", writeErr)
+		}
+		log.Info("Link previews added", "tootCount", previewCount, "fetchedCount", previewer.fetchedCount)
+	}
+	if cla.customEmoji {
+		foundCount, missingCount := outboxFeed.fetchCustomEmoji(fetcher, log)
+		log.Info("Custom emoji resolved", "foundCount", foundCount, "missingCount", missingCount)
+	}
+	return nil
+}
+
+// convertArchive is the convert command. It renders the archive toots to the
+// output in the --format.
+func convertArchive(cla *commandLineArgs, log *slog.Logger) {
+	cleanupFuncs := []cleanupFunc{}
+	// A dry run doesn't write to the output, so it doesn't lock it
+	var lock *outputLock
+	if !cla.dryRun {
+		ensureDirectory(cla.outputRootPathHugoAssets, false, log)
+		var lockErr error
+		lock, lockErr = lockOutput(cla.outputRootPathHugoAssets, log)
+		if lockErr != nil {
+			log.Error("Failed to lock the output directory", "error", lockErr)
+			os.Exit(-1)
+		}
+		cleanupFuncs = append(cleanupFuncs, lock.release)
+	}
+	exitFailure := func() {
+		lock.release(log)
 		os.Exit(-1)
 	}
-	totalToots := outboxFeed.TotalItems
-	outboxFeed.filterToots(selfPublishFilter)
-	logger.Info("Toots filtered", "totalCount", totalToots, "filteredCount", len(outboxFeed.OrderedItems))
+	var run *dryRun
+	if cla.dryRun {
+		var runErr error
+		run, runErr = newDryRun(cla)
+		if runErr != nil {
+			log.Error("Failed to create dry run directory", "error", runErr)
+			exitFailure()
+		}
+		log.Info("Dry run", "stagingPath", run.stagingRoot)
+		cleanupFuncs = append(cleanupFuncs, run.report)
+	}
+
+	// Unmarshal the data and filter
+	outboxFeed, outboxErr := readArchive(cla, log)
+	if outboxErr != nil {
+		log.Error("Failed to read archive", "error", outboxErr)
+		exitFailure()
+	}
+	resolveErr := resolveContent(cla, outboxFeed, log)
+	if resolveErr != nil {
+		log.Error("Failed to resolve toot content", "error", resolveErr)
+		exitFailure()
+	}
+	if cla.verifyMedia {
+		checkedCount, corruptCount := outboxFeed.removeCorruptMedia(log)
+		log.Info("Media verified", "checkedCount", checkedCount, "corruptCount", corruptCount)
+	}
+	redactArchive(cla, outboxFeed, log)
 
 	// Render out the toots to disk
-	ensureDirectory(cla.outputRootPathHugoAssets, true, logger)
-	renderErr := renderTootsToDisk(cla.outputRootPathHugoAssets,
+	if cla.stripMetadata {
+		outboxFeed.MediaProcessors = append(outboxFeed.MediaProcessors, metadataStripper)
+	}
+	if cla.maxImageWidth > 0 {
+		outboxFeed.MediaProcessors = append(outboxFeed.MediaProcessors, imageResizer(cla.maxImageWidth, cla.keepOriginals))
+	}
+	if len(cla.srcsetWidths) != 0 {
+		outboxFeed.MediaProcessors = append(outboxFeed.MediaProcessors, srcsetGenerator(cla.srcsetWidths))
+	}
+	if len(cla.imageFormat) != 0 {
+		outboxFeed.MediaProcessors = append(outboxFeed.MediaProcessors, imageTranscoder(cla.imageFormat, cla.imageQuality, cla.keepFallback))
+	}
+	if cla.blurhashPlaceholders {
+		outboxFeed.MediaProcessors = append(outboxFeed.MediaProcessors, blurhashPlaceholder)
+	}
+	outboxFeed.MediaMode = cla.mediaMode
+	outboxFeed.MediaHook = cla.mediaHook
+	outboxFeed.VerifyMedia = cla.verifyMedia
+	if cla.dedupeMedia {
+		outboxFeed.MediaHashes = map[string]string{}
+	}
+	if cla.skipUnchangedMedia {
+		mediaSettings := fmt.Sprintf("strip-exif=%t max-image-width=%d keep-originals=%t srcset-widths=%v image-format=%s image-quality=%d keep-fallback=%t blurhash=%t media-hook=%q media-mode=%s",
+			cla.stripMetadata,
+			cla.maxImageWidth,
+			cla.keepOriginals,
+			cla.srcsetWidths,
+			cla.imageFormat,
+			cla.imageQuality,
+			cla.keepFallback,
+			cla.blurhashPlaceholders,
+			strings.Join(cla.mediaHook, " "),
+			cla.mediaMode)
+		manifest, manifestErr := newMediaManifest(cla.outputRootPathHugoAssets, mediaSettings)
+		if manifestErr != nil {
+			log.Error("Failed to read media manifest", "error", manifestErr)
+			exitFailure()
+		}
+		outboxFeed.MediaManifest = manifest
+	}
+	if cla.incremental {
+		state, stateErr := newRenderState(cla.outputRootPathHugoAssets, cla.noOverwrite)
+		if stateErr != nil {
+			log.Error("Failed to read render state", "error", stateErr)
+			exitFailure()
+		}
+		outboxFeed.RenderState = state
+	}
+	outputManifest, outputManifestErr := newOutputManifest(cla.outputRootPathHugoAssets, cla.outputDirectories())
+	if outputManifestErr != nil {
+		log.Error("Failed to read output manifest", "error", outputManifestErr)
+		exitFailure()
+	}
+	outputManifest.removeTempFiles(log)
+	if cla.clean {
+		// Refuse to clean what may not be an output directory, e.g. a typo
+		foreignFiles := outputManifest.foreignFiles()
+		if len(foreignFiles) != 0 && !cla.force {
+			log.Error("Output has files the previous run didn't generate. Use --force to clean it anyway, which keeps them",
+				"path", cla.outputRootPathHugoAssets,
+				"fileCount", len(foreignFiles),
+				"firstPath", foreignFiles[0])
+			exitFailure()
+		}
+	}
+	// The pages of toots deleted from the archive are never written again.
+	// A dry run finds them in the real output.
+	orphansManifest := outputManifest
+	if run != nil {
+		orphansManifest = run.manifest
+	}
+	orphanedFiles := orphansManifest.orphanedFiles(outboxFeed.archiveTootIDs())
+	// Save the output before anything is deleted from it
+	if len(cla.backupDirectory) != 0 && run == nil && ((cla.clean && len(outputManifest.previous) != 0) || (cla.removeOrphans && len(orphanedFiles) != 0)) {
+		backupErr := cla.saveBackup(log)
+		if backupErr != nil {
+			log.Error("Failed to back up output", "error", backupErr)
+			exitFailure()
+		}
+	}
+	if cla.clean {
+		_, cleanErr := outputManifest.clean(outboxFeed.keptFiles(), log)
+		if cleanErr != nil {
+			log.Error("Failed to delete generated files", "error", cleanErr)
+			exitFailure()
+		}
+	}
+	// --clean deletes them with the other generated files
+	if len(orphanedFiles) != 0 && !cla.removeOrphans {
+		if !cla.clean {
+			log.Warn("Output has files of toots no longer in the archive. Use --remove-orphans to delete them",
+				"fileCount", len(orphanedFiles),
+				"firstPath", orphanedFiles[0])
+		}
+	} else if len(orphanedFiles) != 0 && run != nil {
+		if !cla.clean {
+			run.cleanedFiles = append(run.cleanedFiles, orphanedFiles...)
+		}
+	} else if len(orphanedFiles) != 0 {
+		deletedCount := 0
+		for _, eachPath := range orphanedFiles {
+			removed, removeErr := outputManifest.remove(eachPath)
+			if removeErr != nil {
+				log.Error("Failed to delete orphaned file", "path", eachPath, "error", removeErr)
+				exitFailure()
+			} else if removed {
+				deletedCount += 1
+			}
+		}
+		log.Info("Deleted files of toots no longer in the archive", "deletedCount", deletedCount)
+	}
+	outboxFeed.OutputManifest = outputManifest
+	ensureDirectory(cla.outputRootPathHugoAssets, false, log)
+	renderErr := OUTPUT_FORMATS[cla.outputFormat](cla,
 		outboxFeed,
-		logger)
+		log)
 	if renderErr != nil {
-		logger.Error("Failed to render toots", "error", renderErr)
+		log.Error("Failed to render toots", "error", renderErr)
+		exitFailure()
+	}
+	if outboxFeed.MediaManifest != nil {
+		manifestErr := outboxFeed.MediaManifest.write()
+		if manifestErr != nil {
+			log.Error("Failed to write media manifest", "error", manifestErr)
+			exitFailure()
+		}
+		log.Info("Unchanged media reused",
+			"skippedCount", outboxFeed.MediaManifest.skippedCount,
+			"copiedCount", len(outboxFeed.MediaManifest.current)-int(outboxFeed.MediaManifest.skippedCount))
+	}
+	if outboxFeed.RenderState != nil {
+		stateErr := outboxFeed.RenderState.write(log)
+		if stateErr != nil {
+			log.Error("Failed to write render state", "error", stateErr)
+			exitFailure()
+		}
+		log.Info("Unchanged pages kept",
+			"skippedCount", outboxFeed.RenderState.skippedCount,
+			"editedCount", outboxFeed.RenderState.editedCount,
+			"writtenCount", len(outboxFeed.RenderState.current)-int(outboxFeed.RenderState.skippedCount+outboxFeed.RenderState.editedCount))
+	}
+	if len(cla.jsonFeedPath) != 0 {
+		feedErr := writeJSONFeed(cla.jsonFeedPath, cla, outboxFeed, log)
+		if feedErr != nil {
+			log.Error("Failed to write JSON Feed", "path", cla.jsonFeedPath, "error", feedErr)
+			exitFailure()
+		}
+	}
+	if cla.dedupeMedia {
+		log.Info("Media deduplicated",
+			"linkedFilesCount", outboxFeed.DedupedMediaCount,
+			"savedBytes", outboxFeed.DedupedMediaBytes)
+	}
+	if len(cla.reportPath) != 0 {
+		reportErr := writeSkipReport(cla.reportPath, cla, outboxFeed, log)
+		if reportErr != nil {
+			log.Error("Failed to write skip report", "path", cla.reportPath, "error", reportErr)
+			exitFailure()
+		}
+	}
+	if len(cla.altTextReportPath) != 0 {
+		altTextReportErr := writeAltTextReport(cla.altTextReportPath, cla, outboxFeed, log)
+		if altTextReportErr != nil {
+			log.Error("Failed to write alt text report", "path", cla.altTextReportPath, "error", altTextReportErr)
+			exitFailure()
+		}
+	}
+	if len(cla.audioShortcode) != 0 && len(cla.shortcodesDirectory) != 0 {
+		shortcodeErr := writeShortcode(cla.shortcodesDirectory, cla.audioShortcode+".html", TEMPLATE_AUDIO_SHORTCODE, log)
+		if shortcodeErr != nil {
+			log.Error("Failed to write audio shortcode", "error", shortcodeErr)
+			exitFailure()
+		}
+	}
+	if len(cla.galleryShortcode) != 0 && len(cla.shortcodesDirectory) != 0 {
+		shortcodeErr := writeShortcode(cla.shortcodesDirectory, cla.galleryShortcode+".html", TEMPLATE_GALLERY_SHORTCODE, log)
+		if shortcodeErr != nil {
+			log.Error("Failed to write gallery shortcode", "error", shortcodeErr)
+			exitFailure()
+		}
+	}
+	if cla.layoutShortcodes && len(cla.shortcodesDirectory) != 0 {
+		for _, eachName := range []string{"cw.html", "mastodon-video.html"} {
+			shortcodeErr := writeShortcode(cla.shortcodesDirectory, eachName, LAYOUT_SHORTCODES[eachName], log)
+			if shortcodeErr != nil {
+				log.Error("Failed to write layout shortcode", "error", shortcodeErr)
+				exitFailure()
+			}
+		}
+	}
+	if cla.activityPub {
+		activityPubErr := writeActivityPubObjects(cla, outboxFeed, log)
+		if activityPubErr != nil {
+			log.Error("Failed to write ActivityPub objects", "error", activityPubErr)
+			exitFailure()
+		}
+	}
+	if len(cla.hugoConfigPath) != 0 {
+		hugoConfigErr := writeHugoConfig(cla.hugoConfigPath, cla, outboxFeed, log)
+		if hugoConfigErr != nil {
+			log.Error("Failed to write Hugo config", "path", cla.hugoConfigPath, "error", hugoConfigErr)
+			exitFailure()
+		}
+	}
+	if len(cla.redirectsPath) != 0 {
+		redirectsErr := writeRedirects(cla.redirectsPath, cla, outboxFeed, log)
+		if redirectsErr != nil {
+			log.Error("Failed to write redirects", "path", cla.redirectsPath, "error", redirectsErr)
+			exitFailure()
+		}
+	}
+	if len(cla.searchIndexPath) != 0 {
+		searchErr := writeSearchIndex(cla.searchIndexPath, cla, outboxFeed, log)
+		if searchErr != nil {
+			log.Error("Failed to write search index", "path", cla.searchIndexPath, "error", searchErr)
+			exitFailure()
+		}
+	}
+	if len(cla.csvPath) != 0 {
+		csvErr := writeCSV(cla.csvPath, outboxFeed, log)
+		if csvErr != nil {
+			log.Error("Failed to write CSV", "path", cla.csvPath, "error", csvErr)
+			exitFailure()
+		}
+	}
+	if len(cla.sqlitePath) != 0 {
+		sqliteErr := writeSQLite(cla.sqlitePath, outboxFeed, log)
+		if sqliteErr != nil {
+			log.Error("Failed to write SQLite database", "path", cla.sqlitePath, "error", sqliteErr)
+			exitFailure()
+		}
+	}
+	if len(cla.atomFeedPath) != 0 {
+		feedErr := writeAtomFeed(cla.atomFeedPath, cla, outboxFeed, log)
+		if feedErr != nil {
+			log.Error("Failed to write Atom feed", "path", cla.atomFeedPath, "error", feedErr)
+			exitFailure()
+		}
+	}
+	manifestErr := outputManifest.write(cla.outputDirectories())
+	if manifestErr != nil {
+		log.Error("Failed to write output manifest", "error", manifestErr)
+		exitFailure()
+	}
+	runCleanupFuncs(cleanupFuncs, log)
+	log.Info("Toot replication complete")
+}
+
+// fetchRemoteContent is the fetch command. It downloads the boosted toots,
+// media, link previews, expanded URLs and custom emoji of the toots into
+// their caches, without writing the output, so that later runs can convert
+// --offline.
+func fetchRemoteContent(cla *commandLineArgs, log *slog.Logger) {
+	outboxFeed, outboxErr := readArchive(cla, log)
+	if outboxErr != nil {
+		log.Error("Failed to read archive", "error", outboxErr)
 		os.Exit(-1)
 	}
-	// Anything to cleanup?
-	for _, eachFunc := range cleanupFuncs {
-		eachFunc(logger)
+	resolveErr := resolveContent(cla, outboxFeed, log)
+	if resolveErr != nil {
+		log.Error("Failed to fetch toot content", "error", resolveErr)
+		os.Exit(-1)
+	}
+	log.Info("Remote content fetched", "mediaCachePath", cla.mediaCacheDirectory)
+}
+
+// logStatistics is the stats command. It logs the counts of the toots the
+// convert command would publish, by year and hashtag, without writing the
+// output or fetching anything.
+func logStatistics(cla *commandLineArgs, log *slog.Logger) {
+	outboxFeed, outboxErr := readArchive(cla, log)
+	if outboxErr != nil {
+		log.Error("Failed to read archive", "error", outboxErr)
+		os.Exit(-1)
+	}
+	filterErr := filterArchive(cla, outboxFeed, false, log)
+	if filterErr != nil {
+		log.Error("Failed to filter archive", "error", filterErr)
+		os.Exit(-1)
+	}
+	redactArchive(cla, outboxFeed, log)
+	outboxFeed.logStatistics(log)
+}
+
+// validateArchive is the validate command. It checks the command line, the
+// archive outbox and the media of the toots the convert command would
+// publish, and exits with an error if there are problems.
+func validateArchive(cla *commandLineArgs, log *slog.Logger) {
+	outboxFeed, outboxErr := readArchive(cla, log)
+	if outboxErr != nil {
+		log.Error("Failed to read archive", "error", outboxErr)
+		os.Exit(-1)
+	}
+	filterErr := filterArchive(cla, outboxFeed, false, log)
+	if filterErr != nil {
+		log.Error("Failed to filter archive", "error", filterErr)
+		os.Exit(-1)
+	}
+	redactArchive(cla, outboxFeed, log)
+	checkedCount, problemCount := outboxFeed.validate(log)
+	if problemCount != 0 {
+		log.Error("Archive has problems", "tootCount", len(outboxFeed.OrderedItems), "checkedMediaCount", checkedCount, "problemCount", problemCount)
+		os.Exit(-1)
+	}
+	log.Info("Archive is valid", "tootCount", len(outboxFeed.OrderedItems), "checkedMediaCount", checkedCount)
+}
+
+// cleanOutput is the clean command. It deletes the files the previous run
+// generated, then the output manifest, without reading the archive.
+func cleanOutput(cla *commandLineArgs, log *slog.Logger) {
+	cleanupFuncs := []cleanupFunc{}
+	ensureDirectory(cla.outputRootPathHugoAssets, false, log)
+	lock, lockErr := lockOutput(cla.outputRootPathHugoAssets, log)
+	if lockErr != nil {
+		log.Error("Failed to lock the output directory", "error", lockErr)
+		os.Exit(-1)
+	}
+	cleanupFuncs = append(cleanupFuncs, lock.release)
+	exitFailure := func() {
+		lock.release(log)
+		os.Exit(-1)
+	}
+	outputManifest, outputManifestErr := newOutputManifest(cla.outputRootPathHugoAssets, cla.outputDirectories())
+	if outputManifestErr != nil {
+		log.Error("Failed to read output manifest", "error", outputManifestErr)
+		exitFailure()
+	}
+	outputManifest.removeTempFiles(log)
+	// Refuse to clean what may not be an output directory, e.g. a typo
+	foreignFiles := outputManifest.foreignFiles()
+	if len(foreignFiles) != 0 && !cla.force {
+		log.Error("Output has files the previous run didn't generate. Use --force to clean it anyway, which keeps them",
+			"path", cla.outputRootPathHugoAssets,
+			"fileCount", len(foreignFiles),
+			"firstPath", foreignFiles[0])
+		exitFailure()
+	}
+	if len(cla.backupDirectory) != 0 && len(outputManifest.previous) != 0 {
+		backupErr := cla.saveBackup(log)
+		if backupErr != nil {
+			log.Error("Failed to back up output", "error", backupErr)
+			exitFailure()
+		}
+	}
+	_, cleanErr := outputManifest.clean(map[string]bool{}, log)
+	if cleanErr != nil {
+		log.Error("Failed to delete generated files", "error", cleanErr)
+		exitFailure()
+	}
+	_, removeErr := outputManifest.remove(path.Join(cla.outputRootPathHugoAssets, OUTPUT_MANIFEST_FILENAME))
+	if removeErr != nil {
+		log.Error("Failed to delete output manifest", "error", removeErr)
+		exitFailure()
+	}
+	runCleanupFuncs(cleanupFuncs, log)
+}
+
+func main() {
+	lvl := &slog.LevelVar{}
+	lvl.Set(slog.LevelInfo)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: lvl,
+	}))
+
+	// The first argument names the command, unless it's a flag
+	commandName := "convert"
+	args := os.Args[1:]
+	if len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		commandName = args[0]
+		args = args[1:]
+	}
+	if commandName == "install-layouts" {
+		installErr := installLayouts(args, logger)
+		if installErr != nil {
+			logger.Error("Failed to install layouts", "error", installErr)
+			os.Exit(-1)
+		}
+		return
 	}
-	logger.Info("Toot replication complete")
+	command, commandExists := COMMANDS[commandName]
+	if !commandExists {
+		logger.Error("Invalid command specified", "command", commandName, "commands", append(commandNames(), "install-layouts"))
+		os.Exit(-1)
+	}
+	cla := commandLineArgs{
+		command: commandName,
+	}
+	parseError := cla.parseCommandLine(args, logger)
+	if parseError != nil {
+		logger.Error("Failed to parse command line arguments", "error", parseError)
+		os.Exit(-1)
+	}
+	lvl.Set(slog.Level(cla.logLevelValue))
+	logger.Info("Welcome to Hugodon!", "command", commandName)
+	command(&cla, logger)
 }
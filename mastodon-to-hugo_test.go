@@ -0,0 +1,2054 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TEST_ARCHIVE_OUTBOX is a small archive: a toot with a link and a hashtag, a
+// photo toot with a self-reply, a toot with a content warning and a
+// followers-only toot
+var TEST_ARCHIVE_OUTBOX = `{
+  "totalItems": 5,
+  "orderedItems": [
+    {
+      "id": "https://hachyderm.io/users/mweagle/statuses/110/activity",
+      "type": "Create",
+      "published": "2023-12-31T10:00:00Z",
+      "to": ["https://www.w3.org/ns/activitystreams#Public"],
+      "cc": ["https://hachyderm.io/users/mweagle/followers"],
+      "object": {
+        "id": "https://hachyderm.io/users/mweagle/statuses/110",
+        "type": "Note",
+        "published": "2023-12-31T10:00:00Z",
+        "url": "https://hachyderm.io/@mweagle/110",
+        "to": ["https://www.w3.org/ns/activitystreams#Public"],
+        "cc": ["https://hachyderm.io/users/mweagle/followers"],
+        "content": "<p>Happy new year! Read <a href=\"https://example.com/post\">example.com/post</a> <a href=\"https://hachyderm.io/tags/GoLang\" class=\"mention hashtag\" rel=\"tag\">#<span>GoLang</span></a></p>",
+        "attachment": [],
+        "tag": [{"type": "Hashtag", "href": "https://hachyderm.io/tags/golang", "name": "#GoLang"}]
+      }
+    },
+    {
+      "id": "https://hachyderm.io/users/mweagle/statuses/111/activity",
+      "type": "Create",
+      "published": "2024-02-02T17:40:31Z",
+      "to": ["https://www.w3.org/ns/activitystreams#Public"],
+      "cc": ["https://hachyderm.io/users/mweagle/followers"],
+      "object": {
+        "id": "https://hachyderm.io/users/mweagle/statuses/111",
+        "type": "Note",
+        "published": "2024-02-02T17:40:31Z",
+        "url": "https://hachyderm.io/@mweagle/111",
+        "to": ["https://www.w3.org/ns/activitystreams#Public"],
+        "cc": ["https://hachyderm.io/users/mweagle/followers"],
+        "content": "<p>Photo time with <strong>bold</strong> text</p>",
+        "attachment": [{
+          "type": "Document",
+          "mediaType": "image/png",
+          "url": "/media_attachments/files/111/original/a.png",
+          "name": "A red square",
+          "width": 8,
+          "height": 6
+        }],
+        "tag": [{"type": "Hashtag", "href": "https://hachyderm.io/tags/photo", "name": "#photo"}]
+      }
+    },
+    {
+      "id": "https://hachyderm.io/users/mweagle/statuses/112/activity",
+      "type": "Create",
+      "published": "2024-02-02T18:00:00Z",
+      "to": ["https://www.w3.org/ns/activitystreams#Public"],
+      "cc": ["https://hachyderm.io/users/mweagle/followers"],
+      "object": {
+        "id": "https://hachyderm.io/users/mweagle/statuses/112",
+        "type": "Note",
+        "published": "2024-02-02T18:00:00Z",
+        "url": "https://hachyderm.io/@mweagle/112",
+        "inReplyTo": "https://hachyderm.io/users/mweagle/statuses/111",
+        "to": ["https://www.w3.org/ns/activitystreams#Public"],
+        "cc": ["https://hachyderm.io/users/mweagle/followers"],
+        "content": "<p>Reply in the thread</p>",
+        "attachment": [],
+        "tag": []
+      }
+    },
+    {
+      "id": "https://hachyderm.io/users/mweagle/statuses/113/activity",
+      "type": "Create",
+      "published": "2024-02-03T09:00:00Z",
+      "to": ["https://www.w3.org/ns/activitystreams#Public"],
+      "cc": ["https://hachyderm.io/users/mweagle/followers"],
+      "object": {
+        "id": "https://hachyderm.io/users/mweagle/statuses/113",
+        "type": "Note",
+        "published": "2024-02-03T09:00:00Z",
+        "url": "https://hachyderm.io/@mweagle/113",
+        "summary": "Spoilers",
+        "to": ["https://www.w3.org/ns/activitystreams#Public"],
+        "cc": ["https://hachyderm.io/users/mweagle/followers"],
+        "content": "<p>The butler did it</p>",
+        "attachment": [],
+        "tag": []
+      }
+    },
+    {
+      "id": "https://hachyderm.io/users/mweagle/statuses/114/activity",
+      "type": "Create",
+      "published": "2024-02-03T10:00:00Z",
+      "to": ["https://hachyderm.io/users/mweagle/followers"],
+      "cc": [],
+      "object": {
+        "id": "https://hachyderm.io/users/mweagle/statuses/114",
+        "type": "Note",
+        "published": "2024-02-03T10:00:00Z",
+        "url": "https://hachyderm.io/@mweagle/114",
+        "to": ["https://hachyderm.io/users/mweagle/followers"],
+        "cc": [],
+        "content": "<p>Followers only</p>",
+        "attachment": [],
+        "tag": []
+      }
+    }
+  ]
+}`
+
+// testLogger discards the log output
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// testArchive writes an archive of the outbox JSON, with an 8x6 red PNG for
+// the photo toot, to a temporary directory and returns its path
+func testArchive(t *testing.T, outboxJSON string) string {
+	archiveRoot := t.TempDir()
+	mediaDirectory := filepath.Join(archiveRoot, "media_attachments", "files", "111", "original")
+	if mkdirErr := os.MkdirAll(mediaDirectory, 0755); mkdirErr != nil {
+		t.Fatal(mkdirErr)
+	}
+	redSquare := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	draw.Draw(redSquare, redSquare.Bounds(), image.NewUniform(color.RGBA{0xFF, 0x00, 0x00, 0xFF}), image.Point{}, draw.Src)
+	var pngBuffer bytes.Buffer
+	png.Encode(&pngBuffer, redSquare)
+	os.WriteFile(filepath.Join(mediaDirectory, "a.png"), pngBuffer.Bytes(), 0644)
+	os.WriteFile(filepath.Join(archiveRoot, "outbox.json"), []byte(outboxJSON), 0644)
+	return archiveRoot
+}
+
+// testCommandLineArgs parses the arguments as the command line would
+func testCommandLineArgs(t *testing.T, args ...string) *commandLineArgs {
+	t.Helper()
+	cla, parseErr := testParseCommandLine(args...)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	return cla
+}
+
+// testParseCommandLine parses the arguments into a fresh flag set
+func testParseCommandLine(args ...string) (*commandLineArgs, error) {
+	flag.CommandLine = flag.NewFlagSet("mastodon-to-hugo", flag.ContinueOnError)
+	flag.CommandLine.SetOutput(io.Discard)
+	cla := &commandLineArgs{
+		command: "convert",
+	}
+	return cla, cla.parseCommandLine(args, testLogger())
+}
+
+// testRender renders the test archive in the format, with any other
+// arguments, to a temporary directory and returns its path
+func testRender(t *testing.T, format string, args ...string) string {
+	t.Helper()
+	return testRenderArchive(t, testArchive(t, TEST_ARCHIVE_OUTBOX), format, args...)
+}
+
+// testRenderArchive renders the archive in the format, with any other
+// arguments, to a temporary directory and returns its path
+func testRenderArchive(t *testing.T, archiveRoot string, format string, args ...string) string {
+	t.Helper()
+	cla, outbox := testReadArchive(t, archiveRoot, append([]string{"--format", format}, args...)...)
+	if renderErr := OUTPUT_FORMATS[cla.outputFormat](cla, outbox, testLogger()); renderErr != nil {
+		t.Fatal(renderErr)
+	}
+	return cla.outputRootPathHugoAssets
+}
+
+// testReadArchive parses the arguments for the archive and a temporary
+// output directory, then reads the archive
+func testReadArchive(t *testing.T, archiveRoot string, args ...string) (*commandLineArgs, *Outbox) {
+	t.Helper()
+	cla := testCommandLineArgs(t, append([]string{"--input", archiveRoot, "--output", t.TempDir()}, args...)...)
+	return cla, testOutbox(t, cla)
+}
+
+// readTestOutput returns the contents of the output file, failing the test
+// if it wasn't written
+func readTestOutput(t *testing.T, outputPath string) string {
+	t.Helper()
+	outputData, outputDataErr := os.ReadFile(outputPath)
+	if outputDataErr != nil {
+		t.Fatalf("expected %s to be written: %s", outputPath, outputDataErr)
+	}
+	return string(outputData)
+}
+
+// expectContains checks that the output contains each of the expected strings
+func expectContains(t *testing.T, name string, output string, expected ...string) {
+	t.Helper()
+	for _, eachExpected := range expected {
+		if !strings.Contains(output, eachExpected) {
+			t.Errorf("%s: expected %q in:\n%s", name, eachExpected, output)
+		}
+	}
+}
+
+// testOutbox reads the archive and keeps the toots the filters publish
+func testOutbox(t *testing.T, cla *commandLineArgs) *Outbox {
+	t.Helper()
+	outbox, outboxErr := newOutbox(filepath.Join(cla.inputRootPathExpandedArchive, "outbox.json"))
+	if outboxErr != nil {
+		t.Fatal(outboxErr)
+	}
+	outbox.filterToots("audience", selfPublishFilter(cla.includeReplies, len(cla.boostStyle) != 0, cla.visibilities))
+	return outbox
+}
+
+func TestRenderOrgToDisk(t *testing.T) {
+	outputRoot := testRender(t, "org")
+	expectContains(t, "2023-12-31.org", readTestOutput(t, filepath.Join(outputRoot, "2023-12-31.org")),
+		"#+TITLE: Mastodon - 2023-12-31\n",
+		"#+FILETAGS: :GoLang:",
+		"[[https://example.com/post][example.com/post]]")
+	dayFile := readTestOutput(t, filepath.Join(outputRoot, "2024-02-02.org"))
+	expectContains(t, "2024-02-02.org", dayFile,
+		"\n* 17:40 Photo time with bold text\n",
+		":ID: https://hachyderm.io/users/mweagle/statuses/111\n",
+		"[[file:media/a.png][A red square]]",
+		// The self-reply is nested below its parent
+		"\n** 18:00 Reply in the thread\n")
+	if followersFile := readTestOutput(t, filepath.Join(outputRoot, "2024-02-03.org")); strings.Contains(followersFile, "Followers only") {
+		t.Errorf("expected the followers-only toot to be left out:\n%s", followersFile)
+	}
+	readTestOutput(t, filepath.Join(outputRoot, "media", "a.png"))
+}
+
+func TestRenderObsidianToDisk(t *testing.T) {
+	outputRoot := testRender(t, "obsidian")
+	expectContains(t, "2024-02-02.md", readTestOutput(t, filepath.Join(outputRoot, "2024-02-02.md")),
+		"---\ndate: 2024-02-02\ntags: [photo, Social_Media]\nsource: mastodon\n---\n",
+		"\n## 17:40\n",
+		"![[a.png]]",
+		// The thread is linked both ways through the block IDs
+		"↪ [[2024-02-02#^112|Next in thread]]",
+		"↩ [[2024-02-02#^111|Previous in thread]]",
+		" ^111\n",
+		" ^112\n")
+	expectContains(t, "2023-12-31.md", readTestOutput(t, filepath.Join(outputRoot, "2023-12-31.md")),
+		"[example.com/post](https://example.com/post)")
+	readTestOutput(t, filepath.Join(outputRoot, "attachments", "a.png"))
+}
+
+func TestRenderLogseqToDisk(t *testing.T) {
+	outputRoot := testRender(t, "logseq")
+	expectContains(t, "2024_02_02.md", readTestOutput(t, filepath.Join(outputRoot, "journals", "2024_02_02.md")),
+		"- 17:40 Photo time with **bold** text #photo #[[Social Media]]\n",
+		"  ![A red square](../assets/a.png)\n",
+		"  source:: https://hachyderm.io/@mweagle/111\n",
+		// The self-reply is a child block of its parent
+		"\t- 18:00 Reply in the thread #[[Social Media]]\n",
+		"\t  source:: https://hachyderm.io/@mweagle/112\n")
+	expectContains(t, "2023_12_31.md", readTestOutput(t, filepath.Join(outputRoot, "journals", "2023_12_31.md")),
+		"#GoLang")
+	readTestOutput(t, filepath.Join(outputRoot, "assets", "a.png"))
+}
+
+func TestMergeLogseqJournal(t *testing.T) {
+	page := "title:: Mine\n\n" +
+		"- My own note\n" +
+		"- 17:40 Old text\n" +
+		"  source:: https://hachyderm.io/@mweagle/111\n" +
+		"\t- My comment on the toot\n" +
+		"- 19:00 Deleted toot\n" +
+		"  source:: https://hachyderm.io/@mweagle/113\n"
+	chunks := []*logseqChunk{
+		{source: "https://hachyderm.io/@mweagle/110", text: "- 09:00 Earlier toot\n  source:: https://hachyderm.io/@mweagle/110\n"},
+		{source: "https://hachyderm.io/@mweagle/111", text: "- 17:40 New text\n  source:: https://hachyderm.io/@mweagle/111\n"},
+	}
+	tootSources := map[string]bool{
+		"https://hachyderm.io/@mweagle/110": true,
+		"https://hachyderm.io/@mweagle/111": true,
+		"https://hachyderm.io/@mweagle/113": true,
+	}
+	expected := "title:: Mine\n" +
+		"- My own note\n" +
+		"- 09:00 Earlier toot\n  source:: https://hachyderm.io/@mweagle/110\n" +
+		"- 17:40 New text\n  source:: https://hachyderm.io/@mweagle/111\n" +
+		"\t- My comment on the toot\n"
+	if merged := mergeLogseqJournal(page, chunks, tootSources); merged != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, merged)
+	}
+}
+
+func TestRenderHugoDataToDisk(t *testing.T) {
+	outputRoot := testRender(t, "hugo-data")
+	monthData := HugoDataMonth{}
+	if unmarshalErr := json.Unmarshal([]byte(readTestOutput(t, filepath.Join(outputRoot, "data", "mastodon", "2024-02.json"))), &monthData); unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+	tootIDs := []string{}
+	for _, eachToot := range monthData.Toots {
+		tootIDs = append(tootIDs, eachToot.ID)
+	}
+	if monthData.Month != "2024-02" || !reflect.DeepEqual(tootIDs, []string{"111", "112", "113"}) {
+		t.Fatalf("expected the February toots, got %s %v", monthData.Month, tootIDs)
+	}
+	photoToot := monthData.Toots[0]
+	if len(photoToot.Attachments) != 1 ||
+		photoToot.Attachments[0].URL != "/mastodon/2024/02/111/a.png" ||
+		photoToot.Attachments[0].Name != "A red square" {
+		t.Errorf("unexpected attachments: %#v", photoToot.Attachments)
+	}
+	if !reflect.DeepEqual(photoToot.Tags, []string{"photo", "Social Media"}) {
+		t.Errorf("expected the photo tag, got %v", photoToot.Tags)
+	}
+	if replyToot := monthData.Toots[1]; replyToot.ThreadRoot != "111" {
+		t.Errorf("expected the reply's thread root to be 111, got %s", replyToot.ThreadRoot)
+	}
+	readTestOutput(t, filepath.Join(outputRoot, "static", "mastodon", "2024", "02", "111", "a.png"))
+	readTestOutput(t, filepath.Join(outputRoot, "layouts", "shortcodes", "mastodon-toots.html"))
+}
+
+func TestGroupByMonth(t *testing.T) {
+	outputRoot := testRender(t, "hugo", "--group-by", "month")
+	february := readTestOutput(t, filepath.Join(outputRoot, "2024-02", "index.md"))
+	expectContains(t, "2024-02", february,
+		"title: \"Mastodon - 2024-02\"",
+		"\n## 2024-02-02\n",
+		"\n## 2024-02-03\n",
+		"Reply in the thread",
+		"The butler did it")
+	if strings.Count(february, "\n## 2024-02-02\n") != 1 {
+		t.Errorf("expected one 2024-02-02 section, got:\n%s", february)
+	}
+	if strings.Index(february, "\n## 2024-02-02\n") > strings.Index(february, "\n## 2024-02-03\n") {
+		t.Errorf("expected the days in order, got:\n%s", february)
+	}
+	expectContains(t, "2023-12", readTestOutput(t, filepath.Join(outputRoot, "2023-12", "index.md")), "\n## 2023-12-31\n")
+	if _, statErr := os.Stat(filepath.Join(outputRoot, "2024-02", "a.png")); statErr != nil {
+		t.Errorf("expected the attachment in the month bundle: %s", statErr)
+	}
+}
+
+func TestGroupByYear(t *testing.T) {
+	outputRoot := testRender(t, "hugo", "--group-by", "year")
+	year := readTestOutput(t, filepath.Join(outputRoot, "2024", "index.md"))
+	expectContains(t, "2024", year,
+		"title: \"Mastodon - 2024\"",
+		"\n- [2024-02-02 17:40 — Photo time with bold text](#toot-111)\n",
+		"\n  - [2024-02-02 18:00 — Reply in the thread](#toot-112)\n",
+		"\n## February 2024\n",
+		"\n### 2024-02-02 17:40 — Photo time with bold text {#toot-111}\n",
+		"\n### 2024-02-02 18:00 — Reply in the thread {#toot-112}\n")
+	if strings.Index(year, "(#toot-113)") > strings.Index(year, "\n## February 2024\n") {
+		t.Errorf("expected the table of contents before the sections, got:\n%s", year)
+	}
+	expectContains(t, "2023", readTestOutput(t, filepath.Join(outputRoot, "2023", "index.md")), "\n## December 2023\n")
+}
+
+func TestGroupByToot(t *testing.T) {
+	outputRoot := testRender(t, "hugo", "--group-by", "toot")
+	expectContains(t, "111", readTestOutput(t, filepath.Join(outputRoot, "2024", "02", "111", "index.md")), "Photo time")
+	reply := readTestOutput(t, filepath.Join(outputRoot, "2024", "02", "112", "index.md"))
+	expectContains(t, "112", reply, "title: \"Mastodon - 2024-02-02T18:00:00Z\"", "Reply in the thread")
+	if strings.Contains(reply, "Photo time") {
+		t.Errorf("expected only the reply on its page, got:\n%s", reply)
+	}
+}
+
+func TestGroupByThread(t *testing.T) {
+	outputRoot := testRender(t, "hugo", "--group-by", "thread")
+	thread := readTestOutput(t, filepath.Join(outputRoot, "2024", "02", "111", "index.md"))
+	expectContains(t, "111", thread, "Photo time", "\n## 2024-02-02 18:00 {#toot-112}\n", "Reply in the thread")
+	if strings.Contains(thread, "{#toot-111}") {
+		t.Errorf("expected no section for the thread root, got:\n%s", thread)
+	}
+	if _, statErr := os.Stat(filepath.Join(outputRoot, "2024", "02", "112")); !os.IsNotExist(statErr) {
+		t.Errorf("expected no page for the reply: %v", statErr)
+	}
+}
+
+func TestRenderHTMLToDisk(t *testing.T) {
+	outputRoot := testRender(t, "html")
+	page := readTestOutput(t, filepath.Join(outputRoot, "2024", "02", "111", "index.html"))
+	expectContains(t, "111", page,
+		"<nav><a href=\"../../../index.html\">",
+		"<article id=\"toot-111\">",
+		"Photo time with <strong>bold</strong> text",
+		"<img src=\"a.png\" alt=\"A red square\" loading=\"lazy\">",
+		"<article id=\"toot-112\">",
+		"Reply in the thread")
+	if _, statErr := os.Stat(filepath.Join(outputRoot, "2024", "02", "111", "a.png")); statErr != nil {
+		t.Errorf("expected the attachment beside the page: %s", statErr)
+	}
+	index := readTestOutput(t, filepath.Join(outputRoot, "index.html"))
+	expectContains(t, "index", index,
+		"<li><a href=\"2024/02/111/index.html\">2024-02-02 17:40 — Photo time with bold text</a></li>",
+		"<li><a href=\"2023/12/110/index.html\">")
+	if strings.Index(index, "2024/02/113/") > strings.Index(index, "2024/02/111/") {
+		t.Errorf("expected the newest thread first, got:\n%s", index)
+	}
+}
+
+func TestRenderGemtextToDisk(t *testing.T) {
+	outputRoot := testRender(t, "gemtext")
+	newYear := readTestOutput(t, filepath.Join(outputRoot, "2023-12-31.gmi"))
+	expectContains(t, "2023-12-31", newYear,
+		"# Mastodon - 2023-12-31\n",
+		"\n## 10:00\n\nHappy new year! Read example.com/post #GoLang\n\n",
+		"\n=> https://example.com/post example.com/post\n",
+		"\n=> https://hachyderm.io/@mweagle/110 ")
+	expectContains(t, "2024-02-02", readTestOutput(t, filepath.Join(outputRoot, "2024-02-02.gmi")),
+		"\n## 17:40\n\nPhoto time with bold text\n\n",
+		"\n=> media/a.png A red square\n",
+		"\n## 18:00\n\nReply in the thread\n")
+	expectContains(t, "index", readTestOutput(t, filepath.Join(outputRoot, "index.gmi")),
+		"\n=> 2024-02-02.gmi 2024-02-02 (2)\n",
+		"\n=> 2023-12-31.gmi 2023-12-31 (1)\n")
+	if _, statErr := os.Stat(filepath.Join(outputRoot, "media", "a.png")); statErr != nil {
+		t.Errorf("expected the attachment in media/: %s", statErr)
+	}
+}
+
+func TestEscapeGemtextText(t *testing.T) {
+	for _, eachCase := range []struct {
+		text     string
+		expected string
+	}{
+		{"plain text", "plain text"},
+		{"=> not a link\n# not a heading", " => not a link\n # not a heading"},
+		{"* item\n> quote\n```", " * item\n > quote\n ```"},
+		{"a #tag mid line", "a #tag mid line"},
+	} {
+		if escaped := escapeGemtextText(eachCase.text); escaped != eachCase.expected {
+			t.Errorf("escapeGemtextText(%q): expected %q, got %q", eachCase.text, eachCase.expected, escaped)
+		}
+	}
+}
+
+func TestGemtextLinkLine(t *testing.T) {
+	for _, eachCase := range []struct {
+		linkURL  string
+		text     string
+		expected string
+	}{
+		{"https://example.com", "Example", "=> https://example.com Example"},
+		{"media/a b.png", "A red\nsquare", "=> media/a%20b.png A red square"},
+		{"https://example.com", "", "=> https://example.com"},
+	} {
+		if linkLine := gemtextLinkLine(eachCase.linkURL, eachCase.text); linkLine != eachCase.expected {
+			t.Errorf("gemtextLinkLine(%q, %q): expected %q, got %q", eachCase.linkURL, eachCase.text, eachCase.expected, linkLine)
+		}
+	}
+}
+
+// readZipEntries returns the contents of every entry in the ZIP file, and the
+// entry names in order
+func readZipEntries(t *testing.T, zipPath string) (map[string]string, []string) {
+	t.Helper()
+	zipReader, zipReaderErr := zip.OpenReader(zipPath)
+	if zipReaderErr != nil {
+		t.Fatalf("expected %s to be a ZIP file: %s", zipPath, zipReaderErr)
+	}
+	defer zipReader.Close()
+	entries := map[string]string{}
+	entryNames := []string{}
+	for _, eachFile := range zipReader.File {
+		entryReader, entryReaderErr := eachFile.Open()
+		if entryReaderErr != nil {
+			t.Fatal(entryReaderErr)
+		}
+		entryData, _ := io.ReadAll(entryReader)
+		entryReader.Close()
+		entries[eachFile.Name] = string(entryData)
+		entryNames = append(entryNames, eachFile.Name)
+	}
+	return entries, entryNames
+}
+
+func TestRenderEPUBToDisk(t *testing.T) {
+	outputRoot := testRender(t, "epub")
+	entries, entryNames := readZipEntries(t, filepath.Join(outputRoot, "mastodon-2024.epub"))
+	if entryNames[0] != "mimetype" || entries["mimetype"] != "application/epub+zip" {
+		t.Errorf("expected the mimetype entry first, got %v", entryNames)
+	}
+	chapter := entries["OEBPS/chapter-01.xhtml"]
+	expectContains(t, "chapter", chapter,
+		"<h1>February 2024</h1>",
+		"<section id=\"toot-111\">\n<h2>2024-02-02 17:40</h2>\n<p>Photo time with <strong>bold</strong> text</p>\n",
+		"<img src=\"media/a.png\" alt=\"A red square\"/>",
+		"<section id=\"toot-112\">")
+	xmlDecoder := xml.NewDecoder(strings.NewReader(chapter))
+	for {
+		_, tokenErr := xmlDecoder.Token()
+		if tokenErr == io.EOF {
+			break
+		}
+		if tokenErr != nil {
+			t.Fatalf("expected the chapter to be well-formed XML: %s", tokenErr)
+		}
+	}
+	expectContains(t, "content.opf", entries["OEBPS/content.opf"],
+		"<item id=\"media-1\" href=\"media/a.png\" media-type=\"image/png\"/>",
+		"<itemref idref=\"chapter-01\"/>")
+	expectContains(t, "nav", entries["OEBPS/nav.xhtml"], "<li><a href=\"chapter-01.xhtml\">February 2024</a></li>")
+	if len(entries["OEBPS/media/a.png"]) <= 0 {
+		t.Errorf("expected the image in the EPUB, got %v", entryNames)
+	}
+	if _, statErr := os.Stat(filepath.Join(outputRoot, "mastodon-2023.epub")); statErr != nil {
+		t.Errorf("expected an EPUB for 2023: %s", statErr)
+	}
+}
+
+func TestRenderGhostToDisk(t *testing.T) {
+	outputRoot := testRender(t, "ghost")
+	var ghostImport GhostImport
+	if unmarshalErr := json.Unmarshal([]byte(readTestOutput(t, filepath.Join(outputRoot, "ghost-import.json"))), &ghostImport); unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+	ghostData := ghostImport.DB[0].Data
+	if len(ghostData.Posts) != 3 {
+		t.Fatalf("expected a post per thread, got %d", len(ghostData.Posts))
+	}
+	postIndex := slices.IndexFunc(ghostData.Posts, func(post *GhostPost) bool { return post.Slug == "mastodon-111" })
+	if postIndex < 0 {
+		t.Fatalf("expected a mastodon-111 post")
+	}
+	photoPost := ghostData.Posts[postIndex]
+	if photoPost.PublishedAt != "2024-02-02T17:40:31Z" || photoPost.UpdatedAt != "2024-02-02T18:00:00Z" || photoPost.Status != "published" {
+		t.Errorf("unexpected post dates or status: %+v", photoPost)
+	}
+	var lexical struct {
+		Root struct {
+			Children []struct {
+				HTML string `json:"html"`
+			} `json:"children"`
+		} `json:"root"`
+	}
+	if unmarshalErr := json.Unmarshal([]byte(photoPost.Lexical), &lexical); unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+	expectContains(t, "lexical", lexical.Root.Children[0].HTML,
+		"<img src=\"/content/images/2024/02/a.png\" alt=\"A red square\">",
+		"<p>Reply in the thread</p>")
+	tagIndex := slices.IndexFunc(ghostData.Tags, func(tag *GhostTag) bool { return tag.Slug == "photo" })
+	if tagIndex < 0 || !slices.ContainsFunc(ghostData.PostsTags, func(postTag *GhostPostTag) bool {
+		return *postTag == GhostPostTag{PostID: photoPost.ID, TagID: ghostData.Tags[tagIndex].ID}
+	}) {
+		t.Errorf("expected the post tagged photo, got %d post tags", len(ghostData.PostsTags))
+	}
+	if _, statErr := os.Stat(filepath.Join(outputRoot, "content", "images", "2024", "02", "a.png")); statErr != nil {
+		t.Errorf("expected the image in content/images: %s", statErr)
+	}
+}
+
+func TestRenderMicroblogToDisk(t *testing.T) {
+	outputRoot := testRender(t, "microblog")
+	photo := readTestOutput(t, filepath.Join(outputRoot, "2024", "02", "111", "index.md"))
+	expectContains(t, "111", photo,
+		"---\ndate: 2024-02-02T17:40:31Z\nphotos: [\"a.png\"]\ntags: [\"photo\",\"Social Media\"]\ncanonical: https://hachyderm.io/@mweagle/111\n",
+		"<img src=\"a.png\" alt=\"A red square\" />")
+	if strings.Contains(photo, "title:") || strings.Contains(photo, "Reply in the thread") {
+		t.Errorf("expected an untitled micropost of one toot, got:\n%s", photo)
+	}
+	expectContains(t, "112", readTestOutput(t, filepath.Join(outputRoot, "2024", "02", "112", "index.md")), "Reply in the thread")
+	expectContains(t, "feed.json", readTestOutput(t, filepath.Join(outputRoot, "feed.json")),
+		"\"url\": \"2024/02/111/a.png\"",
+		"\"url\": \"https://hachyderm.io/@mweagle/112\"")
+}
+
+func TestRenderMicroblogAltText(t *testing.T) {
+	archiveRoot := testArchive(t, strings.Replace(TEST_ARCHIVE_OUTBOX, `"name": "A red square"`, `"name": "A \"red\" <square>"`, 1))
+	outputRoot := testRenderArchive(t, archiveRoot, "microblog")
+	expectContains(t, "111", readTestOutput(t, filepath.Join(outputRoot, "2024", "02", "111", "index.md")),
+		"alt=\"A &#34;red&#34; &lt;square&gt;\"")
+}
+
+func TestRenderDayOneToDisk(t *testing.T) {
+	outputRoot := testRender(t, "dayone")
+	entries, _ := readZipEntries(t, filepath.Join(outputRoot, "mastodon-dayone.zip"))
+	var journal DayOneJournal
+	if unmarshalErr := json.Unmarshal([]byte(entries["Mastodon.json"]), &journal); unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+	if len(journal.Entries) != 4 {
+		t.Fatalf("expected an entry per toot, got %d", len(journal.Entries))
+	}
+	photoIndex := slices.IndexFunc(journal.Entries, func(entry *DayOneEntry) bool { return entry.CreationDate == "2024-02-02T17:40:31Z" })
+	if photoIndex < 0 {
+		t.Fatalf("expected an entry for the photo toot")
+	}
+	photoEntry := journal.Entries[photoIndex]
+	if photoEntry.UUID != dayOneIdentifier("https://hachyderm.io/users/mweagle/statuses/111") || len(photoEntry.UUID) != 32 {
+		t.Errorf("unexpected entry UUID %s", photoEntry.UUID)
+	}
+	if !slices.Contains(photoEntry.Tags, "photo") || len(photoEntry.Photos) != 1 {
+		t.Fatalf("expected the photo tag and one photo, got %+v", photoEntry)
+	}
+	photo := photoEntry.Photos[0]
+	expectContains(t, "text", photoEntry.Text,
+		"Photo time with **bold** text",
+		"\n\n![](dayone-moment://"+photo.Identifier+")",
+		"\n\n[Mastodon Source 🐘](https://hachyderm.io/@mweagle/111)")
+	photoBytes, photoFound := entries["photos/"+photo.MD5+".png"]
+	if !photoFound || fmt.Sprintf("%x", md5.Sum([]byte(photoBytes))) != photo.MD5 {
+		t.Errorf("expected the photo stored by its MD5 %s", photo.MD5)
+	}
+	if photo.Width != 8 || photo.Height != 6 || photo.Type != "png" {
+		t.Errorf("unexpected photo metadata %+v", photo)
+	}
+}
+
+func TestDownloadedMediaSource(t *testing.T) {
+	archiveRoot := testArchive(t, TEST_ARCHIVE_OUTBOX)
+	// The image was downloaded because it's missing from the archive
+	downloadPath := filepath.Join(t.TempDir(), "a.png")
+	if renameErr := os.Rename(filepath.Join(archiveRoot, TEST_ARCHIVE_PNG_PATH), downloadPath); renameErr != nil {
+		t.Fatal(renameErr)
+	}
+	downloadInfo, _ := os.Stat(downloadPath)
+	for _, eachFormat := range []string{"epub", "dayone"} {
+		cla, outbox := testReadArchive(t, archiveRoot, "--format", eachFormat)
+		for _, eachItem := range outbox.OrderedItems {
+			for _, eachAttachment := range eachItem.Object.Attachments {
+				eachAttachment.SourcePath = downloadPath
+			}
+		}
+		if renderErr := OUTPUT_FORMATS[cla.outputFormat](cla, outbox, testLogger()); renderErr != nil {
+			t.Errorf("%s: expected the downloaded image to be used: %s", eachFormat, renderErr)
+		}
+		if eachFormat != "epub" {
+			continue
+		}
+		entries, _ := readZipEntries(t, filepath.Join(cla.outputRootPathHugoAssets, "mastodon-2024.epub"))
+		if int64(len(entries["OEBPS/media/a.png"])) != downloadInfo.Size() {
+			t.Errorf("expected the downloaded image in the EPUB")
+		}
+		feedPath := filepath.Join(t.TempDir(), "feed.xml")
+		if feedErr := writeAtomFeed(feedPath, cla, outbox, testLogger()); feedErr != nil {
+			t.Fatal(feedErr)
+		}
+		expectContains(t, "feed.xml", readTestOutput(t, feedPath), fmt.Sprintf(" length=\"%d\"", downloadInfo.Size()))
+	}
+}
+
+func TestWriteSQLite(t *testing.T) {
+	_, outbox := testReadArchive(t, testArchive(t, TEST_ARCHIVE_OUTBOX))
+	scriptPath := filepath.Join(t.TempDir(), "toots.sql")
+	if writeErr := writeSQLite(scriptPath, outbox, testLogger()); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	script := readTestOutput(t, scriptPath)
+	expectContains(t, "toots.sql", script,
+		"BEGIN TRANSACTION;\n",
+		"INSERT INTO toots VALUES ('https://hachyderm.io/users/mweagle/statuses/112', 'https://hachyderm.io/@mweagle/112', '2024-02-02T18:00:00Z', 'https://hachyderm.io/users/mweagle/statuses/111', '<p>Reply in the thread</p>', 'Reply in the thread');\n",
+		"INSERT INTO attachments VALUES ('https://hachyderm.io/users/mweagle/statuses/111', 0, 'image/png', '/media_attachments/files/111/original/a.png', 'A red square', 8, 6);\n",
+		"INSERT INTO tags VALUES ('https://hachyderm.io/users/mweagle/statuses/111', 'Hashtag', 'photo', ",
+		"INSERT INTO threads VALUES ('https://hachyderm.io/users/mweagle/statuses/111', 'https://hachyderm.io/users/mweagle/statuses/112', 1);\n",
+		"COMMIT;\n")
+	if quoted := sqlQuote("it's"); quoted != "'it''s'" {
+		t.Errorf("expected the quote doubled, got %s", quoted)
+	}
+
+	if _, lookPathErr := exec.LookPath("sqlite3"); lookPathErr != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+	databasePath := filepath.Join(t.TempDir(), "toots.db")
+	if writeErr := writeSQLite(databasePath, outbox, testLogger()); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	queryOutput, queryErr := exec.Command("sqlite3", databasePath, "SELECT count(*) FROM toots; SELECT toot_id FROM threads WHERE position = 1;").Output()
+	if queryErr != nil {
+		t.Fatal(queryErr)
+	}
+	if string(queryOutput) != "4\nhttps://hachyderm.io/users/mweagle/statuses/112\n" {
+		t.Errorf("unexpected query output %q", queryOutput)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	_, outbox := testReadArchive(t, testArchive(t, TEST_ARCHIVE_OUTBOX))
+	for _, eachName := range []string{"toots.csv", "toots.tsv"} {
+		csvPath := filepath.Join(t.TempDir(), eachName)
+		if writeErr := writeCSV(csvPath, outbox, testLogger()); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		csvReader := csv.NewReader(strings.NewReader(readTestOutput(t, csvPath)))
+		if strings.HasSuffix(eachName, ".tsv") {
+			csvReader.Comma = '\t'
+		}
+		rows, rowsErr := csvReader.ReadAll()
+		if rowsErr != nil {
+			t.Fatalf("%s: %s", eachName, rowsErr)
+		}
+		if len(rows) != 5 || strings.Join(rows[0], " ") != "id date visibility reply_to hashtags media_count word_count url" {
+			t.Fatalf("%s: expected a header and a row per toot, got %v", eachName, rows)
+		}
+		photoIndex := slices.IndexFunc(rows, func(row []string) bool { return row[0] == "https://hachyderm.io/users/mweagle/statuses/111" })
+		if photoIndex < 0 {
+			t.Fatalf("%s: expected a row for the photo toot", eachName)
+		}
+		photoRow := rows[photoIndex]
+		if photoRow[1] != "2024-02-02T17:40:31Z" || photoRow[2] != "public" || photoRow[3] != "" || !strings.Contains(photoRow[4], "photo") || photoRow[5] != "1" || photoRow[6] != "5" {
+			t.Errorf("%s: unexpected photo row %v", eachName, photoRow)
+		}
+	}
+}
+
+func TestVisibility(t *testing.T) {
+	for _, eachCase := range []struct {
+		to       []string
+		cc       []string
+		expected string
+	}{
+		{[]string{"https://www.w3.org/ns/activitystreams#Public"}, []string{MY_FOLLOWERS_URL}, "public"},
+		{[]string{"as:Public"}, nil, "public"},
+		{[]string{MY_FOLLOWERS_URL}, []string{"Public"}, "unlisted"},
+		{[]string{MY_FOLLOWERS_URL}, nil, "followers"},
+		{[]string{"https://example.com/users/someone"}, nil, "direct"},
+	} {
+		activityObject := &ActivityObject{To: eachCase.to, CC: eachCase.cc}
+		if visibility := activityObject.Visibility(); visibility != eachCase.expected {
+			t.Errorf("to %v cc %v: expected %s, got %s", eachCase.to, eachCase.cc, eachCase.expected, visibility)
+		}
+	}
+}
+
+func TestWriteSearchIndex(t *testing.T) {
+	for _, eachCase := range []struct {
+		groupBy  string
+		expected string
+	}{
+		{"bundle", "/mastodon/2024/02/111/"},
+		{"thread", "/mastodon/2024/02/111/#toot-112"},
+		{"year", "/mastodon/2024/#toot-112"},
+	} {
+		shortcodesDirectory := t.TempDir()
+		cla, outbox := testReadArchive(t, testArchive(t, TEST_ARCHIVE_OUTBOX),
+			"--group-by", eachCase.groupBy,
+			"--section-url", "/mastodon",
+			"--shortcodes", shortcodesDirectory)
+		indexPath := filepath.Join(t.TempDir(), "mastodon-search.json")
+		if writeErr := writeSearchIndex(indexPath, cla, outbox, testLogger()); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		searchDocuments := []*SearchDocument{}
+		if unmarshalErr := json.Unmarshal([]byte(readTestOutput(t, indexPath)), &searchDocuments); unmarshalErr != nil {
+			t.Fatal(unmarshalErr)
+		}
+		replyIndex := slices.IndexFunc(searchDocuments, func(document *SearchDocument) bool { return document.ID == "112" })
+		if len(searchDocuments) != 4 || replyIndex < 0 {
+			t.Fatalf("%s: expected a document per toot, got %d", eachCase.groupBy, len(searchDocuments))
+		}
+		reply := searchDocuments[replyIndex]
+		if reply.URL != eachCase.expected || reply.Content != "Reply in the thread" || reply.Date != "2024-02-02T18:00:00Z" {
+			t.Errorf("%s: unexpected reply document %+v", eachCase.groupBy, reply)
+		}
+		expectContains(t, "shortcode", readTestOutput(t, filepath.Join(shortcodesDirectory, "mastodon-search.html")), "class=\"mastodon-search\"")
+	}
+}
+
+func TestWriteActivityPubObjects(t *testing.T) {
+	archiveRoot := testArchive(t, TEST_ARCHIVE_OUTBOX)
+	if _, parseErr := testParseCommandLine("--input", archiveRoot, "--output", t.TempDir(), "--activitypub"); parseErr == nil {
+		t.Errorf("expected --activitypub without --base-url to be rejected")
+	}
+	cla, outbox := testReadArchive(t, archiveRoot, "--activitypub", "--base-url", "https://example.com/", "--section-url", "/mastodon/")
+	if writeErr := writeActivityPubObjects(cla, outbox, testLogger()); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	var reply ActivityPubNote
+	if unmarshalErr := json.Unmarshal([]byte(readTestOutput(t, filepath.Join(cla.outputRootPathHugoAssets, "2024", "02", "111", "112.json"))), &reply); unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+	if reply.ID != "https://example.com/mastodon/2024/02/111/112.json" ||
+		reply.URL != "https://example.com/mastodon/2024/02/111/" ||
+		reply.InReplyTo != "https://example.com/mastodon/2024/02/111/111.json" ||
+		reply.AttributedTo != "https://hachyderm.io/users/mweagle" {
+		t.Errorf("unexpected reply note %+v", reply)
+	}
+	var photo ActivityPubNote
+	if unmarshalErr := json.Unmarshal([]byte(readTestOutput(t, filepath.Join(cla.outputRootPathHugoAssets, "2024", "02", "111", "111.json"))), &photo); unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+	if len(photo.Attachments) != 1 || photo.Attachments[0].URL != "https://example.com/mastodon/2024/02/111/a.png" {
+		t.Errorf("unexpected photo attachments %+v", photo.Attachments)
+	}
+	mappings := []*ActivityPubMapping{}
+	if unmarshalErr := json.Unmarshal([]byte(readTestOutput(t, filepath.Join(cla.outputRootPathHugoAssets, "activitypub.json"))), &mappings); unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+	if len(mappings) != 4 || mappings[0].OriginalURL != "https://hachyderm.io/@mweagle/110" {
+		t.Errorf("expected a mapping per toot, got %d", len(mappings))
+	}
+}
+
+func TestWriteRedirects(t *testing.T) {
+	archiveRoot := testArchive(t, TEST_ARCHIVE_OUTBOX)
+	if _, parseErr := testParseCommandLine("--input", archiveRoot, "--output", t.TempDir(), "--redirects-format", "apache"); parseErr == nil {
+		t.Errorf("expected an unknown redirects format to be rejected")
+	}
+	for _, eachCase := range []struct {
+		format   string
+		args     []string
+		expected []string
+	}{
+		{"netlify", nil, []string{
+			"/@mweagle/112 /mastodon/2024/02/111/ 301\n",
+			"/users/mweagle/statuses/112 /mastodon/2024/02/111/ 301\n"}},
+		{"caddy", []string{"--base-url", "https://example.com"}, []string{
+			"redir /@mweagle/110 https://example.com/mastodon/2023/12/110/ permanent\n"}},
+		{"nginx", []string{"--group-by", "thread"}, []string{
+			"map $uri $mastodon_redirect {\n",
+			"    /@mweagle/112 /mastodon/2024/02/111/#toot-112;\n",
+			"\n}\n"}},
+	} {
+		cla, outbox := testReadArchive(t, archiveRoot, append([]string{"--redirects-format", eachCase.format, "--section-url", "/mastodon/"}, eachCase.args...)...)
+		redirectsPath := filepath.Join(t.TempDir(), "_redirects")
+		if writeErr := writeRedirects(redirectsPath, cla, outbox, testLogger()); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		redirects := readTestOutput(t, redirectsPath)
+		expectContains(t, eachCase.format, redirects, eachCase.expected...)
+		if strings.Count(redirects, "/mastodon/") != 8 {
+			t.Errorf("%s: expected two rules per toot, got:\n%s", eachCase.format, redirects)
+		}
+	}
+}
+
+// testToot returns a public toot with the status ID, publish time and content
+func testToot(statusID string, published string, content string) *ActivityEntry {
+	return &ActivityEntry{
+		Type:      "Create",
+		Published: published,
+		To:        ACTIVITY_STREAMS_PUBLIC[:1],
+		Object: &ActivityObject{
+			ID:      "https://hachyderm.io/users/mweagle/statuses/" + statusID,
+			Content: content,
+		},
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	testCases := []struct {
+		name     string
+		document string
+		expected interface{}
+	}{
+		{"mapping", "a: 1\nb: text\nc: true\nd: ~\n", map[string]interface{}{
+			"a": yamlNumber("1"),
+			"b": "text",
+			"c": true,
+			"d": nil,
+		}},
+		{"nested mapping", "filters:\n  since: 2023-01-01\n  tags: [go, hugo]\n", map[string]interface{}{
+			"filters": map[string]interface{}{
+				"since": "2023-01-01",
+				"tags":  []interface{}{"go", "hugo"},
+			},
+		}},
+		{"sequence of mappings", "rules:\n  - action: include\n    weight: 1.50\n  - action: exclude\n", map[string]interface{}{
+			"rules": []interface{}{
+				map[string]interface{}{"action": "include", "weight": yamlNumber("1.50")},
+				map[string]interface{}{"action": "exclude"},
+			},
+		}},
+		{"quoted scalars", "a: \"x: y\"\nb: 'it''s'\nc: 007\n", map[string]interface{}{
+			"a": "x: y",
+			"b": "it's",
+			"c": yamlNumber("007"),
+		}},
+		{"flow mapping", "a: {b: 1, c: [x, y]}\n", map[string]interface{}{
+			"a": map[string]interface{}{"b": yamlNumber("1"), "c": []interface{}{"x", "y"}},
+		}},
+		{"comments", "# heading\na: 1 # trailing\nb: \"#not\"\n", map[string]interface{}{
+			"a": yamlNumber("1"),
+			"b": "#not",
+		}},
+		{"literal block", "a: |\n  one\n  two\nb: x\n", map[string]interface{}{
+			"a": "one\ntwo\n",
+			"b": "x",
+		}},
+		{"folded block", "a: >\n  one\n  two\n", map[string]interface{}{
+			"a": "one two\n",
+		}},
+		{"document markers", "---\na: x\n...\n", map[string]interface{}{
+			"a": "x",
+		}},
+		{"not a number", "a: 1.2.3\nb: .inf\n", map[string]interface{}{
+			"a": "1.2.3",
+			"b": ".inf",
+		}},
+	}
+	for _, eachCase := range testCases {
+		parsed, parsedErr := parseYAML(eachCase.document)
+		if parsedErr != nil {
+			t.Errorf("%s: unexpected error: %s", eachCase.name, parsedErr)
+			continue
+		}
+		if !reflect.DeepEqual(parsed, eachCase.expected) {
+			t.Errorf("%s: expected %#v, got %#v", eachCase.name, eachCase.expected, parsed)
+		}
+	}
+	for _, eachDocument := range []string{"a: [1, 2\n", "a: {b: 1\n", "a: 'open\n"} {
+		if _, parsedErr := parseYAML(eachDocument); parsedErr == nil {
+			t.Errorf("expected an error parsing %q", eachDocument)
+		}
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	testCases := []struct {
+		filename string
+		document string
+	}{
+		{"rules.yaml", "default: exclude\nrules:\n  - action: include\n    tags: [go, 2024]\n    matching: 1.50\n    since: 2024-01-01\n"},
+		{"rules.yml", "default: exclude\nrules: [{action: include, tags: [go, 2024], matching: 1.50, since: 2024-01-01}]\n"},
+		{"rules.json", `{"default": "exclude", "rules": [{"action": "include", "tags": ["go", 2024], "matching": 1.50, "since": "2024-01-01"}]}`},
+	}
+	for _, eachCase := range testCases {
+		configPath := filepath.Join(t.TempDir(), eachCase.filename)
+		os.WriteFile(configPath, []byte(eachCase.document), 0644)
+		rules := &filterRules{}
+		if readErr := readConfigFile(configPath, rules); readErr != nil {
+			t.Errorf("%s: unexpected error: %s", eachCase.filename, readErr)
+			continue
+		}
+		if rules.Default != "exclude" ||
+			len(rules.Rules) != 1 ||
+			!reflect.DeepEqual(rules.Rules[0].Tags, []string{"go", "2024"}) ||
+			rules.Rules[0].Matching != "1.50" ||
+			rules.Rules[0].Since != "2024-01-01" {
+			t.Errorf("%s: unexpected rules: %#v", eachCase.filename, rules.Rules)
+		}
+	}
+	// Numbers read into untyped values are numbers
+	paramsPath := filepath.Join(t.TempDir(), "params.toml")
+	os.WriteFile(paramsPath, []byte("weight = 10\nratio = 1.5\n"), 0644)
+	params := map[string]interface{}{}
+	if readErr := readConfigFile(paramsPath, &params); readErr != nil {
+		t.Errorf("params.toml: unexpected error: %s", readErr)
+	} else if !reflect.DeepEqual(params, map[string]interface{}{"weight": 10.0, "ratio": 1.5}) {
+		t.Errorf("params.toml: expected numbers, got %#v", params)
+	}
+}
+
+func TestFilterRules(t *testing.T) {
+	rulesDocument := `default: exclude
+rules:
+  - action: exclude
+    tags: ["#Politics"]
+  - action: include
+    tags: [hugo, 2024]
+  - action: include
+    matching: "(?i)golang"
+    since: 2024-01-01
+  - action: include
+    visibility: [unlisted]
+  - action: exclude
+    replies: others
+  - action: include
+    replies: self
+`
+	rulesPath := filepath.Join(t.TempDir(), "rules.yaml")
+	os.WriteFile(rulesPath, []byte(rulesDocument), 0644)
+	rules, rulesErr := newFilterRules(rulesPath)
+	if rulesErr != nil {
+		t.Fatalf("unexpected error: %s", rulesErr)
+	}
+	withTags := func(entry *ActivityEntry, tagNames ...string) *ActivityEntry {
+		for _, eachName := range tagNames {
+			entry.Object.Tags = append(entry.Object.Tags, &ActivityObjectTag{Type: "Hashtag", Name: eachName})
+		}
+		return entry
+	}
+	replyTo := func(entry *ActivityEntry, inReplyTo string) *ActivityEntry {
+		entry.Object.InReplyTo = inReplyTo
+		return entry
+	}
+	unlisted := testToot("114", "2023-06-01T10:00:00Z", "<p>quiet</p>")
+	unlisted.To, unlisted.CC = nil, ACTIVITY_STREAMS_PUBLIC[:1]
+	testCases := []struct {
+		name     string
+		toot     *ActivityEntry
+		expected bool
+	}{
+		{"excluded tag wins", withTags(testToot("111", "2024-02-01T10:00:00Z", "<p>x</p>"), "#politics", "#hugo"), false},
+		{"tag", withTags(testToot("112", "2023-02-01T10:00:00Z", "<p>x</p>"), "#Hugo"), true},
+		{"numeric tag", withTags(testToot("112", "2023-02-01T10:00:00Z", "<p>x</p>"), "#2024"), true},
+		{"matching since", testToot("113", "2024-02-01T10:00:00Z", "<p>GoLang tips</p>"), true},
+		{"matching before since", testToot("113", "2023-02-01T10:00:00Z", "<p>GoLang tips</p>"), false},
+		{"visibility", unlisted, true},
+		{"reply to others", replyTo(testToot("115", "2023-02-01T10:00:00Z", "<p>x</p>"), "https://example.com/users/other/statuses/1"), false},
+		{"self reply", replyTo(testToot("116", "2023-02-01T10:00:00Z", "<p>x</p>"), "https://hachyderm.io/users/mweagle/statuses/1"), true},
+		{"default", testToot("117", "2023-02-01T10:00:00Z", "<p>x</p>"), false},
+	}
+	for _, eachCase := range testCases {
+		if included := rules.filter(eachCase.toot); included != eachCase.expected {
+			t.Errorf("%s: expected %t, got %t", eachCase.name, eachCase.expected, included)
+		}
+	}
+	for _, eachDocument := range []string{
+		"default: maybe\n",
+		"rules:\n  - action: keep\n",
+		"rules:\n  - action: include\n    visibility: [everyone]\n",
+		"rules:\n  - action: include\n    matching: \"(\"\n",
+		"rules:\n  - action: include\n    since: someday\n",
+		"rules:\n  - action: include\n    replies: all\n",
+	} {
+		invalidPath := filepath.Join(t.TempDir(), "rules.yaml")
+		os.WriteFile(invalidPath, []byte(eachDocument), 0644)
+		if _, invalidErr := newFilterRules(invalidPath); invalidErr == nil {
+			t.Errorf("expected an error reading %q", eachDocument)
+		}
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	testCases := []struct {
+		name     string
+		document string
+		expected map[string]interface{}
+	}{
+		{"pairs", "a = 1\nb = \"text\"\nc = false\nd = 1.5\n", map[string]interface{}{
+			"a": yamlNumber("1"),
+			"b": "text",
+			"c": false,
+			"d": yamlNumber("1.5"),
+		}},
+		{"tables", "[filters]\nsince = \"2023-01-01\"\n[output.hugo]\nsection = \"toots\"\n", map[string]interface{}{
+			"filters": map[string]interface{}{"since": "2023-01-01"},
+			"output": map[string]interface{}{
+				"hugo": map[string]interface{}{"section": "toots"},
+			},
+		}},
+		{"dotted keys", "a.b = 1\na.c = 2\n", map[string]interface{}{
+			"a": map[string]interface{}{"b": yamlNumber("1"), "c": yamlNumber("2")},
+		}},
+		{"multi-line array", "tags = [\n  \"go\", # comment\n  \"hugo\",\n]\n", map[string]interface{}{
+			"tags": []interface{}{"go", "hugo"},
+		}},
+		{"comments", "# heading\na = \"#not\" # trailing\n", map[string]interface{}{
+			"a": "#not",
+		}},
+	}
+	for _, eachCase := range testCases {
+		parsed, parsedErr := parseTOML(eachCase.document)
+		if parsedErr != nil {
+			t.Errorf("%s: unexpected error: %s", eachCase.name, parsedErr)
+			continue
+		}
+		if !reflect.DeepEqual(parsed, eachCase.expected) {
+			t.Errorf("%s: expected %#v, got %#v", eachCase.name, eachCase.expected, parsed)
+		}
+	}
+	for _, eachDocument := range []string{"[[rules]]\n", "a = { b = 1 }\n", "a = \"\"\"x\"\"\"\n", "a\n", "a = 1\n[a]\n", "[open\n"} {
+		if _, parsedErr := parseTOML(eachDocument); parsedErr == nil {
+			t.Errorf("expected an error parsing %q", eachDocument)
+		}
+	}
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	testFlagSet := func() (*flag.FlagSet, *string, *string, *bool, *regexpListFlag) {
+		flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+		flagSet.SetOutput(io.Discard)
+		output := flagSet.String("output", "", "")
+		widths := flagSet.String("srcset-widths", "", "")
+		stripExif := flagSet.Bool("strip-exif", false, "")
+		patterns := &regexpListFlag{}
+		flagSet.Var(patterns, "include-matching", "")
+		flagSet.String("config", "", "")
+		return flagSet, output, widths, stripExif, patterns
+	}
+	for _, eachCase := range []struct {
+		filename string
+		document string
+	}{
+		{"config.yaml", "output: content/posts\nmedia:\n  srcset-widths: [480, 960]\n  strip-exif: true\ninclude-matching: [go, hugo]\n"},
+		{"config.toml", "output = \"content/posts\"\ninclude-matching = [\"go\", \"hugo\"]\n[media]\nsrcset-widths = [480, 960]\nstrip-exif = true\n"},
+		{"config.json", `{"output": "content/posts", "media": {"srcset-widths": [480, 960], "strip-exif": true}, "include-matching": ["go", "hugo"]}`},
+	} {
+		configPath := filepath.Join(t.TempDir(), eachCase.filename)
+		os.WriteFile(configPath, []byte(eachCase.document), 0644)
+		flagSet, output, widths, stripExif, patterns := testFlagSet()
+		if applyErr := applyConfigFile(configPath, flagSet); applyErr != nil {
+			t.Errorf("%s: unexpected error: %s", eachCase.filename, applyErr)
+			continue
+		}
+		if *output != "content/posts" || *widths != "480,960" || !*stripExif || patterns.String() != "go, hugo" {
+			t.Errorf("%s: unexpected flags: %q %q %t %q", eachCase.filename, *output, *widths, *stripExif, patterns.String())
+		}
+	}
+
+	// Numbers set flags to their text as written
+	numbersPath := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(numbersPath, []byte("output: 007\nmedia:\n  srcset-widths: [480, 960.0]\n"), 0644)
+	flagSet, output, widths, _, _ := testFlagSet()
+	if applyErr := applyConfigFile(numbersPath, flagSet); applyErr != nil || *output != "007" || *widths != "480,960.0" {
+		t.Errorf("expected numbers as written: %q %q %v", *output, *widths, applyErr)
+	}
+
+	// Flags given on the command line override the file
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(configPath, []byte("output: content/posts\n"), 0644)
+	flagSet, output, _, _, _ = testFlagSet()
+	flagSet.Parse([]string{"--output", "public/toots"})
+	if applyErr := applyConfigFile(configPath, flagSet); applyErr != nil || *output != "public/toots" {
+		t.Errorf("expected the command line to override the file: %q %v", *output, applyErr)
+	}
+
+	for _, eachDocument := range []string{
+		"unknown: 1\n",
+		"config: other.yaml\n",
+		"output: a\nsection:\n  output: b\n",
+		"output: {a: b}\n",
+		"- output\n",
+	} {
+		invalidPath := filepath.Join(t.TempDir(), "config.yaml")
+		os.WriteFile(invalidPath, []byte(eachDocument), 0644)
+		flagSet, _, _, _, _ := testFlagSet()
+		if applyErr := applyConfigFile(invalidPath, flagSet); applyErr == nil {
+			t.Errorf("expected an error applying %q", eachDocument)
+		}
+	}
+}
+
+func TestConfigFlag(t *testing.T) {
+	archiveRoot := testArchive(t, TEST_ARCHIVE_OUTBOX)
+	outputRoot := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(configPath, []byte(fmt.Sprintf("input: %s\noutput: %s\nfilters:\n  exclude-tags: [politics, \"#Go\"]\n", archiveRoot, outputRoot)), 0644)
+	cla := testCommandLineArgs(t, "--config", configPath)
+	if cla.inputRootPathExpandedArchive != archiveRoot || cla.outputRootPathHugoAssets != outputRoot || !reflect.DeepEqual(cla.excludeTags, []string{"politics", "go"}) {
+		t.Errorf("expected the flags from the config file: %q %q %v", cla.inputRootPathExpandedArchive, cla.outputRootPathHugoAssets, cla.excludeTags)
+	}
+}
+
+// testRedactArgs writes the --redact terms file and returns the arguments
+// that read it
+func testRedactArgs(t *testing.T, mode string, terms ...string) []string {
+	termsPath := filepath.Join(t.TempDir(), "redact.txt")
+	os.WriteFile(termsPath, []byte("# Terms to redact\n\n"+strings.Join(terms, "\n")+"\n"), 0644)
+	return []string{"--redact", termsPath, "--redact-mode", mode}
+}
+
+func TestRedactToots(t *testing.T) {
+	archiveRoot := testArchive(t, TEST_ARCHIVE_OUTBOX)
+	cla, outbox := testReadArchive(t, archiveRoot, testRedactArgs(t, "replace", "butler", "square", "example", "cat")...)
+	if redactedCount := outbox.redactToots(cla.redactPattern); redactedCount != 3 {
+		t.Errorf("expected 3 toots redacted, got %d", redactedCount)
+	}
+	redactedObjects := map[string]*ActivityObject{}
+	for _, eachItem := range outbox.OrderedItems {
+		redactedObjects[tootFileID(eachItem)] = eachItem.Object
+	}
+	if redactedObjects["113"].Content != "<p>The █████ did it</p>" {
+		t.Errorf("unexpected redacted content %s", redactedObjects["113"].Content)
+	}
+	// A link whose target matches becomes the redacted text
+	expectContains(t, "110", redactedObjects["110"].Content, "Read █████.com/post <a ")
+	if strings.Contains(redactedObjects["110"].Content, "example") {
+		t.Errorf("expected the matching link target to be removed: %s", redactedObjects["110"].Content)
+	}
+	if name := redactedObjects["111"].Attachments[0].Name; name != "A red █████" {
+		t.Errorf("unexpected redacted media description %s", name)
+	}
+	if !cla.redactPattern.MatchString("Cat!") || cla.redactPattern.MatchString("concatenate") {
+		t.Errorf("expected terms to match whole words regardless of case")
+	}
+
+	cla, outbox = testReadArchive(t, archiveRoot, testRedactArgs(t, "skip", "butler")...)
+	outbox.filterToots("redact", redactFilter(cla.redactPattern))
+	if len(outbox.OrderedItems) != 3 || slices.ContainsFunc(outbox.OrderedItems, func(entry *ActivityEntry) bool { return tootFileID(entry) == "113" }) {
+		t.Errorf("expected only the butler toot skipped, got %d toots", len(outbox.OrderedItems))
+	}
+	// Media descriptions are matched too
+	cla, outbox = testReadArchive(t, archiveRoot, testRedactArgs(t, "skip", "square")...)
+	outbox.filterToots("redact", redactFilter(cla.redactPattern))
+	if slices.ContainsFunc(outbox.OrderedItems, func(entry *ActivityEntry) bool { return tootFileID(entry) == "111" }) {
+		t.Errorf("expected the toot with a matching media description skipped")
+	}
+	if _, parseErr := testParseCommandLine(append([]string{"--input", archiveRoot, "--output", t.TempDir()}, testRedactArgs(t, "hide", "butler")...)...); parseErr == nil {
+		t.Errorf("expected an unknown redact mode to be rejected")
+	}
+}
+
+// TEST_MENTION_TOOT replies to, and mentions, another user and mentions the
+// archive owner
+var TEST_MENTION_TOOT = `{
+      "id": "https://hachyderm.io/users/mweagle/statuses/115/activity",
+      "type": "Create",
+      "published": "2024-02-04T12:00:00Z",
+      "to": ["https://www.w3.org/ns/activitystreams#Public"],
+      "cc": ["https://hachyderm.io/users/mweagle/followers", "https://mastodon.social/users/alice"],
+      "object": {
+        "id": "https://hachyderm.io/users/mweagle/statuses/115",
+        "type": "Note",
+        "published": "2024-02-04T12:00:00Z",
+        "url": "https://hachyderm.io/@mweagle/115",
+        "inReplyTo": "https://mastodon.social/users/alice/statuses/9",
+        "to": ["https://www.w3.org/ns/activitystreams#Public"],
+        "cc": ["https://hachyderm.io/users/mweagle/followers", "https://mastodon.social/users/alice"],
+        "content": "<p><span class=\"h-card\"><a href=\"https://mastodon.social/@alice\" class=\"u-url mention\">@<span>alice</span></a></span> thanks @alice@mastodon.social and @alice! cc <a href=\"https://hachyderm.io/@mweagle\" class=\"u-url mention\">@<span>mweagle</span></a></p>",
+        "attachment": [],
+        "tag": [
+          {"type": "Mention", "href": "https://mastodon.social/users/alice", "name": "@alice@mastodon.social"},
+          {"type": "Mention", "href": "https://hachyderm.io/users/mweagle", "name": "@mweagle"}
+        ]
+      }
+    },`
+
+func TestAnonymizeMentions(t *testing.T) {
+	archiveRoot := testArchive(t, strings.Replace(TEST_ARCHIVE_OUTBOX, `"orderedItems": [`, `"orderedItems": [`+TEST_MENTION_TOOT, 1))
+	cla, outbox := testReadArchive(t, archiveRoot, "--include-replies", "--anonymize-mentions", "--mention-placeholder", "<someone>")
+	if anonymizedCount := outbox.anonymizeMentions(cla.mentionPlaceholder); anonymizedCount != 1 {
+		t.Errorf("expected one toot anonymized, got %d", anonymizedCount)
+	}
+	mentionIndex := slices.IndexFunc(outbox.OrderedItems, func(entry *ActivityEntry) bool { return tootFileID(entry) == "115" })
+	if mentionIndex < 0 {
+		t.Fatalf("expected the reply to be published with --include-replies")
+	}
+	mentionObject := outbox.OrderedItems[mentionIndex].Object
+	expected := "<p><span class=\"h-card\">&lt;someone&gt;</span> thanks &lt;someone&gt; and &lt;someone&gt;! cc <a href=\"https://hachyderm.io/@mweagle\" class=\"u-url mention\">@<span>mweagle</span></a></p>"
+	if mentionObject.Content != expected {
+		t.Errorf("expected the content:\n%s\ngot:\n%s", expected, mentionObject.Content)
+	}
+	if len(mentionObject.InReplyTo) != 0 || slices.Contains(mentionObject.CC, "https://mastodon.social/users/alice") {
+		t.Errorf("expected the other user's reply target and address removed, got %s %v", mentionObject.InReplyTo, mentionObject.CC)
+	}
+	mentionNames := []string{}
+	for _, eachTag := range mentionObject.Tags {
+		if eachTag.Type == "Mention" {
+			mentionNames = append(mentionNames, eachTag.Name)
+		}
+	}
+	if !slices.Equal(mentionNames, []string{"@mweagle"}) {
+		t.Errorf("expected only the self mention tag kept, got %v", mentionNames)
+	}
+}
+
+// testFilterKeeps returns which of the toots the filter keeps
+func testFilterKeeps(filter FilterTootFunc, toots ...*ActivityEntry) []bool {
+	kept := []bool{}
+	for _, eachToot := range toots {
+		kept = append(kept, filter(eachToot))
+	}
+	return kept
+}
+
+func TestDuplicateIDFilter(t *testing.T) {
+	noObjectID := testToot("", "2024-02-02T10:00:00Z", "<p>a</p>")
+	noObjectID.ID = "https://hachyderm.io/users/mweagle/statuses/3/activity"
+	kept := testFilterKeeps(duplicateIDFilter(),
+		testToot("1", "2024-02-02T10:00:00Z", "<p>a</p>"),
+		testToot("2", "2024-02-02T10:00:00Z", "<p>a</p>"),
+		testToot("1", "2024-02-02T11:00:00Z", "<p>b</p>"),
+		noObjectID,
+		noObjectID)
+	if !slices.Equal(kept, []bool{true, true, false, true, false}) {
+		t.Errorf("unexpected duplicate ID filtering %v", kept)
+	}
+}
+
+func TestDuplicateContentFilter(t *testing.T) {
+	cla := testCommandLineArgs(t, "--input", t.TempDir(), "--output", t.TempDir(), "--dedupe-window", "15m")
+	if cla.dedupeWindow != 15*time.Minute {
+		t.Fatalf("unexpected dedupe window %s", cla.dedupeWindow)
+	}
+	withWarning := testToot("6", "2024-02-02T10:21:00Z", "<p>Cross posted</p>")
+	withWarning.Object.Summary = "Spoilers"
+	kept := testFilterKeeps(duplicateContentFilter(cla.dedupeWindow),
+		testToot("1", "2024-02-02T10:00:00Z", "<p>Cross posted</p>"),
+		testToot("2", "2024-02-02T10:10:00Z", "<p>Cross posted</p>"),
+		testToot("3", "2024-02-02T10:20:00Z", "<p>Cross posted</p>"),
+		testToot("4", "2024-02-02T10:21:00Z", "<p>Something else</p>"),
+		testToot("5", "2024-02-02T10:22:00Z", ""),
+		testToot("7", "2024-02-02T10:23:00Z", ""),
+		withWarning,
+		testToot("8", "2024-02-02T11:00:00Z", "<p>Cross posted</p>"))
+	if !slices.Equal(kept, []bool{true, false, true, true, true, true, true, true}) {
+		t.Errorf("unexpected duplicate content filtering %v", kept)
+	}
+}
+
+// jpegSegment returns a JPEG marker segment with the data
+func jpegSegment(marker byte, data []byte) []byte {
+	segment := []byte{0xFF, marker, byte((len(data) + 2) >> 8), byte(len(data) + 2)}
+	return append(segment, data...)
+}
+
+// exifSegmentData returns APP1 data with a big endian IFD0 orientation
+func exifSegmentData(orientation uint16) []byte {
+	return []byte{'E', 'x', 'i', 'f', 0x00, 0x00,
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08,
+		0x00, 0x01,
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, byte(orientation >> 8), byte(orientation), 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00}
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	soi := []byte{0xFF, 0xD8}
+	jfif := jpegSegment(0xE0, []byte("JFIF\x00\x01\x01"))
+	quantization := jpegSegment(0xDB, []byte{0x00, 0x01, 0x02})
+	scan := append(jpegSegment(0xDA, []byte{0x01}), 0x12, 0x34, 0xFF, 0xD9)
+	joinSegments := func(segments ...[]byte) []byte {
+		return bytes.Join(segments, nil)
+	}
+	testCases := []struct {
+		name     string
+		input    []byte
+		expected []byte
+	}{
+		{"no metadata",
+			joinSegments(soi, jfif, quantization, scan),
+			joinSegments(soi, jfif, quantization, scan)},
+		{"exif, iptc and comment",
+			joinSegments(soi, jfif, jpegSegment(0xE1, exifSegmentData(1)), jpegSegment(0xED, []byte("iptc")), jpegSegment(0xFE, []byte("comment")), quantization, scan),
+			joinSegments(soi, jfif, quantization, scan)},
+		{"xmp",
+			joinSegments(soi, jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x/>")), quantization, scan),
+			joinSegments(soi, quantization, scan)},
+		{"orientation kept after jfif",
+			joinSegments(soi, jfif, jpegSegment(0xE1, exifSegmentData(6)), quantization, scan),
+			joinSegments(soi, jfif, jpegSegment(0xE1, exifSegmentData(6)), quantization, scan)},
+		{"orientation kept without jfif",
+			joinSegments(soi, jpegSegment(0xE1, exifSegmentData(8)), quantization, scan),
+			joinSegments(soi, jpegSegment(0xE1, exifSegmentData(8)), quantization, scan)},
+	}
+	for _, eachCase := range testCases {
+		stripped, strippedErr := stripJPEGMetadata(eachCase.input)
+		if strippedErr != nil {
+			t.Errorf("%s: unexpected error: %s", eachCase.name, strippedErr)
+			continue
+		}
+		if !bytes.Equal(stripped, eachCase.expected) {
+			t.Errorf("%s: expected % X, got % X", eachCase.name, eachCase.expected, stripped)
+		}
+	}
+	for _, eachInput := range [][]byte{
+		[]byte("\x89PNG\r\n\x1a\n"),
+		joinSegments(soi, []byte{0x00, 0xE0, 0x00, 0x04, 0x00, 0x00}),
+		joinSegments(soi, []byte{0xFF, 0xE0, 0x01, 0x00, 0x00, 0x00}),
+	} {
+		if _, strippedErr := stripJPEGMetadata(eachInput); strippedErr == nil {
+			t.Errorf("expected an error stripping % X", eachInput)
+		}
+	}
+}
+
+// pngChunk returns a PNG chunk of the type with the data
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	signature := []byte("\x89PNG\r\n\x1a\n")
+	header := pngChunk("IHDR", make([]byte, 13))
+	imageData := pngChunk("IDAT", []byte{0x78, 0x9C})
+	end := pngChunk("IEND", nil)
+	joinChunks := func(chunks ...[]byte) []byte {
+		return bytes.Join(chunks, nil)
+	}
+	testCases := []struct {
+		name     string
+		input    []byte
+		expected []byte
+	}{
+		{"no metadata",
+			joinChunks(signature, header, imageData, end),
+			joinChunks(signature, header, imageData, end)},
+		{"text chunks",
+			joinChunks(signature, header, pngChunk("tEXt", []byte("Author\x00me")), pngChunk("zTXt", []byte("a\x00\x00")), pngChunk("iTXt", []byte("b\x00\x00\x00\x00\x00")), imageData, end),
+			joinChunks(signature, header, imageData, end)},
+		{"exif and time",
+			joinChunks(signature, header, pngChunk("eXIf", []byte("MM")), pngChunk("tIME", make([]byte, 7)), imageData, end),
+			joinChunks(signature, header, imageData, end)},
+		{"other ancillary chunks kept",
+			joinChunks(signature, header, pngChunk("gAMA", []byte{0, 0, 0xB1, 0x8F}), imageData, end),
+			joinChunks(signature, header, pngChunk("gAMA", []byte{0, 0, 0xB1, 0x8F}), imageData, end)},
+	}
+	for _, eachCase := range testCases {
+		stripped, strippedErr := stripPNGMetadata(eachCase.input)
+		if strippedErr != nil {
+			t.Errorf("%s: unexpected error: %s", eachCase.name, strippedErr)
+			continue
+		}
+		if !bytes.Equal(stripped, eachCase.expected) {
+			t.Errorf("%s: expected % X, got % X", eachCase.name, eachCase.expected, stripped)
+		}
+	}
+	for _, eachInput := range [][]byte{
+		{0xFF, 0xD8, 0xFF, 0xD9},
+		joinChunks(signature, header[:8]),
+		joinChunks(signature, binary.BigEndian.AppendUint32(nil, 100), []byte("IDAT\x00\x00\x00\x00")),
+	} {
+		if _, strippedErr := stripPNGMetadata(eachInput); strippedErr == nil {
+			t.Errorf("expected an error stripping % X", eachInput)
+		}
+	}
+}
+
+// TEST_ARCHIVE_PNG_PATH is the archive path of the photo toot's image
+var TEST_ARCHIVE_PNG_PATH = filepath.Join("media_attachments", "files", "111", "original", "a.png")
+
+// testAddPNGText adds a tEXt chunk, after the header, to the archive's PNG
+func testAddPNGText(t *testing.T, archiveRoot string) {
+	t.Helper()
+	pngPath := filepath.Join(archiveRoot, TEST_ARCHIVE_PNG_PATH)
+	pngData, pngDataErr := os.ReadFile(pngPath)
+	if pngDataErr != nil {
+		t.Fatal(pngDataErr)
+	}
+	// The signature and the 25 byte IHDR chunk
+	headerEnd := 8 + 25
+	textChunk := pngChunk("tEXt", []byte("GPS\x0047.6,-122.3"))
+	os.WriteFile(pngPath, bytes.Join([][]byte{pngData[:headerEnd], textChunk, pngData[headerEnd:]}, nil), 0644)
+}
+
+func TestStripMetadataOutput(t *testing.T) {
+	for _, eachStrip := range []bool{false, true} {
+		archiveRoot := testArchive(t, TEST_ARCHIVE_OUTBOX)
+		testAddPNGText(t, archiveRoot)
+		cla, outbox := testReadArchive(t, archiveRoot, fmt.Sprintf("--strip-exif=%t", eachStrip))
+		if cla.stripMetadata {
+			outbox.MediaProcessors = append(outbox.MediaProcessors, metadataStripper)
+		}
+		if renderErr := renderTootsToDisk(cla, outbox, testLogger()); renderErr != nil {
+			t.Fatal(renderErr)
+		}
+		outputRoot := cla.outputRootPathHugoAssets
+		outputPNG := readTestOutput(t, filepath.Join(outputRoot, "2024", "02", "111", "a.png"))
+		if strings.Contains(outputPNG, "GPS") == eachStrip {
+			t.Errorf("--strip-exif=%t: unexpected metadata in the copied image", eachStrip)
+		}
+		if _, decodeErr := png.Decode(strings.NewReader(outputPNG)); decodeErr != nil {
+			t.Errorf("--strip-exif=%t: expected a valid PNG: %s", eachStrip, decodeErr)
+		}
+	}
+}
+
+func TestStripMetadataEmbedded(t *testing.T) {
+	for _, eachCase := range []struct {
+		format     string
+		outputName string
+		entryName  string
+	}{
+		{"epub", "mastodon-2024.epub", "OEBPS/media/a.png"},
+		{"dayone", "mastodon-dayone.zip", ""},
+	} {
+		for _, eachStrip := range []bool{false, true} {
+			archiveRoot := testArchive(t, TEST_ARCHIVE_OUTBOX)
+			testAddPNGText(t, archiveRoot)
+			cla, outbox := testReadArchive(t, archiveRoot, "--format", eachCase.format, fmt.Sprintf("--strip-exif=%t", eachStrip))
+			if cla.stripMetadata {
+				outbox.MediaProcessors = append(outbox.MediaProcessors, metadataStripper)
+			}
+			if renderErr := OUTPUT_FORMATS[cla.outputFormat](cla, outbox, testLogger()); renderErr != nil {
+				t.Fatal(renderErr)
+			}
+			entries, entryNames := readZipEntries(t, filepath.Join(cla.outputRootPathHugoAssets, eachCase.outputName))
+			entryName := eachCase.entryName
+			if len(entryName) == 0 {
+				photoIndex := slices.IndexFunc(entryNames, func(name string) bool { return strings.HasPrefix(name, "photos/") })
+				if photoIndex < 0 {
+					t.Fatalf("%s: expected a photo in %v", eachCase.format, entryNames)
+				}
+				entryName = entryNames[photoIndex]
+			}
+			embeddedPNG := entries[entryName]
+			if strings.Contains(embeddedPNG, "GPS") == eachStrip {
+				t.Errorf("%s --strip-exif=%t: unexpected metadata in the embedded image", eachCase.format, eachStrip)
+			}
+			if _, decodeErr := png.Decode(strings.NewReader(embeddedPNG)); decodeErr != nil {
+				t.Errorf("%s --strip-exif=%t: expected a valid PNG: %s", eachCase.format, eachStrip, decodeErr)
+			}
+		}
+	}
+}
+
+// encodeBase83 encodes the value as length blurhash base 83 digits
+func encodeBase83(value int, length int) string {
+	digits := make([]byte, length)
+	for index := length - 1; index >= 0; index-- {
+		digits[index] = BLURHASH_BASE83_CHARACTERS[value%83]
+		value /= 83
+	}
+	return string(digits)
+}
+
+func TestDecodeBlurhash(t *testing.T) {
+	testCases := []struct {
+		name     string
+		blurhash string
+		width    int
+		height   int
+		expected map[[2]int]color.RGBA
+	}{
+		// A single component is a solid color
+		{"solid red", "00" + encodeBase83(0xFF0000, 4), 4, 3, map[[2]int]color.RGBA{
+			{0, 0}: {0xFF, 0x00, 0x00, 0xFF},
+			{3, 2}: {0xFF, 0x00, 0x00, 0xFF},
+		}},
+		{"solid gray", "00" + encodeBase83(0x808080, 4), 2, 2, map[[2]int]color.RGBA{
+			{1, 1}: {0x80, 0x80, 0x80, 0xFF},
+		}},
+		// The DC component plus a neutral (9, 9, 9) horizontal AC component
+		{"neutral ac component", "10" + encodeBase83(0x00FF00, 4) + encodeBase83(9*19*19+9*19+9, 2), 8, 1, map[[2]int]color.RGBA{
+			{0, 0}: {0x00, 0xFF, 0x00, 0xFF},
+			{7, 0}: {0x00, 0xFF, 0x00, 0xFF},
+		}},
+		{"mastodon example", "LEHV6nWB2yk8pyo0adR*.7kCMdnj", 32, 24, map[[2]int]color.RGBA{}},
+	}
+	for _, eachCase := range testCases {
+		decoded, decodeErr := decodeBlurhash(eachCase.blurhash, eachCase.width, eachCase.height)
+		if decodeErr != nil {
+			t.Errorf("%s: unexpected error: %s", eachCase.name, decodeErr)
+			continue
+		}
+		if decoded.Bounds().Dx() != eachCase.width || decoded.Bounds().Dy() != eachCase.height {
+			t.Errorf("%s: expected %dx%d, got %s", eachCase.name, eachCase.width, eachCase.height, decoded.Bounds())
+		}
+		for eachPoint, eachColor := range eachCase.expected {
+			if pixel := color.RGBAModel.Convert(decoded.At(eachPoint[0], eachPoint[1])); pixel != eachColor {
+				t.Errorf("%s: expected %v at %v, got %v", eachCase.name, eachColor, eachPoint, pixel)
+			}
+		}
+	}
+	for _, eachBlurhash := range []string{"", "00000", "00000!", "1000000", "LEHV6nWB2yk8pyo0adR*.7kCMdn"} {
+		if _, decodeErr := decodeBlurhash(eachBlurhash, 4, 4); decodeErr == nil {
+			t.Errorf("expected an error decoding %q", eachBlurhash)
+		}
+	}
+}
+
+func TestEscapeMarkdownText(t *testing.T) {
+	testCases := []struct {
+		text     string
+		expected string
+	}{
+		{"plain text", "plain text"},
+		{"*bold* and _em_", `\*bold\* and \_em\_`},
+		{"[link](url)", `\[link\](url)`},
+		{"a `code` b", "a \\`code\\` b"},
+		{`back\slash`, `back\\slash`},
+		{"<b>tag</b>", `\<b>tag\</b>`},
+		{"~~strike~~", `\~\~strike\~\~`},
+		{"# not a heading", `\# not a heading`},
+		{"> not a quote", `\> not a quote`},
+		{"- not a list", `\- not a list`},
+		{"+ not a list", `\+ not a list`},
+		{"1. not a list", `1\. not a list`},
+		{"2024) not a list", `2024\) not a list`},
+		{"   # indented", `   \# indented`},
+		{"line\n# second", "line\n\\# second"},
+		{"issue #42", "issue #42"},
+		{"1.5 times", `1\.5 times`},
+	}
+	for _, eachCase := range testCases {
+		escaped := resolveMarkdownEscapes(escapeMarkdownText(eachCase.text))
+		if escaped != eachCase.expected {
+			t.Errorf("%q: expected %q, got %q", eachCase.text, eachCase.expected, escaped)
+		}
+	}
+	// Line start escapes are dropped when the text doesn't start a line
+	if resolved := resolveMarkdownEscapes("see " + escapeMarkdownText("# tag")); resolved != "see # tag" {
+		t.Errorf("expected the mid-line escape to be dropped, got %q", resolved)
+	}
+}
+
+func TestConvertFrontmatter(t *testing.T) {
+	page := "---\n# generated\ntitle: \"A: title\"\ndate: 2024-02-02T10:00:00Z\nweight: 10\nratio: 1.5\ndraft: false\ntags: [go, hugo]\nparams: {a: x}\n---\nbody\n"
+	testCases := []struct {
+		format   string
+		expected string
+	}{
+		{"toml", "+++\ntitle = \"A: title\"\ndate = 2024-02-02T10:00:00Z\nweight = 10\nratio = 1.5\ndraft = false\ntags = [\"go\", \"hugo\"]\nparams = { a = \"x\" }\n# generated\n+++\nbody\n"},
+		{"json", "{\n  \"title\": \"A: title\",\n  \"date\": \"2024-02-02T10:00:00Z\",\n  \"weight\": 10,\n  \"ratio\": 1.5,\n  \"draft\": false,\n  \"tags\": [\n    \"go\",\n    \"hugo\"\n  ],\n  \"params\": {\n    \"a\": \"x\"\n  }\n}\nbody\n"},
+		{"yaml", page},
+	}
+	for _, eachCase := range testCases {
+		converted, convertErr := convertFrontmatter(page, eachCase.format)
+		if convertErr != nil {
+			t.Errorf("%s: unexpected error: %s", eachCase.format, convertErr)
+			continue
+		}
+		if converted != eachCase.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", eachCase.format, eachCase.expected, converted)
+		}
+	}
+	jsonPage, _ := convertFrontmatter(page, "json")
+	frontmatter, _, _ := strings.Cut(jsonPage, "}\nbody")
+	if !json.Valid([]byte(frontmatter + "}")) {
+		t.Errorf("json: invalid frontmatter: %s", frontmatter)
+	}
+	for _, eachPage := range []string{"no frontmatter\n", "---\nunterminated: x\n"} {
+		converted, _ := convertFrontmatter(eachPage, "toml")
+		if converted != eachPage {
+			t.Errorf("expected %q unchanged, got %q", eachPage, converted)
+		}
+	}
+}
+
+func TestFrontmatterFormatOutput(t *testing.T) {
+	page := readTestOutput(t, filepath.Join(testRender(t, "hugo", "--frontmatter", "toml"), "2024", "02", "111", "index.md"))
+	if !strings.HasPrefix(page, "+++\n") || !strings.Contains(page, "\ntitle = \"") {
+		t.Errorf("expected TOML frontmatter, got:\n%s", page)
+	}
+}
+
+func TestAssignSlugs(t *testing.T) {
+	testCases := []struct {
+		name               string
+		style              string
+		toots              []*ActivityEntry
+		expectedSlugs      []string
+		expectedCollisions uint
+	}{
+		{"id", "id", []*ActivityEntry{
+			testToot("111", "2024-02-01T10:00:00Z", "<p>one</p>"),
+			testToot("112", "2024-02-02T10:00:00Z", "<p>two</p>"),
+		}, []string{"111", "112"}, 0},
+		{"same content in the same month", "content-slug", []*ActivityEntry{
+			testToot("111", "2024-02-01T10:00:00Z", "<p>Photo time</p>"),
+			testToot("112", "2024-02-02T10:00:00Z", "<p>Photo time</p>"),
+			testToot("113", "2024-02-03T10:00:00Z", "<p>Photo time</p>"),
+		}, []string{"photo-time", "photo-time-2", "photo-time-3"}, 2},
+		{"same content in different months", "content-slug", []*ActivityEntry{
+			testToot("111", "2024-02-01T10:00:00Z", "<p>Photo time</p>"),
+			testToot("112", "2024-03-01T10:00:00Z", "<p>Photo time</p>"),
+		}, []string{"photo-time", "photo-time"}, 0},
+		{"suffixed name already taken", "content-slug", []*ActivityEntry{
+			testToot("111", "2024-02-01T10:00:00Z", "<p>Photo time 2</p>"),
+			testToot("112", "2024-02-02T10:00:00Z", "<p>Photo time</p>"),
+			testToot("113", "2024-02-03T10:00:00Z", "<p>Photo time</p>"),
+		}, []string{"photo-time-2", "photo-time", "photo-time-3"}, 1},
+		{"unparseable date", "date-id", []*ActivityEntry{
+			testToot("111", "yesterday", "<p>one</p>"),
+			testToot("112", "2024-02-02T10:00:00Z", "<p>two</p>"),
+		}, []string{"", "2024-02-02-112"}, 0},
+	}
+	for _, eachCase := range testCases {
+		outbox := &Outbox{OrderedItems: eachCase.toots}
+		collisionCount := outbox.assignSlugs(eachCase.style)
+		slugs := []string{}
+		for _, eachToot := range eachCase.toots {
+			slugs = append(slugs, eachToot.Object.Slug)
+		}
+		if !reflect.DeepEqual(slugs, eachCase.expectedSlugs) {
+			t.Errorf("%s: expected %q, got %q", eachCase.name, eachCase.expectedSlugs, slugs)
+		}
+		if collisionCount != eachCase.expectedCollisions {
+			t.Errorf("%s: expected %d collisions, got %d", eachCase.name, eachCase.expectedCollisions, collisionCount)
+		}
+	}
+}
+
+// testRunMain runs main with the arguments in a child test process, so that
+// its exit status can be checked, and returns its log output
+func testRunMain(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	testBinary, testBinaryErr := os.Executable()
+	if testBinaryErr != nil {
+		t.Fatal(testBinaryErr)
+	}
+	mainCommand := exec.Command(testBinary, "-test.run=^TestMainProcess$")
+	mainCommand.Env = append(os.Environ(), "MASTODON_TO_HUGO_TEST_ARGS="+strings.Join(args, "\n"))
+	mainOutput, mainErr := mainCommand.CombinedOutput()
+	return string(mainOutput), mainErr
+}
+
+// TestMainProcess is the child process of testRunMain
+func TestMainProcess(t *testing.T) {
+	mainArgs, isMainProcess := os.LookupEnv("MASTODON_TO_HUGO_TEST_ARGS")
+	if !isMainProcess {
+		return
+	}
+	flag.CommandLine = flag.NewFlagSet("mastodon-to-hugo", flag.ExitOnError)
+	os.Args = append([]string{"mastodon-to-hugo"}, strings.Split(mainArgs, "\n")...)
+	main()
+}
+
+// testRunMainOK runs main with the arguments and fails the test if it exits
+// with an error
+func testRunMainOK(t *testing.T, args ...string) string {
+	t.Helper()
+	mainOutput, mainErr := testRunMain(t, args...)
+	if mainErr != nil {
+		t.Fatalf("%v: %s\n%s", args, mainErr, mainOutput)
+	}
+	return mainOutput
+}
+
+// expectLogAttrs checks that the log output has a line with the message and
+// each of the key=value attributes
+func expectLogAttrs(t *testing.T, name string, output string, message string, attrs ...string) {
+	t.Helper()
+	for _, eachLine := range strings.Split(output, "\n") {
+		lineFields := strings.Fields(eachLine)
+		if strings.Contains(eachLine, fmt.Sprintf(" msg=%q", message)) &&
+			!slices.ContainsFunc(attrs, func(attr string) bool { return !slices.Contains(lineFields, attr) }) {
+			return
+		}
+	}
+	t.Errorf("%s: expected the message %q with %v in:\n%s", name, message, attrs, output)
+}
+
+func TestIncremental(t *testing.T) {
+	archiveRoot := testArchive(t, TEST_ARCHIVE_OUTBOX)
+	outputRoot := t.TempDir()
+	args := []string{"--input", archiveRoot, "--output", outputRoot, "--incremental"}
+	expectLogAttrs(t, "first run", testRunMainOK(t, args...), "Unchanged pages kept", "skippedCount=0", "writtenCount=3")
+	readTestOutput(t, filepath.Join(outputRoot, RENDER_STATE_FILENAME))
+
+	// Unchanged pages aren't written again
+	pagePath := filepath.Join(outputRoot, "2024", "02", "111", "index.md")
+	pastTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	os.Chtimes(pagePath, pastTime, pastTime)
+	expectLogAttrs(t, "second run", testRunMainOK(t, args...), "Unchanged pages kept", "skippedCount=3", "writtenCount=0")
+	if pageInfo, statErr := os.Stat(pagePath); statErr != nil || !pageInfo.ModTime().Equal(pastTime) {
+		t.Errorf("expected the unchanged page to be kept: %v", statErr)
+	}
+
+	// Only the page of the edited toot is written
+	os.WriteFile(filepath.Join(archiveRoot, "outbox.json"), []byte(strings.Replace(TEST_ARCHIVE_OUTBOX, "The butler did it", "The gardener did it", 1)), 0644)
+	expectLogAttrs(t, "edited run", testRunMainOK(t, args...), "Unchanged pages kept", "skippedCount=2", "writtenCount=1")
+	expectContains(t, "113", readTestOutput(t, filepath.Join(outputRoot, "2024", "02", "113", "index.md")), "The gardener did it")
+
+	if _, parseErr := testParseCommandLine("--input", archiveRoot, "--output", outputRoot, "--incremental", "--format", "org"); parseErr == nil {
+		t.Errorf("expected --incremental to be rejected for the org format")
+	}
+}
+
+func TestNoOverwrite(t *testing.T) {
+	archiveRoot := testArchive(t, TEST_ARCHIVE_OUTBOX)
+	outputRoot := t.TempDir()
+	args := []string{"--input", archiveRoot, "--output", outputRoot, "--no-overwrite"}
+	testRunMainOK(t, args...)
+	pagePath := filepath.Join(outputRoot, "2024", "02", "113", "index.md")
+	os.WriteFile(pagePath, []byte(readTestOutput(t, pagePath)+"\nAn edit\n"), 0644)
+	os.WriteFile(filepath.Join(archiveRoot, "outbox.json"), []byte(strings.Replace(TEST_ARCHIVE_OUTBOX, "The butler did it", "The gardener did it", 1)), 0644)
+	mainOutput := testRunMainOK(t, args...)
+	expectLogAttrs(t, "edited run", mainOutput, "Keeping edited page", "path="+pagePath)
+	expectLogAttrs(t, "edited run", mainOutput, "Unchanged pages kept", "skippedCount=2", "editedCount=1", "writtenCount=0")
+	expectContains(t, "113", readTestOutput(t, pagePath), "The butler did it", "\nAn edit\n")
+
+	// An edited page of a deleted toot isn't deleted either
+	os.WriteFile(filepath.Join(archiveRoot, "outbox.json"), []byte(strings.Replace(TEST_ARCHIVE_OUTBOX, "/statuses/113", "/statuses/116", -1)), 0644)
+	testRunMainOK(t, args...)
+	expectContains(t, "113", readTestOutput(t, pagePath), "\nAn edit\n")
+
+	if cla := testCommandLineArgs(t, args...); !cla.incremental {
+		t.Errorf("expected --no-overwrite to imply --incremental")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	archiveRoot := testArchive(t, TEST_ARCHIVE_OUTBOX)
+	outputRoot := t.TempDir()
+	args := []string{"--input", archiveRoot, "--output", outputRoot}
+	testRunMainOK(t, args...)
+	os.WriteFile(filepath.Join(archiveRoot, "outbox.json"), []byte(strings.Replace(TEST_ARCHIVE_OUTBOX, "The butler did it", "The gardener did it", 1)), 0644)
+	pagePath := filepath.Join(outputRoot, "2024", "02", "113", "index.md")
+	mainOutput := testRunMainOK(t, append(args, "--dry-run", "--dry-run-diff", "--since", "2024-01-01", "--clean")...)
+	expectLogAttrs(t, "dry run", mainOutput, "Dry run change", "change=update", "path="+pagePath)
+	expectLogAttrs(t, "dry run", mainOutput, "Dry run change", "change=delete", "path="+filepath.Join(outputRoot, "2023", "12", "110", "index.md"))
+	expectLogAttrs(t, "dry run", mainOutput, "Dry run complete", "createdCount=0", "deletedCount=1")
+	expectContains(t, "diff", mainOutput, "\n-<p>The butler did it</p>\n+<p>The gardener did it</p>\n")
+	expectContains(t, "113", readTestOutput(t, pagePath), "The butler did it")
+	readTestOutput(t, filepath.Join(outputRoot, "2023", "12", "110", "index.md"))
+
+	if _, parseErr := testParseCommandLine(append(args, "--dry-run-diff")...); parseErr == nil {
+		t.Errorf("expected --dry-run-diff without --dry-run to be rejected")
+	}
+}
+
+func TestClean(t *testing.T) {
+	archiveRoot := testArchive(t, TEST_ARCHIVE_OUTBOX)
+	outputRoot := t.TempDir()
+	args := []string{"--input", archiveRoot, "--output", outputRoot, "--since", "2024-01-01"}
+	testRunMainOK(t, "--input", archiveRoot, "--output", outputRoot)
+	expectContains(t, "manifest", readTestOutput(t, filepath.Join(outputRoot, OUTPUT_MANIFEST_FILENAME)),
+		"\"path\": \"2023/12/110/index.md\"",
+		"\"path\": \"2024/02/111/a.png\"")
+
+	// Without --clean the previous run's files are kept
+	newYearPath := filepath.Join(outputRoot, "2023", "12", "110", "index.md")
+	testRunMainOK(t, args...)
+	readTestOutput(t, newYearPath)
+
+	// Other files stop --clean, and are kept with --force
+	notesPath := filepath.Join(outputRoot, "notes.md")
+	os.WriteFile(notesPath, []byte("Mine"), 0644)
+	mainOutput, mainErr := testRunMain(t, append(args, "--clean")...)
+	if mainErr == nil {
+		t.Errorf("expected --clean to stop for the other file")
+	}
+	expectLogAttrs(t, "clean", mainOutput, "Output has files the previous run didn't generate. Use --force to clean it anyway, which keeps them", "firstPath="+notesPath)
+	readTestOutput(t, newYearPath)
+	expectLogAttrs(t, "force", testRunMainOK(t, append(args, "--clean", "--force")...), "Deleted generated files", "deletedCount=4")
+	if _, statErr := os.Stat(newYearPath); !os.IsNotExist(statErr) {
+		t.Errorf("expected --clean to delete the generated page: %v", statErr)
+	}
+	expectContains(t, "notes.md", readTestOutput(t, notesPath), "Mine")
+	readTestOutput(t, filepath.Join(outputRoot, "2024", "02", "111", "index.md"))
+
+	if _, parseErr := testParseCommandLine(append(args, "--force")...); parseErr == nil {
+		t.Errorf("expected --force without --clean to be rejected")
+	}
+}
+
+func TestRemoveOrphans(t *testing.T) {
+	archiveRoot := testArchive(t, TEST_ARCHIVE_OUTBOX)
+	outputRoot := t.TempDir()
+	args := []string{"--input", archiveRoot, "--output", outputRoot}
+	testRunMainOK(t, args...)
+	pagePath := filepath.Join(outputRoot, "2024", "02", "113", "index.md")
+
+	// Pages of deleted toots are only reported
+	os.WriteFile(filepath.Join(archiveRoot, "outbox.json"), []byte(strings.Replace(TEST_ARCHIVE_OUTBOX, "/statuses/113", "/statuses/116", -1)), 0644)
+	expectLogAttrs(t, "orphan run", testRunMainOK(t, args...), "Output has files of toots no longer in the archive. Use --remove-orphans to delete them", "fileCount=1", "firstPath="+pagePath)
+	readTestOutput(t, pagePath)
+
+	expectLogAttrs(t, "remove run", testRunMainOK(t, append(args, "--remove-orphans")...), "Deleted files of toots no longer in the archive", "deletedCount=1")
+	if _, statErr := os.Stat(pagePath); !os.IsNotExist(statErr) {
+		t.Errorf("expected the orphaned page to be deleted: %v", statErr)
+	}
+	readTestOutput(t, filepath.Join(outputRoot, "2024", "02", "116", "index.md"))
+	readTestOutput(t, filepath.Join(outputRoot, "2024", "02", "111", "index.md"))
+}
+
+func TestLockOutput(t *testing.T) {
+	outputRoot := t.TempDir()
+	lockPath := filepath.Join(outputRoot, OUTPUT_LOCK_FILENAME)
+	lock, lockErr := lockOutput(outputRoot, testLogger())
+	if lockErr != nil {
+		t.Fatal(lockErr)
+	}
+	expectContains(t, "lockfile", readTestOutput(t, lockPath), fmt.Sprintf("%d\n", os.Getpid()))
+	lock.release(testLogger())
+	if _, statErr := os.Stat(lockPath); !os.IsNotExist(statErr) {
+		t.Errorf("expected release to delete the lockfile: %v", statErr)
+	}
+
+	// A locked lockfile is kept
+	lock, lockErr = lockOutput(outputRoot, testLogger())
+	if lockErr != nil {
+		t.Fatal(lockErr)
+	}
+	if _, secondLockErr := lockOutput(outputRoot, testLogger()); secondLockErr == nil || !strings.Contains(secondLockErr.Error(), fmt.Sprintf("Another run (process %d)", os.Getpid())) {
+		t.Errorf("expected a locked lockfile to be kept: %v", secondLockErr)
+	}
+	expectContains(t, "lockfile", readTestOutput(t, lockPath), fmt.Sprintf("%d\n", os.Getpid()))
+	lock.release(testLogger())
+
+	// The unlocked lockfile of a process that's no longer running is taken
+	// over, replacing its longer process ID
+	os.WriteFile(lockPath, []byte("2147483647\n"), 0644)
+	lock, lockErr = lockOutput(outputRoot, testLogger())
+	if lockErr != nil {
+		t.Fatalf("expected the stale lockfile to be taken over: %s", lockErr)
+	}
+	if lockData := readTestOutput(t, lockPath); lockData != fmt.Sprintf("%d\n", os.Getpid()) {
+		t.Errorf("expected the lockfile to hold only the process ID: %q", lockData)
+	}
+	lock.release(testLogger())
+
+	// A lockfile holding this process ID was left by an earlier run
+	os.WriteFile(lockPath, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+	lock, lockErr = lockOutput(outputRoot, testLogger())
+	if lockErr != nil {
+		t.Fatalf("expected the lockfile of this process ID to be taken over: %s", lockErr)
+	}
+	lock.release(testLogger())
+	(*outputLock)(nil).release(testLogger())
+}
+
+func TestLockedOutput(t *testing.T) {
+	archiveRoot := testArchive(t, TEST_ARCHIVE_OUTBOX)
+	outputRoot := t.TempDir()
+	lock, lockErr := lockOutput(outputRoot, testLogger())
+	if lockErr != nil {
+		t.Fatal(lockErr)
+	}
+	if mainOutput, mainErr := testRunMain(t, "--input", archiveRoot, "--output", outputRoot); mainErr == nil {
+		t.Errorf("expected a run on a locked output to fail:\n%s", mainOutput)
+	}
+	if _, statErr := os.Stat(filepath.Join(outputRoot, "2024", "02", "111", "index.md")); !os.IsNotExist(statErr) {
+		t.Errorf("expected a run on a locked output to write nothing: %v", statErr)
+	}
+	lock.release(testLogger())
+
+	// The unlocked lockfile of an earlier run is taken over
+	os.WriteFile(filepath.Join(outputRoot, OUTPUT_LOCK_FILENAME), []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+	testRunMainOK(t, "--input", archiveRoot, "--output", outputRoot)
+	if _, statErr := os.Stat(filepath.Join(outputRoot, OUTPUT_LOCK_FILENAME)); !os.IsNotExist(statErr) {
+		t.Errorf("expected the run to delete its lockfile: %v", statErr)
+	}
+
+	// A run that fails deletes its lockfile too
+	os.WriteFile(filepath.Join(outputRoot, "notes.md"), []byte("Mine\n"), 0644)
+	if mainOutput, mainErr := testRunMain(t, "--input", archiveRoot, "--output", outputRoot, "--clean"); mainErr == nil {
+		t.Errorf("expected --clean of a foreign file to fail:\n%s", mainOutput)
+	}
+	if _, statErr := os.Stat(filepath.Join(outputRoot, OUTPUT_LOCK_FILENAME)); !os.IsNotExist(statErr) {
+		t.Errorf("expected the failed run to delete its lockfile: %v", statErr)
+	}
+}
+
+// readTarEntries returns the contents of the files in the .tar.gz file
+func readTarEntries(t *testing.T, tarballPath string) map[string]string {
+	t.Helper()
+	tarballFile, tarballFileErr := os.Open(tarballPath)
+	if tarballFileErr != nil {
+		t.Fatal(tarballFileErr)
+	}
+	defer tarballFile.Close()
+	gzipReader, gzipReaderErr := gzip.NewReader(tarballFile)
+	if gzipReaderErr != nil {
+		t.Fatal(gzipReaderErr)
+	}
+	tarReader := tar.NewReader(gzipReader)
+	entries := map[string]string{}
+	for {
+		header, headerErr := tarReader.Next()
+		if headerErr == io.EOF {
+			return entries
+		} else if headerErr != nil {
+			t.Fatal(headerErr)
+		}
+		entryData, _ := io.ReadAll(tarReader)
+		entries[header.Name] = string(entryData)
+	}
+}
+
+func TestBackupOutput(t *testing.T) {
+	outputRoot := t.TempDir()
+	os.MkdirAll(filepath.Join(outputRoot, "2024", "02", "113"), os.ModePerm)
+	os.WriteFile(filepath.Join(outputRoot, "2024", "02", "113", "index.md"), []byte("The butler did it\n"), 0644)
+	os.WriteFile(filepath.Join(outputRoot, OUTPUT_LOCK_FILENAME), []byte("1\n"), 0644)
+	backupDirectory := t.TempDir()
+	for _, eachName := range []string{"20200101T000000Z", "20210101T000000Z", "20220101T000000Z"} {
+		os.WriteFile(filepath.Join(backupDirectory, BACKUP_FILENAME_PREFIX+eachName+".tar.gz"), nil, 0644)
+	}
+	backupPath, backupErr := backupOutput(backupDirectory, outputRoot, []string{outputRoot}, 2, testLogger())
+	if backupErr != nil {
+		t.Fatal(backupErr)
+	}
+	entries := readTarEntries(t, backupPath)
+	if entries["2024/02/113/index.md"] != "The butler did it\n" {
+		t.Errorf("expected the page in the backup: %v", entries)
+	}
+	if _, isLockfile := entries[OUTPUT_LOCK_FILENAME]; isLockfile {
+		t.Errorf("expected the lockfile to be left out of the backup")
+	}
+	backupPaths, _ := filepath.Glob(filepath.Join(backupDirectory, BACKUP_FILENAME_PREFIX+"*.tar.gz"))
+	expectedPaths := []string{filepath.Join(backupDirectory, BACKUP_FILENAME_PREFIX+"20220101T000000Z.tar.gz"), backupPath}
+	if !reflect.DeepEqual(backupPaths, expectedPaths) {
+		t.Errorf("expected the newest backups %v to be kept, got %v", expectedPaths, backupPaths)
+	}
+}
+
+func TestBackupBeforeRemoveOrphans(t *testing.T) {
+	archiveRoot := testArchive(t, TEST_ARCHIVE_OUTBOX)
+	outputRoot := t.TempDir()
+	backupDirectory := filepath.Join(t.TempDir(), "backups")
+	args := []string{"--input", archiveRoot, "--output", outputRoot, "--remove-orphans", "--backup", backupDirectory}
+	testRunMainOK(t, args...)
+	if backupPaths, _ := filepath.Glob(filepath.Join(backupDirectory, "*")); len(backupPaths) != 0 {
+		t.Errorf("expected no backup when nothing is deleted: %v", backupPaths)
+	}
+	os.WriteFile(filepath.Join(archiveRoot, "outbox.json"), []byte(strings.Replace(TEST_ARCHIVE_OUTBOX, "/statuses/113", "/statuses/116", -1)), 0644)
+	mainOutput := testRunMainOK(t, args...)
+	expectLogAttrs(t, "remove run", mainOutput, "Backed up output", "deletedBackupCount=0")
+	backupPaths, _ := filepath.Glob(filepath.Join(backupDirectory, BACKUP_FILENAME_PREFIX+"*.tar.gz"))
+	if len(backupPaths) != 1 {
+		t.Fatalf("expected one backup: %v", backupPaths)
+	}
+	expectContains(t, "backup", readTarEntries(t, backupPaths[0])["2024/02/113/index.md"], "The butler did it")
+
+	for _, eachArgs := range [][]string{
+		{"--backup", backupDirectory},
+		{"--clean", "--backup", filepath.Join(outputRoot, "backups")},
+		{"--clean", "--backup", backupDirectory, "--backup-count", "0"},
+	} {
+		if _, parseErr := testParseCommandLine(append([]string{"--input", archiveRoot, "--output", outputRoot}, eachArgs...)...); parseErr == nil {
+			t.Errorf("expected %v to be rejected", eachArgs)
+		}
+	}
+}
+
+func TestBoostCache(t *testing.T) {
+	var requestCount atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requestCount.Add(1)
+		fmt.Fprintf(writer, `{"id": "http://%s/users/alice/statuses/9", "type": "Note", "url": "http://%s/@alice/9", "content": "<p>Boosted news</p>"}`, request.Host, request.Host)
+	}))
+	defer server.Close()
+	boostToot := fmt.Sprintf(`{
+      "id": "https://hachyderm.io/users/mweagle/statuses/117/activity",
+      "type": "Announce",
+      "published": "2024-02-05T12:00:00Z",
+      "to": ["https://www.w3.org/ns/activitystreams#Public"],
+      "cc": ["https://hachyderm.io/users/mweagle/followers"],
+      "object": "%s/users/alice/statuses/9"
+    },`, server.URL)
+	archiveRoot := testArchive(t, strings.Replace(TEST_ARCHIVE_OUTBOX, `"orderedItems": [`, `"orderedItems": [`+boostToot, 1))
+	outputRoot := t.TempDir()
+	cachePath := filepath.Join(t.TempDir(), "boosts.json")
+	args := []string{"--input", archiveRoot, "--output", outputRoot, "--boost-style", "quote", "--boost-cache", cachePath}
+
+	// stats doesn't fetch anything
+	testRunMainOK(t, append([]string{"stats"}, args...)...)
+	if _, statErr := os.Stat(cachePath); requestCount.Load() != 0 || !os.IsNotExist(statErr) {
+		t.Errorf("expected stats not to fetch the boosted toot: %d requests, %v", requestCount.Load(), statErr)
+	}
+
+	// fetch caches the boosted toot for the --offline convert
+	expectLogAttrs(t, "fetch", testRunMainOK(t, append([]string{"fetch"}, args...)...), "Boosted toots fetched", "fetchedCount=1")
+	expectContains(t, "boosts.json", readTestOutput(t, cachePath), "/users/alice/statuses/9")
+	testRunMainOK(t, append(args, "--offline")...)
+	if requestCount.Load() != 1 {
+		t.Errorf("expected the --offline convert to use the cached boosted toot: %d requests", requestCount.Load())
+	}
+	expectContains(t, "117", readTestOutput(t, filepath.Join(outputRoot, "2024", "02", "117", "index.md")), "Boosted news")
+}